@@ -12,6 +12,10 @@ GO_VERSION="${GO_VERSION:-1.22.5}"
 GO_ARCHIVE="go${GO_VERSION}.linux-amd64.tar.gz"
 GO_URL="${GO_URL:-https://go.dev/dl/${GO_ARCHIVE}}"
 GO_BIN=""
+# Trusted ed25519 release keys (base64, space separated). Keep in sync with
+# payload/cmd/api-guard/release.pub. API_HARDENER_PUBKEY replaces them, e.g. to
+# test with a key from "api-guard keygen".
+RELEASE_PUBKEYS="${API_HARDENER_PUBKEY:-KVy4s/qmFaS+bG7ywB20u96fjjUAtgROjfHBaZk43ls=}"
 
 cleanup() {
   if [[ -n "${PAYLOAD_FILE}" && -f "${PAYLOAD_FILE}" ]]; then
@@ -25,24 +29,24 @@ TMPDIR="$(mktemp -d)"
 PAYLOAD_FILE="${TMPDIR}/payload.tgz"
 PAYLOAD_DIR="${TMPDIR}/payload"
 
-if [[ -f "${SCRIPT_DIR}/payload.b64" ]]; then
-  cp "${SCRIPT_DIR}/payload.b64" "${TMPDIR}/payload.b64"
-else
-  echo ">> Downloading payload from ${PAYLOAD_URL}"
+fetch() {
+  local url="$1" dest="$2"
   if command -v curl >/dev/null 2>&1; then
-    curl -fsSL "${PAYLOAD_URL}" -o "${TMPDIR}/payload.b64"
+    curl -fsSL "${url}" -o "${dest}"
   elif command -v wget >/dev/null 2>&1; then
-    wget -qO "${TMPDIR}/payload.b64" "${PAYLOAD_URL}"
+    wget -qO "${dest}" "${url}"
   else
     echo "curl or wget required to download payload" >&2
     exit 1
   fi
-fi
+}
 
-if command -v base64 >/dev/null 2>&1; then
-  base64 -d "${TMPDIR}/payload.b64" > "${PAYLOAD_FILE}"
-else
-  cat > "${TMPDIR}/decode_payload.py" <<'PY'
+b64decode() {
+  local src="$1" dst="$2"
+  if command -v base64 >/dev/null 2>&1; then
+    base64 -d "${src}" > "${dst}"
+  else
+    cat > "${TMPDIR}/decode_payload.py" <<'PY'
 import base64
 import pathlib
 import sys
@@ -51,9 +55,60 @@ src = pathlib.Path(sys.argv[1])
 dst = pathlib.Path(sys.argv[2])
 dst.write_bytes(base64.b64decode(src.read_bytes()))
 PY
-  python "${TMPDIR}/decode_payload.py" "${TMPDIR}/payload.b64" "${PAYLOAD_FILE}"
+    python "${TMPDIR}/decode_payload.py" "${src}" "${dst}"
+  fi
+}
+
+# verify_signature checks a detached ed25519 signature (<file>.sig, base64 of the
+# raw signature as written by "api-guard sign") against RELEASE_PUBKEYS. openssl
+# is used instead of api-guard, whose copy inside the payload is not trusted yet.
+verify_signature() {
+  local file="$1" sig="$2" key
+  if [[ -z "${RELEASE_PUBKEYS// /}" ]]; then
+    echo "!! Нет доверенного ключа подписи: задайте API_HARDENER_PUBKEY" >&2
+    return 1
+  fi
+  if ! command -v openssl >/dev/null 2>&1; then
+    echo "!! Для проверки подписи нужен openssl (1.1.1+)" >&2
+    return 1
+  fi
+  b64decode "${sig}" "${TMPDIR}/sig.bin"
+  for key in ${RELEASE_PUBKEYS}; do
+    # SubjectPublicKeyInfo prefix for a raw 32-byte Ed25519 key.
+    { printf '\x30\x2a\x30\x05\x06\x03\x2b\x65\x70\x03\x21\x00'; printf '%s' "${key}" | base64 -d 2>/dev/null; } > "${TMPDIR}/pub.der"
+    if openssl pkeyutl -verify -pubin -keyform DER -inkey "${TMPDIR}/pub.der" -rawin \
+      -in "${file}" -sigfile "${TMPDIR}/sig.bin" >/dev/null 2>&1; then
+      return 0
+    fi
+  done
+  return 1
+}
+
+if [[ -f "${SCRIPT_DIR}/payload.b64" ]]; then
+  cp "${SCRIPT_DIR}/payload.b64" "${TMPDIR}/payload.b64"
+  if [[ -f "${SCRIPT_DIR}/payload.b64.sig" ]]; then
+    cp "${SCRIPT_DIR}/payload.b64.sig" "${TMPDIR}/payload.b64.sig"
+  fi
+else
+  echo ">> Downloading payload from ${PAYLOAD_URL}"
+  fetch "${PAYLOAD_URL}" "${TMPDIR}/payload.b64"
+  fetch "${PAYLOAD_URL}.sig" "${TMPDIR}/payload.b64.sig" || rm -f "${TMPDIR}/payload.b64.sig"
+fi
+
+if [[ "${SKIP_VERIFY:-0}" == "1" ]]; then
+  echo "!! SKIP_VERIFY=1: подпись payload НЕ проверяется" >&2
+elif [[ ! -f "${TMPDIR}/payload.b64.sig" ]]; then
+  echo "!! payload не подписан (нет payload.b64.sig), установка прервана" >&2
+  exit 1
+elif ! verify_signature "${TMPDIR}/payload.b64" "${TMPDIR}/payload.b64.sig"; then
+  echo "!! Подпись payload не прошла проверку, установка прервана" >&2
+  exit 1
+else
+  echo ">> Payload signature OK"
 fi
 
+b64decode "${TMPDIR}/payload.b64" "${PAYLOAD_FILE}"
+
 mkdir -p "${PAYLOAD_DIR}"
 tar -xzf "${PAYLOAD_FILE}" -C "${PAYLOAD_DIR}"
 
@@ -81,50 +136,55 @@ ensure_go() {
   export PATH="${TMPDIR}/go/bin:${PATH}"
 }
 
-echo ">> Copying hardened API payload into ${TARGET_DIR}"
-if command -v rsync >/dev/null 2>&1; then
-  rsync -a "${PAYLOAD_DIR}/" "${TARGET_DIR}/"
+GUARD_BIN="${TMPDIR}/api-guard"
+
+# api-guard is taken from the payload when it ships a prebuilt binary,
+# otherwise it is built in a throw-away copy of the panel tree, so the
+# real tree is only touched by "api-guard patch".
+if [[ -f "${PAYLOAD_DIR}/api-guard.linux-amd64" ]]; then
+  cp -f "${PAYLOAD_DIR}/api-guard.linux-amd64" "${GUARD_BIN}"
+elif tar -xzf "${PAYLOAD_FILE}" -C "${TMPDIR}" api-guard.linux-amd64 2>/dev/null; then
+  mv -f "${TMPDIR}/api-guard.linux-amd64" "${GUARD_BIN}"
+elif [[ "${SKIP_BUILD}" != "1" && -f "${TARGET_DIR}/go.mod" ]]; then
+  ensure_go
+  echo ">> Building api-guard CLI in a staging copy..."
+  STAGE_DIR="${TMPDIR}/stage"
+  mkdir -p "${STAGE_DIR}"
+  cp -R "${TARGET_DIR}/." "${STAGE_DIR}/"
+  cp -R "${PAYLOAD_DIR}/." "${STAGE_DIR}/"
+  (cd "${STAGE_DIR}" && "${GO_BIN}" mod tidy && "${GO_BIN}" build -tags toolsignore -o "${GUARD_BIN}" ./cmd/api-guard)
 else
-  cp -R "${PAYLOAD_DIR}/." "${TARGET_DIR}/"
+  echo "!! api-guard не найден в payload и не может быть собран (нужен go.mod в ${TARGET_DIR})" >&2
+  exit 1
 fi
+chmod +x "${GUARD_BIN}"
 
-if [[ ! -f "${TARGET_DIR}/api-guard.linux-amd64" && -f "${PAYLOAD_DIR}/api-guard.linux-amd64" ]]; then
-  cp -f "${PAYLOAD_DIR}/api-guard.linux-amd64" "${TARGET_DIR}/api-guard.linux-amd64"
-fi
-if [[ ! -f "${TARGET_DIR}/api-guard.linux-amd64" && ! -f "${TARGET_DIR}/api-guard" ]]; then
-  echo ">> Extracting api-guard binary from payload..."
-  if ! tar -xzf "${PAYLOAD_FILE}" -C "${TARGET_DIR}" api-guard.linux-amd64 2>/dev/null; then
-    echo "!! Не удалось извлечь api-guard из архива" >&2
-  fi
+PATCH_ARGS=(-payload "${PAYLOAD_DIR}" -target "${TARGET_DIR}")
+if [[ "${FORCE:-0}" == "1" ]]; then
+  PATCH_ARGS+=(-force)
 fi
 
-if [[ -f "${TARGET_DIR}/api-guard.linux-amd64" ]]; then
-  mv -f "${TARGET_DIR}/api-guard.linux-amd64" "${TARGET_DIR}/api-guard"
+echo ">> Patching ${TARGET_DIR} (backup in ${TARGET_DIR}/.api-guard/backups)"
+if ! "${GUARD_BIN}" patch "${PATCH_ARGS[@]}"; then
+  echo "!! Патч не применён: версия 3x-ui не поддерживается или файлы изменены." >&2
+  echo "!! Проверьте вывод выше; FORCE=1 применит патч принудительно." >&2
+  exit 1
 fi
 
-if [[ -f "${TARGET_DIR}/api-guard" ]]; then
-  chmod +x "${TARGET_DIR}/api-guard"
-  if [[ -w "/usr/local/bin" ]]; then
-    ln -sf "${TARGET_DIR}/api-guard" /usr/local/bin/api-guard
-  fi
+cp -f "${GUARD_BIN}" "${TARGET_DIR}/api-guard"
+chmod +x "${TARGET_DIR}/api-guard"
+if [[ -w "/usr/local/bin" ]]; then
+  ln -sf "${TARGET_DIR}/api-guard" /usr/local/bin/api-guard
 fi
 
-if [[ "${SKIP_BUILD}" != "1" ]]; then
-  if [[ ! -f "${TARGET_DIR}/go.mod" ]]; then
-    echo ">> go.mod не найден в ${TARGET_DIR}, сборка пропущена (использую встроенный бинарник api-guard)"
-  else
-    ensure_go
-    echo ">> Running go mod tidy..."
-    (cd "${TARGET_DIR}" && "${GO_BIN}" mod tidy)
-    echo ">> Building api-guard CLI..."
-    (cd "${TARGET_DIR}" && "${GO_BIN}" build -tags toolsignore -o api-guard ./cmd/api-guard)
-  fi
+if [[ "${SKIP_BUILD}" != "1" && -f "${TARGET_DIR}/go.mod" ]]; then
+  ensure_go
+  # go.mod/go.sum are part of the backup, "api-guard rollback" restores them.
+  echo ">> Running go mod tidy..."
+  (cd "${TARGET_DIR}" && "${GO_BIN}" mod tidy)
 fi
 
 API_BIN="${TARGET_DIR}/api-guard"
-if [[ ! -x "${API_BIN}" ]] && command -v api-guard >/dev/null 2>&1; then
-  API_BIN="$(command -v api-guard)"
-fi
 
 if [[ -x "${API_BIN}" ]]; then
   echo ">> Running api-guard install..."
@@ -134,6 +194,6 @@ fi
 cat <<'DONE'
 API hardener applied.
 Next:
-  1) Перезапустите панель.
+  1) Пересоберите и перезапустите панель.
   2) В панели появится вкладка "API" для управления токенами и лимитами.
 DONE
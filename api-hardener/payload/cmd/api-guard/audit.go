@@ -0,0 +1,93 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// auditEventView is how an API audit event is printed.
+type auditEventView struct {
+	ID        int       `json:"id" yaml:"id"`
+	Time      time.Time `json:"time" yaml:"time"`
+	Event     string    `json:"event" yaml:"event"`
+	APIUserID int       `json:"apiUserId,omitempty" yaml:"apiUserId,omitempty"`
+	APIUser   string    `json:"apiUser,omitempty" yaml:"apiUser,omitempty"`
+	Actor     string    `json:"actor" yaml:"actor"`
+	IP        string    `json:"ip" yaml:"ip"`
+	Detail    string    `json:"detail" yaml:"detail"`
+}
+
+func newAuditEventView(e *model.APIAuditEvent) auditEventView {
+	return auditEventView{
+		ID:        e.Id,
+		Time:      e.CreatedAt,
+		Event:     e.Event,
+		APIUserID: e.APIUserId,
+		APIUser:   e.APIUserName,
+		Actor:     e.Actor,
+		IP:        e.IP,
+		Detail:    e.Detail,
+	}
+}
+
+// handleAudit prints the API audit trail, optionally only the events of one API user.
+func handleAudit(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	limit := fs.Int("limit", 50, "show at most this many events (0 = all)")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *limit < 0 {
+		return usageErrorf("-limit can not be negative")
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	apiUserID := 0
+	if ref.id > 0 || ref.name != "" {
+		if err := ref.validate(); err != nil {
+			return err
+		}
+		user, err := ref.resolve(b)
+		if err != nil {
+			return err
+		}
+		apiUserID = user.Id
+	}
+	events, err := b.ListAuditEvents(apiUserID, *limit)
+	if err != nil {
+		return err
+	}
+	views := make([]auditEventView, 0, len(events))
+	for i := range events {
+		views = append(views, newAuditEventView(&events[i]))
+	}
+	return g.render(views, func(w io.Writer) {
+		if len(views) == 0 {
+			fmt.Fprintln(w, "No audit events found.")
+			return
+		}
+		fmt.Fprintln(w, "ID\tTIME\tEVENT\tAPI USER\tACTOR\tIP\tDETAIL")
+		for _, e := range views {
+			user := "-"
+			if e.APIUser != "" {
+				user = fmt.Sprintf("%s (%d)", e.APIUser, e.APIUserID)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				e.ID, formatTime(&e.Time), e.Event, user, e.Actor, e.IP, e.Detail)
+		}
+	})
+}
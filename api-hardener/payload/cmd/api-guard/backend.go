@@ -0,0 +1,259 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/pkg/client"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// errLocalOnly is returned by commands that need direct database access in remote mode.
+var errLocalOnly = errors.New("this command must run on the panel host (remote mode is not supported)")
+
+// backend performs API user and panel session operations either directly on the
+// panel database or remotely through the admin API of a running panel.
+type backend interface {
+	GetUser(id int) (*model.APIUser, error)
+	GetUserByName(name string) (*model.APIUser, error)
+	DefaultRateLimit() (int, error)
+	CreateUser(name string, rateLimitPerMinute int, scopes []model.APIScope) (*model.APIUser, string, error)
+	ListUsers() ([]model.APIUser, error)
+	SetEnabled(id int, enabled bool) error
+	DeleteUser(id int) error
+	RotateToken(id int) (string, error)
+	UpdateRateLimit(id int, rateLimitPerMinute int) error
+	UpdateScopes(id int, scopes []model.APIScope) error
+	UpdateOrigins(id int, origins string) error
+	UpdateAccessWindow(id int, window string) error
+	UpdateInboundScope(id int, allowlist string) error
+	ListSessions() ([]model.PanelSession, error)
+	RevokeSession(id int) error
+	RevokeUserSessions(username string) (int, error)
+	RevokeLeakedTokens(tokens []string, source string) ([]service.LeakedTokenResult, error)
+	ListAuditEvents(apiUserId int, limit int) ([]model.APIAuditEvent, error)
+	GetLockdown() (*service.APILockdownStatus, error)
+	SetLockdown(mode string, revokeSessions bool, reason string) (*service.APILockdownStatus, int, error)
+	ListSigningKeys() ([]model.APISigningKey, error)
+	RotateSigningKey(dropOld bool) (*model.APISigningKey, error)
+	Close() error
+}
+
+// localBackend works on the SQLite database of the panel installed on this host.
+type localBackend struct {
+	service.APIUserService
+	settingService      service.SettingService
+	panelSessionService service.PanelSessionService
+	lockdownService     service.APILockdownService
+	jwtService          service.APIJWTService
+	auditService        service.APIAuditService
+}
+
+func initDB() error {
+	return database.InitDB(config.GetDBPath())
+}
+
+func openLocal() (*localBackend, error) {
+	if err := initDB(); err != nil {
+		return nil, err
+	}
+	return &localBackend{}, nil
+}
+
+func (b *localBackend) DefaultRateLimit() (int, error) {
+	return b.settingService.GetAPIDefaultRateLimit()
+}
+
+func (b *localBackend) ListSessions() ([]model.PanelSession, error) {
+	return b.panelSessionService.ListSessions()
+}
+
+func (b *localBackend) RevokeSession(id int) error {
+	return b.panelSessionService.RevokeSession(id)
+}
+
+func (b *localBackend) RevokeUserSessions(username string) (int, error) {
+	return b.panelSessionService.RevokeUserSessions(username, "")
+}
+
+func (b *localBackend) RevokeLeakedTokens(tokens []string, source string) ([]service.LeakedTokenResult, error) {
+	return b.APIUserService.RevokeLeakedTokens(tokens, "api-guard", "local", source)
+}
+
+func (b *localBackend) ListAuditEvents(apiUserId int, limit int) ([]model.APIAuditEvent, error) {
+	return b.auditService.ListEvents(apiUserId, limit)
+}
+
+func (b *localBackend) GetLockdown() (*service.APILockdownStatus, error) {
+	return b.lockdownService.Status()
+}
+
+func (b *localBackend) SetLockdown(mode string, revokeSessions bool, reason string) (*service.APILockdownStatus, int, error) {
+	revoked, err := b.lockdownService.SetMode(mode, revokeSessions, "api-guard", "local", reason)
+	if err != nil {
+		return nil, 0, err
+	}
+	status, err := b.lockdownService.Status()
+	return status, revoked, err
+}
+
+func (b *localBackend) ListSigningKeys() ([]model.APISigningKey, error) {
+	return b.jwtService.ListKeys()
+}
+
+func (b *localBackend) RotateSigningKey(dropOld bool) (*model.APISigningKey, error) {
+	return b.jwtService.RotateKey(dropOld, "api-guard", "local")
+}
+
+func (b *localBackend) Close() error {
+	return database.CloseDB()
+}
+
+// remoteBackend talks to the /panel/api/api-users routes with an admin-scoped token.
+type remoteBackend struct {
+	ctx    context.Context
+	client *client.Client
+}
+
+// findUser resolves a user from the list endpoint; the admin API has no single-user route.
+func (b *remoteBackend) findUser(match func(u *model.APIUser) bool) (*model.APIUser, error) {
+	users, err := b.client.ListAPIUsers(b.ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		if match(&users[i]) {
+			return &users[i], nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (b *remoteBackend) GetUser(id int) (*model.APIUser, error) {
+	return b.findUser(func(u *model.APIUser) bool { return u.Id == id })
+}
+
+func (b *remoteBackend) GetUserByName(name string) (*model.APIUser, error) {
+	return b.findUser(func(u *model.APIUser) bool { return u.Name == name })
+}
+
+func (b *remoteBackend) DefaultRateLimit() (int, error) {
+	settings, err := b.client.GetAPISettings(b.ctx)
+	if err != nil {
+		return 0, err
+	}
+	return settings.APIDefaultRateLimit, nil
+}
+
+func (b *remoteBackend) CreateUser(name string, rateLimitPerMinute int, scopes []model.APIScope) (*model.APIUser, string, error) {
+	return b.client.CreateAPIUser(b.ctx, name, rateLimitPerMinute, scopes)
+}
+
+func (b *remoteBackend) ListUsers() ([]model.APIUser, error) {
+	return b.client.ListAPIUsers(b.ctx)
+}
+
+func (b *remoteBackend) SetEnabled(id int, enabled bool) error {
+	return b.client.SetAPIUserEnabled(b.ctx, id, enabled)
+}
+
+func (b *remoteBackend) DeleteUser(id int) error {
+	return b.client.DeleteAPIUser(b.ctx, id)
+}
+
+func (b *remoteBackend) RotateToken(id int) (string, error) {
+	return b.client.RotateAPIUserToken(b.ctx, id)
+}
+
+func (b *remoteBackend) UpdateRateLimit(id int, rateLimitPerMinute int) error {
+	return b.client.SetAPIUserRateLimit(b.ctx, id, rateLimitPerMinute)
+}
+
+func (b *remoteBackend) UpdateScopes(id int, scopes []model.APIScope) error {
+	return b.client.SetAPIUserScopes(b.ctx, id, scopes)
+}
+
+func (b *remoteBackend) UpdateOrigins(id int, origins string) error {
+	return b.client.SetAPIUserOrigins(b.ctx, id, strings.Split(origins, ","))
+}
+
+func (b *remoteBackend) UpdateAccessWindow(id int, window string) error {
+	return b.client.SetAPIUserAccessWindow(b.ctx, id, window)
+}
+
+func (b *remoteBackend) UpdateInboundScope(id int, allowlist string) error {
+	return b.client.SetAPIUserInbounds(b.ctx, id, allowlist)
+}
+
+func (b *remoteBackend) ListSessions() ([]model.PanelSession, error) {
+	listed, err := b.client.ListPanelSessions(b.ctx)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]model.PanelSession, 0, len(listed))
+	for _, s := range listed {
+		sessions = append(sessions, s.PanelSession)
+	}
+	return sessions, nil
+}
+
+func (b *remoteBackend) RevokeSession(id int) error {
+	return b.client.RevokePanelSession(b.ctx, id)
+}
+
+func (b *remoteBackend) RevokeUserSessions(username string) (int, error) {
+	return b.client.RevokeUserPanelSessions(b.ctx, username)
+}
+
+func (b *remoteBackend) RevokeLeakedTokens(tokens []string, source string) ([]service.LeakedTokenResult, error) {
+	reported, err := b.client.RevokeLeakedTokens(b.ctx, tokens, source)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]service.LeakedTokenResult, 0, len(reported))
+	for _, r := range reported {
+		results = append(results, service.LeakedTokenResult(r))
+	}
+	return results, nil
+}
+
+func (b *remoteBackend) ListAuditEvents(apiUserId int, limit int) ([]model.APIAuditEvent, error) {
+	return b.client.ListAuditEvents(b.ctx, apiUserId, limit)
+}
+
+func (b *remoteBackend) GetLockdown() (*service.APILockdownStatus, error) {
+	status, err := b.client.GetLockdown(b.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &service.APILockdownStatus{Mode: status.Mode, BreakGlass: status.BreakGlass}, nil
+}
+
+func (b *remoteBackend) SetLockdown(mode string, revokeSessions bool, reason string) (*service.APILockdownStatus, int, error) {
+	status, err := b.client.SetLockdown(b.ctx, mode, revokeSessions, reason)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &service.APILockdownStatus{Mode: status.Mode, BreakGlass: status.BreakGlass}, status.RevokedSessions, nil
+}
+
+func (b *remoteBackend) ListSigningKeys() ([]model.APISigningKey, error) {
+	return b.client.ListSigningKeys(b.ctx)
+}
+
+func (b *remoteBackend) RotateSigningKey(dropOld bool) (*model.APISigningKey, error) {
+	return b.client.RotateSigningKey(b.ctx, dropOld)
+}
+
+func (b *remoteBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,256 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// certResult is the output of the cert commands.
+type certResult struct {
+	User        string     `json:"user,omitempty" yaml:"user,omitempty"`
+	Action      string     `json:"action" yaml:"action"`
+	Serial      string     `json:"serial,omitempty" yaml:"serial,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty" yaml:"fingerprint,omitempty"`
+	Subject     string     `json:"subject,omitempty" yaml:"subject,omitempty"`
+	NotAfter    *time.Time `json:"notAfter,omitempty" yaml:"notAfter,omitempty"`
+	CertFile    string     `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile     string     `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+}
+
+func (r certResult) print(w io.Writer) {
+	switch r.Action {
+	case "issued":
+		fmt.Fprintf(w, "Client certificate for %s issued (serial %s, valid until %s)\n", r.User, r.Serial, formatTime(r.NotAfter))
+		fmt.Fprintf(w, "Certificate:\t%s\n", r.CertFile)
+		fmt.Fprintf(w, "Private key:\t%s (store securely)\n", r.KeyFile)
+		fmt.Fprintf(w, "Fingerprint:\t%s\n", r.Fingerprint)
+	case "bound":
+		fmt.Fprintf(w, "Client certificate binding of %s updated (fingerprint %q, subject %q)\n", r.User, r.Fingerprint, r.Subject)
+	case "unbound":
+		fmt.Fprintf(w, "Client certificate of %s unbound\n", r.User)
+	case "revoked":
+		fmt.Fprintf(w, "Certificate serial %s added to the CRL\n", r.Serial)
+	}
+}
+
+// handleCert dispatches "cert issue", "cert bind" and "cert revoke". They work on the
+// local client CA and the panel database, so they only run on the panel host.
+func handleCert(g *globalOptions, args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("cert requires a subcommand: issue, bind or revoke")
+	}
+	if g.remote() {
+		return errLocalOnly
+	}
+	switch args[0] {
+	case "issue":
+		return handleCertIssue(g, args[1:])
+	case "bind":
+		return handleCertBind(g, args[1:])
+	case "revoke":
+		return handleCertRevoke(g, args[1:])
+	default:
+		return usageErrorf("unknown cert subcommand %q (want issue, bind or revoke)", args[0])
+	}
+}
+
+func handleCertIssue(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("cert issue", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	days := fs.Int("days", 365, "validity in days")
+	out := fs.String("out", "", "write <out>.crt and <out>.key (default: the user name)")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := ref.validate(); err != nil {
+		return err
+	}
+	if *days <= 0 {
+		return usageErrorf("-days must be > 0")
+	}
+
+	b, err := openLocal()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	user, err := ref.resolve(b)
+	if err != nil {
+		return err
+	}
+	prefix := *out
+	if prefix == "" {
+		prefix = user.Name
+	}
+	certFile, keyFile := prefix+".crt", prefix+".key"
+	for _, path := range []string{certFile, keyFile} {
+		if _, err := os.Stat(path); err == nil {
+			return usageErrorf("%s already exists", path)
+		}
+	}
+
+	cert, certPEM, keyPEM, err := b.IssueClientCert(user.Id, time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		return err
+	}
+	result := certResult{
+		User:        user.Name,
+		Action:      "issued",
+		Serial:      cert.SerialNumber.Text(16),
+		Fingerprint: service.ClientCertFingerprint(cert),
+		NotAfter:    &cert.NotAfter,
+		CertFile:    certFile,
+		KeyFile:     keyFile,
+	}
+	return g.render(result, result.print)
+}
+
+func handleCertBind(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("cert bind", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	fingerprint := fs.String("fingerprint", "", "SHA-256 fingerprint of the client certificate (hex, colons allowed)")
+	subject := fs.String("subject", "", "subject DN (e.g. CN=ci,O=Acme) or common name of accepted certificates")
+	file := fs.String("f", "", "PEM client certificate to take the fingerprint from")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := ref.validate(); err != nil {
+		return err
+	}
+	if *file != "" {
+		if *fingerprint != "" {
+			return usageErrorf("-f and -fingerprint are mutually exclusive")
+		}
+		cert, err := readCertFile(*file)
+		if err != nil {
+			return err
+		}
+		*fingerprint = service.ClientCertFingerprint(cert)
+	}
+	if *fingerprint == "" && *subject == "" {
+		return usageErrorf("one of -fingerprint, -subject or -f is required")
+	}
+
+	b, err := openLocal()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	user, err := ref.resolve(b)
+	if err != nil {
+		return err
+	}
+	if err := b.BindClientCert(user.Id, *fingerprint, *subject); err != nil {
+		return &cliError{code: exitUsage, err: err}
+	}
+	user, err = b.GetUser(user.Id)
+	if err != nil {
+		return err
+	}
+	result := certResult{User: user.Name, Action: "bound", Fingerprint: user.CertFingerprint, Subject: user.CertSubject}
+	return g.render(result, result.print)
+}
+
+func handleCertRevoke(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("cert revoke", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	file := fs.String("f", "", "PEM client certificate to add to the CRL")
+	serialHex := fs.String("serial", "", "serial number (hex) to add to the CRL")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	byUser := ref.id > 0 || ref.name != ""
+	given := 0
+	for _, set := range []bool{byUser, *file != "", *serialHex != ""} {
+		if set {
+			given++
+		}
+	}
+	if given != 1 {
+		return usageErrorf("exactly one of -id/-name, -f or -serial must be provided")
+	}
+	if byUser {
+		if err := ref.validate(); err != nil {
+			return err
+		}
+	}
+
+	b, err := openLocal()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	if byUser {
+		// Unbinding is immediate; certificates matched by subject stay valid until the CRL lists them.
+		user, err := ref.resolve(b)
+		if err != nil {
+			return err
+		}
+		if err := b.BindClientCert(user.Id, "", user.CertSubject); err != nil {
+			return err
+		}
+		result := certResult{User: user.Name, Action: "unbound", Fingerprint: user.CertFingerprint}
+		return g.render(result, result.print)
+	}
+
+	var serial *big.Int
+	fingerprint := ""
+	if *file != "" {
+		cert, err := readCertFile(*file)
+		if err != nil {
+			return err
+		}
+		serial, fingerprint = cert.SerialNumber, service.ClientCertFingerprint(cert)
+	} else {
+		var ok bool
+		if serial, ok = new(big.Int).SetString(strings.TrimPrefix(strings.ReplaceAll(*serialHex, ":", ""), "0x"), 16); !ok {
+			return usageErrorf("-serial must be a hex number")
+		}
+	}
+	if err := b.RevokeClientCert(serial, fingerprint); err != nil {
+		return err
+	}
+	result := certResult{Action: "revoked", Serial: serial.Text(16), Fingerprint: fingerprint}
+	return g.render(result, result.print)
+}
+
+func readCertFile(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &cliError{code: exitUsage, err: err}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, usageErrorf("%s is not a PEM certificate", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, &cliError{code: exitUsage, err: errors.New("parse certificate: " + err.Error())}
+	}
+	return cert, nil
+}
@@ -0,0 +1,415 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// desiredUser is one API user entry of a declarative users file.
+type desiredUser struct {
+	Name               string   `yaml:"name"`
+	RateLimitPerMinute int      `yaml:"rateLimitPerMinute"`
+	Enabled            *bool    `yaml:"enabled"` // defaults to true
+	Scopes             []string `yaml:"scopes"`  // defaults to read, write
+}
+
+// desiredState is the document read by plan/apply:
+//
+//	users:
+//	  - name: monitoring
+//	    rateLimitPerMinute: 60
+//	    scopes: [read]
+//	  - name: provisioning
+//	    enabled: false
+//	    scopes: [read, write]
+type desiredState struct {
+	Users []desiredUser `yaml:"users"`
+}
+
+type changeKind string
+
+const (
+	changeCreate    changeKind = "+"
+	changeUpdate    changeKind = "~"
+	changeDelete    changeKind = "-"
+	changeUnmanaged changeKind = "!"
+)
+
+// change is one step of a reconciliation plan.
+type change struct {
+	kind    changeKind
+	name    string
+	current *model.APIUser
+	desired *desiredUser
+	scopes  []model.APIScope // parsed desired scopes
+	diffs   []string
+}
+
+func (c change) String() string {
+	switch c.kind {
+	case changeCreate:
+		return fmt.Sprintf("%s create %s (rate=%d/min, scopes=%s, enabled=%t)",
+			c.kind, c.name, c.desired.RateLimitPerMinute, joinScopes(c.scopes), c.enabledDesired())
+	case changeUpdate:
+		return fmt.Sprintf("%s update %s: %s", c.kind, c.name, strings.Join(c.diffs, ", "))
+	case changeDelete:
+		return fmt.Sprintf("%s delete %s (id=%d, unmanaged)", c.kind, c.name, c.current.Id)
+	}
+	return fmt.Sprintf("%s unmanaged %s (id=%d), use --prune to delete", c.kind, c.name, c.current.Id)
+}
+
+func (c change) enabledDesired() bool {
+	return c.desired.Enabled == nil || *c.desired.Enabled
+}
+
+func joinScopes(scopes []model.APIScope) string {
+	joined, _ := service.JoinAPIScopes(scopes)
+	return joined
+}
+
+func loadDesiredState(path string) (*desiredState, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, &cliError{code: exitUsage, err: err}
+	}
+
+	state := &desiredState{}
+	if err := yaml.Unmarshal(data, state); err != nil {
+		return nil, usageErrorf("parse %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i := range state.Users {
+		u := &state.Users[i]
+		u.Name = strings.TrimSpace(u.Name)
+		if u.Name == "" {
+			return nil, usageErrorf("users[%d]: name is required", i)
+		}
+		if seen[u.Name] {
+			return nil, usageErrorf("users[%d]: duplicate name %q", i, u.Name)
+		}
+		seen[u.Name] = true
+		if u.RateLimitPerMinute < 0 {
+			return nil, usageErrorf("users[%d] %s: rateLimitPerMinute must be >= 0", i, u.Name)
+		}
+	}
+	return state, nil
+}
+
+// buildPlan diffs the desired users against the current ones.
+func buildPlan(state *desiredState, current []model.APIUser, prune bool) ([]change, error) {
+	byName := map[string]*model.APIUser{}
+	for i := range current {
+		byName[current[i].Name] = &current[i]
+	}
+
+	plan := make([]change, 0)
+	for i := range state.Users {
+		desired := &state.Users[i]
+		scopes, err := service.ParseAPIScopes(strings.Join(desired.Scopes, ","))
+		if err != nil {
+			return nil, usageErrorf("%s: %w", desired.Name, err)
+		}
+		c := change{name: desired.Name, desired: desired, scopes: scopes}
+
+		existing, ok := byName[desired.Name]
+		if !ok {
+			c.kind = changeCreate
+			plan = append(plan, c)
+			continue
+		}
+		delete(byName, desired.Name)
+
+		c.kind = changeUpdate
+		c.current = existing
+		if existing.RateLimitPerMinute != desired.RateLimitPerMinute {
+			c.diffs = append(c.diffs, fmt.Sprintf("rate %d -> %d", existing.RateLimitPerMinute, desired.RateLimitPerMinute))
+		}
+		if existing.Enabled != c.enabledDesired() {
+			c.diffs = append(c.diffs, fmt.Sprintf("enabled %t -> %t", existing.Enabled, c.enabledDesired()))
+		}
+		if wanted := joinScopes(scopes); existing.Scopes != wanted {
+			c.diffs = append(c.diffs, fmt.Sprintf("scopes %s -> %s", existing.Scopes, wanted))
+		}
+		if len(c.diffs) > 0 {
+			plan = append(plan, c)
+		}
+	}
+
+	unmanaged := make([]*model.APIUser, 0, len(byName))
+	for _, u := range byName {
+		unmanaged = append(unmanaged, u)
+	}
+	sort.Slice(unmanaged, func(i, j int) bool { return unmanaged[i].Id < unmanaged[j].Id })
+	for _, u := range unmanaged {
+		kind := changeUnmanaged
+		if prune {
+			kind = changeDelete
+		}
+		plan = append(plan, change{kind: kind, name: u.Name, current: u})
+	}
+	return plan, nil
+}
+
+// planEntry is the structured form of one change.
+type planEntry struct {
+	Action string   `json:"action" yaml:"action"`
+	Name   string   `json:"name" yaml:"name"`
+	ID     int      `json:"id,omitempty" yaml:"id,omitempty"`
+	Diffs  []string `json:"diffs,omitempty" yaml:"diffs,omitempty"`
+}
+
+type planSummary struct {
+	Create    int `json:"create" yaml:"create"`
+	Update    int `json:"update" yaml:"update"`
+	Delete    int `json:"delete" yaml:"delete"`
+	Unmanaged int `json:"unmanaged" yaml:"unmanaged"`
+}
+
+// planResult is the output of the plan command and the first part of apply's output.
+type planResult struct {
+	Changes []planEntry `json:"changes" yaml:"changes"`
+	Summary planSummary `json:"summary" yaml:"summary"`
+
+	plan []change
+}
+
+func newPlanResult(plan []change) planResult {
+	r := planResult{Changes: make([]planEntry, 0, len(plan)), plan: plan}
+	for _, c := range plan {
+		entry := planEntry{Name: c.name, Diffs: c.diffs}
+		if c.current != nil {
+			entry.ID = c.current.Id
+		}
+		switch c.kind {
+		case changeCreate:
+			entry.Action = "create"
+			r.Summary.Create++
+		case changeUpdate:
+			entry.Action = "update"
+			r.Summary.Update++
+		case changeDelete:
+			entry.Action = "delete"
+			r.Summary.Delete++
+		default:
+			entry.Action = "unmanaged"
+			r.Summary.Unmanaged++
+		}
+		r.Changes = append(r.Changes, entry)
+	}
+	return r
+}
+
+func (r planResult) print(w io.Writer) {
+	for _, c := range r.plan {
+		fmt.Fprintln(w, c)
+	}
+	if len(r.plan) > 0 {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Plan: %d to create, %d to update, %d to delete, %d unmanaged.\n",
+		r.Summary.Create, r.Summary.Update, r.Summary.Delete, r.Summary.Unmanaged)
+}
+
+// applyResult is the output of the apply command. Tokens holds the new tokens
+// unless they were written to SecretsFile.
+type applyResult struct {
+	planResult  `yaml:",inline"`
+	Applied     int               `json:"applied" yaml:"applied"`
+	Complete    bool              `json:"complete" yaml:"complete"`
+	Tokens      map[string]string `json:"tokens,omitempty" yaml:"tokens,omitempty"`
+	SecretsFile string            `json:"secretsFile,omitempty" yaml:"secretsFile,omitempty"`
+}
+
+func (r applyResult) print(w io.Writer) {
+	r.planResult.print(w)
+	if len(r.Tokens) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "New tokens (store securely, shown once):")
+		names := make([]string, 0, len(r.Tokens))
+		for name := range r.Tokens {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s: %s\n", name, r.Tokens[name])
+		}
+	}
+	if r.SecretsFile != "" {
+		fmt.Fprintf(w, "Wrote new token(s) to %s\n", r.SecretsFile)
+	}
+	if r.Complete {
+		fmt.Fprintln(w, "Apply complete.")
+	} else {
+		fmt.Fprintf(w, "Apply stopped after %d of %d change(s).\n", r.Applied, r.Summary.Create+r.Summary.Update+r.Summary.Delete)
+	}
+}
+
+// loadPlan reads the desired state and diffs it against the backend.
+func loadPlan(b backend, file string, prune bool) ([]change, error) {
+	state, err := loadDesiredState(file)
+	if err != nil {
+		return nil, err
+	}
+	current, err := b.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	return buildPlan(state, current, prune)
+}
+
+func handlePlan(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	file := fs.String("f", "", "desired users file (YAML, - for stdin)")
+	prune := fs.Bool("prune", false, "show unmanaged users as deletions")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return usageErrorf("-f is required")
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	plan, err := loadPlan(b, *file, *prune)
+	if err != nil {
+		return err
+	}
+	result := newPlanResult(plan)
+	return g.render(result, result.print)
+}
+
+func handleApply(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	file := fs.String("f", "", "desired users file (YAML, - for stdin)")
+	prune := fs.Bool("prune", false, "delete API users that are not in the file")
+	secretsFile := fs.String("secrets-file", "", "write tokens of created users to this YAML file (default: print them)")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return usageErrorf("-f is required")
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	plan, err := loadPlan(b, *file, *prune)
+	if err != nil {
+		return err
+	}
+
+	result := applyResult{planResult: newPlanResult(plan)}
+	tokens := map[string]string{}
+	var applyErr error
+	for _, c := range plan {
+		if c.kind == changeUnmanaged {
+			continue
+		}
+		if err := applyChange(b, c, tokens); err != nil {
+			applyErr = fmt.Errorf("%s %s: %w", c.kind, c.name, err)
+			break
+		}
+		result.Applied++
+	}
+	result.Complete = applyErr == nil
+
+	// Tokens are flushed even when a later step fails, otherwise they would be lost.
+	if len(tokens) > 0 {
+		if *secretsFile == "" {
+			result.Tokens = tokens
+		} else if err := writeSecrets(*secretsFile, tokens); err != nil {
+			result.Tokens = tokens
+			applyErr = errors.Join(applyErr, fmt.Errorf("write secrets: %w", err))
+		} else {
+			result.SecretsFile = *secretsFile
+		}
+	}
+
+	if err := g.render(result, result.print); err != nil {
+		return err
+	}
+	return applyErr
+}
+
+func applyChange(b backend, c change, tokens map[string]string) error {
+	switch c.kind {
+	case changeCreate:
+		user, token, err := b.CreateUser(c.name, c.desired.RateLimitPerMinute, c.scopes)
+		if err != nil {
+			return err
+		}
+		tokens[c.name] = token
+		if !c.enabledDesired() {
+			return b.SetEnabled(user.Id, false)
+		}
+	case changeUpdate:
+		id := c.current.Id
+		if c.current.RateLimitPerMinute != c.desired.RateLimitPerMinute {
+			if err := b.UpdateRateLimit(id, c.desired.RateLimitPerMinute); err != nil {
+				return err
+			}
+		}
+		if c.current.Scopes != joinScopes(c.scopes) {
+			if err := b.UpdateScopes(id, c.scopes); err != nil {
+				return err
+			}
+		}
+		if c.current.Enabled != c.enabledDesired() {
+			return b.SetEnabled(id, c.enabledDesired())
+		}
+	case changeDelete:
+		return b.DeleteUser(c.current.Id)
+	}
+	return nil
+}
+
+// writeSecrets merges new tokens into a 0600 YAML file.
+func writeSecrets(path string, tokens map[string]string) error {
+	secrets := map[string]string{}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := yaml.Unmarshal(data, &secrets); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for name, token := range tokens {
+		secrets[name] = token
+	}
+	data, err := yaml.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0o600)
+}
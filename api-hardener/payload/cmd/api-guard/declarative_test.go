@@ -0,0 +1,241 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestLoadDesiredState(t *testing.T) {
+	tests := []struct {
+		name      string
+		doc       string
+		wantNames []string
+		wantErr   string
+	}{
+		{"users", "users:\n  - name: monitoring\n    scopes: [read]\n  - name: ' provisioning '\n", []string{"monitoring", "provisioning"}, ""},
+		{"empty", "", nil, ""},
+		{"no name", "users:\n  - rateLimitPerMinute: 5\n", nil, "users[0]: name is required"},
+		{"duplicate", "users:\n  - name: a\n  - name: a\n", nil, `users[1]: duplicate name "a"`},
+		{"negative rate", "users:\n  - name: a\n    rateLimitPerMinute: -1\n", nil, "rateLimitPerMinute must be >= 0"},
+		{"not yaml", "users: [", nil, "parse "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.yaml")
+			if err := os.WriteFile(path, []byte(tt.doc), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			state, err := loadDesiredState(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || exitCode(err) != exitUsage {
+					t.Fatalf("err = %v (exit %d), want usage error %q", err, exitCode(err), tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, u := range state.Users {
+				names = append(names, u.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Fatalf("names = %q, want %q", names, tt.wantNames)
+			}
+		})
+	}
+
+	if _, err := loadDesiredState(filepath.Join(t.TempDir(), "missing.yaml")); exitCode(err) != exitUsage {
+		t.Fatalf("missing file: exit %d (%v), want %d", exitCode(err), err, exitUsage)
+	}
+}
+
+func TestBuildPlan(t *testing.T) {
+	disabled := false
+	current := []model.APIUser{
+		{Id: 3, Name: "stale", Enabled: true, Scopes: "read"},
+		{Id: 1, Name: "monitoring", Enabled: true, RateLimitPerMinute: 60, Scopes: "read"},
+		{Id: 2, Name: "provisioning", Enabled: true, Scopes: "read,write"},
+	}
+
+	tests := []struct {
+		name    string
+		users   []desiredUser
+		prune   bool
+		want    []string
+		wantErr bool
+	}{
+		{"in sync", []desiredUser{
+			{Name: "monitoring", RateLimitPerMinute: 60, Scopes: []string{"read"}},
+			{Name: "provisioning"},
+		}, false, []string{"! unmanaged stale (id=3), use --prune to delete"}, false},
+		{"create", []desiredUser{{Name: "backup", RateLimitPerMinute: 5, Scopes: []string{"read", "admin"}}}, false, []string{
+			"+ create backup (rate=5/min, scopes=read,admin, enabled=true)",
+			"! unmanaged monitoring (id=1), use --prune to delete",
+			"! unmanaged provisioning (id=2), use --prune to delete",
+			"! unmanaged stale (id=3), use --prune to delete",
+		}, false},
+		{"update and prune", []desiredUser{
+			{Name: "monitoring", RateLimitPerMinute: 30, Enabled: &disabled, Scopes: []string{"read", "write"}},
+			{Name: "provisioning"},
+		}, true, []string{
+			"~ update monitoring: rate 60 -> 30, enabled true -> false, scopes read -> read,write",
+			"- delete stale (id=3, unmanaged)",
+		}, false},
+		{"unknown scope", []desiredUser{{Name: "monitoring", Scopes: []string{"root"}}}, false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := buildPlan(&desiredState{Users: tt.users}, current, tt.prune)
+			if tt.wantErr {
+				if exitCode(err) != exitUsage {
+					t.Fatalf("err = %v, want a usage error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(plan))
+			for _, c := range plan {
+				got = append(got, c.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("plan =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestNewPlanResult(t *testing.T) {
+	plan := []change{
+		{kind: changeCreate, name: "a", desired: &desiredUser{Name: "a"}},
+		{kind: changeUpdate, name: "b", current: &model.APIUser{Id: 2}, diffs: []string{"rate 0 -> 1"}},
+		{kind: changeDelete, name: "c", current: &model.APIUser{Id: 3}},
+		{kind: changeUnmanaged, name: "d", current: &model.APIUser{Id: 4}},
+	}
+	got := newPlanResult(plan)
+	want := []planEntry{
+		{Action: "create", Name: "a"},
+		{Action: "update", Name: "b", ID: 2, Diffs: []string{"rate 0 -> 1"}},
+		{Action: "delete", Name: "c", ID: 3},
+		{Action: "unmanaged", Name: "d", ID: 4},
+	}
+	if !reflect.DeepEqual(got.Changes, want) || got.Summary != (planSummary{Create: 1, Update: 1, Delete: 1, Unmanaged: 1}) {
+		t.Fatalf("newPlanResult = %+v, %+v", got.Changes, got.Summary)
+	}
+}
+
+func TestWriteSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.yaml")
+	if err := os.WriteFile(path, []byte("old: xui_live_old\nkept: xui_live_kept\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSecrets(path, map[string]string{"old": "xui_live_new", "added": "xui_live_added"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(path)
+	secrets := map[string]string{}
+	if err := yaml.Unmarshal(data, &secrets); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"old": "xui_live_new", "kept": "xui_live_kept", "added": "xui_live_added"}
+	if !reflect.DeepEqual(secrets, want) {
+		t.Fatalf("secrets = %v, want %v", secrets, want)
+	}
+
+	if err := os.WriteFile(path, []byte("["), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSecrets(path, map[string]string{"a": "b"}); err == nil {
+		t.Fatal("writeSecrets merged into a malformed file")
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	dir := t.TempDir()
+	users := filepath.Join(dir, "users.yaml")
+	secrets := filepath.Join(dir, "secrets.yaml")
+	write := func(doc string) {
+		if err := os.WriteFile(users, []byte(doc), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, name := range []string{"plan-monitoring", "plan-provisioning"} {
+			guard(t, "delete", "-name", name)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		doc        string
+		args       []string
+		wantResult applyResult
+	}{
+		{"create", "users:\n  - name: plan-monitoring\n    scopes: [read]\n  - name: plan-provisioning\n    enabled: false\n",
+			[]string{"apply", "-secrets-file", secrets},
+			applyResult{Applied: 2, Complete: true, SecretsFile: secrets}},
+		{"in sync", "users:\n  - name: plan-monitoring\n    scopes: [read]\n  - name: plan-provisioning\n    enabled: false\n",
+			[]string{"apply"}, applyResult{Complete: true}},
+		{"update", "users:\n  - name: plan-monitoring\n    rateLimitPerMinute: 10\n    scopes: [read]\n  - name: plan-provisioning\n",
+			[]string{"apply"}, applyResult{Applied: 2, Complete: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(tt.doc)
+			out, err := guard(t, append(tt.args, "-f", users, "-o", "json")...)
+			if err != nil {
+				t.Fatalf("%v: %v", tt.args, err)
+			}
+			var got applyResult
+			if err := json.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatalf("apply printed %s: %v", out, err)
+			}
+			if got.Applied != tt.wantResult.Applied || got.Complete != tt.wantResult.Complete ||
+				got.SecretsFile != tt.wantResult.SecretsFile || len(got.Tokens) != 0 {
+				t.Fatalf("apply = %s", out)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "plan-monitoring: xui_live_") || !strings.Contains(string(data), "plan-provisioning: xui_live_") {
+		t.Fatalf("secrets file = %s", data)
+	}
+
+	write("users:\n  - name: plan-monitoring\n    rateLimitPerMinute: 20\n    scopes: [read]\n")
+	out, err := guard(t, "plan", "-f", users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"~ update plan-monitoring: rate 10 -> 20", "! unmanaged plan-provisioning", "1 to update"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("plan printed %s, want %q", out, want)
+		}
+	}
+	if _, err := guard(t, "apply"); exitCode(err) != exitUsage {
+		t.Fatalf("apply without -f: exit %d (%v), want %d", exitCode(err), err, exitUsage)
+	}
+}
@@ -0,0 +1,427 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// severity ranks doctor findings; a higher value is worse.
+type severity int
+
+const (
+	severityOK severity = iota
+	severityLow
+	severityMedium
+	severityHigh
+	severityCritical
+)
+
+var severityNames = []string{"ok", "low", "medium", "high", "critical"}
+
+func (s severity) String() string { return severityNames[s] }
+
+func (s severity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+func parseSeverity(raw string) (severity, error) {
+	for i, name := range severityNames {
+		if strings.EqualFold(raw, name) {
+			return severity(i), nil
+		}
+	}
+	return 0, usageErrorf("unknown severity %q (use low, medium, high or critical)", raw)
+}
+
+// finding is the result of one doctor check.
+type finding struct {
+	Check    string   `json:"check" yaml:"check"`
+	Severity severity `json:"severity" yaml:"severity"`
+	Message  string   `json:"message" yaml:"message"`
+	Fix      string   `json:"fix,omitempty" yaml:"fix,omitempty"`
+}
+
+// doctorReport is the output of the doctor command.
+type doctorReport struct {
+	Database string    `json:"database" yaml:"database"`
+	Findings []finding `json:"findings" yaml:"findings"`
+	Worst    severity  `json:"worst" yaml:"worst"`
+}
+
+func (r *doctorReport) add(f finding) {
+	r.Findings = append(r.Findings, f)
+	if f.Severity > r.Worst {
+		r.Worst = f.Severity
+	}
+}
+
+func (r *doctorReport) print(w io.Writer) {
+	fmt.Fprintf(w, "Database: %s\n\n", r.Database)
+	fmt.Fprintln(w, "SEVERITY\tCHECK\tFINDING")
+	for _, f := range r.Findings {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", strings.ToUpper(f.Severity.String()), f.Check, f.Message)
+		if f.Fix != "" {
+			fmt.Fprintf(w, "\t\tfix: %s\n", f.Fix)
+		}
+	}
+}
+
+func handleDoctor(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	staleDays := fs.Int("stale-days", 90, "report enabled tokens unused for this many days")
+	failOn := fs.String("fail-on", "high", "exit with code 6 when a finding has at least this severity (low, medium, high, critical)")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *staleDays <= 0 {
+		return usageErrorf("-stale-days must be > 0")
+	}
+	threshold, err := parseSeverity(*failOn)
+	if err != nil {
+		return err
+	}
+	if g.remote() {
+		return errLocalOnly
+	}
+
+	report, err := runDoctor(*staleDays)
+	if err != nil {
+		return err
+	}
+	if err := g.render(report, report.print); err != nil {
+		return err
+	}
+	if report.Worst >= threshold && report.Worst > severityOK {
+		return &cliError{code: exitFindings, err: fmt.Errorf("doctor found %s severity issues", report.Worst)}
+	}
+	return nil
+}
+
+func runDoctor(staleDays int) (*doctorReport, error) {
+	report := &doctorReport{Database: config.GetDBPath(), Findings: make([]finding, 0)}
+
+	// Integrity runs on a throw-away connection before InitDB migrates anything.
+	if err := database.ValidateSQLiteDB(report.Database); err != nil {
+		report.add(finding{
+			Check:    "db-integrity",
+			Severity: severityCritical,
+			Message:  fmt.Sprintf("database check failed: %v", err),
+			Fix:      "restore the database from a backup before changing anything else",
+		})
+		return report, nil
+	}
+	report.add(finding{Check: "db-integrity", Severity: severityOK, Message: "sqlite integrity_check passed"})
+
+	if err := initDB(); err != nil {
+		return nil, err
+	}
+	defer database.CloseDB()
+
+	checks := []func(*doctorReport) error{
+		checkTokenOnly,
+		checkDefaultAdmin,
+		checkRateLimits,
+		func(r *doctorReport) error { return checkStaleTokens(r, staleDays) },
+		checkTokenHashes,
+		checkLockdown,
+		checkTLS,
+		checkBasePath,
+		checkTrustedProxies,
+	}
+	for _, check := range checks {
+		if err := check(report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func checkTokenOnly(r *doctorReport) error {
+	settingSvc := service.SettingService{}
+	tokenOnly, err := settingSvc.GetAPITokenOnly()
+	if err != nil {
+		return err
+	}
+	if tokenOnly {
+		r.add(finding{Check: "api-token-only", Severity: severityOK, Message: "/panel/api requires API tokens"})
+		return nil
+	}
+	r.add(finding{
+		Check:    "api-token-only",
+		Severity: severityHigh,
+		Message:  "apiTokenOnly is off: browser sessions can call /panel/api without a token",
+		Fix:      "api-guard install, or enable token-only mode in Settings > API",
+	})
+	return nil
+}
+
+func checkLockdown(r *doctorReport) error {
+	lockdownSvc := service.APILockdownService{}
+	status, err := lockdownSvc.Status()
+	if err != nil {
+		return err
+	}
+	if status.Mode != service.APILockdownOff {
+		r.add(finding{
+			Check:    "lockdown",
+			Severity: severityMedium,
+			Message:  fmt.Sprintf("API lockdown is active (%s)", status.Mode),
+			Fix:      "api-guard lockdown off once the incident is resolved",
+		})
+	}
+	if !status.BreakGlass {
+		r.add(finding{
+			Check:    "break-glass",
+			Severity: severityLow,
+			Message:  "no break-glass token issued: a lockdown can only be managed from the panel host",
+			Fix:      "api-guard lockdown break-glass, and store the token offline",
+		})
+		return nil
+	}
+	if status.Mode == service.APILockdownOff {
+		r.add(finding{Check: "lockdown", Severity: severityOK, Message: "no lockdown active, break-glass token issued"})
+	}
+	return nil
+}
+
+func checkDefaultAdmin(r *doctorReport) error {
+	user := &model.User{}
+	err := database.GetDB().Model(&model.User{}).Where("username = ?", "admin").First(user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		r.add(finding{Check: "default-admin", Severity: severityOK, Message: "no panel user named admin"})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if crypto.CheckPasswordHash(user.Password, "admin") {
+		r.add(finding{
+			Check:    "default-admin",
+			Severity: severityCritical,
+			Message:  "the default admin/admin panel login still exists",
+			Fix:      "change the username and password in Settings > Authentication or with x-ui setting -username -password",
+		})
+		return nil
+	}
+	r.add(finding{
+		Check:    "default-admin",
+		Severity: severityLow,
+		Message:  "the panel login is still named admin (password was changed)",
+		Fix:      "use a less guessable username",
+	})
+	return nil
+}
+
+func checkRateLimits(r *doctorReport) error {
+	settingSvc := service.SettingService{}
+	defaultRate, err := settingSvc.GetAPIDefaultRateLimit()
+	if err != nil {
+		return err
+	}
+	apiSvc := service.APIUserService{}
+	users, err := apiSvc.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	unlimited := make([]string, 0)
+	for _, u := range users {
+		if u.Enabled && u.RateLimitPerMinute == 0 && defaultRate == 0 {
+			unlimited = append(unlimited, u.Name)
+		}
+	}
+	if len(unlimited) == 0 {
+		r.add(finding{Check: "rate-limit", Severity: severityOK, Message: "every enabled API user has a rate limit"})
+		return nil
+	}
+	r.add(finding{
+		Check:    "rate-limit",
+		Severity: severityMedium,
+		Message:  fmt.Sprintf("API users without a rate limit: %s", strings.Join(unlimited, ", ")),
+		Fix:      "set apiDefaultRateLimit or api-guard rate -name <user> -rate <n>",
+	})
+	return nil
+}
+
+func checkTokenHashes(r *doctorReport) error {
+	apiSvc := service.APIUserService{}
+	statuses, err := apiSvc.TokenHashReport()
+	if err != nil {
+		return err
+	}
+	if os.Getenv(service.APITokenPepperEnv) == "" {
+		info, err := os.Stat(service.APITokenPepperPath())
+		if err != nil {
+			return err
+		}
+		if info.Mode().Perm()&0o077 != 0 {
+			r.add(finding{
+				Check:    "token-hashes",
+				Severity: severityHigh,
+				Message:  fmt.Sprintf("%s is readable by other users (mode %v)", service.APITokenPepperPath(), info.Mode().Perm()),
+				Fix:      "chmod 600 " + service.APITokenPepperPath(),
+			})
+		}
+	}
+
+	legacy, foreign := make([]string, 0), make([]string, 0)
+	for _, st := range statuses {
+		if st.Legacy {
+			legacy = append(legacy, st.Name)
+		} else if st.Foreign {
+			foreign = append(foreign, st.Name)
+		}
+	}
+	switch {
+	case len(foreign) > 0:
+		r.add(finding{
+			Check:    "token-hashes",
+			Severity: severityHigh,
+			Message:  fmt.Sprintf("tokens hashed with another pepper can not be verified: %s", strings.Join(foreign, ", ")),
+			Fix:      "restore " + service.APITokenPepperFile + " from the original panel or api-guard rotate -name <user>",
+		})
+	case len(legacy) > 0:
+		r.add(finding{
+			Check:    "token-hashes",
+			Severity: severityLow,
+			Message:  fmt.Sprintf("tokens still on legacy bcrypt hashes (migrated on next use): %s", strings.Join(legacy, ", ")),
+			Fix:      "use the tokens once or api-guard rotate -name <user>; see api-guard rehash-report",
+		})
+	default:
+		r.add(finding{Check: "token-hashes", Severity: severityOK, Message: "every token hash uses " + service.APITokenHashHMAC})
+	}
+	return nil
+}
+
+func checkStaleTokens(r *doctorReport, staleDays int) error {
+	apiSvc := service.APIUserService{}
+	users, err := apiSvc.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -staleDays)
+	stale := make([]string, 0)
+	for _, u := range users {
+		if !u.Enabled {
+			continue
+		}
+		// Tokens that were never used count from the time they were issued.
+		last := u.CreatedAt
+		if u.TokenIssuedAt != nil {
+			last = *u.TokenIssuedAt
+		}
+		if u.LastUsedAt != nil && u.LastUsedAt.After(last) {
+			last = *u.LastUsedAt
+		}
+		if last.Before(cutoff) {
+			stale = append(stale, u.Name)
+		}
+	}
+	if len(stale) == 0 {
+		r.add(finding{Check: "stale-tokens", Severity: severityOK, Message: fmt.Sprintf("no enabled token unused for %d+ days", staleDays)})
+		return nil
+	}
+	r.add(finding{
+		Check:    "stale-tokens",
+		Severity: severityMedium,
+		Message:  fmt.Sprintf("enabled tokens unused for %d+ days: %s", staleDays, strings.Join(stale, ", ")),
+		Fix:      "disable or delete them: api-guard disable -name <user>",
+	})
+	return nil
+}
+
+func checkTLS(r *doctorReport) error {
+	settingSvc := service.SettingService{}
+	certFile, err := settingSvc.GetCertFile()
+	if err != nil {
+		return err
+	}
+	keyFile, err := settingSvc.GetKeyFile()
+	if err != nil {
+		return err
+	}
+	if certFile == "" || keyFile == "" {
+		r.add(finding{
+			Check:    "tls",
+			Severity: severityHigh,
+			Message:  "the panel is served without TLS: API tokens travel in plain text",
+			Fix:      "set webCertFile and webKeyFile in Settings > Panel, or put the panel behind a TLS proxy",
+		})
+		return nil
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if _, err := os.Stat(file); err != nil {
+			r.add(finding{
+				Check:    "tls",
+				Severity: severityHigh,
+				Message:  fmt.Sprintf("TLS file %s is not readable: %v", file, err),
+				Fix:      "fix the path in Settings > Panel; the panel falls back to plain HTTP",
+			})
+			return nil
+		}
+	}
+	r.add(finding{Check: "tls", Severity: severityOK, Message: "panel certificate and key are configured"})
+	return nil
+}
+
+func checkBasePath(r *doctorReport) error {
+	settingSvc := service.SettingService{}
+	basePath, err := settingSvc.GetBasePath()
+	if err != nil {
+		return err
+	}
+	if basePath != "/" {
+		r.add(finding{Check: "base-path", Severity: severityOK, Message: "webBasePath is not the default"})
+		return nil
+	}
+	r.add(finding{
+		Check:    "base-path",
+		Severity: severityMedium,
+		Message:  "webBasePath is /, so the panel and /panel/api are found by scanners",
+		Fix:      "set a random webBasePath in Settings > Panel",
+	})
+	return nil
+}
+
+func checkTrustedProxies(r *doctorReport) error {
+	settingSvc := service.SettingService{}
+	proxies, err := settingSvc.GetTrustedProxies()
+	if err != nil {
+		r.add(finding{
+			Check:    "trusted-proxies",
+			Severity: severityMedium,
+			Message:  fmt.Sprintf("trustedProxies can not be parsed: %v", err),
+			Fix:      "fix the list in Settings > API > Reverse proxy",
+		})
+		return nil
+	}
+	for _, proxy := range proxies {
+		if proxy.Bits() == 0 {
+			r.add(finding{
+				Check:    "trusted-proxies",
+				Severity: severityHigh,
+				Message:  fmt.Sprintf("trustedProxies contains %s: any client can forge its IP in audit logs and session lists", proxy),
+				Fix:      "list only the addresses of your reverse proxies in Settings > API > Reverse proxy",
+			})
+			return nil
+		}
+	}
+	r.add(finding{Check: "trusted-proxies", Severity: severityOK, Message: fmt.Sprintf("forwarding headers are trusted from %d network(s)", len(proxies))})
+	return nil
+}
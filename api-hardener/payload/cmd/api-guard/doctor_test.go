@@ -0,0 +1,181 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+)
+
+func TestParseSeverity(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    severity
+		wantErr bool
+	}{
+		{"low", severityLow, false},
+		{"Medium", severityMedium, false},
+		{"HIGH", severityHigh, false},
+		{"critical", severityCritical, false},
+		{"ok", severityOK, false},
+		{"severe", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSeverity(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSeverity(%q) = %v, %v; want %v", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+// setSetting stores value for the panel setting key, or drops it for the default when
+// value is empty, until the test ends.
+func setSetting(t *testing.T, key string, value string) {
+	t.Helper()
+	drop := func() {
+		if err := database.GetDB().Where("key = ?", key).Delete(&model.Setting{}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	drop()
+	t.Cleanup(drop)
+	if value != "" {
+		if err := database.GetDB().Create(&model.Setting{Key: key, Value: value}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDoctorChecks(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.WriteFile(file, []byte("pem"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]string
+		check    func(*doctorReport) error
+		want     severity
+	}{
+		{"token-only on", map[string]string{"apiTokenOnly": "true"}, checkTokenOnly, severityOK},
+		{"token-only off", map[string]string{"apiTokenOnly": "false"}, checkTokenOnly, severityHigh},
+		{"rate limit by default", map[string]string{"apiDefaultRateLimit": "60"}, checkRateLimits, severityOK},
+		{"unlimited users", map[string]string{"apiDefaultRateLimit": "0"}, checkRateLimits, severityMedium},
+		{"no TLS", map[string]string{"webCertFile": "", "webKeyFile": ""}, checkTLS, severityHigh},
+		{"missing TLS file", map[string]string{"webCertFile": certFile, "webKeyFile": keyFile + ".missing"}, checkTLS, severityHigh},
+		{"TLS", map[string]string{"webCertFile": certFile, "webKeyFile": keyFile}, checkTLS, severityOK},
+		{"default base path", map[string]string{"webBasePath": "/"}, checkBasePath, severityMedium},
+		{"random base path", map[string]string{"webBasePath": "/a8f3k2/"}, checkBasePath, severityOK},
+		{"loopback proxies", map[string]string{"trustedProxies": "127.0.0.0/8,::1/128"}, checkTrustedProxies, severityOK},
+		{"any proxy", map[string]string{"trustedProxies": "10.0.0.0/8,0.0.0.0/0"}, checkTrustedProxies, severityHigh},
+		{"unparsable proxies", map[string]string{"trustedProxies": "proxy.example"}, checkTrustedProxies, severityMedium},
+		{"stale tokens", nil, func(r *doctorReport) error { return checkStaleTokens(r, 1) }, severityOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.settings {
+				setSetting(t, key, value)
+			}
+			report := &doctorReport{}
+			if err := tt.check(report); err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Findings) != 1 || report.Worst != tt.want {
+				t.Fatalf("findings = %+v, want one with severity %v", report.Findings, tt.want)
+			}
+		})
+	}
+}
+
+// setPanelLogin replaces the panel login created by InitDB until the test ends.
+func setPanelLogin(t *testing.T, username string, password string) {
+	t.Helper()
+	user := &model.User{}
+	if err := database.GetDB().First(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	saved := *user
+	t.Cleanup(func() { database.GetDB().Save(&saved) })
+	hash, err := crypto.HashPasswordAsBcrypt(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Username, user.Password = username, hash
+	if err := database.GetDB().Save(user).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckDefaultAdmin(t *testing.T) {
+	tests := []struct {
+		username string
+		password string
+		want     severity
+	}{
+		{"admin", "admin", severityCritical},
+		{"admin", "k3x9-long-password", severityLow},
+		{"operator", "admin", severityOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username+"/"+tt.password, func(t *testing.T) {
+			setPanelLogin(t, tt.username, tt.password)
+			report := &doctorReport{}
+			if err := checkDefaultAdmin(report); err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Findings) != 1 || report.Worst != tt.want {
+				t.Fatalf("findings = %+v, want one with severity %v", report.Findings, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	setPanelLogin(t, "operator", "k3x9-long-password")
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"findings below the threshold", []string{"doctor", "-fail-on", "critical"}, exitOK},
+		{"findings at the threshold", []string{"doctor", "-fail-on", "low"}, exitFindings},
+		{"unknown severity", []string{"doctor", "-fail-on", "severe"}, exitUsage},
+		{"no stale days", []string{"doctor", "-stale-days", "0"}, exitUsage},
+		{"remote", []string{"-endpoint", testPanelURL, "-token", testAdminToken, "doctor"}, exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := guard(t, tt.args...); exitCode(err) != tt.wantCode {
+				t.Fatalf("exit %d (%v), want %d", exitCode(err), err, tt.wantCode)
+			}
+		})
+	}
+
+	out, err := guard(t, "doctor", "-fail-on", "critical", "-o", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Findings []struct {
+			Check    string `json:"check"`
+			Severity string `json:"severity"`
+		} `json:"findings"`
+		Worst string `json:"worst"`
+	}
+	if err := json.Unmarshal([]byte(out), &report); err != nil || len(report.Findings) == 0 ||
+		report.Findings[0].Check != "db-integrity" || report.Findings[0].Severity != "ok" {
+		t.Fatalf("doctor printed %s (%v)", out, err)
+	}
+}
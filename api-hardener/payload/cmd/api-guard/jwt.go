@@ -0,0 +1,102 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// signingKeyView is how an access token signing key is printed.
+type signingKeyView struct {
+	Kid       string     `json:"kid" yaml:"kid"`
+	Active    bool       `json:"active" yaml:"active"`
+	CreatedAt time.Time  `json:"createdAt" yaml:"createdAt"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty" yaml:"retiredAt,omitempty"`
+}
+
+func newSigningKeyView(k *model.APISigningKey) signingKeyView {
+	return signingKeyView{Kid: k.Kid, Active: k.RetiredAt == nil, CreatedAt: k.CreatedAt, RetiredAt: k.RetiredAt}
+}
+
+// handleJWT dispatches "jwt keys" and "jwt rotate".
+func handleJWT(g *globalOptions, args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("jwt requires a subcommand: keys or rotate")
+	}
+	switch args[0] {
+	case "keys":
+		return handleJWTKeys(g, args[1:])
+	case "rotate":
+		return handleJWTRotate(g, args[1:])
+	default:
+		return usageErrorf("unknown jwt subcommand %q (want keys or rotate)", args[0])
+	}
+}
+
+func handleJWTKeys(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("jwt keys", flag.ContinueOnError)
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	keys, err := b.ListSigningKeys()
+	if err != nil {
+		return err
+	}
+	views := make([]signingKeyView, 0, len(keys))
+	for i := range keys {
+		views = append(views, newSigningKeyView(&keys[i]))
+	}
+	return g.render(views, func(w io.Writer) {
+		fmt.Fprintln(w, "KID\tSTATUS\tCREATED\tRETIRED")
+		for _, k := range views {
+			status := "retired"
+			if k.Active {
+				status = "active"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", k.Kid, status, formatTime(&k.CreatedAt), formatTime(k.RetiredAt))
+		}
+	})
+}
+
+func handleJWTRotate(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("jwt rotate", flag.ContinueOnError)
+	dropOld := fs.Bool("drop-old", false, "delete the previous keys, invalidating every access token issued so far")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	key, err := b.RotateSigningKey(*dropOld)
+	if err != nil {
+		return err
+	}
+	view := newSigningKeyView(key)
+	return g.render(view, func(w io.Writer) {
+		fmt.Fprintf(w, "New signing key %s is active\n", view.Kid)
+		if *dropOld {
+			fmt.Fprintln(w, "Previous keys dropped: access tokens issued before now are rejected")
+		} else {
+			fmt.Fprintln(w, "Previous keys keep verifying their access tokens until those expire")
+		}
+	})
+}
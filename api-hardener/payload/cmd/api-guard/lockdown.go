@@ -0,0 +1,135 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// lockdownView is the output of the lockdown command.
+type lockdownView struct {
+	Mode            string `json:"mode" yaml:"mode"`
+	BreakGlass      bool   `json:"breakGlass" yaml:"breakGlass"`
+	RevokedSessions int    `json:"revokedSessions,omitempty" yaml:"revokedSessions,omitempty"`
+	Secret          string `json:"token,omitempty" yaml:"token,omitempty"` // new break-glass token, shown once
+}
+
+func (v lockdownView) print(w io.Writer) {
+	switch v.Mode {
+	case service.APILockdownFull:
+		fmt.Fprintln(w, "API lockdown: full (/panel/api only accepts the break-glass token)")
+	case service.APILockdownReadOnly:
+		fmt.Fprintln(w, "API lockdown: read-only (GET requests only; changes need the break-glass token)")
+	default:
+		fmt.Fprintln(w, "API lockdown: off")
+	}
+	if v.RevokedSessions > 0 {
+		fmt.Fprintf(w, "Revoked %d panel session(s)\n", v.RevokedSessions)
+	}
+	if v.Secret != "" {
+		fmt.Fprintf(w, "Break-glass token (store offline, shown once): %s\n", v.Secret)
+	} else if !v.BreakGlass {
+		fmt.Fprintln(w, "No break-glass token issued: during a lockdown only api-guard on the panel host can change anything (api-guard lockdown break-glass)")
+	}
+}
+
+// handleLockdown dispatches "lockdown status|on|readonly|off|break-glass".
+func handleLockdown(g *globalOptions, args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("lockdown requires a subcommand: status, on, readonly, off or break-glass")
+	}
+	switch args[0] {
+	case "status":
+		return handleLockdownStatus(g, args[1:])
+	case "break-glass":
+		return handleLockdownBreakGlass(g, args[1:])
+	}
+	mode, err := service.ParseAPILockdownMode(args[0])
+	if err != nil {
+		return usageErrorf("unknown lockdown subcommand %q (want status, on, readonly, off or break-glass)", args[0])
+	}
+	return handleLockdownSet(g, mode, args[1:])
+}
+
+func handleLockdownStatus(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("lockdown status", flag.ContinueOnError)
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	status, err := b.GetLockdown()
+	if err != nil {
+		return err
+	}
+	view := lockdownView{Mode: status.Mode, BreakGlass: status.BreakGlass}
+	return g.render(view, view.print)
+}
+
+func handleLockdownSet(g *globalOptions, mode string, args []string) error {
+	fs := flag.NewFlagSet("lockdown "+mode, flag.ContinueOnError)
+	revokeSessions := fs.Bool("revoke-sessions", false, "log out every panel session when entering the lockdown")
+	reason := fs.String("reason", "", "reason recorded in the audit trail")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *revokeSessions && mode == service.APILockdownOff {
+		return usageErrorf("-revoke-sessions only applies when entering a lockdown")
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	status, revoked, err := b.SetLockdown(mode, *revokeSessions, *reason)
+	if err != nil {
+		return err
+	}
+	view := lockdownView{Mode: status.Mode, BreakGlass: status.BreakGlass, RevokedSessions: revoked}
+	return g.render(view, view.print)
+}
+
+// handleLockdownBreakGlass issues a new break-glass token. It only runs on the panel
+// host, so a stolen admin token can not mint a way around the lockdown.
+func handleLockdownBreakGlass(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("lockdown break-glass", flag.ContinueOnError)
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if g.remote() {
+		return errLocalOnly
+	}
+
+	if err := initDB(); err != nil {
+		return err
+	}
+	defer database.CloseDB()
+
+	lockdownSvc := service.APILockdownService{}
+	token, err := lockdownSvc.IssueBreakGlassToken("api-guard", "local")
+	if err != nil {
+		return err
+	}
+	status, err := lockdownSvc.Status()
+	if err != nil {
+		return err
+	}
+	view := lockdownView{Mode: status.Mode, BreakGlass: status.BreakGlass, Secret: token}
+	return g.render(view, view.print)
+}
@@ -4,78 +4,243 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
-	"text/tabwriter"
-	"time"
+	"strings"
 
-	"github.com/mhsanaei/3x-ui/v2/config"
 	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 )
 
 func main() {
-	if err := run(os.Args[1:]); err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+	g, args, err := parseGlobalOptions(os.Args[1:])
+	if err == nil {
+		err = run(g, args)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitOK)
+	}
+	if err != nil {
+		g.reportError(err)
+		os.Exit(exitCode(err))
 	}
 }
 
-func run(args []string) error {
+func run(g *globalOptions, args []string) error {
 	if len(args) == 0 {
 		printUsage()
 		return nil
 	}
+	if err := g.resolve(); err != nil {
+		return err
+	}
 
 	switch args[0] {
 	case "install":
-		return handleInstall(args[1:])
+		return handleInstall(g, args[1:])
 	case "create":
-		return handleCreate(args[1:])
+		return handleCreate(g, args[1:])
 	case "list":
-		return handleList()
+		return handleList(g, args[1:])
+	case "get":
+		return handleGet(g, args[1:])
 	case "enable":
-		return handleToggle(args[1:], true)
+		return handleToggle(g, args[1:], true)
 	case "disable":
-		return handleToggle(args[1:], false)
+		return handleToggle(g, args[1:], false)
 	case "delete":
-		return handleDelete(args[1:])
+		return handleDelete(g, args[1:])
 	case "rotate":
-		return handleRotate(args[1:])
+		return handleRotate(g, args[1:])
 	case "rate":
-		return handleRate(args[1:])
+		return handleRate(g, args[1:])
+	case "origins":
+		return handleOrigins(g, args[1:])
+	case "window":
+		return handleWindow(g, args[1:])
+	case "inbounds":
+		return handleInbounds(g, args[1:])
+	case "plan":
+		return handlePlan(g, args[1:])
+	case "apply":
+		return handleApply(g, args[1:])
+	case "patch":
+		return handlePatch(g, args[1:])
+	case "rollback":
+		return handleRollback(g, args[1:])
+	case "manifest":
+		return handleManifest(g, args[1:])
+	case "verify":
+		return handleVerify(g, args[1:])
+	case "keygen":
+		return handleKeygen(g, args[1:])
+	case "sign":
+		return handleSign(g, args[1:])
+	case "doctor":
+		return handleDoctor(g, args[1:])
+	case "export":
+		return handleExport(g, args[1:])
+	case "import":
+		return handleImport(g, args[1:])
+	case "sessions":
+		return handleSessions(g, args[1:])
+	case "rehash-report":
+		return handleRehashReport(g, args[1:])
+	case "revoke-leaked":
+		return handleRevokeLeaked(g, args[1:])
+	case "audit":
+		return handleAudit(g, args[1:])
+	case "lockdown":
+		return handleLockdown(g, args[1:])
+	case "cert":
+		return handleCert(g, args[1:])
+	case "jwt":
+		return handleJWT(g, args[1:])
+	case "peer":
+		return handlePeer(g, args[1:])
 	default:
 		printUsage()
-		return fmt.Errorf("unknown command %q", args[0])
+		return usageErrorf("unknown command %q", args[0])
 	}
 }
 
 func printUsage() {
-	fmt.Println("Usage: api-guard <command> [options]")
+	fmt.Println("Usage: api-guard [global options] <command> [options]")
 	fmt.Println()
 	fmt.Println("Commands:")
 	fmt.Println("  install      Apply API hardening (migrate DB, enforce tokens, create bootstrap user)")
 	fmt.Println("  create       Create a new API user and print its token")
 	fmt.Println("  list         List API users with status and rate limits")
-	fmt.Println("  enable       Enable an API user by id")
-	fmt.Println("  disable      Disable an API user by id")
-	fmt.Println("  delete       Delete an API user by id")
+	fmt.Println("  get          Show one API user with token metadata and usage (-id or -name)")
+	fmt.Println("  enable       Enable an API user (-id or -name)")
+	fmt.Println("  disable      Disable an API user (-id or -name)")
+	fmt.Println("  delete       Delete an API user (-id or -name)")
 	fmt.Println("  rotate       Rotate token for an API user and print the new token")
 	fmt.Println("  rate         Set per-minute rate limit for an API user (0 = unlimited)")
+	fmt.Println("  origins      Restrict an API user to browser origins (-origins a,b; empty = any)")
+	fmt.Println("  window       Restrict an API user to weekdays and hours (-window \"mon-fri 09:00-18:00\"; empty = any time)")
+	fmt.Println("  inbounds     Restrict an API user to inbounds (-allow id:3,tag:inbound-2000*,port:20000-20999; empty = all)")
+	fmt.Println("  plan         Show changes needed to match a declarative users file (-f users.yaml)")
+	fmt.Println("  apply        Reconcile API users with a declarative users file (-f users.yaml [--prune])")
+	fmt.Println("  patch        Apply the payload to a 3x-ui source tree after version and hash checks (-target dir)")
+	fmt.Println("  rollback     Restore the files replaced by patch (-target dir [-backup id] [-list])")
+	fmt.Println("  manifest     Regenerate payload manifest.json against a clean 3x-ui checkout (-upstream dir)")
+	fmt.Println("  verify       Check the ed25519 signature of a payload or binary (-f file [-sig file.sig] [-pubkey key.pub])")
+	fmt.Println("  keygen       Generate an ed25519 signing key pair (-out name)")
+	fmt.Println("  sign         Write a detached signature <file>.sig (-key name.key -f file)")
+	fmt.Println("  doctor       Check the panel and API hardening for insecure settings (local only)")
+	fmt.Println("  export       Export API users, token hashes and API settings (-f file, local only)")
+	fmt.Println("  import       Import an export into this panel (-f file [--on-conflict fail|skip|replace] [--reissue-tokens])")
+	fmt.Println("  rehash-report Show API token hashes still on legacy bcrypt or another pepper (local only)")
+	fmt.Println("  revoke-leaked Disable the owners of leaked plaintext tokens (-f file|- [-source name])")
+	fmt.Println("  cert         Client certificates for the API listener (cert issue|bind|revoke, local only)")
+	fmt.Println("  peer         Map unix socket callers to an API user (peer bind -name n -uid u|-gid g | peer unbind, local only)")
+	fmt.Println("  jwt          Access token signing keys (jwt keys | jwt rotate [-drop-old])")
+	fmt.Println("  lockdown     Emergency lockdown of /panel/api (lockdown status|on|readonly|off [-revoke-sessions] | lockdown break-glass)")
+	fmt.Println("  audit        Show the API audit trail, newest first ([-id n|-name n] [-limit 50]; -limit 0 = all)")
+	fmt.Println("  sessions     List or revoke panel login sessions (sessions list [-user name] | sessions revoke -id n|-user name)")
+	fmt.Println()
+	fmt.Println("Global options (remote mode, all commands except install, patch, rollback, manifest, verify, keygen, sign, doctor, export, import, rehash-report, cert, peer and lockdown break-glass):")
+	fmt.Println("  --endpoint   Panel URL incl. base path, or unix:///path/api.sock (env API_GUARD_ENDPOINT)")
+	fmt.Println("  --token      Admin-scoped API token (env API_GUARD_TOKEN)")
+	fmt.Println("  --profile    Profile name from the profiles file (env API_GUARD_PROFILE)")
+	fmt.Println("  --config     Profiles file (env API_GUARD_CONFIG)")
+	fmt.Println("  --ca-cert    PEM CA bundle for panels with private certificates")
+	fmt.Println("  --timeout    Timeout for remote requests (default 30s)")
+	fmt.Println("  --output/-o  Output format: table (default), json or yaml; also accepted after the command")
+	fmt.Println()
+	fmt.Println("Exit codes: 0 ok, 1 unexpected error, 2 invalid usage or input, 3 not found,")
+	fmt.Println("            4 database or panel failure, 5 token rejected or missing scope,")
+	fmt.Println("            6 doctor findings at or above -fail-on, 7 patch/rollback refused,")
+	fmt.Println("            8 missing or invalid signature")
+}
+
+// parseFlags parses subcommand flags, turning parse failures into usage errors.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &cliError{code: exitUsage, err: err}
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
+
+// userRef selects an API user by -id or -name.
+type userRef struct {
+	id   int
+	name string
+}
+
+func addUserRefFlags(fs *flag.FlagSet) *userRef {
+	ref := &userRef{}
+	fs.IntVar(&ref.id, "id", 0, "API user id")
+	fs.StringVar(&ref.name, "name", "", "API user name (alternative to -id)")
+	return ref
+}
+
+func (r *userRef) String() string {
+	if r.name != "" {
+		return fmt.Sprintf("%q", r.name)
+	}
+	return fmt.Sprintf("%d", r.id)
+}
+
+func (r *userRef) validate() error {
+	if (r.id > 0) == (r.name != "") {
+		return usageErrorf("exactly one of -id or -name must be provided")
+	}
+	return nil
+}
+
+func (r *userRef) resolve(b backend) (*model.APIUser, error) {
+	var user *model.APIUser
+	var err error
+	if r.name != "" {
+		user, err = b.GetUserByName(r.name)
+	} else {
+		user, err = b.GetUser(r.id)
+	}
+	if exitCode(err) == exitNotFound {
+		return nil, notFoundErrorf("API user %s not found", r)
+	}
+	return user, err
 }
 
-func initDB() error {
-	return database.InitDB(config.GetDBPath())
+// installResult is the output of the install command.
+type installResult struct {
+	TokenOnly        bool      `json:"tokenOnly" yaml:"tokenOnly"`
+	DefaultRateLimit int       `json:"defaultRateLimit" yaml:"defaultRateLimit"`
+	ExistingUsers    int64     `json:"existingUsers" yaml:"existingUsers"`
+	Bootstrap        *userView `json:"bootstrap,omitempty" yaml:"bootstrap,omitempty"`
 }
 
-func handleInstall(args []string) error {
-	fs := flag.NewFlagSet("install", flag.ExitOnError)
+func handleInstall(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("install", flag.ContinueOnError)
 	tokenOnly := fs.Bool("token-only", true, "deny session-based access to /panel/api and require tokens")
 	defaultRate := fs.Int("default-rate", 120, "default per-minute limit for API tokens (0 = unlimited)")
 	bootstrapUser := fs.String("bootstrap-user", "api-root", "bootstrap API user (created only when none exist)")
 	bootstrapRate := fs.Int("bootstrap-rate", 120, "rate limit for the bootstrap user (0 = use default)")
-	fs.Parse(args)
+	bootstrapScopes := fs.String("bootstrap-scopes", "admin", "comma-separated scopes of the bootstrap user (read, write, admin)")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if g.remote() {
+		return errLocalOnly
+	}
+	scopes, err := service.ParseAPIScopes(*bootstrapScopes)
+	if err != nil {
+		return &cliError{code: exitUsage, err: err}
+	}
 
 	if err := initDB(); err != nil {
 		return err
@@ -99,168 +264,426 @@ func handleInstall(args []string) error {
 		return err
 	}
 
+	result := installResult{TokenOnly: *tokenOnly, DefaultRateLimit: *defaultRate, ExistingUsers: count}
 	if count == 0 {
-		user, token, err := apiSvc.CreateUser(*bootstrapUser, *bootstrapRate)
+		user, token, err := apiSvc.CreateUser(*bootstrapUser, *bootstrapRate, scopes)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("Bootstrap API user created (id=%d, name=%s)\n", user.Id, user.Name)
-		fmt.Printf("Token (store securely, shown once): %s\n", token)
-	} else {
-		fmt.Printf("API users already present (%d); bootstrap user not created\n", count)
+		view := newUserView(user)
+		view.Secret = token
+		result.Bootstrap = &view
 	}
 
-	fmt.Println("API hardening installed.")
-	return nil
+	return g.render(result, func(w io.Writer) {
+		if result.Bootstrap != nil {
+			fmt.Fprintf(w, "Bootstrap API user created (id=%d, name=%s, scopes=%s)\n",
+				result.Bootstrap.ID, result.Bootstrap.Name, strings.Join(result.Bootstrap.Scopes, ","))
+			fmt.Fprintf(w, "Token (store securely, shown once): %s\n", result.Bootstrap.Secret)
+		} else {
+			fmt.Fprintf(w, "API users already present (%d); bootstrap user not created\n", count)
+		}
+		fmt.Fprintln(w, "API hardening installed.")
+	})
 }
 
-func handleCreate(args []string) error {
-	fs := flag.NewFlagSet("create", flag.ExitOnError)
+func handleCreate(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
 	name := fs.String("name", "", "API user name (required)")
 	rate := fs.Int("rate", 0, "per-minute rate limit (0 = use default)")
-	fs.Parse(args)
+	scopeList := fs.String("scopes", "read,write", "comma-separated scopes (read, write, admin)")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
 
-	if *name == "" {
-		return fmt.Errorf("-name is required")
+	if strings.TrimSpace(*name) == "" {
+		return usageErrorf("-name is required")
+	}
+	if *rate < 0 {
+		return usageErrorf("-rate must be >= 0")
+	}
+	scopes, err := service.ParseAPIScopes(*scopeList)
+	if err != nil {
+		return &cliError{code: exitUsage, err: err}
 	}
 
-	if err := initDB(); err != nil {
+	b, err := g.open()
+	if err != nil {
 		return err
 	}
-	defer database.CloseDB()
+	defer b.Close()
 
-	apiSvc := service.APIUserService{}
-	user, token, err := apiSvc.CreateUser(*name, *rate)
+	user, token, err := b.CreateUser(*name, *rate, scopes)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("API user created (id=%d, name=%s, rate=%d/min)\n", user.Id, user.Name, user.RateLimitPerMinute)
-	fmt.Printf("Token (store securely, shown once): %s\n", token)
-	return nil
+	view := newUserView(user)
+	view.Secret = token
+	return g.render(view, func(w io.Writer) {
+		fmt.Fprintf(w, "API user created (id=%d, name=%s, rate=%d/min, scopes=%s)\n",
+			user.Id, user.Name, user.RateLimitPerMinute, user.Scopes)
+		fmt.Fprintf(w, "Token (store securely, shown once): %s\n", token)
+	})
 }
 
-func handleList() error {
-	if err := initDB(); err != nil {
+func handleList(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
 		return err
 	}
-	defer database.CloseDB()
 
-	apiSvc := service.APIUserService{}
-	users, err := apiSvc.ListUsers()
+	b, err := g.open()
 	if err != nil {
 		return err
 	}
+	defer b.Close()
 
-	if len(users) == 0 {
-		fmt.Println("No API users found.")
-		return nil
+	users, err := b.ListUsers()
+	if err != nil {
+		return err
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "ID\tNAME\tENABLED\tRATE/MIN\tLAST USED")
-	for _, u := range users {
-		lastUsed := "never"
-		if u.LastUsedAt != nil {
-			lastUsed = u.LastUsedAt.Format(time.RFC3339)
-		}
-		fmt.Fprintf(w, "%d\t%s\t%t\t%d\t%s\n", u.Id, u.Name, u.Enabled, u.RateLimitPerMinute, lastUsed)
+	views := make([]userView, 0, len(users))
+	for i := range users {
+		views = append(views, newUserView(&users[i]))
 	}
-	w.Flush()
-	return nil
+	return g.render(views, func(w io.Writer) {
+		if len(views) == 0 {
+			fmt.Fprintln(w, "No API users found.")
+			return
+		}
+		fmt.Fprintln(w, "ID\tNAME\tENABLED\tRATE/MIN\tSCOPES\tLAST USED")
+		for _, u := range views {
+			fmt.Fprintf(w, "%d\t%s\t%t\t%d\t%s\t%s\n",
+				u.ID, u.Name, u.Enabled, u.RateLimitPerMinute, strings.Join(u.Scopes, ","), formatTime(u.Usage.LastUsedAt))
+		}
+	})
 }
 
-func handleToggle(args []string, enabled bool) error {
-	fs := flag.NewFlagSet("toggle", flag.ExitOnError)
-	id := fs.Int("id", 0, "API user id")
-	fs.Parse(args)
+func handleGet(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := ref.validate(); err != nil {
+		return err
+	}
 
-	if *id <= 0 {
-		return fmt.Errorf("-id must be provided")
+	b, err := g.open()
+	if err != nil {
+		return err
 	}
+	defer b.Close()
 
-	if err := initDB(); err != nil {
+	user, err := ref.resolve(b)
+	if err != nil {
 		return err
 	}
-	defer database.CloseDB()
+	view := newUserView(user)
+	effective := user.RateLimitPerMinute
+	if effective == 0 {
+		if effective, err = b.DefaultRateLimit(); err != nil {
+			return err
+		}
+	}
+	view.EffectiveRateLimit = &effective
+
+	return g.render(view, func(w io.Writer) {
+		fmt.Fprintf(w, "ID:\t%d\n", view.ID)
+		fmt.Fprintf(w, "Name:\t%s\n", view.Name)
+		fmt.Fprintf(w, "Enabled:\t%t\n", view.Enabled)
+		fmt.Fprintf(w, "Scopes:\t%s\n", strings.Join(view.Scopes, ","))
+		fmt.Fprintf(w, "Rate limit:\t%d/min (effective %d/min)\n", view.RateLimitPerMinute, effective)
+		fmt.Fprintf(w, "Token prefix:\t%s\n", view.Token.Prefix)
+		fmt.Fprintf(w, "Token issued:\t%s\n", formatTime(view.Token.IssuedAt))
+		if view.Cert != nil {
+			fmt.Fprintf(w, "Client cert:\tfingerprint %q, subject %q\n", view.Cert.Fingerprint, view.Cert.Subject)
+		}
+		if view.Peer != nil {
+			fmt.Fprintf(w, "Unix socket:\t%s\n", formatPeer(view.Peer.UID, view.Peer.GID))
+		}
+		if len(view.Origins) > 0 {
+			fmt.Fprintf(w, "Origins:\t%s\n", strings.Join(view.Origins, ", "))
+		}
+		if view.AccessWindow != "" {
+			fmt.Fprintf(w, "Access window:\t%s\n", view.AccessWindow)
+		}
+		if view.Inbounds != "" {
+			fmt.Fprintf(w, "Inbounds:\t%s\n", view.Inbounds)
+		}
+		fmt.Fprintf(w, "Last used:\t%s\n", formatTime(view.Usage.LastUsedAt))
+		fmt.Fprintf(w, "Requests:\t%d\n", view.Usage.RequestCount)
+		fmt.Fprintf(w, "Created:\t%s\n", formatTime(&view.CreatedAt))
+	})
+}
 
-	apiSvc := service.APIUserService{}
-	if err := apiSvc.SetEnabled(*id, enabled); err != nil {
+func handleToggle(g *globalOptions, args []string, enabled bool) error {
+	fs := flag.NewFlagSet("toggle", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
 		return err
 	}
+	if err := ref.validate(); err != nil {
+		return err
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	user, err := ref.resolve(b)
+	if err != nil {
+		return err
+	}
+	if err := b.SetEnabled(user.Id, enabled); err != nil {
+		return err
+	}
+	action := "disable"
 	if enabled {
-		fmt.Printf("API user %d enabled\n", *id)
-	} else {
-		fmt.Printf("API user %d disabled\n", *id)
+		action = "enable"
 	}
-	return nil
+	return g.renderAction(actionResult{
+		ID:      user.Id,
+		Name:    user.Name,
+		Action:  action,
+		Message: fmt.Sprintf("API user %d %sd", user.Id, action),
+	})
 }
 
-func handleDelete(args []string) error {
-	fs := flag.NewFlagSet("delete", flag.ExitOnError)
-	id := fs.Int("id", 0, "API user id")
-	fs.Parse(args)
-
-	if *id <= 0 {
-		return fmt.Errorf("-id must be provided")
+func handleDelete(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := ref.validate(); err != nil {
+		return err
 	}
 
-	if err := initDB(); err != nil {
+	b, err := g.open()
+	if err != nil {
 		return err
 	}
-	defer database.CloseDB()
+	defer b.Close()
 
-	apiSvc := service.APIUserService{}
-	if err := apiSvc.DeleteUser(*id); err != nil {
+	user, err := ref.resolve(b)
+	if err != nil {
 		return err
 	}
-	fmt.Printf("API user %d deleted\n", *id)
-	return nil
+	if err := b.DeleteUser(user.Id); err != nil {
+		return err
+	}
+	return g.renderAction(actionResult{
+		ID:      user.Id,
+		Name:    user.Name,
+		Action:  "delete",
+		Message: fmt.Sprintf("API user %d deleted", user.Id),
+	})
 }
 
-func handleRotate(args []string) error {
-	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
-	id := fs.Int("id", 0, "API user id")
-	fs.Parse(args)
+// rotateResult is the output of the rotate command.
+type rotateResult struct {
+	ID     int    `json:"id" yaml:"id"`
+	Name   string `json:"name" yaml:"name"`
+	Secret string `json:"secret" yaml:"secret"`
+}
 
-	if *id <= 0 {
-		return fmt.Errorf("-id must be provided")
+func handleRotate(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := ref.validate(); err != nil {
+		return err
 	}
 
-	if err := initDB(); err != nil {
+	b, err := g.open()
+	if err != nil {
 		return err
 	}
-	defer database.CloseDB()
+	defer b.Close()
 
-	apiSvc := service.APIUserService{}
-	token, err := apiSvc.RotateToken(*id)
+	user, err := ref.resolve(b)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("API user %d token rotated\n", *id)
-	fmt.Printf("New token (store securely, shown once): %s\n", token)
-	return nil
+	token, err := b.RotateToken(user.Id)
+	if err != nil {
+		return err
+	}
+	result := rotateResult{ID: user.Id, Name: user.Name, Secret: token}
+	return g.render(result, func(w io.Writer) {
+		fmt.Fprintf(w, "API user %d token rotated\n", user.Id)
+		fmt.Fprintf(w, "New token (store securely, shown once): %s\n", token)
+	})
 }
 
-func handleRate(args []string) error {
-	fs := flag.NewFlagSet("rate", flag.ExitOnError)
-	id := fs.Int("id", 0, "API user id")
+func handleRate(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("rate", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
 	rate := fs.Int("rate", 0, "per-minute rate limit (0 = unlimited/default)")
-	fs.Parse(args)
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := ref.validate(); err != nil {
+		return err
+	}
+	if *rate < 0 {
+		return usageErrorf("-rate must be >= 0")
+	}
 
-	if *id <= 0 {
-		return fmt.Errorf("-id must be provided")
+	b, err := g.open()
+	if err != nil {
+		return err
 	}
+	defer b.Close()
 
-	if err := initDB(); err != nil {
+	user, err := ref.resolve(b)
+	if err != nil {
 		return err
 	}
-	defer database.CloseDB()
+	if err := b.UpdateRateLimit(user.Id, *rate); err != nil {
+		return err
+	}
+	return g.renderAction(actionResult{
+		ID:      user.Id,
+		Name:    user.Name,
+		Action:  "rate",
+		Message: fmt.Sprintf("API user %d rate limit set to %d requests/minute", user.Id, *rate),
+	})
+}
 
-	apiSvc := service.APIUserService{}
-	if err := apiSvc.UpdateRateLimit(*id, *rate); err != nil {
+func handleOrigins(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("origins", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	originsFlag := fs.String("origins", "", "comma separated origins like https://dash.example.com (empty = any origin)")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
 		return err
 	}
-	fmt.Printf("API user %d rate limit set to %d requests/minute\n", *id, *rate)
-	return nil
+	if err := ref.validate(); err != nil {
+		return err
+	}
+	origins, err := service.ParseOrigins(*originsFlag)
+	if err != nil {
+		return usageErrorf("-origins: %w", err)
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	user, err := ref.resolve(b)
+	if err != nil {
+		return err
+	}
+	if err := b.UpdateOrigins(user.Id, origins); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("API user %d may be used from any origin", user.Id)
+	if origins != "" {
+		message = fmt.Sprintf("API user %d restricted to origins %s", user.Id, strings.ReplaceAll(origins, ",", ", "))
+	}
+	return g.renderAction(actionResult{
+		ID:      user.Id,
+		Name:    user.Name,
+		Action:  "origins",
+		Message: message,
+	})
+}
+
+func handleWindow(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("window", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	windowFlag := fs.String("window", "", `weekdays and hours in the panel time zone, e.g. "mon-fri 09:00-18:00; sat 10:00-14:00" (empty = any time)`)
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := ref.validate(); err != nil {
+		return err
+	}
+	window, err := service.NormalizeAPIAccessWindow(*windowFlag)
+	if err != nil {
+		return usageErrorf("-window: %w", err)
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	user, err := ref.resolve(b)
+	if err != nil {
+		return err
+	}
+	if err := b.UpdateAccessWindow(user.Id, window); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("API user %d may be used at any time", user.Id)
+	if window != "" {
+		message = fmt.Sprintf("API user %d restricted to %s", user.Id, window)
+	}
+	return g.renderAction(actionResult{
+		ID:      user.Id,
+		Name:    user.Name,
+		Action:  "window",
+		Message: message,
+	})
+}
+
+func handleInbounds(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("inbounds", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	allowFlag := fs.String("allow", "", "comma-separated inbounds, as id:N, tag:PATTERN or port:FROM-TO (empty = all inbounds)")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := ref.validate(); err != nil {
+		return err
+	}
+	allowlist, err := service.NormalizeAPIInboundScope(*allowFlag)
+	if err != nil {
+		return usageErrorf("-allow: %w", err)
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	user, err := ref.resolve(b)
+	if err != nil {
+		return err
+	}
+	if err := b.UpdateInboundScope(user.Id, allowlist); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("API user %d may use all inbounds", user.Id)
+	if allowlist != "" {
+		message = fmt.Sprintf("API user %d restricted to inbounds %s", user.Id, allowlist)
+	}
+	return g.renderAction(actionResult{
+		ID:      user.Id,
+		Name:    user.Name,
+		Action:  "inbounds",
+		Message: message,
+	})
 }
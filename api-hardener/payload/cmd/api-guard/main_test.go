@@ -0,0 +1,132 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/controller"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// testPanelURL serves the panel API from the test database, which local commands use
+// too, and testAdminToken is an admin API token of that panel.
+var testPanelURL, testAdminToken string
+
+// TestMain runs the tests against a fresh panel database in a temporary XUI_DB_FOLDER,
+// with a fixed token pepper and without the api-guard settings of the environment.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "api-guard-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XUI_DB_FOLDER", dir)
+	os.Setenv(service.APITokenPepperEnv, "0123456789abcdef0123456789abcdef")
+	os.Setenv("API_GUARD_CONFIG", filepath.Join(dir, "profiles.json"))
+	for _, env := range []string{"API_GUARD_ENDPOINT", "API_GUARD_TOKEN", "API_GUARD_PROFILE"} {
+		os.Unsetenv(env)
+	}
+	if err := initDB(); err != nil {
+		panic(err)
+	}
+	if _, testAdminToken, err = (&service.APIUserService{}).CreateUser("guard-admin", 0, []model.APIScope{model.APIScopeAdmin}); err != nil {
+		panic(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	controller.NewAPIController(engine.Group("/"))
+	server := httptest.NewServer(engine)
+	testPanelURL = server.URL + "/"
+
+	code := m.Run()
+	server.Close()
+	database.CloseDB()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// guard runs api-guard with args as given on the command line and returns its output.
+func guard(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	g, rest, err := parseGlobalOptions(args)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := captureStdout(t, func() error { return run(g, rest) })
+
+	// Local commands close the database when they are done; the test panel still needs it.
+	if !g.remote() {
+		if err := initDB(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out, err
+}
+
+// captureStdout returns what f prints to os.Stdout.
+func captureStdout(t *testing.T, f func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	printed := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		printed <- string(data)
+	}()
+	err = f()
+	os.Stdout = stdout
+	w.Close()
+	out := <-printed
+	r.Close()
+	return out, err
+}
+
+func TestOrigins(t *testing.T) {
+	users := &service.APIUserService{}
+	user, _, err := users.CreateUser("origins-dashboard", 0, []model.APIScope{model.APIScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { users.DeleteUser(user.Id) })
+	remote := []string{"-endpoint", testPanelURL, "-token", testAdminToken}
+
+	tests := []struct {
+		name     string
+		args     []string
+		origins  string
+		wantCode int
+		want     string // AllowedOrigins afterwards
+	}{
+		{"local", nil, "https://Dash.example.com/, https://b.example", exitOK, "https://dash.example.com,https://b.example"},
+		{"invalid", nil, "https://dash.example.com/app", exitUsage, "https://dash.example.com,https://b.example"},
+		{"remote", remote, "https://c.example", exitOK, "https://c.example"},
+		{"lift", nil, "", exitOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append(append([]string{}, tt.args...), "origins", "-name", "origins-dashboard", "-origins", tt.origins)
+			if _, err := guard(t, args...); exitCode(err) != tt.wantCode {
+				t.Fatalf("exit %d (%v), want %d", exitCode(err), err, tt.wantCode)
+			}
+			got, err := users.GetUser(user.Id)
+			if err != nil || got.AllowedOrigins != tt.want {
+				t.Fatalf("AllowedOrigins = %q (%v), want %q", got.AllowedOrigins, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/pkg/client"
+)
+
+// Exit codes shared by every command.
+const (
+	exitOK         = 0
+	exitError      = 1 // unexpected failure
+	exitUsage      = 2 // invalid flags or input (also used by the flag package)
+	exitNotFound   = 3 // the referenced API user does not exist
+	exitBackend    = 4 // database or remote panel failure
+	exitPermission = 5 // remote panel rejected the token or its scopes
+	exitFindings   = 6 // doctor found issues at or above -fail-on
+	exitConflict   = 7 // patch/rollback refused: unsupported version or modified files
+	exitSignature  = 8 // missing, invalid or untrusted signature
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// cliError carries the exit code for an error.
+type cliError struct {
+	code int
+	err  error
+}
+
+func (e *cliError) Error() string { return e.err.Error() }
+func (e *cliError) Unwrap() error { return e.err }
+
+func usageErrorf(format string, args ...any) error {
+	return &cliError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+func notFoundErrorf(format string, args ...any) error {
+	return &cliError{code: exitNotFound, err: fmt.Errorf(format, args...)}
+}
+
+// exitCode classifies err into one of the exit codes above.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var ce *cliError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, client.ErrNotFound) {
+		return exitNotFound
+	}
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return exitPermission
+		}
+		return exitBackend
+	}
+	if errors.Is(err, errLocalOnly) {
+		return exitUsage
+	}
+	return exitBackend
+}
+
+// addOutputFlag lets a subcommand override the global --output option.
+func (g *globalOptions) addOutputFlag(fs *flag.FlagSet) {
+	usage := "output format: table, json or yaml"
+	fs.StringVar(&g.output, "output", g.output, usage)
+	fs.StringVar(&g.output, "o", g.output, usage+" (shorthand)")
+}
+
+func validateOutput(output string) error {
+	switch output {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	}
+	return usageErrorf("unknown output format %q (use table, json or yaml)", output)
+}
+
+// render writes v as JSON/YAML, or calls table for the human-readable form.
+func (g *globalOptions) render(v any, table func(w io.Writer)) error {
+	if err := validateOutput(g.output); err != nil {
+		return err
+	}
+	switch g.output {
+	case outputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// reportError prints err to stderr, as a JSON/YAML document when structured output was requested.
+func (g *globalOptions) reportError(err error) {
+	output := outputTable
+	if g != nil {
+		output = g.output
+	}
+	doc := map[string]any{"error": err.Error(), "exitCode": exitCode(err)}
+	switch output {
+	case outputJSON:
+		json.NewEncoder(os.Stderr).Encode(doc)
+	case outputYAML:
+		yaml.NewEncoder(os.Stderr).Encode(doc)
+	default:
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+}
+
+// userView is the stable, documented shape of an API user in command output.
+type userView struct {
+	ID                 int       `json:"id" yaml:"id"`
+	Name               string    `json:"name" yaml:"name"`
+	Enabled            bool      `json:"enabled" yaml:"enabled"`
+	Scopes             []string  `json:"scopes" yaml:"scopes"`
+	RateLimitPerMinute int       `json:"rateLimitPerMinute" yaml:"rateLimitPerMinute"`
+	EffectiveRateLimit *int      `json:"effectiveRateLimit,omitempty" yaml:"effectiveRateLimit,omitempty"`
+	Token              tokenView `json:"token" yaml:"token"`
+	Cert               *certView `json:"cert,omitempty" yaml:"cert,omitempty"`
+	Peer               *peerView `json:"peer,omitempty" yaml:"peer,omitempty"`
+	Origins            []string  `json:"origins,omitempty" yaml:"origins,omitempty"` // browser origins the user is restricted to
+	AccessWindow       string    `json:"accessWindow,omitempty" yaml:"accessWindow,omitempty"`
+	Inbounds           string    `json:"inbounds,omitempty" yaml:"inbounds,omitempty"` // inbound allowlist
+	Usage              usageView `json:"usage" yaml:"usage"`
+	CreatedAt          time.Time `json:"createdAt" yaml:"createdAt"`
+	UpdatedAt          time.Time `json:"updatedAt" yaml:"updatedAt"`
+	Secret             string    `json:"secret,omitempty" yaml:"secret,omitempty"` // plaintext token, only right after create/rotate
+}
+
+type tokenView struct {
+	Prefix   string     `json:"prefix" yaml:"prefix"`
+	IssuedAt *time.Time `json:"issuedAt,omitempty" yaml:"issuedAt,omitempty"`
+}
+
+// certView is the client certificate binding of an API user.
+type certView struct {
+	Fingerprint string `json:"fingerprint,omitempty" yaml:"fingerprint,omitempty"`
+	Subject     string `json:"subject,omitempty" yaml:"subject,omitempty"`
+}
+
+// peerView is the unix socket caller mapping of an API user.
+type peerView struct {
+	UID *int `json:"uid,omitempty" yaml:"uid,omitempty"`
+	GID *int `json:"gid,omitempty" yaml:"gid,omitempty"`
+}
+
+type usageView struct {
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty" yaml:"lastUsedAt,omitempty"`
+	RequestCount int64      `json:"requestCount" yaml:"requestCount"`
+}
+
+func newUserView(u *model.APIUser) userView {
+	scopes := make([]string, 0)
+	for _, scope := range u.ScopeList() {
+		scopes = append(scopes, string(scope))
+	}
+	var cert *certView
+	if u.CertFingerprint != "" || u.CertSubject != "" {
+		cert = &certView{Fingerprint: u.CertFingerprint, Subject: u.CertSubject}
+	}
+	var peer *peerView
+	if u.PeerUID != nil || u.PeerGID != nil {
+		peer = &peerView{UID: u.PeerUID, GID: u.PeerGID}
+	}
+	var origins []string
+	if u.AllowedOrigins != "" {
+		origins = strings.Split(u.AllowedOrigins, ",")
+	}
+	return userView{
+		ID:                 u.Id,
+		Name:               u.Name,
+		Enabled:            u.Enabled,
+		Scopes:             scopes,
+		RateLimitPerMinute: u.RateLimitPerMinute,
+		Token:              tokenView{Prefix: u.TokenPrefix, IssuedAt: u.TokenIssuedAt},
+		Cert:               cert,
+		Peer:               peer,
+		Origins:            origins,
+		AccessWindow:       u.AccessWindow,
+		Inbounds:           u.InboundAllowlist,
+		Usage:              usageView{LastUsedAt: u.LastUsedAt, RequestCount: u.RequestCount},
+		CreatedAt:          u.CreatedAt,
+		UpdatedAt:          u.UpdatedAt,
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// actionResult is printed by commands that change a single user without returning data.
+type actionResult struct {
+	ID      int    `json:"id" yaml:"id"`
+	Name    string `json:"name,omitempty" yaml:"name,omitempty"`
+	Action  string `json:"action" yaml:"action"`
+	Message string `json:"message" yaml:"message"`
+}
+
+func (g *globalOptions) renderAction(r actionResult) error {
+	return g.render(r, func(w io.Writer) {
+		fmt.Fprintln(w, r.Message)
+	})
+}
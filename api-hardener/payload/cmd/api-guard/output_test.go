@@ -0,0 +1,120 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/pkg/client"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, exitOK},
+		{"usage", usageErrorf("bad flag"), exitUsage},
+		{"wrapped usage", fmt.Errorf("apply: %w", usageErrorf("bad flag")), exitUsage},
+		{"not found", notFoundErrorf("no user"), exitNotFound},
+		{"record not found", fmt.Errorf("get: %w", gorm.ErrRecordNotFound), exitNotFound},
+		{"panel 404", &client.Error{StatusCode: http.StatusNotFound}, exitNotFound},
+		{"panel 401", &client.Error{StatusCode: http.StatusUnauthorized}, exitPermission},
+		{"panel 403", &client.Error{StatusCode: http.StatusForbidden}, exitPermission},
+		{"panel 500", &client.Error{StatusCode: http.StatusInternalServerError}, exitBackend},
+		{"panel rejected", &client.Error{StatusCode: http.StatusOK, Message: "exists"}, exitBackend},
+		{"local only", fmt.Errorf("cert: %w", errLocalOnly), exitUsage},
+		{"explicit code", &cliError{code: exitSignature, err: errors.New("unsigned")}, exitSignature},
+		{"anything else", errors.New("disk full"), exitBackend},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Fatalf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateOutput(t *testing.T) {
+	tests := []struct {
+		output  string
+		wantErr bool
+	}{
+		{outputTable, false},
+		{outputJSON, false},
+		{outputYAML, false},
+		{"", true},
+		{"JSON", true},
+		{"xml", true},
+	}
+	for _, tt := range tests {
+		err := validateOutput(tt.output)
+		if (err != nil) != tt.wantErr || (err != nil && exitCode(err) != exitUsage) {
+			t.Errorf("validateOutput(%q) = %v, want error %v", tt.output, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewUserView(t *testing.T) {
+	uid := 1000
+	issued := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		user model.APIUser
+		want userView
+	}{
+		{"plain", model.APIUser{Id: 1, Name: "plain", Enabled: true, Scopes: "read", TokenPrefix: "xui_live_ab"},
+			userView{ID: 1, Name: "plain", Enabled: true, Scopes: []string{"read"}, Token: tokenView{Prefix: "xui_live_ab"}}},
+		{"bindings", model.APIUser{Id: 2, Name: "bound", Scopes: "read,write", TokenIssuedAt: &issued,
+			CertFingerprint: "aa:bb", PeerUID: &uid, AllowedOrigins: "https://a.example,https://b.example",
+			AccessWindow: "Mon-Fri 09:00-18:00", InboundAllowlist: "1,2", RequestCount: 7},
+			userView{ID: 2, Name: "bound", Scopes: []string{"read", "write"}, Token: tokenView{IssuedAt: &issued},
+				Cert: &certView{Fingerprint: "aa:bb"}, Peer: &peerView{UID: &uid},
+				Origins: []string{"https://a.example", "https://b.example"}, AccessWindow: "Mon-Fri 09:00-18:00",
+				Inbounds: "1,2", Usage: usageView{RequestCount: 7}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newUserView(&tt.user); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("newUserView = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	result := actionResult{ID: 4, Name: "ops", Action: "disable", Message: "API user 4 disabled"}
+	tests := []struct {
+		output  string
+		want    string
+		wantErr bool
+	}{
+		{outputTable, "API user 4 disabled\n", false},
+		{outputJSON, "{\n  \"id\": 4,\n  \"name\": \"ops\",\n  \"action\": \"disable\",\n  \"message\": \"API user 4 disabled\"\n}\n", false},
+		{outputYAML, "id: 4\nname: ops\naction: disable\nmessage: API user 4 disabled\n", false},
+		{"xml", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			g := &globalOptions{output: tt.output}
+			out, err := captureStdout(t, func() error {
+				return g.render(result, func(w io.Writer) { fmt.Fprintln(w, result.Message) })
+			})
+			if (err != nil) != tt.wantErr || out != tt.want {
+				t.Fatalf("render = %q, %v; want %q", out, err, tt.want)
+			}
+		})
+	}
+}
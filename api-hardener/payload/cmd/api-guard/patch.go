@@ -0,0 +1,688 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	manifestName   = "manifest.json"
+	manifestFormat = 1
+	backupRoot     = ".api-guard/backups"
+	stageSuffix    = ".api-guard-tmp"
+)
+
+// moduleFiles are backed up on every patch so that rollback also undoes a
+// later go mod tidy, which is needed for the payload's extra dependencies.
+var moduleFiles = []string{"go.mod", "go.sum"}
+
+// manifest describes the payload and the upstream files it replaces.
+type manifest struct {
+	Format int               `json:"format"`
+	Files  map[string]string `json:"files"` // payload path -> sha256
+	// Upstream maps a 3x-ui version (config/version) to the sha256 of every
+	// payload path in that release; "" means the file does not exist upstream.
+	Upstream map[string]map[string]string `json:"upstream"`
+}
+
+// backupFile is one file saved before patching.
+type backupFile struct {
+	Path          string `json:"path"`
+	Existed       bool   `json:"existed"`
+	OriginalHash  string `json:"originalSha256,omitempty"`
+	PatchedHash   string `json:"patchedSha256,omitempty"`
+	Mode          uint32 `json:"mode,omitempty"`
+	Module        bool   `json:"module,omitempty"` // go.mod/go.sum, restored without a hash check
+	originalBytes []byte
+}
+
+// backupRecord is stored as backup.json next to the saved files.
+type backupRecord struct {
+	ID         string       `json:"id"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	Version    string       `json:"version"`
+	Files      []backupFile `json:"files"`
+	RolledBack *time.Time   `json:"rolledBack,omitempty"`
+}
+
+// patchFile is the planned or performed action for one path.
+type patchFile struct {
+	Path   string `json:"path" yaml:"path"`
+	Action string `json:"action" yaml:"action"` // replace, create, unchanged, conflict, restore, remove
+	Reason string `json:"reason,omitempty" yaml:"reason,omitempty"`
+}
+
+// patchResult is the output of the patch and rollback commands.
+type patchResult struct {
+	Version string      `json:"version" yaml:"version"`
+	Backup  string      `json:"backup,omitempty" yaml:"backup,omitempty"`
+	DryRun  bool        `json:"dryRun" yaml:"dryRun"`
+	Files   []patchFile `json:"files" yaml:"files"`
+}
+
+func (r patchResult) print(w io.Writer) {
+	fmt.Fprintf(w, "3x-ui version: %s\n", r.Version)
+	fmt.Fprintln(w, "ACTION\tPATH\tREASON")
+	for _, f := range r.Files {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Action, f.Path, f.Reason)
+	}
+	switch {
+	case r.DryRun:
+		fmt.Fprintln(w, "Dry run: nothing was written.")
+	case r.Backup != "":
+		fmt.Fprintf(w, "Backup: %s\n", r.Backup)
+	}
+}
+
+func (r patchResult) conflicts() int {
+	n := 0
+	for _, f := range r.Files {
+		if f.Action == "conflict" {
+			n++
+		}
+	}
+	return n
+}
+
+func (r patchResult) changes() int {
+	n := 0
+	for _, f := range r.Files {
+		if f.Action == "replace" || f.Action == "create" {
+			n++
+		}
+	}
+	return n
+}
+
+func conflictErrorf(format string, args ...any) error {
+	return &cliError{code: exitConflict, err: fmt.Errorf(format, args...)}
+}
+
+func fileHash(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// targetVersion reads the 3x-ui version embedded from config/version.
+func targetVersion(target string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(target, "config", "version"))
+	if err != nil {
+		return "", usageErrorf("%s does not look like a 3x-ui source tree: %v", target, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func loadManifest(payload string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(payload, manifestName))
+	if err != nil {
+		return nil, usageErrorf("read payload manifest: %v", err)
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, usageErrorf("parse payload manifest: %v", err)
+	}
+	if m.Format != manifestFormat {
+		return nil, usageErrorf("unsupported manifest format %d", m.Format)
+	}
+	return m, nil
+}
+
+// payloadFiles lists the files of a payload directory relative to it.
+func payloadFiles(payload string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.WalkDir(payload, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(payload, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == manifestName || strings.HasPrefix(rel, "api-guard") {
+			return nil // the manifest itself and prebuilt binaries
+		}
+		files = append(files, rel)
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// planPatch compares the target tree with the manifest. It never writes.
+func planPatch(m *manifest, payload, target, version string, force bool) (patchResult, error) {
+	result := patchResult{Version: version, Files: make([]patchFile, 0, len(m.Files))}
+	upstream, supported := m.Upstream[version]
+	if !supported && !force {
+		known := make([]string, 0, len(m.Upstream))
+		for v := range m.Upstream {
+			known = append(known, v)
+		}
+		sort.Strings(known)
+		return result, conflictErrorf("3x-ui %s is not supported by this payload (supported: %s); use -force to patch anyway",
+			version, strings.Join(known, ", "))
+	}
+
+	present, err := payloadFiles(payload)
+	if err != nil {
+		return result, err
+	}
+	for _, rel := range present {
+		if _, ok := m.Files[rel]; !ok {
+			return result, usageErrorf("payload file %s is not listed in the manifest", rel)
+		}
+	}
+
+	paths := make([]string, 0, len(m.Files))
+	for rel := range m.Files {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	for _, rel := range paths {
+		payloadHash, err := fileHash(filepath.Join(payload, filepath.FromSlash(rel)))
+		if err != nil {
+			return result, err
+		}
+		if payloadHash != m.Files[rel] {
+			return result, usageErrorf("payload file %s does not match the manifest", rel)
+		}
+
+		f := patchFile{Path: rel}
+		current, err := fileHash(filepath.Join(target, filepath.FromSlash(rel)))
+		exists := err == nil
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return result, err
+		}
+		expected, known := upstream[rel]
+
+		switch {
+		case exists && current == payloadHash:
+			f.Action = "unchanged"
+			f.Reason = "already patched"
+		case !exists && known && expected == "":
+			f.Action = "create"
+		case exists && known && current == expected:
+			f.Action = "replace"
+		case force:
+			f.Action = "replace"
+			if !exists {
+				f.Action = "create"
+			}
+			f.Reason = "forced: target differs from upstream " + version
+		case !exists:
+			f.Action = "conflict"
+			f.Reason = "missing in target"
+		case !known || expected == "":
+			f.Action = "conflict"
+			f.Reason = "exists in target but not upstream"
+		default:
+			f.Action = "conflict"
+			f.Reason = "modified locally or changed upstream"
+		}
+		result.Files = append(result.Files, f)
+	}
+	return result, nil
+}
+
+func handlePatch(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("patch", flag.ContinueOnError)
+	payload := fs.String("payload", ".", "payload directory containing manifest.json")
+	target := fs.String("target", "", "3x-ui source tree to patch (required)")
+	force := fs.Bool("force", false, "patch unsupported versions and locally modified files")
+	dryRun := fs.Bool("dry-run", false, "only show what would change")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *target == "" {
+		return usageErrorf("-target is required")
+	}
+	if g.remote() {
+		return errLocalOnly
+	}
+
+	m, err := loadManifest(*payload)
+	if err != nil {
+		return err
+	}
+	version, err := targetVersion(*target)
+	if err != nil {
+		return err
+	}
+	result, err := planPatch(m, *payload, *target, version, *force)
+	if err != nil {
+		return err
+	}
+	result.DryRun = *dryRun
+	if n := result.conflicts(); n > 0 || *dryRun {
+		if err := g.render(result, result.print); err != nil {
+			return err
+		}
+		if n > 0 {
+			return conflictErrorf("%d file(s) differ from upstream 3x-ui %s; nothing was changed (use -force to override)", n, version)
+		}
+		return nil
+	}
+
+	if result.changes() == 0 {
+		return g.render(result, result.print)
+	}
+
+	record, err := writeBackup(*target, version, *payload, result.Files)
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	result.Backup = filepath.Join(*target, filepath.FromSlash(backupRoot), record.ID)
+
+	writes := map[string][]byte{}
+	for _, f := range result.Files {
+		if f.Action != "replace" && f.Action != "create" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(*payload, filepath.FromSlash(f.Path)))
+		if err != nil {
+			return err
+		}
+		writes[f.Path] = data
+	}
+	if err := applyFiles(*target, writes, nil, record); err != nil {
+		return err
+	}
+	return g.render(result, result.print)
+}
+
+// writeBackup saves the files about to be replaced plus go.mod/go.sum.
+func writeBackup(target, version, payload string, files []patchFile) (*backupRecord, error) {
+	now := time.Now().UTC()
+	record := &backupRecord{ID: now.Format("20060102T150405Z"), CreatedAt: now, Version: version}
+	dir := filepath.Join(target, filepath.FromSlash(backupRoot), record.ID)
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0o700); err != nil {
+		return nil, err
+	}
+
+	save := func(rel string, module bool) error {
+		bf := backupFile{Path: rel, Module: module}
+		path := filepath.Join(target, filepath.FromSlash(rel))
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if module {
+				return nil
+			}
+		case err != nil:
+			return err
+		default:
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(data)
+			bf.Existed = true
+			bf.OriginalHash = hex.EncodeToString(sum[:])
+			bf.Mode = uint32(info.Mode().Perm())
+			bf.originalBytes = data
+			saved := filepath.Join(dir, "files", filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(saved), 0o700); err != nil {
+				return err
+			}
+			if err := os.WriteFile(saved, data, 0o600); err != nil {
+				return err
+			}
+		}
+		if !module {
+			hash, err := fileHash(filepath.Join(payload, filepath.FromSlash(rel)))
+			if err != nil {
+				return err
+			}
+			bf.PatchedHash = hash
+		}
+		record.Files = append(record.Files, bf)
+		return nil
+	}
+
+	for _, f := range files {
+		if f.Action == "replace" || f.Action == "create" {
+			if err := save(f.Path, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, rel := range moduleFiles {
+		if err := save(rel, true); err != nil {
+			return nil, err
+		}
+	}
+	return record, saveBackupRecord(target, record)
+}
+
+func saveBackupRecord(target string, record *backupRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(target, filepath.FromSlash(backupRoot), record.ID, "backup.json")
+	return os.WriteFile(path, data, 0o600)
+}
+
+// applyFiles stages every write next to its destination and then renames them
+// into place. If a rename fails, files already moved are restored from record.
+func applyFiles(target string, writes map[string][]byte, removes []string, record *backupRecord) error {
+	modes := map[string]os.FileMode{}
+	for _, bf := range record.Files {
+		if bf.Mode != 0 {
+			modes[bf.Path] = os.FileMode(bf.Mode)
+		}
+	}
+
+	paths := make([]string, 0, len(writes))
+	for rel := range writes {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	staged := make([]string, 0, len(paths))
+	cleanup := func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}
+	for _, rel := range paths {
+		dest := filepath.Join(target, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			cleanup()
+			return err
+		}
+		mode, ok := modes[rel]
+		if !ok {
+			mode = 0o644
+		}
+		tmp := dest + stageSuffix
+		if err := writeSynced(tmp, writes[rel], mode); err != nil {
+			cleanup()
+			return fmt.Errorf("stage %s: %w", rel, err)
+		}
+		staged = append(staged, tmp)
+	}
+
+	done := make([]string, 0, len(paths))
+	for i, rel := range paths {
+		dest := filepath.Join(target, filepath.FromSlash(rel))
+		if err := os.Rename(staged[i], dest); err != nil {
+			staged = staged[i:]
+			cleanup()
+			if rerr := restoreFiles(target, record, done); rerr != nil {
+				return fmt.Errorf("apply %s: %w (restore failed: %v)", rel, err, rerr)
+			}
+			return fmt.Errorf("apply %s: %w (changes were reverted)", rel, err)
+		}
+		done = append(done, rel)
+	}
+	for _, rel := range removes {
+		if err := os.Remove(filepath.Join(target, filepath.FromSlash(rel))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFiles puts back the original content of paths from record.
+func restoreFiles(target string, record *backupRecord, paths []string) error {
+	byPath := map[string]backupFile{}
+	for _, bf := range record.Files {
+		byPath[bf.Path] = bf
+	}
+	var errs []error
+	for _, rel := range paths {
+		bf := byPath[rel]
+		dest := filepath.Join(target, filepath.FromSlash(rel))
+		if !bf.Existed {
+			if err := os.Remove(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if err := writeSynced(dest, bf.originalBytes, os.FileMode(bf.Mode)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func writeSynced(path string, data []byte, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadBackups returns the backups of target, newest first.
+func loadBackups(target string) ([]*backupRecord, error) {
+	root := filepath.Join(target, filepath.FromSlash(backupRoot))
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	records := make([]*backupRecord, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(root, e.Name(), "backup.json"))
+		if err != nil {
+			continue // incomplete backup
+		}
+		record := &backupRecord{}
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, fmt.Errorf("parse backup %s: %w", e.Name(), err)
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID > records[j].ID })
+	return records, nil
+}
+
+// backupView is how backups are listed by rollback -list.
+type backupView struct {
+	ID         string     `json:"id" yaml:"id"`
+	CreatedAt  time.Time  `json:"createdAt" yaml:"createdAt"`
+	Version    string     `json:"version" yaml:"version"`
+	Files      int        `json:"files" yaml:"files"`
+	RolledBack *time.Time `json:"rolledBack,omitempty" yaml:"rolledBack,omitempty"`
+}
+
+func handleRollback(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	target := fs.String("target", "", "patched 3x-ui source tree (required)")
+	backupID := fs.String("backup", "", "backup id to restore (default: latest not rolled back)")
+	list := fs.Bool("list", false, "list backups instead of restoring")
+	force := fs.Bool("force", false, "restore even if patched files were modified since")
+	dryRun := fs.Bool("dry-run", false, "only show what would be restored")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *target == "" {
+		return usageErrorf("-target is required")
+	}
+	if g.remote() {
+		return errLocalOnly
+	}
+
+	records, err := loadBackups(*target)
+	if err != nil {
+		return err
+	}
+	if *list {
+		views := make([]backupView, 0, len(records))
+		for _, r := range records {
+			views = append(views, backupView{ID: r.ID, CreatedAt: r.CreatedAt, Version: r.Version, Files: len(r.Files), RolledBack: r.RolledBack})
+		}
+		return g.render(views, func(w io.Writer) {
+			fmt.Fprintln(w, "ID\tVERSION\tFILES\tROLLED BACK")
+			for _, v := range views {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", v.ID, v.Version, v.Files, formatTime(v.RolledBack))
+			}
+		})
+	}
+
+	var record *backupRecord
+	for _, r := range records {
+		if (*backupID == "" && r.RolledBack == nil) || r.ID == *backupID {
+			record = r
+			break
+		}
+	}
+	if record == nil {
+		if *backupID != "" {
+			return notFoundErrorf("backup %s not found", *backupID)
+		}
+		return notFoundErrorf("no backup to roll back in %s", *target)
+	}
+
+	dir := filepath.Join(*target, filepath.FromSlash(backupRoot), record.ID)
+	result := patchResult{Version: record.Version, Backup: dir, DryRun: *dryRun, Files: make([]patchFile, 0, len(record.Files))}
+	writes := map[string][]byte{}
+	removes := make([]string, 0)
+	for i := range record.Files {
+		bf := &record.Files[i]
+		f := patchFile{Path: bf.Path, Action: "restore"}
+		if !bf.Existed {
+			f.Action = "remove"
+		}
+		current, err := fileHash(filepath.Join(*target, filepath.FromSlash(bf.Path)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if !bf.Module && current != bf.PatchedHash && !*force {
+			f.Action = "conflict"
+			f.Reason = "modified after patching"
+		}
+		if bf.Existed {
+			if bf.originalBytes, err = os.ReadFile(filepath.Join(dir, "files", filepath.FromSlash(bf.Path))); err != nil {
+				return fmt.Errorf("backup %s is incomplete: %w", record.ID, err)
+			}
+			writes[bf.Path] = bf.originalBytes
+		} else {
+			removes = append(removes, bf.Path)
+		}
+		result.Files = append(result.Files, f)
+	}
+
+	if n := result.conflicts(); n > 0 || *dryRun {
+		if err := g.render(result, result.print); err != nil {
+			return err
+		}
+		if n > 0 {
+			return conflictErrorf("%d file(s) changed since backup %s; nothing was restored (use -force to override)", n, record.ID)
+		}
+		return nil
+	}
+
+	// Restoring works on a copy of the current state, so a failed rename
+	// during rollback returns the tree to the patched files.
+	current := &backupRecord{ID: record.ID}
+	for _, bf := range record.Files {
+		data, err := os.ReadFile(filepath.Join(*target, filepath.FromSlash(bf.Path)))
+		if errors.Is(err, os.ErrNotExist) {
+			current.Files = append(current.Files, backupFile{Path: bf.Path})
+			continue
+		} else if err != nil {
+			return err
+		}
+		current.Files = append(current.Files, backupFile{Path: bf.Path, Existed: true, Mode: bf.Mode, originalBytes: data})
+	}
+	if err := applyFiles(*target, writes, removes, current); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	record.RolledBack = &now
+	if err := saveBackupRecord(*target, record); err != nil {
+		return err
+	}
+	return g.render(result, result.print)
+}
+
+func handleManifest(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("manifest", flag.ContinueOnError)
+	payload := fs.String("payload", ".", "payload directory")
+	upstream := fs.String("upstream", "", "clean 3x-ui checkout of the release the payload targets (required)")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *upstream == "" {
+		return usageErrorf("-upstream is required")
+	}
+	version, err := targetVersion(*upstream)
+	if err != nil {
+		return err
+	}
+
+	m := &manifest{Format: manifestFormat, Upstream: map[string]map[string]string{}}
+	if existing, err := loadManifest(*payload); err == nil {
+		m.Upstream = existing.Upstream
+	}
+	files, err := payloadFiles(*payload)
+	if err != nil {
+		return err
+	}
+
+	m.Files = make(map[string]string, len(files))
+	hashes := make(map[string]string, len(files))
+	for _, rel := range files {
+		if m.Files[rel], err = fileHash(filepath.Join(*payload, filepath.FromSlash(rel))); err != nil {
+			return err
+		}
+		hash, err := fileHash(filepath.Join(*upstream, filepath.FromSlash(rel)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		hashes[rel] = hash
+	}
+	m.Upstream[version] = hashes
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(*payload, manifestName), append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+
+	result := actionResult{Action: "manifest", Message: fmt.Sprintf("manifest.json updated: %d files, 3x-ui %s", len(files), version)}
+	return g.renderAction(result)
+}
@@ -0,0 +1,219 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeTree writes files (path -> content) below dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestPayload writes a payload that replaces web/a.go of 3x-ui v1 and adds web/b.go.
+func newTestPayload(t *testing.T) string {
+	t.Helper()
+	payload := t.TempDir()
+	writeTree(t, payload, map[string]string{"web/a.go": "a patched", "web/b.go": "b added"})
+	m := manifest{
+		Format:   manifestFormat,
+		Files:    map[string]string{"web/a.go": sha256Hex("a patched"), "web/b.go": sha256Hex("b added")},
+		Upstream: map[string]map[string]string{"v1": {"web/a.go": sha256Hex("a upstream"), "web/b.go": ""}},
+	}
+	data, _ := json.Marshal(m)
+	writeTree(t, payload, map[string]string{manifestName: string(data)})
+	return payload
+}
+
+func TestPlanPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   map[string]string
+		payload  map[string]string // written over the payload
+		version  string
+		force    bool
+		want     []string // action:reason of web/a.go and web/b.go
+		wantCode int
+	}{
+		{"upstream tree", map[string]string{"web/a.go": "a upstream"}, nil, "v1", false,
+			[]string{"replace:", "create:"}, exitOK},
+		{"patched tree", map[string]string{"web/a.go": "a patched", "web/b.go": "b added"}, nil, "v1", false,
+			[]string{"unchanged:already patched", "unchanged:already patched"}, exitOK},
+		{"modified file", map[string]string{"web/a.go": "a local"}, nil, "v1", false,
+			[]string{"conflict:modified locally or changed upstream", "create:"}, exitOK},
+		{"file not upstream", map[string]string{"web/a.go": "a upstream", "web/b.go": "b local"}, nil, "v1", false,
+			[]string{"replace:", "conflict:exists in target but not upstream"}, exitOK},
+		{"missing file", map[string]string{}, nil, "v1", false,
+			[]string{"conflict:missing in target", "create:"}, exitOK},
+		{"forced over a modified file", map[string]string{"web/a.go": "a local"}, nil, "v1", true,
+			[]string{"replace:forced: target differs from upstream v1", "create:"}, exitOK},
+		{"unsupported version", map[string]string{"web/a.go": "a upstream"}, nil, "v2", false, nil, exitConflict},
+		{"forced unsupported version", map[string]string{"web/a.go": "a upstream"}, nil, "v2", true,
+			[]string{"replace:forced: target differs from upstream v2", "create:forced: target differs from upstream v2"}, exitOK},
+		{"tampered payload", map[string]string{"web/a.go": "a upstream"}, map[string]string{"web/a.go": "a evil"}, "v1", false, nil, exitUsage},
+		{"file outside the manifest", map[string]string{"web/a.go": "a upstream"}, map[string]string{"web/c.go": "c"}, "v1", false, nil, exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, target := newTestPayload(t), t.TempDir()
+			writeTree(t, payload, tt.payload)
+			writeTree(t, target, tt.target)
+			m, err := loadManifest(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := planPatch(m, payload, target, tt.version, tt.force)
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("planPatch = %v (exit %d), want exit %d", err, code, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			got := make([]string, 0, len(result.Files))
+			for _, f := range result.Files {
+				got = append(got, f.Action+":"+f.Reason)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("plan = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string // not written when empty
+		wantCode int
+	}{
+		{"manifest", `{"format":1,"files":{}}`, exitOK},
+		{"other format", `{"format":2}`, exitUsage},
+		{"not json", `format: 1`, exitUsage},
+		{"missing", "", exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.content != "" {
+				writeTree(t, dir, map[string]string{manifestName: tt.content})
+			}
+			if _, err := loadManifest(dir); exitCode(err) != tt.wantCode {
+				t.Fatalf("loadManifest = %v, want exit %d", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestApplyFilesRevertsOnFailure(t *testing.T) {
+	target := t.TempDir()
+	writeTree(t, target, map[string]string{"a.go": "a original", "z/busy": "keeps z a directory"})
+	record := &backupRecord{Files: []backupFile{
+		{Path: "a.go", Existed: true, Mode: 0o644, originalBytes: []byte("a original")},
+		{Path: "b.go"},
+		{Path: "z"},
+	}}
+
+	err := applyFiles(target, map[string][]byte{"a.go": []byte("a new"), "b.go": []byte("b new"), "z": []byte("z new")}, nil, record)
+	if err == nil || !strings.Contains(err.Error(), "changes were reverted") {
+		t.Fatalf("applyFiles = %v, want a reverted failure", err)
+	}
+	tests := []struct {
+		path string
+		want string // "" when the file must not exist
+	}{
+		{"a.go", "a original"},
+		{"b.go", ""},
+		{"z" + stageSuffix, ""},
+		{"z/busy", "keeps z a directory"},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join(target, tt.path))
+		if tt.want == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s exists after the failed apply", tt.path)
+			}
+		} else if string(data) != tt.want {
+			t.Errorf("%s = %q, %v; want %q", tt.path, data, err, tt.want)
+		}
+	}
+}
+
+func TestPatchRollback(t *testing.T) {
+	payload, target := newTestPayload(t), t.TempDir()
+	writeTree(t, target, map[string]string{"config/version": "v1\n", "web/a.go": "a upstream", "go.mod": "module x\n"})
+	read := func(rel string) string {
+		data, err := os.ReadFile(filepath.Join(target, filepath.FromSlash(rel)))
+		if os.IsNotExist(err) {
+			return "<none>"
+		}
+		return string(data)
+	}
+
+	steps := []struct {
+		name     string
+		args     []string
+		edit     map[string]string // target files changed before the step
+		wantCode int
+		wantA    string
+		wantB    string
+	}{
+		{"dry run", []string{"patch", "-dry-run"}, nil, exitOK, "a upstream", "<none>"},
+		{"patch", []string{"patch"}, nil, exitOK, "a patched", "b added"},
+		{"patch again", []string{"patch"}, nil, exitOK, "a patched", "b added"},
+		{"rollback over a local change", []string{"rollback"}, map[string]string{"web/b.go": "b local"}, exitConflict, "a patched", "b local"},
+		{"rollback dry run", []string{"rollback", "-dry-run"}, map[string]string{"web/b.go": "b added", "go.mod": "module x\n\nrequire y v1\n"}, exitOK, "a patched", "b added"},
+		{"rollback", []string{"rollback"}, nil, exitOK, "a upstream", "<none>"},
+		{"nothing to roll back", []string{"rollback"}, nil, exitNotFound, "a upstream", "<none>"},
+		{"unknown backup", []string{"rollback", "-backup", "19700101T000000Z"}, nil, exitNotFound, "a upstream", "<none>"},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			writeTree(t, target, tt.edit)
+			args := append(tt.args, "-target", target)
+			if tt.args[0] == "patch" {
+				args = append(args, "-payload", payload)
+			}
+			if _, err := guard(t, args...); exitCode(err) != tt.wantCode {
+				t.Fatalf("exit %d (%v), want %d", exitCode(err), err, tt.wantCode)
+			}
+			if a, b := read("web/a.go"), read("web/b.go"); a != tt.wantA || b != tt.wantB {
+				t.Fatalf("web/a.go = %q, web/b.go = %q; want %q, %q", a, b, tt.wantA, tt.wantB)
+			}
+		})
+	}
+	if got := read("go.mod"); got != "module x\n" {
+		t.Fatalf("go.mod after rollback = %q", got)
+	}
+
+	out, err := guard(t, "rollback", "-list", "-target", target, "-o", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backups []backupView
+	if err := json.Unmarshal([]byte(out), &backups); err != nil || len(backups) != 1 || backups[0].RolledBack == nil || backups[0].Files != 3 {
+		t.Fatalf("rollback -list = %s (%v)", out, err)
+	}
+}
@@ -0,0 +1,163 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os/user"
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// peerResult is the output of "peer bind" and "peer unbind".
+type peerResult struct {
+	User   string `json:"user" yaml:"user"`
+	Action string `json:"action" yaml:"action"`
+	UID    *int   `json:"uid,omitempty" yaml:"uid,omitempty"`
+	GID    *int   `json:"gid,omitempty" yaml:"gid,omitempty"`
+}
+
+func (r peerResult) print(w io.Writer) {
+	if r.Action == "unbound" {
+		fmt.Fprintf(w, "Unix socket mapping of %s removed\n", r.User)
+		return
+	}
+	fmt.Fprintf(w, "Unix socket callers with %s now act as %s\n", formatPeer(r.UID, r.GID), r.User)
+}
+
+func formatPeer(uid, gid *int) string {
+	switch {
+	case uid != nil && gid != nil:
+		return fmt.Sprintf("uid %d and gid %d", *uid, *gid)
+	case uid != nil:
+		return fmt.Sprintf("uid %d", *uid)
+	case gid != nil:
+		return fmt.Sprintf("gid %d", *gid)
+	}
+	return "none"
+}
+
+// handlePeer dispatches "peer bind" and "peer unbind", which map unix socket callers to
+// API users. UIDs only mean something on the panel host, so they run there only.
+func handlePeer(g *globalOptions, args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("peer requires a subcommand: bind or unbind")
+	}
+	if g.remote() {
+		return errLocalOnly
+	}
+	switch args[0] {
+	case "bind":
+		return handlePeerBind(g, args[1:])
+	case "unbind":
+		return handlePeerUnbind(g, args[1:])
+	default:
+		return usageErrorf("unknown peer subcommand %q (want bind or unbind)", args[0])
+	}
+}
+
+func handlePeerBind(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("peer bind", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	uidFlag := fs.String("uid", "", "UID or login name of the local callers")
+	gidFlag := fs.String("gid", "", "GID or group name of the local callers")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := ref.validate(); err != nil {
+		return err
+	}
+	if *uidFlag == "" && *gidFlag == "" {
+		return usageErrorf("at least one of -uid or -gid is required")
+	}
+	uid, err := lookupPeerID(*uidFlag, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return usageErrorf("-uid: %w", err)
+	}
+	gid, err := lookupPeerID(*gidFlag, func(name string) (string, error) {
+		group, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return group.Gid, nil
+	})
+	if err != nil {
+		return usageErrorf("-gid: %w", err)
+	}
+
+	b, err := openLocal()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	apiUser, err := ref.resolve(b)
+	if err != nil {
+		return err
+	}
+	if err := b.BindPeerCred(apiUser.Id, uid, gid); err != nil {
+		if errors.Is(err, service.ErrPeerMappingTaken) {
+			return &cliError{code: exitUsage, err: err}
+		}
+		return err
+	}
+	result := peerResult{User: apiUser.Name, Action: "bound", UID: uid, GID: gid}
+	return g.render(result, result.print)
+}
+
+func handlePeerUnbind(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("peer unbind", flag.ContinueOnError)
+	ref := addUserRefFlags(fs)
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := ref.validate(); err != nil {
+		return err
+	}
+
+	b, err := openLocal()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	apiUser, err := ref.resolve(b)
+	if err != nil {
+		return err
+	}
+	if err := b.BindPeerCred(apiUser.Id, nil, nil); err != nil {
+		return err
+	}
+	result := peerResult{User: apiUser.Name, Action: "unbound"}
+	return g.render(result, result.print)
+}
+
+// lookupPeerID parses a numeric ID or resolves a name with lookup; empty yields nil.
+func lookupPeerID(value string, lookup func(name string) (string, error)) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if _, err := strconv.Atoi(value); err != nil {
+		if value, err = lookup(value); err != nil {
+			return nil, err
+		}
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 0 {
+		return nil, fmt.Errorf("invalid id %q", value)
+	}
+	return &id, nil
+}
@@ -0,0 +1,95 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+func TestLookupPeerID(t *testing.T) {
+	lookup := func(name string) (string, error) {
+		switch name {
+		case "www-data":
+			return "33", nil
+		case "broken":
+			return "x", nil
+		}
+		return "", errors.New("unknown name " + name)
+	}
+	id := func(n int) *int { return &n }
+
+	tests := []struct {
+		value   string
+		want    *int
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"0", id(0), false},
+		{"1000", id(1000), false},
+		{"www-data", id(33), false},
+		{"nobody-here", nil, true},
+		{"broken", nil, true},
+		{"-1", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := lookupPeerID(tt.value, lookup)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lookupPeerID(%q) = %v, %v; want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPeer(t *testing.T) {
+	users := &service.APIUserService{}
+	for _, name := range []string{"peer-backup", "peer-monitor"} {
+		user, _, err := users.CreateUser(name, 0, []model.APIScope{model.APIScopeRead})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { users.DeleteUser(user.Id) })
+	}
+	id := func(n int) *int { return &n }
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     peerResult
+	}{
+		{"bind uid", []string{"peer", "bind", "-name", "peer-backup", "-uid", "1500"}, exitOK, peerResult{User: "peer-backup", Action: "bound", UID: id(1500)}},
+		{"bind uid and gid", []string{"peer", "bind", "-name", "peer-backup", "-uid", "1500", "-gid", "1500"}, exitOK, peerResult{User: "peer-backup", Action: "bound", UID: id(1500), GID: id(1500)}},
+		{"uid taken", []string{"peer", "bind", "-name", "peer-monitor", "-uid", "1500"}, exitUsage, peerResult{}},
+		{"bind gid", []string{"peer", "bind", "-name", "peer-monitor", "-gid", "1600"}, exitOK, peerResult{User: "peer-monitor", Action: "bound", GID: id(1600)}},
+		{"no ids", []string{"peer", "bind", "-name", "peer-monitor"}, exitUsage, peerResult{}},
+		{"negative uid", []string{"peer", "bind", "-name", "peer-monitor", "-uid", "-5"}, exitUsage, peerResult{}},
+		{"unbind", []string{"peer", "unbind", "-name", "peer-backup"}, exitOK, peerResult{User: "peer-backup", Action: "unbound"}},
+		{"uid free again", []string{"peer", "bind", "-name", "peer-monitor", "-uid", "1500"}, exitOK, peerResult{User: "peer-monitor", Action: "bound", UID: id(1500)}},
+		{"unknown subcommand", []string{"peer", "list"}, exitUsage, peerResult{}},
+		{"remote", []string{"-endpoint", testPanelURL, "-token", testAdminToken, "peer", "unbind", "-name", "peer-monitor"}, exitUsage, peerResult{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := guard(t, append(tt.args, "-o", "json")...)
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("exit %d (%v), want %d", code, err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			var got peerResult
+			if err := json.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatalf("peer printed %s: %v", out, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("result = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// rehashReport is the output of the rehash-report command.
+type rehashReport struct {
+	Pepper   string                       `json:"pepper" yaml:"pepper"`
+	PepperID string                       `json:"pepperId" yaml:"pepperId"`
+	Total    int                          `json:"total" yaml:"total"`
+	Current  int                          `json:"current" yaml:"current"`
+	Legacy   int                          `json:"legacy" yaml:"legacy"`
+	Foreign  int                          `json:"foreign" yaml:"foreign"`
+	Users    []service.APITokenHashStatus `json:"users" yaml:"users"`
+}
+
+func handleRehashReport(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("rehash-report", flag.ContinueOnError)
+	all := fs.Bool("all", false, "list every user, not only those with legacy or foreign hashes")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if g.remote() {
+		return errLocalOnly
+	}
+
+	if err := initDB(); err != nil {
+		return err
+	}
+	defer database.CloseDB()
+
+	apiSvc := service.APIUserService{}
+	statuses, err := apiSvc.TokenHashReport()
+	if err != nil {
+		return err
+	}
+	pepperID, err := service.LocalAPITokenPepperID()
+	if err != nil {
+		return err
+	}
+
+	report := rehashReport{
+		Pepper:   service.APITokenPepperPath(),
+		PepperID: pepperID,
+		Total:    len(statuses),
+		Users:    make([]service.APITokenHashStatus, 0),
+	}
+	if os.Getenv(service.APITokenPepperEnv) != "" {
+		report.Pepper = "$" + service.APITokenPepperEnv
+	}
+	for _, st := range statuses {
+		switch {
+		case st.Legacy:
+			report.Legacy++
+		case st.Foreign:
+			report.Foreign++
+		default:
+			report.Current++
+		}
+		if *all || st.Legacy || st.Foreign || st.Scheme == service.APITokenHashRevoked {
+			report.Users = append(report.Users, st)
+		}
+	}
+
+	return g.render(report, func(w io.Writer) {
+		fmt.Fprintf(w, "Pepper:\t%s (id %s)\n", report.Pepper, report.PepperID)
+		fmt.Fprintf(w, "Tokens:\t%d total, %d %s, %d legacy bcrypt, %d unverifiable\n",
+			report.Total, report.Current, service.APITokenHashHMAC, report.Legacy, report.Foreign)
+		if len(report.Users) == 0 {
+			return
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "ID\tNAME\tSCHEME\tSTATUS")
+		for _, u := range report.Users {
+			status := "current"
+			switch {
+			case u.Legacy:
+				status = "migrates on next use"
+			case u.Scheme == service.APITokenHashRevoked:
+				status = "revoked as leaked, rotate to re-issue"
+			case u.Scheme == "":
+				status = "unknown scheme, rotate the token"
+			case u.Foreign:
+				status = "other pepper " + u.PepperID + ", rotate the token"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", u.Id, u.Name, u.Scheme, status)
+		}
+	})
+}
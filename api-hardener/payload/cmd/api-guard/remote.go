@@ -0,0 +1,152 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/pkg/client"
+)
+
+// profile describes how to reach one remote panel.
+type profile struct {
+	Endpoint string `json:"endpoint"`         // panel URL including webBasePath
+	Token    string `json:"token"`            // admin-scoped API token
+	CACert   string `json:"caCert,omitempty"` // optional PEM bundle for self-signed panels
+}
+
+// profileFile is the on-disk format of the api-guard profiles file.
+type profileFile struct {
+	Default  string             `json:"default"`
+	Profiles map[string]profile `json:"profiles"`
+}
+
+// globalOptions are the flags accepted before the command name.
+type globalOptions struct {
+	endpoint string
+	token    string
+	profile  string
+	caCert   string
+	config   string
+	timeout  time.Duration
+	output   string
+}
+
+func parseGlobalOptions(args []string) (*globalOptions, []string, error) {
+	g := &globalOptions{output: outputTable}
+	fs := flag.NewFlagSet("api-guard", flag.ContinueOnError)
+	fs.StringVar(&g.endpoint, "endpoint", os.Getenv("API_GUARD_ENDPOINT"), "panel URL for remote mode, e.g. https://host:2053/path/ or unix:///run/x-ui/api.sock")
+	fs.StringVar(&g.token, "token", os.Getenv("API_GUARD_TOKEN"), "admin-scoped API token for remote mode")
+	fs.StringVar(&g.profile, "profile", os.Getenv("API_GUARD_PROFILE"), "named profile from the profiles file")
+	fs.StringVar(&g.caCert, "ca-cert", "", "PEM CA bundle used to verify the panel certificate")
+	fs.StringVar(&g.config, "config", os.Getenv("API_GUARD_CONFIG"), "profiles file (default <user config dir>/api-guard/profiles.json)")
+	fs.DurationVar(&g.timeout, "timeout", 30*time.Second, "timeout for remote requests")
+	g.addOutputFlag(fs)
+	fs.Usage = printUsage
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, &cliError{code: exitUsage, err: err}
+	}
+	if err := validateOutput(g.output); err != nil {
+		return nil, nil, err
+	}
+	return g, fs.Args(), nil
+}
+
+// resolve merges flags, environment and the selected profile. Explicit flags win.
+func (g *globalOptions) resolve() error {
+	path := g.config
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(dir, "api-guard", "profiles.json")
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if g.profile != "" {
+			return usageErrorf("profile %q requested but %s does not exist", g.profile, path)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	file := profileFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return usageErrorf("parse %s: %w", path, err)
+	}
+	name := g.profile
+	if name == "" && g.endpoint == "" {
+		name = file.Default
+	}
+	if name == "" {
+		return nil
+	}
+	p, ok := file.Profiles[name]
+	if !ok {
+		return usageErrorf("profile %q not found in %s", name, path)
+	}
+	if g.endpoint == "" {
+		g.endpoint = p.Endpoint
+	}
+	if g.token == "" {
+		g.token = p.Token
+	}
+	if g.caCert == "" {
+		g.caCert = p.CACert
+	}
+	return nil
+}
+
+func (g *globalOptions) remote() bool {
+	return g.endpoint != ""
+}
+
+// open returns the remote backend when an endpoint is configured, the local one otherwise.
+func (g *globalOptions) open() (backend, error) {
+	if !g.remote() {
+		return openLocal()
+	}
+	c, err := g.client()
+	if err != nil {
+		return nil, err
+	}
+	return &remoteBackend{ctx: context.Background(), client: c}, nil
+}
+
+func (g *globalOptions) client() (*client.Client, error) {
+	// The panel's unix socket authorizes api-guard by its UID/GID instead of a token.
+	if g.token == "" && !strings.HasPrefix(g.endpoint, "unix:") {
+		return nil, usageErrorf("remote mode requires --token, API_GUARD_TOKEN or a profile token")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if g.caCert != "" {
+		pem, err := os.ReadFile(g.caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", g.caCert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return client.New(g.endpoint, g.token,
+		client.WithHTTPClient(&http.Client{Timeout: g.timeout, Transport: transport}),
+		client.WithUserAgent("api-guard"),
+	)
+}
@@ -0,0 +1,173 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseGlobalOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantEndpoint string
+		wantOutput   string
+		wantArgs     []string
+		wantCode     int
+	}{
+		{"command only", []string{"list"}, "", outputTable, []string{"list"}, exitOK},
+		{"remote flags", []string{"-endpoint", "https://panel:2053/x/", "-token", "t", "-o", "json", "list", "-o", "yaml"},
+			"https://panel:2053/x/", outputJSON, []string{"list", "-o", "yaml"}, exitOK},
+		{"unknown output", []string{"--output", "xml", "list"}, "", "", nil, exitUsage},
+		{"unknown flag", []string{"-bogus", "list"}, "", "", nil, exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, args, err := parseGlobalOptions(tt.args)
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("err = %v (exit %d), want exit %d", err, code, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if g.endpoint != tt.wantEndpoint || g.output != tt.wantOutput || strings.Join(args, " ") != strings.Join(tt.wantArgs, " ") {
+				t.Fatalf("got endpoint %q, output %q, args %q", g.endpoint, g.output, args)
+			}
+		})
+	}
+}
+
+func TestGlobalOptionsResolve(t *testing.T) {
+	profiles := filepath.Join(t.TempDir(), "profiles.json")
+	data, _ := json.Marshal(profileFile{
+		Default: "prod",
+		Profiles: map[string]profile{
+			"prod":    {Endpoint: "https://prod:2053/p/", Token: "prod-token", CACert: "/etc/prod-ca.pem"},
+			"staging": {Endpoint: "https://staging:2053/s/", Token: "staging-token"},
+		},
+	})
+	if err := os.WriteFile(profiles, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(t.TempDir(), "profiles.json")
+	if err := os.WriteFile(broken, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "profiles.json")
+
+	tests := []struct {
+		name         string
+		opts         globalOptions
+		wantEndpoint string
+		wantToken    string
+		wantCACert   string
+		wantCode     int
+	}{
+		{"default profile", globalOptions{config: profiles}, "https://prod:2053/p/", "prod-token", "/etc/prod-ca.pem", exitOK},
+		{"named profile", globalOptions{config: profiles, profile: "staging"}, "https://staging:2053/s/", "staging-token", "", exitOK},
+		{"flags win over the profile", globalOptions{config: profiles, profile: "staging", token: "flag-token"},
+			"https://staging:2053/s/", "flag-token", "", exitOK},
+		{"endpoint flag skips the default profile", globalOptions{config: profiles, endpoint: "https://other/"},
+			"https://other/", "", "", exitOK},
+		{"unknown profile", globalOptions{config: profiles, profile: "dev"}, "", "", "", exitUsage},
+		{"broken file", globalOptions{config: broken}, "", "", "", exitUsage},
+		{"no file", globalOptions{config: missing}, "", "", "", exitOK},
+		{"no file for a profile", globalOptions{config: missing, profile: "prod"}, "", "", "", exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := tt.opts
+			err := g.resolve()
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("resolve = %v (exit %d), want exit %d", err, code, tt.wantCode)
+			}
+			if err == nil && (g.endpoint != tt.wantEndpoint || g.token != tt.wantToken || g.caCert != tt.wantCACert) {
+				t.Fatalf("resolved endpoint %q, token %q, CA %q", g.endpoint, g.token, g.caCert)
+			}
+		})
+	}
+}
+
+func TestGlobalOptionsClient(t *testing.T) {
+	noCerts := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(noCerts, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		opts     globalOptions
+		wantErr  bool
+		wantCode int
+	}{
+		{"token", globalOptions{endpoint: "https://panel/", token: "t"}, false, exitOK},
+		{"unix socket without token", globalOptions{endpoint: "unix:///run/x-ui/api.sock"}, false, exitOK},
+		{"https without token", globalOptions{endpoint: "https://panel/"}, true, exitUsage},
+		{"missing CA file", globalOptions{endpoint: "https://panel/", token: "t", caCert: noCerts + ".missing"}, true, exitBackend},
+		{"CA file without certificates", globalOptions{endpoint: "https://panel/", token: "t", caCert: noCerts}, true, exitBackend},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.opts.client()
+			if (err != nil) != tt.wantErr || exitCode(err) != tt.wantCode {
+				t.Fatalf("client = %v (exit %d), want exit %d", err, exitCode(err), tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRemoteMode(t *testing.T) {
+	remote := func(args ...string) (string, error) {
+		return guard(t, append([]string{"-endpoint", testPanelURL, "-token", testAdminToken, "-o", "json"}, args...)...)
+	}
+
+	out, err := remote("create", "-name", "remote-reader", "-scopes", "read", "-rate", "30")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	created := userView{}
+	if err := json.Unmarshal([]byte(out), &created); err != nil || created.Name != "remote-reader" || created.Secret == "" {
+		t.Fatalf("create printed %s (%v)", out, err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantOut  string
+	}{
+		{"get by name", []string{"get", "-name", "remote-reader"}, exitOK, `"rateLimitPerMinute": 30`},
+		{"list", []string{"list"}, exitOK, `"name": "remote-reader"`},
+		{"rate", []string{"rate", "-name", "remote-reader", "-rate", "10"}, exitOK, `"action": "rate"`},
+		{"disable", []string{"disable", "-name", "remote-reader"}, exitOK, `"action": "disable"`},
+		{"get after disable", []string{"get", "-name", "remote-reader"}, exitOK, `"enabled": false`},
+		{"unknown user", []string{"get", "-name", "nobody"}, exitNotFound, ""},
+		{"local-only command", []string{"install"}, exitUsage, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := remote(tt.args...)
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("exit %d (%v), want %d", code, err, tt.wantCode)
+			}
+			if !strings.Contains(out, tt.wantOut) {
+				t.Fatalf("printed %s, want %s", out, tt.wantOut)
+			}
+		})
+	}
+
+	// The panel does not reveal itself to unknown tokens and refuses tokens without admin.
+	if _, err := guard(t, "-endpoint", testPanelURL, "-token", "xui_live_bogus", "list"); exitCode(err) != exitNotFound {
+		t.Fatalf("list with a bogus token: exit %d (%v), want %d", exitCode(err), err, exitNotFound)
+	}
+	if _, err := guard(t, "-endpoint", testPanelURL, "-token", created.Secret, "enable", "-name", "remote-reader"); exitCode(err) != exitNotFound {
+		t.Fatalf("enable with a disabled token: exit %d (%v), want %d", exitCode(err), err, exitNotFound)
+	}
+	if _, err := remote("delete", "-name", "remote-reader"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// handleRevokeLeaked reports plaintext tokens found in a leak, one per line, and
+// disables their owners. Empty lines and lines starting with # are skipped.
+func handleRevokeLeaked(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("revoke-leaked", flag.ContinueOnError)
+	file := fs.String("f", "", "file with one leaked token per line (- for stdin)")
+	source := fs.String("source", "", "where the tokens were found, recorded in the audit trail")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return usageErrorf("-f is required")
+	}
+
+	tokens, err := readLeakedTokens(*file)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return usageErrorf("no tokens found in %s", *file)
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	results := make([]service.LeakedTokenResult, 0, len(tokens))
+	for start := 0; start < len(tokens); start += service.MaxLeakedTokensPerRequest {
+		end := min(start+service.MaxLeakedTokensPerRequest, len(tokens))
+		batch, err := b.RevokeLeakedTokens(tokens[start:end], *source)
+		if err != nil {
+			return err
+		}
+		results = append(results, batch...)
+	}
+	return g.render(results, func(w io.Writer) {
+		fmt.Fprintln(w, "TOKEN\tSTATUS\tUSER")
+		for _, r := range results {
+			user := "-"
+			if r.Name != "" {
+				user = fmt.Sprintf("%s (%d)", r.Name, r.APIUserId)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.Token, r.Status, user)
+		}
+	})
+}
+
+func readLeakedTokens(path string) ([]string, error) {
+	in := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, &cliError{code: exitUsage, err: err}
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var tokens []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &cliError{code: exitUsage, err: err}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,113 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+func TestReadLeakedTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string // not written when empty
+		want    []string
+		wantErr bool
+	}{
+		{"tokens", "xui_live_a\n  xui_live_b  \n", []string{"xui_live_a", "xui_live_b"}, false},
+		{"comments and blank lines", "# from the paste site\n\nxui_live_a\n\t\n#xui_live_b\n", []string{"xui_live_a"}, false},
+		{"only comments", "# nothing\n", nil, false},
+		{"missing", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "leaked.txt")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := readLeakedTokens(path)
+			if (err != nil) != tt.wantErr || (err != nil && exitCode(err) != exitUsage) {
+				t.Fatalf("readLeakedTokens = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("tokens = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRevokeLeaked(t *testing.T) {
+	users := &service.APIUserService{}
+	var tokens []string
+	for _, name := range []string{"leaked-local", "leaked-remote"} {
+		user, token, err := users.CreateUser(name, 0, []model.APIScope{model.APIScopeRead})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { users.DeleteUser(user.Id) })
+		tokens = append(tokens, token)
+	}
+	dir := t.TempDir()
+	file := func(lines string) string {
+		path := filepath.Join(dir, "leaked.txt")
+		if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	remote := []string{"-endpoint", testPanelURL, "-token", testAdminToken}
+
+	tests := []struct {
+		name       string
+		args       []string
+		lines      string
+		wantCode   int
+		wantStatus []string
+	}{
+		{"local", nil, tokens[0] + "\nnot-a-token\n", exitOK, []string{service.LeakedTokenRevoked, service.LeakedTokenMalformed}},
+		{"remote", remote, tokens[1] + "\n" + tokens[0] + "\n", exitOK, []string{service.LeakedTokenRevoked, service.LeakedTokenAlreadyRevoked}},
+		{"no tokens", nil, "# none\n", exitUsage, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append(append([]string{}, tt.args...), "revoke-leaked", "-f", file(tt.lines), "-source", "paste site", "-o", "json")
+			out, err := guard(t, args...)
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("exit %d (%v), want %d", code, err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			var results []service.LeakedTokenResult
+			if err := json.Unmarshal([]byte(out), &results); err != nil {
+				t.Fatalf("revoke-leaked printed %s: %v", out, err)
+			}
+			got := make([]string, 0, len(results))
+			for _, r := range results {
+				got = append(got, r.Status)
+			}
+			if !reflect.DeepEqual(got, tt.wantStatus) {
+				t.Fatalf("statuses = %q, want %q", got, tt.wantStatus)
+			}
+		})
+	}
+
+	out, err := guard(t, "audit", "-name", "leaked-remote", "-o", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []model.APIAuditEvent
+	if err := json.Unmarshal([]byte(out), &events); err != nil || len(events) != 1 ||
+		events[0].Event != service.APIAuditTokenLeaked || events[0].Detail != "reported as leaked by paste site" {
+		t.Fatalf("audit printed %s (%v)", out, err)
+	}
+}
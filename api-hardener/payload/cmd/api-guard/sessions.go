@@ -0,0 +1,129 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// sessionView is how a panel login session is printed.
+type sessionView struct {
+	ID           int       `json:"id" yaml:"id"`
+	Username     string    `json:"username" yaml:"username"`
+	IP           string    `json:"ip" yaml:"ip"`
+	UserAgent    string    `json:"userAgent" yaml:"userAgent"`
+	LoginAt      time.Time `json:"loginAt" yaml:"loginAt"`
+	LastActiveAt time.Time `json:"lastActiveAt" yaml:"lastActiveAt"`
+}
+
+func newSessionView(s *model.PanelSession) sessionView {
+	return sessionView{
+		ID:           s.Id,
+		Username:     s.Username,
+		IP:           s.IP,
+		UserAgent:    s.UserAgent,
+		LoginAt:      s.CreatedAt,
+		LastActiveAt: s.LastActiveAt,
+	}
+}
+
+// handleSessions dispatches "sessions list" and "sessions revoke".
+func handleSessions(g *globalOptions, args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("sessions requires a subcommand: list or revoke")
+	}
+	switch args[0] {
+	case "list":
+		return handleSessionsList(g, args[1:])
+	case "revoke":
+		return handleSessionsRevoke(g, args[1:])
+	default:
+		return usageErrorf("unknown sessions subcommand %q (want list or revoke)", args[0])
+	}
+}
+
+func handleSessionsList(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("sessions list", flag.ContinueOnError)
+	user := fs.String("user", "", "only list sessions of this panel user")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	sessions, err := b.ListSessions()
+	if err != nil {
+		return err
+	}
+	views := make([]sessionView, 0, len(sessions))
+	for i := range sessions {
+		if *user == "" || sessions[i].Username == *user {
+			views = append(views, newSessionView(&sessions[i]))
+		}
+	}
+	return g.render(views, func(w io.Writer) {
+		if len(views) == 0 {
+			fmt.Fprintln(w, "No panel sessions found.")
+			return
+		}
+		fmt.Fprintln(w, "ID\tUSER\tIP\tLOGIN\tLAST ACTIVE\tUSER AGENT")
+		for _, s := range views {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+				s.ID, s.Username, s.IP, formatTime(&s.LoginAt), formatTime(&s.LastActiveAt), s.UserAgent)
+		}
+	})
+}
+
+// sessionsRevokeResult is the output of "sessions revoke".
+type sessionsRevokeResult struct {
+	ID       int    `json:"id,omitempty" yaml:"id,omitempty"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Revoked  int    `json:"revoked" yaml:"revoked"`
+}
+
+func handleSessionsRevoke(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("sessions revoke", flag.ContinueOnError)
+	id := fs.Int("id", 0, "session ID from sessions list")
+	user := fs.String("user", "", "revoke every session of this panel user")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if (*id > 0) == (*user != "") {
+		return usageErrorf("exactly one of -id or -user must be provided")
+	}
+
+	b, err := g.open()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	result := sessionsRevokeResult{ID: *id, Username: *user}
+	if *id > 0 {
+		if err := b.RevokeSession(*id); err != nil {
+			return err
+		}
+		result.Revoked = 1
+	} else if result.Revoked, err = b.RevokeUserSessions(*user); err != nil {
+		return err
+	}
+	return g.render(result, func(w io.Writer) {
+		if result.Username != "" {
+			fmt.Fprintf(w, "Revoked %d session(s) of %s\n", result.Revoked, result.Username)
+			return
+		}
+		fmt.Fprintf(w, "Session %d revoked\n", result.ID)
+	})
+}
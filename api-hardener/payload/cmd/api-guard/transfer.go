@@ -0,0 +1,210 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// exportResult is printed when the export was written to a file.
+type exportResult struct {
+	File  string `json:"file" yaml:"file"`
+	Users int    `json:"users" yaml:"users"`
+}
+
+func handleExport(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	file := fs.String("f", "-", "export file (- for stdout); contains token hashes, written with mode 0600")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if g.remote() {
+		return errLocalOnly
+	}
+
+	if err := initDB(); err != nil {
+		return err
+	}
+	defer database.CloseDB()
+
+	apiSvc := service.APIUserService{}
+	export, err := apiSvc.ExportAPIUsers()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if *file == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*file, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Chmod(*file, 0o600); err != nil {
+		return err
+	}
+
+	result := exportResult{File: *file, Users: len(export.Users)}
+	return g.render(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Exported %d API user(s) and API settings to %s\n", result.Users, result.File)
+	})
+}
+
+// importResult is the output of the import command.
+type importResult struct {
+	DryRun           bool              `json:"dryRun" yaml:"dryRun"`
+	SettingsImported bool              `json:"settingsImported" yaml:"settingsImported"`
+	Users            []importedUser    `json:"users" yaml:"users"`
+	Tokens           map[string]string `json:"tokens,omitempty" yaml:"tokens,omitempty"`
+	SecretsFile      string            `json:"secretsFile,omitempty" yaml:"secretsFile,omitempty"`
+}
+
+type importedUser struct {
+	Name   string `json:"name" yaml:"name"`
+	ID     int    `json:"id,omitempty" yaml:"id,omitempty"`
+	Action string `json:"action" yaml:"action"`
+	Purged int64  `json:"purged,omitempty" yaml:"purged,omitempty"` // soft-deleted rows removed to free the name or prefix
+}
+
+func handleImport(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("f", "", "export file produced by api-guard export (- for stdin)")
+	conflict := fs.String("on-conflict", string(service.APIImportConflictFail), "when a user with the same name exists: fail, skip or replace")
+	reissue := fs.Bool("reissue-tokens", false, "issue new tokens instead of copying token hashes")
+	skipSettings := fs.Bool("skip-settings", false, "do not import apiTokenOnly and apiDefaultRateLimit")
+	dryRun := fs.Bool("dry-run", false, "show what would be imported without writing")
+	secretsFile := fs.String("secrets-file", "", "write re-issued tokens to this YAML file (default: print them)")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return usageErrorf("-f is required")
+	}
+	opts := service.APIImportOptions{
+		Conflict:      service.APIImportConflict(*conflict),
+		ReissueTokens: *reissue,
+		SkipSettings:  *skipSettings,
+		DryRun:        *dryRun,
+	}
+	switch opts.Conflict {
+	case service.APIImportConflictFail, service.APIImportConflictSkip, service.APIImportConflictReplace:
+	default:
+		return usageErrorf("unknown -on-conflict %q (use fail, skip or replace)", *conflict)
+	}
+	if g.remote() {
+		return errLocalOnly
+	}
+
+	export, err := readExport(*file)
+	if err != nil {
+		return err
+	}
+	if err := service.ValidateAPIUsersExport(export, opts.ReissueTokens); err != nil {
+		return &cliError{code: exitUsage, err: err}
+	}
+
+	if err := initDB(); err != nil {
+		return err
+	}
+	defer database.CloseDB()
+
+	apiSvc := service.APIUserService{}
+	users, err := apiSvc.ImportAPIUsers(export, opts)
+	if errors.Is(err, service.ErrAPIImportConflict) {
+		return &cliError{code: exitUsage, err: fmt.Errorf("%w (use -on-conflict skip or replace)", err)}
+	}
+	if err != nil {
+		return err
+	}
+
+	result := importResult{
+		DryRun:           opts.DryRun,
+		SettingsImported: !opts.SkipSettings && !opts.DryRun,
+		Users:            make([]importedUser, 0, len(users)),
+	}
+	tokens := map[string]string{}
+	for _, u := range users {
+		result.Users = append(result.Users, importedUser{Name: u.Name, ID: u.Id, Action: u.Action, Purged: u.Purged})
+		if u.Token != "" && !opts.DryRun {
+			tokens[u.Name] = u.Token
+		}
+	}
+	var secretsErr error
+	if len(tokens) > 0 {
+		if *secretsFile == "" {
+			result.Tokens = tokens
+		} else if secretsErr = writeSecrets(*secretsFile, tokens); secretsErr != nil {
+			result.Tokens = tokens
+			secretsErr = fmt.Errorf("write secrets: %w", secretsErr)
+		} else {
+			result.SecretsFile = *secretsFile
+		}
+	}
+
+	if err := g.render(result, result.print); err != nil {
+		return err
+	}
+	return secretsErr
+}
+
+func (r importResult) print(w io.Writer) {
+	fmt.Fprintln(w, "NAME\tID\tACTION\tPURGED")
+	for _, u := range r.Users {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\n", u.Name, u.ID, u.Action, u.Purged)
+	}
+	if r.SettingsImported {
+		fmt.Fprintln(w, "API settings imported.")
+	}
+	if len(r.Tokens) > 0 {
+		fmt.Fprintln(w, "New tokens (store securely, shown once):")
+		names := make([]string, 0, len(r.Tokens))
+		for name := range r.Tokens {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s: %s\n", name, r.Tokens[name])
+		}
+	}
+	if r.SecretsFile != "" {
+		fmt.Fprintf(w, "Wrote new token(s) to %s\n", r.SecretsFile)
+	}
+	if r.DryRun {
+		fmt.Fprintln(w, "Dry run: nothing was written.")
+	}
+}
+
+func readExport(path string) (*service.APIUsersExport, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, &cliError{code: exitUsage, err: err}
+	}
+	export := &service.APIUsersExport{}
+	if err := json.Unmarshal(data, export); err != nil {
+		return nil, usageErrorf("parse %s: %w", path, err)
+	}
+	return export, nil
+}
@@ -0,0 +1,93 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+func TestReadExport(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name      string
+		content   string // not written when empty
+		wantUsers int
+		wantCode  int
+	}{
+		{"export", `{"version":1,"users":[{"name":"a"},{"name":"b"}]}`, 2, exitOK},
+		{"not json", `users: []`, 0, exitUsage},
+		{"missing", "", 0, exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			export, err := readExport(path)
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("readExport = %v (exit %d), want exit %d", err, code, tt.wantCode)
+			}
+			if err == nil && len(export.Users) != tt.wantUsers {
+				t.Fatalf("users = %+v, want %d", export.Users, tt.wantUsers)
+			}
+		})
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "export.json")
+	if _, err := guard(t, "export", "-f", file); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("export file: %v, %v", info, err)
+	}
+	export, err := readExport(file)
+	if err != nil || len(export.Users) == 0 {
+		t.Fatalf("export = %+v, %v", export, err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantOut  string
+	}{
+		{"existing users", []string{"import", "-f", file}, exitUsage, ""},
+		{"skip", []string{"import", "-f", file, "-on-conflict", "skip", "-dry-run", "-o", "json"}, exitOK, `"action": "skipped"`},
+		{"unknown conflict mode", []string{"import", "-f", file, "-on-conflict", "merge"}, exitUsage, ""},
+		{"no file", []string{"import"}, exitUsage, ""},
+		{"remote", []string{"-endpoint", testPanelURL, "-token", testAdminToken, "export"}, exitUsage, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := guard(t, tt.args...)
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("exit %d (%v), want %d", code, err, tt.wantCode)
+			}
+			if !strings.Contains(out, tt.wantOut) {
+				t.Fatalf("printed %s, want %s", out, tt.wantOut)
+			}
+		})
+	}
+
+	out, err := guard(t, "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var printed service.APIUsersExport
+	if err := json.Unmarshal([]byte(out), &printed); err != nil || len(printed.Users) != len(export.Users) {
+		t.Fatalf("export to stdout = %s (%v)", out, err)
+	}
+}
@@ -0,0 +1,224 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// releaseKeys holds the public keys trusted for payloads and binaries.
+//
+//go:embed release.pub
+var releaseKeys []byte
+
+const signatureSuffix = ".sig"
+
+// errBadSignature is returned when no trusted key verifies a file.
+var errBadSignature = errors.New("signature verification failed")
+
+// parsePublicKeys reads base64 ed25519 public keys, one per line; # starts a comment.
+func parsePublicKeys(data []byte) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("line %d: not a base64 ed25519 public key", n)
+		}
+		keys = append(keys, ed25519.PublicKey(raw))
+	}
+	return keys, scanner.Err()
+}
+
+// keyID is a short fingerprint used to tell keys apart in output.
+func keyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// readSignature reads a detached signature: the base64 of the raw 64-byte ed25519
+// signature, the same bytes openssl pkeyutl -verify -rawin expects.
+func readSignature(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("%s is not an ed25519 signature", path)
+	}
+	return sig, nil
+}
+
+// verifyFile checks file against its detached signature with any of keys and
+// returns the key that matched.
+func verifyFile(file, sigFile string, keys []ed25519.PublicKey) (ed25519.PublicKey, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no trusted public key (this build embeds none, pass -pubkey)", errBadSignature)
+	}
+	sig, err := readSignature(sigFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s is not signed (missing %s)", errBadSignature, file, sigFile)
+	} else if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, data, sig) {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s was modified or signed with an untrusted key", errBadSignature, file)
+}
+
+// verifyResult is the output of the verify command.
+type verifyResult struct {
+	File      string `json:"file" yaml:"file"`
+	Signature string `json:"signature" yaml:"signature"`
+	KeyID     string `json:"keyId" yaml:"keyId"`
+	Valid     bool   `json:"valid" yaml:"valid"`
+}
+
+func handleVerify(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	file := fs.String("f", "", "payload archive or binary to verify (default: this api-guard binary)")
+	sigFile := fs.String("sig", "", "detached signature (default: <file>.sig)")
+	pubKey := fs.String("pubkey", "", "public key file to trust instead of the embedded release keys")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *file == "" {
+		self, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		*file = self
+	}
+	if *sigFile == "" {
+		*sigFile = *file + signatureSuffix
+	}
+
+	keyData := releaseKeys
+	if *pubKey != "" {
+		data, err := os.ReadFile(*pubKey)
+		if err != nil {
+			return &cliError{code: exitUsage, err: err}
+		}
+		keyData = data
+	}
+	keys, err := parsePublicKeys(keyData)
+	if err != nil {
+		return usageErrorf("public keys: %v", err)
+	}
+
+	key, err := verifyFile(*file, *sigFile, keys)
+	if errors.Is(err, errBadSignature) {
+		return &cliError{code: exitSignature, err: err}
+	}
+	if err != nil {
+		return err
+	}
+
+	result := verifyResult{File: *file, Signature: *sigFile, KeyID: keyID(key), Valid: true}
+	return g.render(result, func(w io.Writer) {
+		fmt.Fprintf(w, "%s: signature OK (key %s)\n", result.File, result.KeyID)
+	})
+}
+
+// keygenResult is the output of the keygen command.
+type keygenResult struct {
+	PrivateKey string `json:"privateKey" yaml:"privateKey"`
+	PublicKey  string `json:"publicKey" yaml:"publicKey"`
+	KeyID      string `json:"keyId" yaml:"keyId"`
+}
+
+func handleKeygen(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	out := fs.String("out", "release", "write <out>.key (private, 0600) and <out>.pub")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	keyFile, pubFile := *out+".key", *out+".pub"
+	if _, err := os.Stat(keyFile); err == nil {
+		return usageErrorf("%s already exists", keyFile)
+	}
+	if err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(priv)+"\n"), 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(pubFile, []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0o644); err != nil {
+		return err
+	}
+
+	result := keygenResult{PrivateKey: keyFile, PublicKey: pubFile, KeyID: keyID(pub)}
+	return g.render(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Private key: %s (keep offline)\n", keyFile)
+		fmt.Fprintf(w, "Public key:  %s (key %s)\n", pubFile, result.KeyID)
+	})
+}
+
+func handleSign(g *globalOptions, args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	keyFile := fs.String("key", "", "private key written by api-guard keygen (required)")
+	file := fs.String("f", "", "file to sign (required); the signature is written to <file>.sig")
+	g.addOutputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *keyFile == "" || *file == "" {
+		return usageErrorf("-key and -f are required")
+	}
+
+	keyData, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return &cliError{code: exitUsage, err: err}
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyData)))
+	if err != nil || len(raw) != ed25519.PrivateKeySize {
+		return usageErrorf("%s is not an ed25519 private key", *keyFile)
+	}
+	priv := ed25519.PrivateKey(raw)
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return &cliError{code: exitUsage, err: err}
+	}
+
+	sigFile := *file + signatureSuffix
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+	if err := os.WriteFile(sigFile, []byte(sig+"\n"), 0o644); err != nil {
+		return err
+	}
+
+	result := verifyResult{File: *file, Signature: sigFile, KeyID: keyID(priv.Public().(ed25519.PublicKey)), Valid: true}
+	return g.render(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Signed %s -> %s (key %s)\n", result.File, result.Signature, result.KeyID)
+	})
+}
@@ -0,0 +1,271 @@
+//go:build toolsignore
+// +build toolsignore
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// installScript is install.sh next to the payload in the api-hardener source tree.
+const installScript = "../../../install.sh"
+
+func newTestKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+// writeSigned writes data to dir/name and its detached signature by priv, in the format of
+// "api-guard sign", and returns the path of the file.
+func writeSigned(t *testing.T, dir, name string, data []byte, priv ed25519.PrivateKey) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data)) + "\n"
+	if err := os.WriteFile(file+signatureSuffix, []byte(sig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestEmbeddedReleaseKeys(t *testing.T) {
+	keys, err := parsePublicKeys(releaseKeys)
+	if err != nil {
+		t.Fatalf("release.pub: %v", err)
+	}
+	if len(keys) == 0 {
+		t.Fatal("release.pub embeds no release key")
+	}
+}
+
+func TestReleaseKeysMatchInstallScript(t *testing.T) {
+	script, err := os.ReadFile(installScript)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Skip("install.sh is not next to the payload")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`RELEASE_PUBKEYS="\$\{API_HARDENER_PUBKEY:-([^}]*)\}"`).FindSubmatch(script)
+	if m == nil {
+		t.Fatal("RELEASE_PUBKEYS not found in install.sh")
+	}
+	installKeys := strings.Fields(string(m[1]))
+
+	keys, err := parsePublicKeys(releaseKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded := make([]string, 0, len(keys))
+	for _, key := range keys {
+		embedded = append(embedded, base64.StdEncoding.EncodeToString(key))
+	}
+	slices.Sort(embedded)
+	slices.Sort(installKeys)
+	if !slices.Equal(embedded, installKeys) {
+		t.Fatalf("release.pub has %v, install.sh trusts %v", embedded, installKeys)
+	}
+}
+
+func TestParsePublicKeys(t *testing.T) {
+	pub, _ := newTestKey(t)
+	encoded := base64.StdEncoding.EncodeToString(pub)
+	tests := []struct {
+		name    string
+		data    string
+		want    int
+		wantErr bool
+	}{
+		{"empty", "", 0, false},
+		{"comments and blank lines", "# keys\n\n  # indented\n", 0, false},
+		{"one key", encoded + "\n", 1, false},
+		{"key with spaces", "  " + encoded + "  \n# trailing\n" + encoded, 2, false},
+		{"not base64", "not a key\n", 0, true},
+		{"wrong length", base64.StdEncoding.EncodeToString(pub[:16]) + "\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parsePublicKeys([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != tt.want {
+				t.Fatalf("got %d keys, want %d", len(keys), tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	pub, priv := newTestKey(t)
+	otherPub, otherPriv := newTestKey(t)
+	data := []byte("payload archive")
+
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, dir string) (file, sig string)
+		keys    []ed25519.PublicKey
+		wantKey ed25519.PublicKey
+		wantBad bool // errBadSignature
+		wantErr bool // any other error
+	}{
+		{
+			name: "valid",
+			prepare: func(t *testing.T, dir string) (string, string) {
+				file := writeSigned(t, dir, "payload.b64", data, priv)
+				return file, file + signatureSuffix
+			},
+			keys:    []ed25519.PublicKey{pub},
+			wantKey: pub,
+		},
+		{
+			name: "second trusted key",
+			prepare: func(t *testing.T, dir string) (string, string) {
+				file := writeSigned(t, dir, "payload.b64", data, otherPriv)
+				return file, file + signatureSuffix
+			},
+			keys:    []ed25519.PublicKey{pub, otherPub},
+			wantKey: otherPub,
+		},
+		{
+			name: "modified file",
+			prepare: func(t *testing.T, dir string) (string, string) {
+				file := writeSigned(t, dir, "payload.b64", data, priv)
+				if err := os.WriteFile(file, append(data, '!'), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return file, file + signatureSuffix
+			},
+			keys:    []ed25519.PublicKey{pub},
+			wantBad: true,
+		},
+		{
+			name: "untrusted key",
+			prepare: func(t *testing.T, dir string) (string, string) {
+				file := writeSigned(t, dir, "payload.b64", data, otherPriv)
+				return file, file + signatureSuffix
+			},
+			keys:    []ed25519.PublicKey{pub},
+			wantBad: true,
+		},
+		{
+			name: "missing signature",
+			prepare: func(t *testing.T, dir string) (string, string) {
+				file := writeSigned(t, dir, "payload.b64", data, priv)
+				return file, file + ".missing"
+			},
+			keys:    []ed25519.PublicKey{pub},
+			wantBad: true,
+		},
+		{
+			name: "no trusted keys",
+			prepare: func(t *testing.T, dir string) (string, string) {
+				file := writeSigned(t, dir, "payload.b64", data, priv)
+				return file, file + signatureSuffix
+			},
+			wantBad: true,
+		},
+		{
+			name: "malformed signature",
+			prepare: func(t *testing.T, dir string) (string, string) {
+				file := writeSigned(t, dir, "payload.b64", data, priv)
+				if err := os.WriteFile(file+signatureSuffix, []byte("c2hvcnQ=\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return file, file + signatureSuffix
+			},
+			keys:    []ed25519.PublicKey{pub},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, sig := tt.prepare(t, t.TempDir())
+			key, err := verifyFile(file, sig, tt.keys)
+			switch {
+			case tt.wantBad:
+				if !errors.Is(err, errBadSignature) {
+					t.Fatalf("err = %v, want errBadSignature", err)
+				}
+			case tt.wantErr:
+				if err == nil || errors.Is(err, errBadSignature) {
+					t.Fatalf("err = %v, want a read error", err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case !key.Equal(tt.wantKey):
+				t.Fatalf("verified with key %s, want %s", keyID(key), keyID(tt.wantKey))
+			}
+		})
+	}
+}
+
+// TestInstallScriptVerifiesSignatures runs verify_signature of install.sh, which checks
+// signatures with openssl, against files signed the way "api-guard sign" signs them.
+func TestInstallScriptVerifiesSignatures(t *testing.T) {
+	script, err := os.ReadFile(installScript)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Skip("install.sh is not next to the payload")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl is not installed")
+	}
+	functions := regexp.MustCompile(`(?ms)^(b64decode|verify_signature)\(\) \{.*?^\}\n`).FindAll(script, -1)
+	if len(functions) != 2 {
+		t.Fatalf("found %d of the 2 install.sh functions", len(functions))
+	}
+
+	pub, priv := newTestKey(t)
+	otherPub, _ := newTestKey(t)
+	dir := t.TempDir()
+	file := writeSigned(t, dir, "payload.b64", []byte("payload archive"), priv)
+	tampered := writeSigned(t, dir, "tampered.b64", []byte("payload archive"), priv)
+	if err := os.WriteFile(tampered, []byte("payload archive!"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		keys []ed25519.PublicKey
+		file string
+		want bool
+	}{
+		{"trusted key", []ed25519.PublicKey{otherPub, pub}, file, true},
+		{"untrusted key", []ed25519.PublicKey{otherPub}, file, false},
+		{"modified file", []ed25519.PublicKey{pub}, tampered, false},
+		{"no keys", nil, file, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := make([]string, 0, len(tt.keys))
+			for _, key := range tt.keys {
+				keys = append(keys, base64.StdEncoding.EncodeToString(key))
+			}
+			cmd := exec.Command("bash", "-c", string(functions[0])+string(functions[1])+`verify_signature "$1" "$1.sig"`, "bash", tt.file)
+			cmd.Env = append(os.Environ(), "TMPDIR="+t.TempDir(), "RELEASE_PUBKEYS="+strings.Join(keys, " "))
+			out, err := cmd.CombinedOutput()
+			if got := err == nil; got != tt.want {
+				t.Fatalf("verify_signature ok = %v, want %v (%v: %s)", got, tt.want, err, out)
+			}
+		})
+	}
+}
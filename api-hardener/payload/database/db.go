@@ -36,6 +36,10 @@ func initModels() error {
 	models := []any{
 		&model.User{},
 		&model.APIUser{},
+		&model.PanelSession{},
+		&model.APIAuditEvent{},
+		&model.APISigningKey{},
+		&model.APIRevokedAccessToken{},
 		&model.Inbound{},
 		&model.OutboundTraffics{},
 		&model.Setting{},
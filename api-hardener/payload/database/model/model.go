@@ -6,6 +6,7 @@ package model
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/util/json_util"
@@ -35,20 +36,123 @@ type User struct {
 	Password string `json:"password"`
 }
 
+// APIScope names a permission granted to an API user's token.
+type APIScope string
+
+// APIScope constants. Admin implies every other scope.
+const (
+	APIScopeRead  APIScope = "read"  // GET routes under /panel/api
+	APIScopeWrite APIScope = "write" // mutating routes under /panel/api
+	APIScopeAdmin APIScope = "admin" // management of API users and API settings
+)
+
 // APIUser represents a dedicated API consumer with its own token and rate limit controls.
 type APIUser struct {
 	Id                 int            `json:"id" gorm:"primaryKey;autoIncrement"`
 	Name               string         `json:"name" gorm:"uniqueIndex"`
-	TokenPrefix        string         `json:"-" gorm:"size:32;uniqueIndex"`
+	TokenPrefix        string         `json:"tokenPrefix" gorm:"size:32;uniqueIndex"` // public lookup part of the token
 	TokenHash          string         `json:"-" gorm:"size:255"`
+	TokenIssuedAt      *time.Time     `json:"tokenIssuedAt,omitempty"`                        // set on create and rotate
+	CertFingerprint    string         `json:"certFingerprint,omitempty" gorm:"size:64;index"` // SHA-256 of a bound client certificate
+	CertSubject        string         `json:"certSubject,omitempty"`                          // client certificate subject DN or common name
+	PeerUID            *int           `json:"peerUid,omitempty" gorm:"column:peer_uid;index"` // unix socket callers with this UID
+	PeerGID            *int           `json:"peerGid,omitempty" gorm:"column:peer_gid;index"` // unix socket callers with this GID (and PeerUID, if set)
+	AllowedOrigins     string         `json:"allowedOrigins,omitempty" gorm:"size:1024"`      // comma-separated browser origins allowed to use the token, empty = any
+	AccessWindow       string         `json:"accessWindow,omitempty" gorm:"size:255"`         // weekdays and hours the user may be used, e.g. "mon-fri 09:00-18:00", empty = any time
+	InboundAllowlist   string         `json:"inboundAllowlist,omitempty" gorm:"size:1024"`    // inbounds the user may see and modify, e.g. "id:3,tag:inbound-2000*,port:20000-20999", empty = all
 	RateLimitPerMinute int            `json:"rateLimitPerMinute" form:"rateLimitPerMinute" gorm:"default:0"`
+	Scopes             string         `json:"scopes" form:"scopes" gorm:"default:'read,write'"` // comma-separated APIScope list
 	Enabled            bool           `json:"enabled" form:"enabled" gorm:"default:true"`
 	CreatedAt          time.Time      `json:"createdAt"`
 	UpdatedAt          time.Time      `json:"updatedAt"`
 	LastUsedAt         *time.Time     `json:"lastUsedAt,omitempty"`
+	RequestCount       int64          `json:"requestCount" gorm:"default:0"` // successful token authentications
+	TokensNotBefore    int64          `json:"-" gorm:"default:0"`            // access tokens issued at or before this unix second are void
 	DeletedAt          gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// AllowsOrigin reports whether a browser page from origin may act as the API user.
+// Requests without an Origin header come from outside a browser and are always allowed.
+func (u *APIUser) AllowsOrigin(origin string) bool {
+	if u.AllowedOrigins == "" || origin == "" {
+		return true
+	}
+	for _, allowed := range strings.Split(u.AllowedOrigins, ",") {
+		if strings.EqualFold(strings.TrimSpace(allowed), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeList returns the scopes granted to the API user.
+func (u *APIUser) ScopeList() []APIScope {
+	scopes := make([]APIScope, 0)
+	for _, scope := range strings.Split(u.Scopes, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope != "" {
+			scopes = append(scopes, APIScope(scope))
+		}
+	}
+	return scopes
+}
+
+// HasScope reports whether the API user was granted scope, either directly or through admin.
+func (u *APIUser) HasScope(scope APIScope) bool {
+	for _, granted := range u.ScopeList() {
+		if granted == scope || granted == APIScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// PanelSession is the server-side record of a logged-in panel session. The session cookie
+// carries a random ID whose SHA-256 is stored here, so deleting the row logs the session out.
+type PanelSession struct {
+	Id           int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	SessionHash  string    `json:"-" gorm:"size:64;uniqueIndex"`
+	UserId       int       `json:"userId" gorm:"index"`
+	Username     string    `json:"username"`
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"userAgent"`
+	CreatedAt    time.Time `json:"createdAt"` // login time
+	LastActiveAt time.Time `json:"lastActiveAt"`
+}
+
+// APIAuditEvent records a security-relevant change to an API user, such as a token revoked
+// after a leak, or to the API as a whole (APIUserId 0), such as a lockdown.
+type APIAuditEvent struct {
+	Id          int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	CreatedAt   time.Time `json:"createdAt" gorm:"index"`
+	Event       string    `json:"event" gorm:"index"`
+	APIUserId   int       `json:"apiUserId" gorm:"index"`
+	APIUserName string    `json:"apiUserName"`
+	Actor       string    `json:"actor"` // who triggered it: panel user, api user, token holder or api-guard
+	IP          string    `json:"ip"`
+	Detail      string    `json:"detail"`
+}
+
+// APISigningKey is an Ed25519 key that signs short-lived API access tokens (JWTs). The newest
+// active key signs; retired keys only verify tokens issued before the rotation until they expire.
+type APISigningKey struct {
+	Id        int        `json:"id" gorm:"primaryKey;autoIncrement"`
+	Kid       string     `json:"kid" gorm:"size:32;uniqueIndex"`
+	Seed      string     `json:"-"` // Ed25519 private key seed, sealed with a key derived from the token pepper
+	CreatedAt time.Time  `json:"createdAt"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty"`
+}
+
+// APIRevokedAccessToken denies an access token (by its jti) before it expires, after an OAuth2
+// revocation request. Rows are dropped once the token would have expired anyway.
+type APIRevokedAccessToken struct {
+	Id        int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	Jti       string    `json:"jti" gorm:"size:32;uniqueIndex"`
+	APIUserId int       `json:"apiUserId"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"index"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
 // Inbound represents an Xray inbound configuration with traffic statistics and settings.
 type Inbound struct {
 	Id                   int                  `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`                                                    // Unique identifier
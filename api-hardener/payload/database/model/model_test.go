@@ -0,0 +1,29 @@
+//go:build toolsignore
+// +build toolsignore
+
+package model
+
+import "testing"
+
+func TestAPIUserAllowsOrigin(t *testing.T) {
+	tests := []struct {
+		allowed string
+		origin  string
+		want    bool
+	}{
+		{"", "https://evil.example", true},
+		{"https://dash.example.com", "", true},
+		{"https://dash.example.com", "https://dash.example.com", true},
+		{"https://dash.example.com", "HTTPS://Dash.Example.com", true},
+		{"https://a.example, https://dash.example.com", "https://dash.example.com", true},
+		{"https://dash.example.com", "http://dash.example.com", false},
+		{"https://dash.example.com", "https://dash.example.com:8443", false},
+		{"https://dash.example.com", "https://evil.example", false},
+	}
+	for _, tt := range tests {
+		u := &APIUser{AllowedOrigins: tt.allowed}
+		if got := u.AllowsOrigin(tt.origin); got != tt.want {
+			t.Errorf("APIUser{AllowedOrigins: %q}.AllowsOrigin(%q) = %v, want %v", tt.allowed, tt.origin, got, tt.want)
+		}
+	}
+}
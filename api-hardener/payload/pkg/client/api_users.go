@@ -0,0 +1,156 @@
+//go:build toolsignore
+// +build toolsignore
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// The calls below manage API users and require a token with the admin scope.
+
+// APISettings mirrors the global API settings of the panel.
+type APISettings struct {
+	APITokenOnly        bool `json:"apiTokenOnly"`
+	APIDefaultRateLimit int  `json:"apiDefaultRateLimit"`
+	APIJWTTTL           *int `json:"apiJWTTTL,omitempty"` // access token lifetime in minutes; nil leaves it unchanged
+}
+
+// ListAPIUsers returns all API users.
+func (c *Client) ListAPIUsers(ctx context.Context) ([]model.APIUser, error) {
+	var users []model.APIUser
+	err := c.call(ctx, request{method: http.MethodGet, path: "api-users/list"}, &users)
+	return users, err
+}
+
+// CreateAPIUser creates an API user and returns it with its plaintext token,
+// which the panel never discloses again.
+func (c *Client) CreateAPIUser(ctx context.Context, name string, rateLimitPerMinute int, scopes []model.APIScope) (*model.APIUser, string, error) {
+	req, err := jsonRequest(http.MethodPost, "api-users/create", map[string]any{
+		"name":   name,
+		"rate":   rateLimitPerMinute,
+		"scopes": scopes,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	var obj struct {
+		User  *model.APIUser `json:"user"`
+		Token string         `json:"token"`
+	}
+	if err := c.call(ctx, req, &obj); err != nil {
+		return nil, "", err
+	}
+	return obj.User, obj.Token, nil
+}
+
+// SetAPIUserEnabled enables or disables an API user.
+func (c *Client) SetAPIUserEnabled(ctx context.Context, id int, enabled bool) error {
+	action := "disable"
+	if enabled {
+		action = "enable"
+	}
+	return c.call(ctx, request{method: http.MethodPost, path: fmt.Sprintf("api-users/%s/%d", action, id)}, nil)
+}
+
+// DeleteAPIUser deletes an API user and invalidates its token.
+func (c *Client) DeleteAPIUser(ctx context.Context, id int) error {
+	return c.call(ctx, request{method: http.MethodPost, path: fmt.Sprintf("api-users/delete/%d", id)}, nil)
+}
+
+// RotateAPIUserToken issues a new token for an API user and returns it.
+func (c *Client) RotateAPIUserToken(ctx context.Context, id int) (string, error) {
+	var obj struct {
+		Token string `json:"token"`
+	}
+	err := c.call(ctx, request{method: http.MethodPost, path: fmt.Sprintf("api-users/rotate/%d", id)}, &obj)
+	return obj.Token, err
+}
+
+// SetAPIUserRateLimit sets the per-minute limit of an API user (0 = panel default).
+func (c *Client) SetAPIUserRateLimit(ctx context.Context, id int, rateLimitPerMinute int) error {
+	req, err := jsonRequest(http.MethodPost, fmt.Sprintf("api-users/rate/%d", id), map[string]int{"rate": rateLimitPerMinute})
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, req, nil)
+}
+
+// SetAPIUserScopes replaces the scopes of an API user.
+func (c *Client) SetAPIUserScopes(ctx context.Context, id int, scopes []model.APIScope) error {
+	req, err := jsonRequest(http.MethodPost, fmt.Sprintf("api-users/scopes/%d", id), map[string]any{"scopes": scopes})
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, req, nil)
+}
+
+// SetAPIUserOrigins restricts an API user to browser pages from origins; none lifts the restriction.
+func (c *Client) SetAPIUserOrigins(ctx context.Context, id int, origins []string) error {
+	req, err := jsonRequest(http.MethodPost, fmt.Sprintf("api-users/origins/%d", id), map[string]string{"origins": strings.Join(origins, ",")})
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, req, nil)
+}
+
+// SetAPIUserAccessWindow limits an API user to weekdays and hours, e.g. "mon-fri 09:00-18:00"
+// in the panel's time zone; empty allows any time.
+func (c *Client) SetAPIUserAccessWindow(ctx context.Context, id int, window string) error {
+	req, err := jsonRequest(http.MethodPost, fmt.Sprintf("api-users/window/%d", id), map[string]string{"window": window})
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, req, nil)
+}
+
+// SetAPIUserInbounds restricts an API user to the inbounds of an allowlist such as
+// "id:3,tag:inbound-2000*,port:20000-20999"; empty allows all inbounds.
+func (c *Client) SetAPIUserInbounds(ctx context.Context, id int, allowlist string) error {
+	req, err := jsonRequest(http.MethodPost, fmt.Sprintf("api-users/inbounds/%d", id), map[string]string{"inbounds": allowlist})
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, req, nil)
+}
+
+// GetAPISettings returns the global API settings.
+func (c *Client) GetAPISettings(ctx context.Context) (*APISettings, error) {
+	settings := &APISettings{}
+	if err := c.call(ctx, request{method: http.MethodGet, path: "api-users/settings"}, settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateAPISettings replaces the global API settings.
+func (c *Client) UpdateAPISettings(ctx context.Context, settings APISettings) error {
+	req, err := jsonRequest(http.MethodPost, "api-users/settings", settings)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, req, nil)
+}
+
+// ListAuditEvents returns the API audit trail newest first: token revocations, lockdowns,
+// signing key rotations and the like. apiUserId > 0 keeps only the events of that API
+// user; limit <= 0 returns all events.
+func (c *Client) ListAuditEvents(ctx context.Context, apiUserId int, limit int) ([]model.APIAuditEvent, error) {
+	query := url.Values{}
+	if apiUserId > 0 {
+		query.Set("apiUserId", strconv.Itoa(apiUserId))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var events []model.APIAuditEvent
+	err := c.call(ctx, request{method: http.MethodGet, path: "api-users/audit", query: query}, &events)
+	return events, err
+}
@@ -0,0 +1,100 @@
+//go:build toolsignore
+// +build toolsignore
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// The panel exchanges API tokens for short-lived access tokens (JWTs signed with Ed25519).
+// An access token works with New like an API token, is verified without a database lookup
+// and expires after the panel's apiJWTTTL; ExchangeToken and JWKS need no admin scope.
+
+// AccessToken is an access token issued by ExchangeToken.
+type AccessToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"` // seconds
+	Scope       string `json:"scope"`      // space separated
+}
+
+// Expiry returns when the token expires, counted from issued.
+func (t *AccessToken) Expiry(issued time.Time) time.Time {
+	return issued.Add(time.Duration(t.ExpiresIn) * time.Second)
+}
+
+// JSONWebKey is an Ed25519 public key in JWK form (RFC 8037).
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// ExchangeToken trades the client's API token for an access token limited to scopes;
+// no scopes means all scopes of the API token.
+func (c *Client) ExchangeToken(ctx context.Context, scopes ...model.APIScope) (*AccessToken, error) {
+	names := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		names = append(names, string(scope))
+	}
+	req, err := jsonRequest(http.MethodPost, "auth/token", map[string]any{"scope": strings.Join(names, " ")})
+	if err != nil {
+		return nil, err
+	}
+	raw, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	token := &AccessToken{}
+	if err := json.Unmarshal(raw, token); err != nil {
+		return nil, fmt.Errorf("client: decode response: %w", err)
+	}
+	return token, nil
+}
+
+// JWKS returns the public keys that verify access tokens.
+func (c *Client) JWKS(ctx context.Context) ([]JSONWebKey, error) {
+	raw, err := c.do(ctx, request{method: http.MethodGet, path: "auth/jwks"})
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("client: decode response: %w", err)
+	}
+	return set.Keys, nil
+}
+
+// ListSigningKeys returns the access token signing keys, the active one first.
+// It requires the admin scope.
+func (c *Client) ListSigningKeys(ctx context.Context) ([]model.APISigningKey, error) {
+	var keys []model.APISigningKey
+	err := c.call(ctx, request{method: http.MethodGet, path: "auth/keys"}, &keys)
+	return keys, err
+}
+
+// RotateSigningKey starts signing access tokens with a new key. With dropOld the previous
+// keys are deleted, which invalidates every access token issued so far. It requires the
+// admin scope.
+func (c *Client) RotateSigningKey(ctx context.Context, dropOld bool) (*model.APISigningKey, error) {
+	req, err := jsonRequest(http.MethodPost, "auth/keys/rotate", map[string]any{"dropOld": dropOld})
+	if err != nil {
+		return nil, err
+	}
+	key := &model.APISigningKey{}
+	err = c.call(ctx, req, key)
+	return key, err
+}
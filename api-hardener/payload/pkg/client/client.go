@@ -0,0 +1,274 @@
+//go:build toolsignore
+// +build toolsignore
+
+// Package client is a Go SDK for the token-protected /panel/api of the 3x-ui panel.
+// It handles bearer authentication, the panel's response envelope and automatic
+// backoff when the per-token rate limit is hit.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix         = "panel/api/"
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 3
+	defaultMaxBackoff = 30 * time.Second
+	initialBackoff    = 500 * time.Millisecond
+)
+
+// Client talks to a single panel using one API token, or as the calling process over
+// the panel's unix socket. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	token      string
+	httpClient *http.Client
+	maxRetries int
+	maxBackoff time.Duration
+	userAgent  string
+}
+
+// Option customizes a Client created by New.
+type Option func(*Client)
+
+// WithHTTPClient replaces the default HTTP client (30s timeout).
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
+
+// WithMaxRetries sets how many times a rate-limited request is retried (0 disables retries).
+func WithMaxRetries(n int) Option {
+	return func(c *Client) {
+		if n >= 0 {
+			c.maxRetries = n
+		}
+	}
+}
+
+// WithMaxBackoff caps the wait between retries, including waits requested via Retry-After.
+func WithMaxBackoff(d time.Duration) Option {
+	return func(c *Client) {
+		if d > 0 {
+			c.maxBackoff = d
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// New creates a client for the panel at baseURL, which must include the panel's
+// web base path (for example https://panel.example.com:2053/secret/).
+//
+// A unix:///path/to/api.sock URL talks to the panel's API socket instead. The panel
+// authorizes socket callers by their UID/GID, so token may be empty, and the socket
+// serves the API without base path. WithHTTPClient keeps its timeout but not its
+// transport for sockets.
+func New(baseURL string, token string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSpace(baseURL))
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid base url: %w", err)
+	}
+	socket := ""
+	if u.Scheme == "unix" {
+		socket = u.Path
+		if socket == "" {
+			return nil, fmt.Errorf("client: unix url needs a socket path, got %q", baseURL)
+		}
+		u = &url.URL{Scheme: "http", Host: "localhost", Path: "/"}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: base url must be http, https or unix, got %q", baseURL)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	token = strings.TrimSpace(token)
+	if token == "" && socket == "" {
+		return nil, errors.New("client: token can not be empty")
+	}
+
+	c := &Client{
+		baseURL:    u,
+		token:      token,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		maxRetries: defaultMaxRetries,
+		maxBackoff: defaultMaxBackoff,
+		userAgent:  "3x-ui-client",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if socket != "" {
+		httpClient := *c.httpClient
+		httpClient.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		c.httpClient = &httpClient
+	}
+	return c, nil
+}
+
+// envelope mirrors entity.Msg, the wrapper every panel handler responds with.
+type envelope struct {
+	Success bool            `json:"success"`
+	Msg     string          `json:"msg"`
+	Obj     json.RawMessage `json:"obj"`
+}
+
+// request describes a single call relative to /panel/api/.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   []byte
+	ctype  string
+}
+
+func jsonRequest(method, path string, v any) (request, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return request{}, err
+	}
+	return request{method: method, path: path, body: body, ctype: "application/json"}, nil
+}
+
+func formRequest(method, path string, values url.Values) request {
+	return request{
+		method: method,
+		path:   path,
+		body:   []byte(values.Encode()),
+		ctype:  "application/x-www-form-urlencoded",
+	}
+}
+
+// call performs the request and decodes the envelope's obj into out (when non-nil).
+func (c *Client) call(ctx context.Context, req request, out any) error {
+	raw, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	// Some handlers (e.g. backuptotgbot) answer with an empty body.
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil
+	}
+
+	env := envelope{}
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return fmt.Errorf("client: decode response: %w", err)
+	}
+	if !env.Success {
+		return &Error{StatusCode: http.StatusOK, Message: env.Msg}
+	}
+	if out == nil || len(env.Obj) == 0 || string(env.Obj) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(env.Obj, out); err != nil {
+		return fmt.Errorf("client: decode obj: %w", err)
+	}
+	return nil
+}
+
+// do sends the request, retrying with backoff while the panel answers 429.
+// It returns the raw body of the first non-429 successful response.
+func (c *Client) do(ctx context.Context, req request) ([]byte, error) {
+	endpoint := c.baseURL.ResolveReference(&url.URL{Path: apiPrefix + strings.TrimPrefix(req.path, "/"), RawQuery: req.query.Encode()})
+
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		if req.body != nil {
+			body = bytes.NewReader(req.body)
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, endpoint.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		if c.token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		httpReq.Header.Set("Accept", "application/json")
+		if c.userAgent != "" {
+			httpReq.Header.Set("User-Agent", c.userAgent)
+		}
+		if req.ctype != "" {
+			httpReq.Header.Set("Content-Type", req.ctype)
+		}
+
+		resp, err := c.httpClient.Do(httpReq)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return raw, nil
+		}
+
+		apiErr := newError(resp, raw)
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= c.maxRetries {
+			return nil, apiErr
+		}
+
+		wait := c.backoff(attempt, apiErr.RetryAfter)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff prefers the server's Retry-After hint and falls back to exponential delays.
+func (c *Client) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	wait := retryAfter
+	if wait <= 0 {
+		wait = initialBackoff << attempt
+	}
+	if wait > c.maxBackoff {
+		wait = c.maxBackoff
+	}
+	return wait
+}
+
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
@@ -0,0 +1,472 @@
+//go:build toolsignore
+// +build toolsignore
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/controller"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// testPanelURL serves the panel API routes from a fresh database for the tests, and
+// testAdminToken is an admin API token of that panel.
+var testPanelURL, testAdminToken string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "x-ui-client-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv(service.APITokenPepperEnv, "0123456789abcdef0123456789abcdef")
+	if err := database.InitDB(filepath.Join(dir, "x-ui.db")); err != nil {
+		panic(err)
+	}
+	users := &service.APIUserService{}
+	if _, testAdminToken, err = users.CreateUser("sdk-admin", 0, []model.APIScope{model.APIScopeAdmin}); err != nil {
+		panic(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	controller.NewAPIController(engine.Group("/"))
+	server := httptest.NewServer(engine)
+	testPanelURL = server.URL + "/"
+
+	code := m.Run()
+	server.Close()
+	database.CloseDB()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newTestClient(t *testing.T, token string, opts ...Option) *Client {
+	t.Helper()
+	c, err := New(testPanelURL, token, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	admin := newTestClient(t, testAdminToken)
+
+	users, err := admin.ListAPIUsers(ctx)
+	if err != nil {
+		t.Fatalf("ListAPIUsers: %v", err)
+	}
+	if !slices.ContainsFunc(users, func(u model.APIUser) bool { return u.Name == "sdk-admin" }) {
+		t.Fatalf("ListAPIUsers = %+v, want sdk-admin", users)
+	}
+
+	reader, readerToken, err := admin.CreateAPIUser(ctx, "sdk-reader", 0, []model.APIScope{model.APIScopeRead})
+	if err != nil {
+		t.Fatalf("CreateAPIUser: %v", err)
+	}
+	if reader.Name != "sdk-reader" || readerToken == "" || reader.TokenHash != "" {
+		t.Fatalf("CreateAPIUser = %+v, %q", reader, readerToken)
+	}
+	if _, _, err := admin.CreateAPIUser(ctx, "sdk-reader", 0, nil); !errors.Is(err, ErrRejected) {
+		t.Fatalf("CreateAPIUser with a taken name = %v, want ErrRejected", err)
+	}
+
+	// The reader lacks the admin scope, unknown tokens do not reveal the API.
+	readerClient := newTestClient(t, readerToken)
+	if _, err := readerClient.ListAPIUsers(ctx); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("ListAPIUsers as reader = %v, want ErrForbidden", err)
+	}
+	if _, err := newTestClient(t, "xui_live_bogus").ListAPIUsers(ctx); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ListAPIUsers with a bogus token = %v, want ErrNotFound", err)
+	}
+
+	// A disabled user's token stops working, a rotated token replaces the old one.
+	if err := admin.SetAPIUserEnabled(ctx, reader.Id, false); err != nil {
+		t.Fatalf("SetAPIUserEnabled: %v", err)
+	}
+	if _, err := readerClient.ListAPIUsers(ctx); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ListAPIUsers as disabled reader = %v, want ErrNotFound", err)
+	}
+	if err := admin.SetAPIUserEnabled(ctx, reader.Id, true); err != nil {
+		t.Fatalf("SetAPIUserEnabled: %v", err)
+	}
+	if err := admin.SetAPIUserScopes(ctx, reader.Id, []model.APIScope{model.APIScopeRead, model.APIScopeAdmin}); err != nil {
+		t.Fatalf("SetAPIUserScopes: %v", err)
+	}
+	rotated, err := admin.RotateAPIUserToken(ctx, reader.Id)
+	if err != nil {
+		t.Fatalf("RotateAPIUserToken: %v", err)
+	}
+	if _, err := readerClient.ListAPIUsers(ctx); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ListAPIUsers with the rotated-out token = %v, want ErrNotFound", err)
+	}
+	if _, err := newTestClient(t, rotated).ListAPIUsers(ctx); err != nil {
+		t.Fatalf("ListAPIUsers with the rotated token: %v", err)
+	}
+
+	// Access tokens work like API tokens and are verified with the published keys.
+	access, err := admin.ExchangeToken(ctx, model.APIScopeAdmin)
+	if err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+	if access.TokenType != "Bearer" || access.Scope != string(model.APIScopeAdmin) || access.ExpiresIn <= 0 {
+		t.Fatalf("ExchangeToken = %+v", access)
+	}
+	jwtClient := newTestClient(t, access.AccessToken)
+	if _, err := jwtClient.ListAPIUsers(ctx); err != nil {
+		t.Fatalf("ListAPIUsers with an access token: %v", err)
+	}
+	keys, err := admin.JWKS(ctx)
+	if err != nil || len(keys) == 0 || keys[0].Alg != "EdDSA" {
+		t.Fatalf("JWKS = %+v, %v", keys, err)
+	}
+	signing, err := admin.ListSigningKeys(ctx)
+	if err != nil || len(signing) == 0 || signing[0].Kid != keys[0].Kid {
+		t.Fatalf("ListSigningKeys = %+v, %v", signing, err)
+	}
+
+	status, err := admin.GetLockdown(ctx)
+	if err != nil || status.Mode != LockdownOff {
+		t.Fatalf("GetLockdown = %+v, %v", status, err)
+	}
+	settings, err := admin.GetAPISettings(ctx)
+	if err != nil {
+		t.Fatalf("GetAPISettings: %v", err)
+	}
+	settings.APIDefaultRateLimit = 120
+	if err := admin.UpdateAPISettings(ctx, *settings); err != nil {
+		t.Fatalf("UpdateAPISettings: %v", err)
+	}
+	if got, err := admin.GetAPISettings(ctx); err != nil || got.APIDefaultRateLimit != 120 {
+		t.Fatalf("GetAPISettings after update = %+v, %v", got, err)
+	}
+
+	if err := admin.DeleteAPIUser(ctx, reader.Id); err != nil {
+		t.Fatalf("DeleteAPIUser: %v", err)
+	}
+	users, err = admin.ListAPIUsers(ctx)
+	if err != nil {
+		t.Fatalf("ListAPIUsers: %v", err)
+	}
+	if slices.ContainsFunc(users, func(u model.APIUser) bool { return u.Id == reader.Id }) {
+		t.Fatal("deleted API user is still listed")
+	}
+	if _, err := admin.ListAuditEvents(ctx, 0, 10); err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+}
+
+func TestClientRateLimited(t *testing.T) {
+	ctx := context.Background()
+	admin := newTestClient(t, testAdminToken)
+
+	limited, token, err := admin.CreateAPIUser(ctx, "sdk-limited", 1, []model.APIScope{model.APIScopeAdmin})
+	if err != nil {
+		t.Fatalf("CreateAPIUser: %v", err)
+	}
+	c := newTestClient(t, token, WithMaxRetries(0))
+	if _, err := c.ListAPIUsers(ctx); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	_, err = c.ListAPIUsers(ctx)
+	var apiErr *Error
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &apiErr) {
+		t.Fatalf("second call = %v, want ErrRateLimited", err)
+	}
+	if apiErr.RetryAfter <= 0 || apiErr.RateLimit != limited.RateLimitPerMinute {
+		t.Fatalf("rate limit error = %+v, want Retry-After and limit %d", apiErr, limited.RateLimitPerMinute)
+	}
+}
+
+func TestClientInbounds(t *testing.T) {
+	ctx := context.Background()
+	admin := newTestClient(t, testAdminToken)
+
+	// The inbound stays disabled, so the panel does not push it to a running Xray.
+	settings, _ := json.Marshal(clientSettings{Clients: []model.Client{{ID: "sdk-uuid-1", Email: "sdk-1@example.com", Enable: true}}})
+	created, err := admin.AddInbound(ctx, &model.Inbound{
+		Remark:   "sdk",
+		Port:     20001,
+		Protocol: model.VLESS,
+		Settings: string(settings),
+	})
+	if err != nil {
+		t.Fatalf("AddInbound: %v", err)
+	}
+	if created.Id == 0 || created.Remark != "sdk" || created.Port != 20001 {
+		t.Fatalf("AddInbound = %+v", created)
+	}
+
+	inbounds, err := admin.ListInbounds(ctx)
+	if err != nil || !slices.ContainsFunc(inbounds, func(i model.Inbound) bool { return i.Id == created.Id }) {
+		t.Fatalf("ListInbounds = %+v, %v; want inbound %d", inbounds, err, created.Id)
+	}
+	got, err := admin.GetInbound(ctx, created.Id)
+	if err != nil || got.Remark != "sdk" || got.Protocol != model.VLESS {
+		t.Fatalf("GetInbound = %+v, %v", got, err)
+	}
+	got.Remark = "sdk-renamed"
+	updated, err := admin.UpdateInbound(ctx, created.Id, got)
+	if err != nil || updated.Remark != "sdk-renamed" {
+		t.Fatalf("UpdateInbound = %+v, %v", updated, err)
+	}
+
+	if err := admin.AddClients(ctx, created.Id, model.Client{ID: "sdk-uuid-2", Email: "sdk-2@example.com", Enable: true}); err != nil {
+		t.Fatalf("AddClients: %v", err)
+	}
+	if err := admin.UpdateClient(ctx, created.Id, "sdk-uuid-2", model.Client{ID: "sdk-uuid-2", Email: "sdk-3@example.com", Enable: true}); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	clients, err := admin.Clients(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Clients: %v", err)
+	}
+	emails := make([]string, 0, len(clients))
+	for _, client := range clients {
+		emails = append(emails, client.Email)
+	}
+	if !slices.Equal(emails, []string{"sdk-1@example.com", "sdk-3@example.com"}) {
+		t.Fatalf("Clients = %q, want sdk-1 and the renamed sdk-3", emails)
+	}
+	traffic, err := admin.ClientTraffics(ctx, "sdk-3@example.com")
+	if err != nil || traffic.Email != "sdk-3@example.com" || traffic.InboundId != created.Id {
+		t.Fatalf("ClientTraffics = %+v, %v", traffic, err)
+	}
+
+	if err := admin.DeleteClient(ctx, created.Id, "sdk-uuid-1"); err != nil {
+		t.Fatalf("DeleteClient: %v", err)
+	}
+	if clients, err := admin.Clients(ctx, created.Id); err != nil || len(clients) != 1 || clients[0].ID != "sdk-uuid-2" {
+		t.Fatalf("Clients after DeleteClient = %+v, %v", clients, err)
+	}
+
+	if err := admin.DeleteInbound(ctx, created.Id); err != nil {
+		t.Fatalf("DeleteInbound: %v", err)
+	}
+	if _, err := admin.GetInbound(ctx, created.Id); !errors.Is(err, ErrRejected) {
+		t.Fatalf("GetInbound after DeleteInbound = %v, want ErrRejected", err)
+	}
+}
+
+// rateLimitedPanel answers 429 with retryAfter to the first limited requests and
+// succeeds afterwards; limited < 0 limits every request.
+type rateLimitedPanel struct {
+	limited    int
+	status     int // status of the limited answers, 429 when zero
+	retryAfter string
+
+	mu       sync.Mutex
+	attempts int
+}
+
+func (p *rateLimitedPanel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.attempts++
+	limited := p.limited < 0 || p.attempts <= p.limited
+	p.mu.Unlock()
+	if !limited {
+		_, _ = io.WriteString(w, `{"success":true,"obj":["a@example.com"]}`)
+		return
+	}
+	if p.retryAfter != "" {
+		w.Header().Set("Retry-After", p.retryAfter)
+	}
+	w.Header().Set("X-RateLimit-Limit", "60")
+	status := p.status
+	if status == 0 {
+		status = http.StatusTooManyRequests
+	}
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, `{"error":"slow down"}`)
+}
+
+func (p *rateLimitedPanel) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.attempts
+}
+
+func TestClientRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		panel        *rateLimitedPanel
+		opts         []Option
+		wantStatus   int // status of the returned *Error, 0 for success
+		wantAttempts int
+		minElapsed   time.Duration
+		maxElapsed   time.Duration
+	}{
+		{
+			name:         "waits Retry-After",
+			panel:        &rateLimitedPanel{limited: 1, retryAfter: "1"},
+			wantAttempts: 2,
+			minElapsed:   time.Second,
+			maxElapsed:   5 * time.Second,
+		},
+		{
+			name:         "Retry-After capped by WithMaxBackoff",
+			panel:        &rateLimitedPanel{limited: 2, retryAfter: "30"},
+			opts:         []Option{WithMaxBackoff(20 * time.Millisecond)},
+			wantAttempts: 3,
+			minElapsed:   40 * time.Millisecond,
+			maxElapsed:   5 * time.Second,
+		},
+		{
+			name:         "exponential without Retry-After",
+			panel:        &rateLimitedPanel{limited: 2},
+			wantAttempts: 3,
+			minElapsed:   initialBackoff + 2*initialBackoff,
+			maxElapsed:   10 * time.Second,
+		},
+		{
+			name:         "gives up after WithMaxRetries",
+			panel:        &rateLimitedPanel{limited: -1, retryAfter: "30"},
+			opts:         []Option{WithMaxRetries(2), WithMaxBackoff(10 * time.Millisecond)},
+			wantStatus:   http.StatusTooManyRequests,
+			wantAttempts: 3,
+			maxElapsed:   5 * time.Second,
+		},
+		{
+			name:         "no retries",
+			panel:        &rateLimitedPanel{limited: -1, retryAfter: "1"},
+			opts:         []Option{WithMaxRetries(0)},
+			wantStatus:   http.StatusTooManyRequests,
+			wantAttempts: 1,
+			maxElapsed:   time.Second,
+		},
+		{
+			name:         "other errors are not retried",
+			panel:        &rateLimitedPanel{limited: -1, status: http.StatusServiceUnavailable, retryAfter: "1"},
+			wantStatus:   http.StatusServiceUnavailable,
+			wantAttempts: 1,
+			maxElapsed:   time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.panel)
+			defer server.Close()
+			c, err := New(server.URL, "xui_live_test", tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			emails, err := c.Onlines(context.Background())
+			elapsed := time.Since(start)
+
+			if tt.wantStatus == 0 {
+				if err != nil || !slices.Equal(emails, []string{"a@example.com"}) {
+					t.Fatalf("Onlines = %q, %v", emails, err)
+				}
+			} else {
+				var apiErr *Error
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus {
+					t.Fatalf("Onlines error = %v, want status %d", err, tt.wantStatus)
+				}
+				if limited := errors.Is(err, ErrRateLimited); limited != (tt.wantStatus == http.StatusTooManyRequests) {
+					t.Fatalf("errors.Is(err, ErrRateLimited) = %v for status %d", limited, tt.wantStatus)
+				}
+				if apiErr.RateLimit != 60 || apiErr.Message != "slow down" || apiErr.RetryAfter != parseRetryAfter(tt.panel.retryAfter) {
+					t.Fatalf("Onlines error = %+v, want the limit, message and Retry-After of the panel", apiErr)
+				}
+			}
+			if attempts := tt.panel.count(); attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+				t.Errorf("took %v, want between %v and %v", elapsed, tt.minElapsed, tt.maxElapsed)
+			}
+		})
+	}
+}
+
+func TestClientRetryCanceled(t *testing.T) {
+	panel := &rateLimitedPanel{limited: -1, retryAfter: "30"}
+	server := httptest.NewServer(panel)
+	defer server.Close()
+	c, err := New(server.URL, "xui_live_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.Onlines(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Onlines = %v, want the context error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Onlines returned after %v, want it to stop waiting when the context ends", elapsed)
+	}
+	if attempts := panel.count(); attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestClientBackoff(t *testing.T) {
+	c := &Client{maxBackoff: 4 * time.Second}
+	tests := []struct {
+		attempt    int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{0, 0, initialBackoff},
+		{1, 0, 2 * initialBackoff},
+		{2, 0, 4 * initialBackoff},
+		{10, 0, 4 * time.Second},
+		{0, 3 * time.Second, 3 * time.Second},
+		{5, time.Second, time.Second},
+		{0, time.Minute, 4 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := c.backoff(tt.attempt, tt.retryAfter); got != tt.want {
+			t.Errorf("backoff(%d, %v) = %v, want %v", tt.attempt, tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		want     time.Duration
+		fromDate bool // an HTTP date; allow for the time passed
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{" 7 ", 7 * time.Second, false},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 10 * time.Second, true},
+	}
+	for _, tt := range tests {
+		got := parseRetryAfter(tt.value)
+		if tt.fromDate {
+			if got <= tt.want-2*time.Second || got > tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want about %v", tt.value, got, tt.want)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
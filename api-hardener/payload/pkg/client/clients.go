@@ -0,0 +1,136 @@
+//go:build toolsignore
+// +build toolsignore
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// clientSettings is the subset of an inbound's settings JSON that holds its clients.
+type clientSettings struct {
+	Clients []model.Client `json:"clients"`
+}
+
+func clientsPayload(inboundID int, clients []model.Client) (*model.Inbound, error) {
+	settings, err := json.Marshal(clientSettings{Clients: clients})
+	if err != nil {
+		return nil, err
+	}
+	return &model.Inbound{Id: inboundID, Settings: string(settings)}, nil
+}
+
+// Clients returns the clients configured on an inbound.
+func (c *Client) Clients(ctx context.Context, inboundID int) ([]model.Client, error) {
+	inbound, err := c.GetInbound(ctx, inboundID)
+	if err != nil {
+		return nil, err
+	}
+	settings := clientSettings{}
+	if inbound.Settings != "" {
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			return nil, fmt.Errorf("client: decode inbound %d settings: %w", inboundID, err)
+		}
+	}
+	return settings.Clients, nil
+}
+
+// AddClients appends clients to an inbound.
+func (c *Client) AddClients(ctx context.Context, inboundID int, clients ...model.Client) error {
+	payload, err := clientsPayload(inboundID, clients)
+	if err != nil {
+		return err
+	}
+	req, err := jsonRequest(http.MethodPost, "inbounds/addClient", payload)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, req, nil)
+}
+
+// UpdateClient replaces the client identified by clientID (UUID, password or email,
+// depending on the inbound protocol).
+func (c *Client) UpdateClient(ctx context.Context, inboundID int, clientID string, client model.Client) error {
+	payload, err := clientsPayload(inboundID, []model.Client{client})
+	if err != nil {
+		return err
+	}
+	req, err := jsonRequest(http.MethodPost, "inbounds/updateClient/"+url.PathEscape(clientID), payload)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, req, nil)
+}
+
+// DeleteClient removes a client from an inbound by its protocol-specific ID.
+func (c *Client) DeleteClient(ctx context.Context, inboundID int, clientID string) error {
+	path := fmt.Sprintf("inbounds/%d/delClient/%s", inboundID, url.PathEscape(clientID))
+	return c.call(ctx, request{method: http.MethodPost, path: path}, nil)
+}
+
+// DeleteClientByEmail removes a client from an inbound by email.
+func (c *Client) DeleteClientByEmail(ctx context.Context, inboundID int, email string) error {
+	path := fmt.Sprintf("inbounds/%d/delClientByEmail/%s", inboundID, url.PathEscape(email))
+	return c.call(ctx, request{method: http.MethodPost, path: path}, nil)
+}
+
+// ClientTraffics returns the traffic counters of the client with the given email.
+func (c *Client) ClientTraffics(ctx context.Context, email string) (*xray.ClientTraffic, error) {
+	traffic := &xray.ClientTraffic{}
+	err := c.call(ctx, request{method: http.MethodGet, path: "inbounds/getClientTraffics/" + url.PathEscape(email)}, traffic)
+	if err != nil {
+		return nil, err
+	}
+	return traffic, nil
+}
+
+// ClientTrafficsByID returns the traffic counters of the client with the given ID.
+func (c *Client) ClientTrafficsByID(ctx context.Context, clientID string) ([]xray.ClientTraffic, error) {
+	var traffics []xray.ClientTraffic
+	err := c.call(ctx, request{method: http.MethodGet, path: "inbounds/getClientTrafficsById/" + url.PathEscape(clientID)}, &traffics)
+	return traffics, err
+}
+
+// ResetClientTraffic zeroes the traffic counters of one client of an inbound.
+func (c *Client) ResetClientTraffic(ctx context.Context, inboundID int, email string) error {
+	path := fmt.Sprintf("inbounds/%d/resetClientTraffic/%s", inboundID, url.PathEscape(email))
+	return c.call(ctx, request{method: http.MethodPost, path: path}, nil)
+}
+
+// UpdateClientTraffic overwrites the upload/download counters of a client.
+func (c *Client) UpdateClientTraffic(ctx context.Context, email string, upload, download int64) error {
+	req, err := jsonRequest(http.MethodPost, "inbounds/updateClientTraffic/"+url.PathEscape(email), map[string]int64{
+		"upload":   upload,
+		"download": download,
+	})
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, req, nil)
+}
+
+// ClientIPs returns the IP log recorded for a client, as reported by the panel.
+func (c *Client) ClientIPs(ctx context.Context, email string) (string, error) {
+	var raw json.RawMessage
+	err := c.call(ctx, request{method: http.MethodPost, path: "inbounds/clientIps/" + url.PathEscape(email)}, &raw)
+	if err != nil {
+		return "", err
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text, nil
+	}
+	return string(raw), nil
+}
+
+// ClearClientIPs drops the IP log recorded for a client.
+func (c *Client) ClearClientIPs(ctx context.Context, email string) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "inbounds/clearClientIps/" + url.PathEscape(email)}, nil)
+}
@@ -0,0 +1,86 @@
+//go:build toolsignore
+// +build toolsignore
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinel errors matched by errors.Is against an *Error.
+var (
+	// ErrNotFound is reported for 404 responses. The API middleware also answers 404
+	// for missing or invalid tokens so that the API does not reveal itself.
+	ErrNotFound = errors.New("client: not found")
+	// ErrForbidden is reported when the token lacks access to the route.
+	ErrForbidden = errors.New("client: forbidden")
+	// ErrUnauthorized is reported for 401 responses.
+	ErrUnauthorized = errors.New("client: unauthorized")
+	// ErrRateLimited is reported when retries were exhausted on 429 responses.
+	ErrRateLimited = errors.New("client: rate limit exceeded")
+	// ErrRejected is reported when the panel returned success=false in its envelope.
+	ErrRejected = errors.New("client: request rejected by panel")
+)
+
+// Error describes a failed API call.
+type Error struct {
+	StatusCode int           // HTTP status code; 200 when the envelope reported success=false
+	Message    string        // msg/error text returned by the panel, if any
+	RetryAfter time.Duration // server-provided wait for 429 responses
+	RateLimit  int           // X-RateLimit-Limit of the token, if advertised
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("client: panel responded %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("client: panel responded %d: %s", e.StatusCode, e.Message)
+}
+
+// Is maps the status code onto the package sentinel errors.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrRejected:
+		return e.StatusCode == http.StatusOK
+	}
+	return false
+}
+
+func newError(resp *http.Response, body []byte) *Error {
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	if limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil {
+		e.RateLimit = limit
+	}
+
+	var payload struct {
+		Msg   string `json:"msg"`
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		e.Message = payload.Msg
+		if e.Message == "" {
+			e.Message = payload.Error
+		}
+	}
+	if e.Message == "" {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
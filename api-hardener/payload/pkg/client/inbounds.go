@@ -0,0 +1,105 @@
+//go:build toolsignore
+// +build toolsignore
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// ListInbounds returns every inbound visible to the token, including client stats.
+func (c *Client) ListInbounds(ctx context.Context) ([]model.Inbound, error) {
+	var inbounds []model.Inbound
+	err := c.call(ctx, request{method: http.MethodGet, path: "inbounds/list"}, &inbounds)
+	return inbounds, err
+}
+
+// GetInbound fetches a single inbound by ID.
+func (c *Client) GetInbound(ctx context.Context, id int) (*model.Inbound, error) {
+	inbound := &model.Inbound{}
+	err := c.call(ctx, request{method: http.MethodGet, path: fmt.Sprintf("inbounds/get/%d", id)}, inbound)
+	if err != nil {
+		return nil, err
+	}
+	return inbound, nil
+}
+
+// AddInbound creates an inbound and returns it as stored by the panel.
+func (c *Client) AddInbound(ctx context.Context, inbound *model.Inbound) (*model.Inbound, error) {
+	req, err := jsonRequest(http.MethodPost, "inbounds/add", inbound)
+	if err != nil {
+		return nil, err
+	}
+	created := &model.Inbound{}
+	if err := c.call(ctx, req, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// UpdateInbound replaces the inbound with the given ID.
+func (c *Client) UpdateInbound(ctx context.Context, id int, inbound *model.Inbound) (*model.Inbound, error) {
+	req, err := jsonRequest(http.MethodPost, fmt.Sprintf("inbounds/update/%d", id), inbound)
+	if err != nil {
+		return nil, err
+	}
+	updated := &model.Inbound{}
+	if err := c.call(ctx, req, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteInbound removes the inbound with the given ID.
+func (c *Client) DeleteInbound(ctx context.Context, id int) error {
+	return c.call(ctx, request{method: http.MethodPost, path: fmt.Sprintf("inbounds/del/%d", id)}, nil)
+}
+
+// ImportInbound imports a full inbound definition, including its client stats.
+func (c *Client) ImportInbound(ctx context.Context, inbound *model.Inbound) (*model.Inbound, error) {
+	data, err := json.Marshal(inbound)
+	if err != nil {
+		return nil, err
+	}
+	imported := &model.Inbound{}
+	err = c.call(ctx, formRequest(http.MethodPost, "inbounds/import", url.Values{"data": {string(data)}}), imported)
+	if err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// ResetAllTraffics zeroes the traffic counters of all inbounds.
+func (c *Client) ResetAllTraffics(ctx context.Context) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "inbounds/resetAllTraffics"}, nil)
+}
+
+// ResetAllClientTraffics zeroes the traffic counters of every client of an inbound.
+func (c *Client) ResetAllClientTraffics(ctx context.Context, inboundID int) error {
+	return c.call(ctx, request{method: http.MethodPost, path: fmt.Sprintf("inbounds/resetAllClientTraffics/%d", inboundID)}, nil)
+}
+
+// DeleteDepletedClients removes expired or exhausted clients; inboundID -1 targets all inbounds.
+func (c *Client) DeleteDepletedClients(ctx context.Context, inboundID int) error {
+	return c.call(ctx, request{method: http.MethodPost, path: fmt.Sprintf("inbounds/delDepletedClients/%d", inboundID)}, nil)
+}
+
+// Onlines returns the emails of currently connected clients.
+func (c *Client) Onlines(ctx context.Context) ([]string, error) {
+	var emails []string
+	err := c.call(ctx, request{method: http.MethodPost, path: "inbounds/onlines"}, &emails)
+	return emails, err
+}
+
+// LastOnline returns the last-seen unix timestamp per client email.
+func (c *Client) LastOnline(ctx context.Context) (map[string]int64, error) {
+	lastOnline := map[string]int64{}
+	err := c.call(ctx, request{method: http.MethodPost, path: "inbounds/lastOnline"}, &lastOnline)
+	return lastOnline, err
+}
@@ -0,0 +1,133 @@
+//go:build toolsignore
+// +build toolsignore
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Status returns the server/Xray status snapshot shown on the dashboard.
+func (c *Client) Status(ctx context.Context) (json.RawMessage, error) {
+	var status json.RawMessage
+	err := c.call(ctx, request{method: http.MethodGet, path: "server/status"}, &status)
+	return status, err
+}
+
+// XrayVersions lists the Xray versions available for installation.
+func (c *Client) XrayVersions(ctx context.Context) ([]string, error) {
+	var versions []string
+	err := c.call(ctx, request{method: http.MethodGet, path: "server/getXrayVersion"}, &versions)
+	return versions, err
+}
+
+// ConfigJSON returns the Xray config currently generated by the panel.
+func (c *Client) ConfigJSON(ctx context.Context) (json.RawMessage, error) {
+	var config json.RawMessage
+	err := c.call(ctx, request{method: http.MethodGet, path: "server/getConfigJson"}, &config)
+	return config, err
+}
+
+// NewUUID asks the panel for a fresh client UUID.
+func (c *Client) NewUUID(ctx context.Context) (string, error) {
+	var obj struct {
+		UUID string `json:"uuid"`
+	}
+	err := c.call(ctx, request{method: http.MethodGet, path: "server/getNewUUID"}, &obj)
+	return obj.UUID, err
+}
+
+// NewX25519Cert asks the panel for a fresh REALITY key pair.
+func (c *Client) NewX25519Cert(ctx context.Context) (json.RawMessage, error) {
+	var cert json.RawMessage
+	err := c.call(ctx, request{method: http.MethodGet, path: "server/getNewX25519Cert"}, &cert)
+	return cert, err
+}
+
+// Database downloads the panel's SQLite database.
+func (c *Client) Database(ctx context.Context) ([]byte, error) {
+	return c.do(ctx, request{method: http.MethodGet, path: "server/getDb"})
+}
+
+// ImportDatabase replaces the panel database with the SQLite file read from db.
+func (c *Client) ImportDatabase(ctx context.Context, db io.Reader) error {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("db", "x-ui.db")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, db); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.call(ctx, request{
+		method: http.MethodPost,
+		path:   "server/importDB",
+		body:   body.Bytes(),
+		ctype:  w.FormDataContentType(),
+	}, nil)
+}
+
+// Logs returns the last count panel log lines at or above level.
+func (c *Client) Logs(ctx context.Context, count int, level string, syslog bool) ([]string, error) {
+	var lines []string
+	form := url.Values{
+		"level":  {level},
+		"syslog": {strconv.FormatBool(syslog)},
+	}
+	err := c.call(ctx, formRequest(http.MethodPost, fmt.Sprintf("server/logs/%d", count), form), &lines)
+	return lines, err
+}
+
+// XrayLogs returns the last count Xray access log entries matching filter.
+func (c *Client) XrayLogs(ctx context.Context, count int, filter string) (json.RawMessage, error) {
+	var entries json.RawMessage
+	form := url.Values{
+		"filter":      {filter},
+		"showDirect":  {"true"},
+		"showBlocked": {"true"},
+		"showProxy":   {"true"},
+	}
+	err := c.call(ctx, formRequest(http.MethodPost, fmt.Sprintf("server/xraylogs/%d", count), form), &entries)
+	return entries, err
+}
+
+// StopXray stops the Xray core.
+func (c *Client) StopXray(ctx context.Context) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "server/stopXrayService"}, nil)
+}
+
+// RestartXray restarts the Xray core.
+func (c *Client) RestartXray(ctx context.Context) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "server/restartXrayService"}, nil)
+}
+
+// InstallXray installs the given Xray version (as listed by XrayVersions).
+func (c *Client) InstallXray(ctx context.Context, version string) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "server/installXray/" + url.PathEscape(version)}, nil)
+}
+
+// UpdateGeofile refreshes one geo file by name, or all of them when fileName is empty.
+func (c *Client) UpdateGeofile(ctx context.Context, fileName string) error {
+	path := "server/updateGeofile"
+	if fileName != "" {
+		path += "/" + url.PathEscape(fileName)
+	}
+	return c.call(ctx, request{method: http.MethodPost, path: path}, nil)
+}
+
+// BackupToTelegram sends a database backup to the Telegram bot admins.
+func (c *Client) BackupToTelegram(ctx context.Context) error {
+	return c.call(ctx, request{method: http.MethodGet, path: "backuptotgbot"}, nil)
+}
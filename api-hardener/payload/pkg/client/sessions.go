@@ -0,0 +1,46 @@
+//go:build toolsignore
+// +build toolsignore
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// The calls below manage panel login sessions and require a token with the admin scope.
+
+// PanelSession is a logged-in panel session as listed by the panel.
+type PanelSession struct {
+	model.PanelSession
+	Current bool `json:"current"`
+}
+
+// ListPanelSessions returns all recorded panel sessions, most recently active first.
+func (c *Client) ListPanelSessions(ctx context.Context) ([]PanelSession, error) {
+	var sessions []PanelSession
+	err := c.call(ctx, request{method: http.MethodGet, path: "sessions/list"}, &sessions)
+	return sessions, err
+}
+
+// RevokePanelSession logs out one panel session immediately.
+func (c *Client) RevokePanelSession(ctx context.Context, id int) error {
+	return c.call(ctx, request{method: http.MethodPost, path: fmt.Sprintf("sessions/revoke/%d", id)}, nil)
+}
+
+// RevokeUserPanelSessions logs out every session of the panel user username and
+// returns how many were revoked.
+func (c *Client) RevokeUserPanelSessions(ctx context.Context, username string) (int, error) {
+	req, err := jsonRequest(http.MethodPost, "sessions/revokeUser", map[string]string{"username": username})
+	if err != nil {
+		return 0, err
+	}
+	var obj struct {
+		Revoked int `json:"revoked"`
+	}
+	err = c.call(ctx, req, &obj)
+	return obj.Revoked, err
+}
@@ -0,0 +1,40 @@
+//go:build toolsignore
+// +build toolsignore
+
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// The calls below authenticate with the tokens they revoke, so they work without an
+// admin token and even with a token that is already disabled.
+
+// LeakedTokenResult reports what the panel did with one reported token. Token is
+// masked down to its public part; Status is one of revoked, already-revoked,
+// unknown or malformed.
+type LeakedTokenResult struct {
+	Token     string `json:"token"`
+	Status    string `json:"status"`
+	APIUserId int    `json:"apiUserId,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// RevokeLeakedTokens reports plaintext tokens found in a leak. The panel disables
+// their owners, invalidates the tokens and records source in its audit trail.
+func (c *Client) RevokeLeakedTokens(ctx context.Context, tokens []string, source string) ([]LeakedTokenResult, error) {
+	req, err := jsonRequest(http.MethodPost, "tokens/revoke", map[string]any{"tokens": tokens, "source": source})
+	if err != nil {
+		return nil, err
+	}
+	var results []LeakedTokenResult
+	err = c.call(ctx, req, &results)
+	return results, err
+}
+
+// RevokeToken revokes the token the client was created with. The client can not
+// be used afterwards.
+func (c *Client) RevokeToken(ctx context.Context) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "tokens/revokeSelf"}, nil)
+}
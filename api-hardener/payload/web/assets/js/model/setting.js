@@ -27,6 +27,24 @@ class AllSetting {
         this.twoFactorToken = "";
         this.apiTokenOnly = false;
         this.apiDefaultRateLimit = 120;
+        this.apiJWTTTL = 15;
+        this.panelMaxSessions = 0;
+        this.apiListen = "";
+        this.apiPort = 0;
+        this.apiCertFile = "";
+        this.apiKeyFile = "";
+        this.apiBasePath = "";
+        this.apiWebListener = true;
+        this.apiClientCAFile = "";
+        this.apiClientCRLFile = "";
+        this.apiSocket = "";
+        this.trustedProxies = "127.0.0.0/8,::1/128";
+        this.trustedProxyHeader = "X-Forwarded-For";
+        this.apiCorsOrigins = "";
+        this.apiCorsMethods = "GET,POST";
+        this.apiCorsHeaders = "Authorization,Content-Type,X-API-Token";
+        this.apiCorsCredentials = false;
+        this.apiCorsMaxAge = 600;
         this.xrayTemplateConfig = "";
         this.subEnable = true;
         this.subJsonEnable = false;
@@ -4,11 +4,14 @@ const ApiAccessMixin = {
             apiSettings: {
                 apiTokenOnly: true,
                 apiDefaultRateLimit: 120,
+                apiJWTTTL: 15,
             },
             apiUsers: [],
+            apiScopes: ["read", "write", "admin"],
             apiUserForm: {
                 name: "",
                 rate: 0,
+                scopes: ["read", "write"],
             },
             apiStates: {
                 loading: false,
@@ -20,20 +23,87 @@ const ApiAccessMixin = {
                     { title: "#", dataIndex: "id", key: "id", width: 60 },
                     { title: i18n("pages.settings.api.user"), dataIndex: "name", key: "name" },
                     { title: i18n("status"), dataIndex: "status", key: "status", scopedSlots: { customRender: "status" } },
+                    { title: i18n("pages.settings.api.scopes"), dataIndex: "scopes", key: "scopes", scopedSlots: { customRender: "scopes" } },
                     { title: i18n("pages.settings.api.rate"), dataIndex: "rateLimitPerMinute", key: "rate", scopedSlots: { customRender: "rate" }, width: 180 },
+                    { title: i18n("pages.settings.api.origins"), dataIndex: "allowedOrigins", key: "origins", scopedSlots: { customRender: "origins" }, width: 240 },
+                    { title: i18n("pages.settings.api.window"), dataIndex: "accessWindow", key: "window", scopedSlots: { customRender: "window" }, width: 220 },
+                    { title: i18n("pages.settings.api.inbounds"), dataIndex: "inboundAllowlist", key: "inbounds", scopedSlots: { customRender: "inbounds" }, width: 240 },
                     { title: i18n("pages.settings.api.lastUsed"), dataIndex: "lastUsedAt", key: "lastUsedAt", scopedSlots: { customRender: "lastUsed" }, width: 200 },
                     { title: i18n("action"), key: "actions", scopedSlots: { customRender: "actions" }, width: 260 },
                 ],
             },
+            panelSessions: [],
+            sessionSettings: {
+                maxSessions: 0,
+            },
+            sessionTable: {
+                columns: [
+                    { title: i18n("pages.settings.api.sessionUser"), dataIndex: "username", key: "username", scopedSlots: { customRender: "username" } },
+                    { title: "IP", dataIndex: "ip", key: "ip" },
+                    { title: i18n("pages.settings.api.sessionLogin"), dataIndex: "createdAt", key: "createdAt", scopedSlots: { customRender: "time" } },
+                    { title: i18n("pages.settings.api.sessionLastActive"), dataIndex: "lastActiveAt", key: "lastActiveAt", scopedSlots: { customRender: "time" } },
+                    { title: i18n("pages.settings.api.sessionAgent"), dataIndex: "userAgent", key: "userAgent", ellipsis: true },
+                    { title: i18n("action"), key: "actions", scopedSlots: { customRender: "actions" }, width: 120 },
+                ],
+            },
             tokenModal: {
                 visible: false,
                 token: "",
             },
+            reauthModal: {
+                visible: false,
+                confirming: false,
+                twoFactor: false,
+                password: "",
+                twoFactorCode: "",
+                resolve: null,
+            },
         };
     },
     methods: {
+        csrfOptions() {
+            return { headers: { "X-CSRF-Token": getCsrfToken() } };
+        },
+        // sensitivePost posts to an endpoint that needs a recent re-authentication and,
+        // when the server asks for it, prompts for the password (and TOTP code) and retries.
+        async sensitivePost(url, data = {}) {
+            let msg = await HttpUtil.post(url, data, this.csrfOptions());
+            if (msg && !msg.success && msg.obj && msg.obj.reauthRequired) {
+                if (!await this.reauthenticate(msg.obj.twoFactor)) return msg;
+                msg = await HttpUtil.post(url, data, this.csrfOptions());
+            }
+            return msg;
+        },
+        reauthenticate(twoFactor) {
+            return new Promise(resolve => {
+                Object.assign(this.reauthModal, {
+                    visible: true,
+                    confirming: false,
+                    twoFactor: !!twoFactor,
+                    password: "",
+                    twoFactorCode: "",
+                    resolve,
+                });
+            });
+        },
+        async confirmReauth() {
+            this.reauthModal.confirming = true;
+            const msg = await HttpUtil.post("/panel/api-users/reauth", {
+                password: this.reauthModal.password,
+                twoFactorCode: this.reauthModal.twoFactorCode,
+            }, this.csrfOptions());
+            this.reauthModal.confirming = false;
+            if (msg && msg.success) {
+                this.closeReauth(true);
+            }
+        },
+        closeReauth(confirmed) {
+            const resolve = this.reauthModal.resolve;
+            Object.assign(this.reauthModal, { visible: false, password: "", twoFactorCode: "", resolve: null });
+            if (resolve) resolve(confirmed);
+        },
         async initApiAccess() {
-            await Promise.all([this.fetchApiSettings(), this.fetchApiUsers()]);
+            await Promise.all([this.fetchApiSettings(), this.fetchApiUsers(), this.fetchPanelSessions(), this.fetchSessionSettings()]);
         },
         async fetchApiSettings() {
             this.apiStates.loading = true;
@@ -45,7 +115,7 @@ const ApiAccessMixin = {
         },
         async saveApiSettings() {
             this.apiStates.saving = true;
-            const msg = await HttpUtil.post("/panel/api-users/settings", this.apiSettings);
+            const msg = await this.sensitivePost("/panel/api-users/settings", this.apiSettings);
             this.apiStates.saving = false;
             if (msg && msg.success) {
                 Vue.prototype.$message.success(i18n("pages.settings.api.settingsUpdated"));
@@ -69,11 +139,11 @@ const ApiAccessMixin = {
                 return;
             }
             this.apiStates.creating = true;
-            const msg = await HttpUtil.post("/panel/api-users/create", this.apiUserForm);
+            const msg = await this.sensitivePost("/panel/api-users/create", this.apiUserForm);
             this.apiStates.creating = false;
             if (msg && msg.success) {
                 await this.fetchApiUsers();
-                this.apiUserForm = { name: "", rate: 0 };
+                this.apiUserForm = { name: "", rate: 0, scopes: ["read", "write"] };
                 if (msg.obj && msg.obj.token) {
                     this.tokenModal.token = msg.obj.token;
                     this.tokenModal.visible = true;
@@ -82,14 +152,14 @@ const ApiAccessMixin = {
         },
         async toggleApiUser(user, enabled) {
             const endpoint = enabled ? "enable" : "disable";
-            const msg = await HttpUtil.post(`/panel/api-users/${endpoint}/${user.id}`);
+            const msg = await HttpUtil.post(`/panel/api-users/${endpoint}/${user.id}`, {}, this.csrfOptions());
             if (msg && msg.success) {
                 Vue.prototype.$message.success(i18n(enabled ? "pages.settings.api.userEnabled" : "pages.settings.api.userDisabled"));
                 await this.fetchApiUsers();
             }
         },
         async rotateApiUser(user) {
-            const msg = await HttpUtil.post(`/panel/api-users/rotate/${user.id}`);
+            const msg = await this.sensitivePost(`/panel/api-users/rotate/${user.id}`);
             if (msg && msg.success && msg.obj && msg.obj.token) {
                 this.tokenModal.token = msg.obj.token;
                 this.tokenModal.visible = true;
@@ -107,7 +177,7 @@ const ApiAccessMixin = {
                 });
             }).then(async (confirm) => {
                 if (!confirm) return;
-                const msg = await HttpUtil.post(`/panel/api-users/delete/${user.id}`);
+                const msg = await this.sensitivePost(`/panel/api-users/delete/${user.id}`);
                 if (msg && msg.success) {
                     Vue.prototype.$message.success(i18n("pages.settings.api.userDeleted"));
                     await this.fetchApiUsers();
@@ -115,12 +185,72 @@ const ApiAccessMixin = {
             });
         },
         async updateApiRate(user) {
-            const msg = await HttpUtil.post(`/panel/api-users/rate/${user.id}`, { rate: user.rateLimitPerMinute });
+            const msg = await HttpUtil.post(`/panel/api-users/rate/${user.id}`, { rate: user.rateLimitPerMinute }, this.csrfOptions());
             if (msg && msg.success) {
                 Vue.prototype.$message.success(i18n("pages.settings.api.rateUpdated"));
                 await this.fetchApiUsers();
             }
         },
+        async updateApiOrigins(user) {
+            const msg = await HttpUtil.post(`/panel/api-users/origins/${user.id}`, { origins: user.allowedOrigins || "" }, this.csrfOptions());
+            if (msg && msg.success) {
+                Vue.prototype.$message.success(i18n("pages.settings.api.originsUpdated"));
+            }
+            await this.fetchApiUsers();
+        },
+        async updateApiWindow(user) {
+            const msg = await HttpUtil.post(`/panel/api-users/window/${user.id}`, { window: user.accessWindow || "" }, this.csrfOptions());
+            if (msg && msg.success) {
+                Vue.prototype.$message.success(i18n("pages.settings.api.windowUpdated"));
+            }
+            await this.fetchApiUsers();
+        },
+        async updateApiInbounds(user) {
+            const msg = await HttpUtil.post(`/panel/api-users/inbounds/${user.id}`, { inbounds: user.inboundAllowlist || "" }, this.csrfOptions());
+            if (msg && msg.success) {
+                Vue.prototype.$message.success(i18n("pages.settings.api.inboundsUpdated"));
+            }
+            await this.fetchApiUsers();
+        },
+        async fetchPanelSessions() {
+            const msg = await HttpUtil.get("/panel/sessions/list");
+            if (msg && msg.success) {
+                this.panelSessions = (msg.obj || []).map(s => ({ ...s, key: s.id }));
+            }
+        },
+        async fetchSessionSettings() {
+            const msg = await HttpUtil.get("/panel/sessions/settings");
+            if (msg && msg.success) {
+                this.sessionSettings = msg.obj;
+            }
+        },
+        async saveSessionSettings() {
+            const msg = await HttpUtil.post("/panel/sessions/settings", this.sessionSettings, this.csrfOptions());
+            if (msg && msg.success) {
+                await this.fetchSessionSettings();
+            }
+        },
+        async revokePanelSession(record) {
+            const msg = await HttpUtil.post(`/panel/sessions/revoke/${record.id}`, {}, this.csrfOptions());
+            if (msg && msg.success) {
+                if (record.current) {
+                    window.location.reload();
+                    return;
+                }
+                await this.fetchPanelSessions();
+            }
+        },
+        async revokeOtherSessions() {
+            const current = this.panelSessions.find(s => s.current);
+            if (!current) return;
+            const msg = await HttpUtil.post("/panel/sessions/revokeUser", { username: current.username }, this.csrfOptions());
+            if (msg && msg.success) {
+                await this.fetchPanelSessions();
+            }
+        },
+        formatSessionTime(value) {
+            return value ? value.replace("T", " ").slice(0, 19) : "—";
+        },
         copyToken() {
             if (!this.tokenModal.token) return;
             ClipboardManager.copyText(this.tokenModal.token).then(() => {
@@ -4,6 +4,7 @@
 package controller
 
 import (
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/web/middleware"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 
@@ -13,11 +14,20 @@ import (
 // APIController handles the main API routes for the 3x-ui panel, including inbounds and server management.
 type APIController struct {
 	BaseController
-	inboundController *InboundController
-	serverController  *ServerController
-	Tgbot             service.Tgbot
-	apiUserService    service.APIUserService
-	settingService    service.SettingService
+	inboundController   *InboundController
+	serverController    *ServerController
+	apiUserController   *APIUserAdminController
+	sessionController   *SessionAdminController
+	tokenController     *APITokenRevokeController
+	authController      *APIAuthController
+	oauthController     *APIOAuthController
+	lockdownController  *LockdownController
+	Tgbot               service.Tgbot
+	apiUserService      service.APIUserService
+	settingService      service.SettingService
+	panelSessionService service.PanelSessionService
+	lockdownService     service.APILockdownService
+	jwtService          service.APIJWTService
 }
 
 // NewAPIController creates a new APIController instance and initializes its routes.
@@ -27,11 +37,32 @@ func NewAPIController(g *gin.RouterGroup) *APIController {
 	return a
 }
 
-// initRouter sets up the API routes for inbounds, server, and other endpoints.
+// initRouter sets up the API routes for inbounds, server, and other endpoints, and
+// (re)starts the dedicated API listener with its own set of them. On the web listener
+// they can be limited to the panel's pages (apiWebListener).
 func (a *APIController) initRouter(g *gin.RouterGroup) {
+	a.mountRoutes(g.Group("", middleware.RealClientIP(&a.settingService), middleware.PanelSessionsOnly(&a.settingService)))
+	startAPIListener(&a.settingService, &a.apiUserService)
+}
+
+// mountRoutes registers the API routes on g.
+func (a *APIController) mountRoutes(g *gin.RouterGroup) {
+	// CORS comes first, so preflight requests are answered before any authentication
+	g = g.Group("", middleware.NewCORSMiddleware(&a.settingService))
+	g.OPTIONS("/panel/api/*path", middleware.CORSPreflight)
+
+	// Token revocation authenticates with the revoked tokens themselves
+	a.tokenController = NewAPITokenRevokeController(g)
+	// The token exchange and OAuth2 authenticate on their own, JWKS is public
+	a.authController = NewAPIAuthController(g)
+	a.oauthController = NewAPIOAuthController(g)
+
 	// Main API group
 	api := g.Group("/panel/api")
-	api.Use(middleware.NewAPIAuthMiddleware(&a.apiUserService, &a.settingService))
+	api.Use(middleware.NewAPIAuthMiddleware(&a.apiUserService, &a.settingService, &a.lockdownService, &a.jwtService))
+	api.Use(middleware.NewSessionTrackingMiddleware(&a.panelSessionService))
+	api.Use(middleware.NewCSRFMiddleware(&a.settingService))         // session access when apiTokenOnly is off
+	api.Use(middleware.NewInboundScopeMiddleware(&a.apiUserService)) // users with an inbound allowlist
 
 	// Inbounds API
 	inbounds := api.Group("/inbounds")
@@ -41,6 +72,13 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	server := api.Group("/server")
 	a.serverController = NewServerController(server)
 
+	// API user management for admin-scoped tokens (used by api-guard remote mode)
+	admin := api.Group("", middleware.RequireAPIScope(model.APIScopeAdmin))
+	a.apiUserController = NewAPIUserAdminController(admin)
+	a.sessionController = NewSessionAdminController(admin)
+	a.lockdownController = NewLockdownController(admin)
+	a.authController.initAdminRouter(admin)
+
 	// Extra routes
 	api.GET("/backuptotgbot", a.BackuptoTgbot)
 }
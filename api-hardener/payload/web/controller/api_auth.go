@@ -0,0 +1,185 @@
+//go:build toolsignore
+// +build toolsignore
+
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIAuthController exchanges API tokens, client certificates and unix socket callers for
+// short-lived access tokens (JWTs) and publishes the keys that verify them. The exchange and
+// JWKS routes are not behind the API auth middleware: the exchange authenticates on its own
+// and must work for read-only tokens as well, and the public keys are public. The exchange
+// is rate limited per client IP instead.
+type APIAuthController struct {
+	BaseController
+	apiUserService  service.APIUserService
+	jwtService      service.APIJWTService
+	lockdownService service.APILockdownService
+}
+
+// NewAPIAuthController registers the token exchange and JWKS routes under /panel/api/auth.
+func NewAPIAuthController(g *gin.RouterGroup) *APIAuthController {
+	a := &APIAuthController{}
+	a.initRouter(g)
+	return a
+}
+
+type tokenExchangeForm struct {
+	Scope string `json:"scope" form:"scope"` // optional narrowing, space or comma separated
+}
+
+type rotateSigningKeyForm struct {
+	DropOld bool `json:"dropOld" form:"dropOld"`
+}
+
+// accessTokenResponse follows the OAuth2 token response (RFC 6749 section 5.1).
+type accessTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+func (a *APIAuthController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/panel/api/auth")
+
+	g.POST("/token", middleware.NewIPRateLimitMiddleware(), a.token)
+	g.GET("/jwks", a.jwks)
+}
+
+// initAdminRouter registers the signing key management routes on the admin group of /panel/api.
+func (a *APIAuthController) initAdminRouter(g *gin.RouterGroup) {
+	g = g.Group("/auth/keys")
+
+	g.GET("", a.keys)
+	g.POST("/rotate", a.rotateKey)
+}
+
+// token issues an access token for the API user behind the bearer token, client certificate
+// or unix socket caller.
+func (a *APIAuthController) token(c *gin.Context) {
+	if mode, _ := a.lockdownService.Mode(); mode != service.APILockdownOff {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "api lockdown", "mode": mode})
+		return
+	}
+
+	var apiUser *model.APIUser
+	var err error
+	token := middleware.ExtractAPIToken(c)
+	cert := middleware.VerifiedClientCert(c)
+	peer := middleware.PeerCred(c)
+	switch {
+	case token == "" && cert != nil:
+		apiUser, err = a.apiUserService.VerifyClientCert(cert)
+	case token == "" && peer != nil:
+		apiUser, err = a.apiUserService.VerifyPeerCred(peer)
+	case token == "" || !service.IsWellFormedAPIToken(token):
+		// Access tokens can not be exchanged for fresh ones; that would make them immortal.
+		err = service.ErrInvalidAPIToken
+	default:
+		apiUser, err = a.apiUserService.VerifyToken(token)
+	}
+	if err != nil {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	form := &tokenExchangeForm{}
+	if err := c.ShouldBind(form); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "")
+		return
+	}
+	issueAccessToken(c, &a.jwtService, apiUser, form.Scope)
+}
+
+// issueAccessToken answers with an access token for apiUser limited to scope (space or
+// comma separated, empty for all of the user's scopes). Browser pages on origins the user
+// is restricted from get none, and the token carries the restriction.
+func issueAccessToken(c *gin.Context, jwtService *service.APIJWTService, apiUser *model.APIUser, scope string) {
+	if !apiUser.AllowsOrigin(c.GetHeader("Origin")) {
+		oauthError(c, http.StatusForbidden, "access_denied", "origin not allowed")
+		return
+	}
+	scopes, err := service.ParseAPIScopes(strings.Join(strings.Fields(strings.ReplaceAll(scope, ",", " ")), ","))
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_scope", err.Error())
+		return
+	}
+
+	accessToken, claims, err := jwtService.Issue(apiUser, scopes)
+	switch {
+	case errors.Is(err, service.ErrAPIPrivilegeEscalation):
+		oauthError(c, http.StatusBadRequest, "invalid_scope", err.Error())
+		return
+	case errors.Is(err, service.ErrAPIJWTDisabled):
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", err.Error())
+		return
+	case errors.Is(err, service.ErrOutsideAccessWindow):
+		oauthError(c, http.StatusForbidden, "access_denied", "outside access window "+apiUser.AccessWindow)
+		return
+	case err != nil:
+		logger.Warning("issue api access token failed:", err)
+		oauthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, accessTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   claims.ExpiresAt - claims.IssuedAt,
+		Scope:       claims.Scope,
+	})
+}
+
+// oauthError answers with an OAuth2 error response (RFC 6749 section 5.2).
+func oauthError(c *gin.Context, status int, code string, description string) {
+	body := gin.H{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	c.Header("Cache-Control", "no-store")
+	c.AbortWithStatusJSON(status, body)
+}
+
+func (a *APIAuthController) jwks(c *gin.Context) {
+	set, err := a.jwtService.JWKS()
+	if err != nil {
+		logger.Warning("load api signing keys failed:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=60")
+	c.JSON(http.StatusOK, set)
+}
+
+func (a *APIAuthController) keys(c *gin.Context) {
+	keys, err := a.jwtService.ListKeys()
+	jsonObj(c, keys, err)
+}
+
+func (a *APIAuthController) rotateKey(c *gin.Context) {
+	form := &rotateSigningKeyForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, "rotate signing key", err)
+		return
+	}
+	actor := "unknown"
+	if user := session.GetLoginUser(c); user != nil {
+		actor = user.Username
+	}
+	key, err := a.jwtService.RotateKey(form.DropOld, actor, getRemoteIp(c))
+	jsonMsgObj(c, "rotate signing key", key, err)
+}
@@ -0,0 +1,238 @@
+//go:build toolsignore
+// +build toolsignore
+
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiListener holds the dedicated API servers, which serve only the API routes: the TLS
+// listener on apiListen:apiPort under apiBasePath, which asks for client certificates
+// so API users can authenticate with one instead of a token, and the unix socket
+// apiSocket, which authenticates local callers by their UID/GID. They are replaced
+// whenever the web server (re)starts, since that is when the API routes are mounted.
+var apiListener struct {
+	mu      sync.Mutex
+	servers []*http.Server
+}
+
+// startAPIListener stops the running API servers and starts the ones enabled in the current settings.
+func startAPIListener(settingService *service.SettingService, apiUserService *service.APIUserService) {
+	apiListener.mu.Lock()
+	defer apiListener.mu.Unlock()
+
+	for _, server := range apiListener.servers {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Warning("stop api listener:", err)
+		}
+		cancel()
+	}
+	apiListener.servers = nil
+
+	startAPITLSListener(settingService, apiUserService)
+	startAPISocketListener(settingService)
+}
+
+func startAPITLSListener(settingService *service.SettingService, apiUserService *service.APIUserService) {
+	port, err := settingService.GetAPIPort()
+	if err != nil || port <= 0 {
+		return
+	}
+	server, err := newAPIListenerServer(settingService, apiUserService)
+	if err != nil {
+		logger.Warning("api listener not started:", err)
+		return
+	}
+	listen, _ := settingService.GetAPIListen()
+	addr := net.JoinHostPort(listen, strconv.Itoa(port))
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Warning("api listener not started:", err)
+		return
+	}
+
+	apiListener.servers = append(apiListener.servers, server)
+	go func() {
+		if err := server.Serve(tls.NewListener(ln, server.TLSConfig)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warning("api listener stopped:", err)
+		}
+	}()
+	logger.Info("API listener running on https://" + addr)
+}
+
+func startAPISocketListener(settingService *service.SettingService) {
+	path, err := settingService.GetAPISocket()
+	if err != nil || path == "" {
+		return
+	}
+	if !peerCredSupported {
+		logger.Warning("api socket not started: peer credentials are only supported on linux")
+		return
+	}
+	ln, err := listenAPISocket(path)
+	if err != nil {
+		logger.Warning("api socket not started:", err)
+		return
+	}
+
+	server := &http.Server{
+		Handler:           newAPISocketEngine(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			cred, err := unixPeerCred(conn)
+			if err != nil {
+				logger.Warning("read api socket peer credentials:", err)
+				return ctx
+			}
+			return middleware.ContextWithPeerCred(ctx, cred)
+		},
+	}
+	apiListener.servers = append(apiListener.servers, server)
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warning("api socket stopped:", err)
+		}
+	}()
+	logger.Info("API socket listening on " + path)
+}
+
+// listenAPISocket listens on the unix socket path, replacing a stale socket left by a
+// previous run. The socket is world-writable: callers are authorized by their UID/GID,
+// and unmapped ones are rejected like unknown tokens.
+func listenAPISocket(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o666); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// newAPISocketEngine serves the API routes at / on the unix socket, as local callers
+// need no secret base path. Forwarding headers are dropped and the remote address names
+// the caller, so logs and the audit trail show uid and pid instead of a spoofable IP.
+func newAPISocketEngine() *gin.Engine {
+	engine := gin.New()
+	_ = engine.SetTrustedProxies(nil)
+	engine.Use(gin.Recovery(), middleware.WithoutSessions(), func(c *gin.Context) {
+		c.Request.Header.Del("X-Real-IP")
+		c.Request.Header.Del("X-Forwarded-For")
+		if cred := middleware.PeerCred(c); cred != nil {
+			c.Request.RemoteAddr = net.JoinHostPort("unix:uid="+strconv.Itoa(cred.UID), strconv.Itoa(cred.PID))
+		}
+		c.Set("base_path", "/")
+	})
+	a := &APIController{}
+	a.mountRoutes(engine.Group("/"))
+	return engine
+}
+
+func newAPIListenerServer(settingService *service.SettingService, apiUserService *service.APIUserService) (*http.Server, error) {
+	certFile, keyFile, err := apiListenerCertFiles(settingService)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	baseConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	tlsConfig := baseConfig.Clone()
+	// The client CA bundle is looked up on every handshake (it is cached until the file
+	// changes), so a new or changed CA applies without a restart.
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := apiUserService.ClientCAPool()
+		if err != nil {
+			logger.Warning("api listener client CA:", err)
+		}
+		if pool == nil {
+			return baseConfig, nil
+		}
+		// Tokens keep working, so a client certificate is optional; an invalid one fails the handshake.
+		config := baseConfig.Clone()
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = pool
+		return config, nil
+	}
+
+	basePath, err := settingService.GetAPIBasePath()
+	if err != nil {
+		return nil, err
+	}
+	engine := gin.New()
+	// RealClientIP resolves the client from trustedProxies; gin must not read the
+	// forwarding headers on its own.
+	_ = engine.SetTrustedProxies(nil)
+	engine.Use(gin.Recovery(), middleware.RealClientIP(settingService), middleware.WithoutSessions(), func(c *gin.Context) {
+		c.Set("base_path", basePath)
+	})
+	a := &APIController{}
+	a.mountRoutes(engine.Group(basePath))
+
+	return &http.Server{
+		Handler:           engine,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}, nil
+}
+
+// apiListenerCertFiles returns the certificate of the API listener: apiCertFile and
+// apiKeyFile, or the panel certificate when they are not set.
+func apiListenerCertFiles(settingService *service.SettingService) (string, string, error) {
+	certFile, err := settingService.GetAPICertFile()
+	if err != nil {
+		return "", "", err
+	}
+	keyFile, err := settingService.GetAPIKeyFile()
+	if err != nil {
+		return "", "", err
+	}
+	if certFile != "" || keyFile != "" {
+		return certFile, keyFile, nil
+	}
+	if certFile, err = settingService.GetCertFile(); err != nil {
+		return "", "", err
+	}
+	if keyFile, err = settingService.GetKeyFile(); err != nil {
+		return "", "", err
+	}
+	if certFile == "" || keyFile == "" {
+		return "", "", errors.New("the API listener needs a certificate (apiCertFile and apiKeyFile, or webCertFile and webKeyFile)")
+	}
+	return certFile, keyFile, nil
+}
@@ -0,0 +1,37 @@
+//go:build toolsignore
+// +build toolsignore
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+func TestAPIListenerCertFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		wantCert string
+		wantKey  string
+		wantErr  bool
+	}{
+		{"api certificate", map[string]string{"apiCertFile": "/etc/api.crt", "apiKeyFile": "/etc/api.key", "webCertFile": "/etc/web.crt", "webKeyFile": "/etc/web.key"}, "/etc/api.crt", "/etc/api.key", false},
+		{"panel certificate", map[string]string{"webCertFile": "/etc/web.crt", "webKeyFile": "/etc/web.key"}, "/etc/web.crt", "/etc/web.key", false},
+		{"api certificate only", map[string]string{"apiCertFile": "/etc/api.crt", "apiKeyFile": "/etc/api.key"}, "/etc/api.crt", "/etc/api.key", false},
+		{"half of the panel certificate", map[string]string{"webCertFile": "/etc/web.crt"}, "", "", true},
+		{"no certificate", nil, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"apiCertFile", "apiKeyFile", "webCertFile", "webKeyFile"} {
+				setSetting(t, key, tt.settings[key])
+			}
+			certFile, keyFile, err := apiListenerCertFiles(&service.SettingService{})
+			if (err != nil) != tt.wantErr || certFile != tt.wantCert || keyFile != tt.wantKey {
+				t.Fatalf("apiListenerCertFiles = %q, %q, %v; want %q, %q, error %v", certFile, keyFile, err, tt.wantCert, tt.wantKey, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+//go:build toolsignore
+// +build toolsignore
+
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIOAuthController is the OAuth2 authorization server for API gateways: the client
+// credentials grant (RFC 6749), token introspection (RFC 7662) and token revocation
+// (RFC 7009). Clients are API users, authenticated by name and API token with HTTP Basic
+// or in the form body, or by client certificate on the API listener. Requests are form
+// encoded, as the RFCs require, and the routes are not behind the API auth middleware but
+// are rate limited per client IP.
+type APIOAuthController struct {
+	BaseController
+	apiUserService  service.APIUserService
+	jwtService      service.APIJWTService
+	lockdownService service.APILockdownService
+}
+
+// NewAPIOAuthController registers the OAuth2 routes under /panel/api/oauth.
+func NewAPIOAuthController(g *gin.RouterGroup) *APIOAuthController {
+	a := &APIOAuthController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *APIOAuthController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/panel/api/oauth", middleware.NewIPRateLimitMiddleware())
+
+	g.POST("/token", a.token)
+	g.POST("/introspect", a.introspect)
+	g.POST("/revoke", a.revoke)
+}
+
+// authenticateClient returns the calling client, or answers with invalid_client and
+// returns nil.
+func (a *APIOAuthController) authenticateClient(c *gin.Context) *model.APIUser {
+	clientID, secret, basic := c.Request.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1: both parts are form encoded before the Basic encoding.
+		var idErr, secretErr error
+		clientID, idErr = url.QueryUnescape(clientID)
+		secret, secretErr = url.QueryUnescape(secret)
+		if idErr != nil || secretErr != nil || c.PostForm("client_secret") != "" {
+			oauthError(c, http.StatusBadRequest, "invalid_request", "")
+			return nil
+		}
+	} else {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	apiUser, err := a.apiUserService.AuthenticateOAuthClient(clientID, secret, middleware.VerifiedClientCert(c))
+	if err != nil {
+		logger.Warningf("oauth client authentication failed for %q from %s", clientID, getRemoteIp(c))
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="3x-ui"`)
+		}
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "")
+		return nil
+	}
+	return apiUser
+}
+
+// token implements the client credentials grant.
+func (a *APIOAuthController) token(c *gin.Context) {
+	if mode, _ := a.lockdownService.Mode(); mode != service.APILockdownOff {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "api lockdown", "mode": mode})
+		return
+	}
+	client := a.authenticateClient(c)
+	if client == nil {
+		return
+	}
+	if grantType := c.PostForm("grant_type"); grantType != "client_credentials" {
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "only client_credentials is supported")
+		return
+	}
+	issueAccessToken(c, &a.jwtService, client, c.PostForm("scope"))
+}
+
+// introspect reports whether an access token is active and, if so, its claims. Clients
+// without the admin scope only learn about their own tokens.
+func (a *APIOAuthController) introspect(c *gin.Context) {
+	client := a.authenticateClient(c)
+	if client == nil {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	claims, active := a.jwtService.Introspect(strings.TrimSpace(c.PostForm("token")), client)
+	if !active {
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"active":     true,
+		"scope":      claims.Scope,
+		"client_id":  claims.Name,
+		"username":   claims.Name,
+		"token_type": "Bearer",
+		"exp":        claims.ExpiresAt,
+		"iat":        claims.IssuedAt,
+		"sub":        claims.Subject,
+		"aud":        claims.Audience,
+		"iss":        claims.Issuer,
+		"jti":        claims.ID,
+		"rate_limit": claims.RateLimit,
+	})
+}
+
+// revoke denies an access token before it expires. It works during a lockdown too.
+func (a *APIOAuthController) revoke(c *gin.Context) {
+	client := a.authenticateClient(c)
+	if client == nil {
+		return
+	}
+	err := a.jwtService.Revoke(strings.TrimSpace(c.PostForm("token")), client, getRemoteIp(c))
+	switch {
+	case errors.Is(err, service.ErrOAuthUnsupportedTokenType):
+		oauthError(c, http.StatusBadRequest, "unsupported_token_type", err.Error())
+	case errors.Is(err, service.ErrOAuthTokenNotOwned):
+		oauthError(c, http.StatusBadRequest, "unauthorized_client", err.Error())
+	case err != nil:
+		logger.Warning("revoke api access token failed:", err)
+		oauthError(c, http.StatusServiceUnavailable, "server_error", "")
+	default:
+		c.Status(http.StatusOK)
+	}
+}
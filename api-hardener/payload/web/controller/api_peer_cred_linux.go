@@ -0,0 +1,37 @@
+//go:build toolsignore && linux
+// +build toolsignore,linux
+
+package controller
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+const peerCredSupported = true
+
+// unixPeerCred asks the kernel for the credentials of the process connected to conn.
+func unixPeerCred(conn net.Conn) (*service.PeerCred, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	return &service.PeerCred{PID: int(ucred.Pid), UID: int(ucred.Uid), GID: int(ucred.Gid)}, nil
+}
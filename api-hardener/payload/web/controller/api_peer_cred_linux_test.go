@@ -0,0 +1,58 @@
+//go:build toolsignore && linux
+// +build toolsignore,linux
+
+package controller
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixPeerCred(t *testing.T) {
+	unixLn, err := net.Listen("unix", filepath.Join(t.TempDir(), "api.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixLn.Close()
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpLn.Close()
+
+	tests := []struct {
+		name    string
+		ln      net.Listener
+		wantErr bool
+	}{
+		{"unix socket", unixLn, false},
+		{"tcp", tcpLn, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := net.Dial(tt.ln.Addr().Network(), tt.ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			conn, err := tt.ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			cred, err := unixPeerCred(conn)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("unixPeerCred = %s, want an error", cred)
+				}
+				return
+			}
+			if err != nil || cred.PID != os.Getpid() || cred.UID != os.Getuid() || cred.GID != os.Getgid() {
+				t.Fatalf("unixPeerCred = %v, %v; want pid=%d uid=%d gid=%d", cred, err, os.Getpid(), os.Getuid(), os.Getgid())
+			}
+		})
+	}
+}
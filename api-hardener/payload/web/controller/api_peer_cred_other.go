@@ -0,0 +1,17 @@
+//go:build toolsignore && !linux
+// +build toolsignore,!linux
+
+package controller
+
+import (
+	"errors"
+	"net"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+const peerCredSupported = false
+
+func unixPeerCred(conn net.Conn) (*service.PeerCred, error) {
+	return nil, errors.New("peer credentials are only supported on linux")
+}
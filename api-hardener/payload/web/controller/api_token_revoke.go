@@ -0,0 +1,77 @@
+//go:build toolsignore
+// +build toolsignore
+
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APITokenRevokeController is the kill path for leaked API tokens. Its routes are not behind
+// the API auth middleware: presenting a token is the authority to revoke it, so secret
+// scanners can report what they find and any token holder, even read-only, can revoke itself.
+// Requests are rate limited per client IP instead.
+type APITokenRevokeController struct {
+	BaseController
+	apiUserService service.APIUserService
+}
+
+// NewAPITokenRevokeController registers the token revocation routes under /panel/api/tokens.
+func NewAPITokenRevokeController(g *gin.RouterGroup) *APITokenRevokeController {
+	a := &APITokenRevokeController{}
+	a.initRouter(g)
+	return a
+}
+
+type revokeLeakedForm struct {
+	Tokens []string `json:"tokens" form:"tokens"`
+	Source string   `json:"source" form:"source"` // free text for the audit trail, e.g. the scanner name
+}
+
+func (a *APITokenRevokeController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/panel/api/tokens", middleware.NewIPRateLimitMiddleware())
+
+	g.POST("/revoke", a.revokeLeaked)
+	g.POST("/revokeSelf", a.revokeSelf)
+}
+
+// revokeLeaked disables the owners of the given plaintext tokens and reports a
+// status per token.
+func (a *APITokenRevokeController) revokeLeaked(c *gin.Context) {
+	form := &revokeLeakedForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, "revoke leaked tokens", err)
+		return
+	}
+	results, err := a.apiUserService.RevokeLeakedTokens(form.Tokens, "leak report", getRemoteIp(c), form.Source)
+	if err != nil {
+		jsonMsg(c, "revoke leaked tokens", err)
+		return
+	}
+	jsonObj(c, results, nil)
+}
+
+// revokeSelf revokes the token the request is authenticated with.
+func (a *APITokenRevokeController) revokeSelf(c *gin.Context) {
+	token := middleware.ExtractAPIToken(c)
+	if token == "" || !service.IsWellFormedAPIToken(token) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	_, err := a.apiUserService.RevokeOwnToken(token, getRemoteIp(c))
+	if errors.Is(err, service.ErrInvalidAPIToken) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonMsg(c, "revoke token", err)
+		return
+	}
+	jsonMsg(c, "token revoked", nil)
+}
@@ -4,22 +4,45 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
 
 	"github.com/gin-gonic/gin"
 )
 
-// APIUserAdminController exposes API user management for panel admins (session-protected).
+// APIUserAdminController exposes API user management for panel admins. It is mounted under
+// the session-protected /panel group and under /panel/api for admin-scoped tokens.
+// Token-authenticated requests are additionally guarded: a token can not grant scopes or
+// rate limits beyond its own, change its own scopes or limit, or remove the last admin.
+// Browser sessions must re-enter their credentials (see reauth) before creating, rotating
+// or deleting API users and before changing apiTokenOnly.
 type APIUserAdminController struct {
 	BaseController
 	apiUserService service.APIUserService
+	auditService   service.APIAuditService
 	settingService service.SettingService
+	userService    service.UserService
 }
 
+// reauthWindow is how long a password/TOTP confirmation unlocks sensitive operations.
+const reauthWindow = 5 * time.Minute
+
+var (
+	errReauthRequired = errors.New("re-authentication required")
+	errReauthFailed   = errors.New("wrong password or two-factor code")
+)
+
 // NewAPIUserAdminController registers routes for managing API users and settings.
 func NewAPIUserAdminController(g *gin.RouterGroup) *APIUserAdminController {
 	a := &APIUserAdminController{}
@@ -28,29 +51,63 @@ func NewAPIUserAdminController(g *gin.RouterGroup) *APIUserAdminController {
 }
 
 type createAPIUserForm struct {
-	Name string `json:"name" form:"name"`
-	Rate int    `json:"rate" form:"rate"`
+	Name   string   `json:"name" form:"name"`
+	Rate   int      `json:"rate" form:"rate"`
+	Scopes []string `json:"scopes" form:"scopes"`
 }
 
 type updateRateForm struct {
 	Rate int `json:"rate" form:"rate"`
 }
 
+type updateScopesForm struct {
+	Scopes []string `json:"scopes" form:"scopes"`
+}
+
+type updateOriginsForm struct {
+	Origins string `json:"origins" form:"origins"` // comma separated, empty lifts the restriction
+}
+
+type updateAccessWindowForm struct {
+	Window string `json:"window" form:"window"` // e.g. "mon-fri 09:00-18:00", empty allows any time
+}
+
+type updateInboundScopeForm struct {
+	Inbounds string `json:"inbounds" form:"inbounds"` // e.g. "id:3,port:20000-20999", empty allows all inbounds
+}
+
+type auditQuery struct {
+	APIUserId int `form:"apiUserId"` // 0 lists the events of all API users and the API itself
+	Limit     int `form:"limit"`     // 0 lists all events
+}
+
+type reauthForm struct {
+	Password      string `json:"password" form:"password"`
+	TwoFactorCode string `json:"twoFactorCode" form:"twoFactorCode"`
+}
+
 type updateAPISettingForm struct {
 	APITokenOnly        bool `json:"apiTokenOnly" form:"apiTokenOnly"`
 	APIDefaultRateLimit int  `json:"apiDefaultRateLimit" form:"apiDefaultRateLimit"`
+	APIJWTTTL           *int `json:"apiJWTTTL,omitempty" form:"apiJWTTTL"` // minutes, 0 disables access tokens; nil keeps the current value
 }
 
 func (a *APIUserAdminController) initRouter(g *gin.RouterGroup) {
 	g = g.Group("/api-users")
 
 	g.GET("/list", a.list)
+	g.POST("/reauth", a.reauth)
 	g.POST("/create", a.create)
 	g.POST("/enable/:id", a.enable)
 	g.POST("/disable/:id", a.disable)
 	g.POST("/delete/:id", a.delete)
 	g.POST("/rotate/:id", a.rotate)
 	g.POST("/rate/:id", a.rate)
+	g.POST("/scopes/:id", a.scopes)
+	g.POST("/origins/:id", a.origins)
+	g.POST("/window/:id", a.window)
+	g.POST("/inbounds/:id", a.inbounds)
+	g.GET("/audit", a.audit)
 
 	g.GET("/settings", a.getSettings)
 	g.POST("/settings", a.updateSettings)
@@ -61,17 +118,62 @@ func (a *APIUserAdminController) list(c *gin.Context) {
 	jsonObj(c, users, err)
 }
 
+// audit lists the API audit trail, newest first.
+func (a *APIUserAdminController) audit(c *gin.Context) {
+	query := &auditQuery{}
+	if err := c.ShouldBindQuery(query); err != nil {
+		jsonObj(c, nil, err)
+		return
+	}
+	events, err := a.auditService.ListEvents(query.APIUserId, query.Limit)
+	jsonObj(c, events, err)
+}
+
 func (a *APIUserAdminController) create(c *gin.Context) {
 	form := &createAPIUserForm{}
 	if err := c.ShouldBind(form); err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
-	user, token, err := a.apiUserService.CreateUser(form.Name, form.Rate)
+	scopes, err := service.ParseAPIScopes(strings.Join(form.Scopes, ","))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	// A token hands its own origin, access window and inbound restrictions down to the
+	// users it creates, so they can not be used to step outside them.
+	var inherited *model.APIUser
+	if !a.guard(c, func(caller *model.APIUser) error {
+		scopeList, err := service.JoinAPIScopes(scopes)
+		if err != nil {
+			return err
+		}
+		inherited = caller
+		return a.apiUserService.CheckUserGrant(caller, &model.APIUser{
+			Scopes:             scopeList,
+			RateLimitPerMinute: form.Rate,
+			AllowedOrigins:     caller.AllowedOrigins,
+			AccessWindow:       caller.AccessWindow,
+			InboundAllowlist:   caller.InboundAllowlist,
+		})
+	}) || !a.stepUp(c) {
+		return
+	}
+	user, token, err := a.apiUserService.CreateUser(form.Name, form.Rate, scopes)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
+	if inherited != nil {
+		if err := a.apiUserService.CopyRestrictions(user.Id, inherited); err != nil {
+			_ = a.apiUserService.DeleteUser(user.Id)
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+		user.AllowedOrigins = inherited.AllowedOrigins
+		user.AccessWindow = inherited.AccessWindow
+		user.InboundAllowlist = inherited.InboundAllowlist
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"msg":     I18nWeb(c, "pages.settings.api.tokenGenerated"),
@@ -84,24 +186,52 @@ func (a *APIUserAdminController) create(c *gin.Context) {
 
 func (a *APIUserAdminController) enable(c *gin.Context) {
 	id := mustID(c.Param("id"))
+	if !a.guard(c, func(caller *model.APIUser) error { return a.checkTarget(caller, id, nil) }) {
+		return
+	}
 	err := a.apiUserService.SetEnabled(id, true)
 	jsonMsg(c, I18nWeb(c, "pages.settings.api.userEnabled"), err)
 }
 
 func (a *APIUserAdminController) disable(c *gin.Context) {
 	id := mustID(c.Param("id"))
+	if !a.guard(c, func(caller *model.APIUser) error {
+		if err := a.checkTarget(caller, id, nil); err != nil {
+			return err
+		}
+		return a.apiUserService.CheckAdminRemains(id)
+	}) {
+		return
+	}
 	err := a.apiUserService.SetEnabled(id, false)
 	jsonMsg(c, I18nWeb(c, "pages.settings.api.userDisabled"), err)
 }
 
 func (a *APIUserAdminController) delete(c *gin.Context) {
 	id := mustID(c.Param("id"))
+	if !a.guard(c, func(caller *model.APIUser) error {
+		if err := a.checkTarget(caller, id, nil); err != nil {
+			return err
+		}
+		return a.apiUserService.CheckAdminRemains(id)
+	}) || !a.stepUp(c) {
+		return
+	}
 	err := a.apiUserService.DeleteUser(id)
 	jsonMsg(c, I18nWeb(c, "pages.settings.api.userDeleted"), err)
 }
 
 func (a *APIUserAdminController) rotate(c *gin.Context) {
 	id := mustID(c.Param("id"))
+	// The new plaintext token goes to the caller, so rotating is taking the user over.
+	if !a.guard(c, func(caller *model.APIUser) error {
+		if caller.Id == id {
+			return nil
+		}
+		return a.checkTarget(caller, id, nil)
+	}) || !a.stepUp(c) {
+		return
+	}
 	token, err := a.apiUserService.RotateToken(id)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.settings.api.tokenRotateFailed"), err)
@@ -123,16 +253,132 @@ func (a *APIUserAdminController) rate(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.settings.api.rateUpdateFailed"), err)
 		return
 	}
+	if !a.guard(c, func(caller *model.APIUser) error {
+		if caller.Id == id {
+			return service.ErrAPISelfModification
+		}
+		return a.checkTarget(caller, id, func(target *model.APIUser) { target.RateLimitPerMinute = form.Rate })
+	}) {
+		return
+	}
 	err := a.apiUserService.UpdateRateLimit(id, form.Rate)
 	jsonMsg(c, I18nWeb(c, "pages.settings.api.rateUpdated"), err)
 }
 
+func (a *APIUserAdminController) scopes(c *gin.Context) {
+	id := mustID(c.Param("id"))
+	form := &updateScopesForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.scopesUpdateFailed"), err)
+		return
+	}
+	scopes, err := service.ParseAPIScopes(strings.Join(form.Scopes, ","))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.scopesUpdateFailed"), err)
+		return
+	}
+	if !a.guard(c, func(caller *model.APIUser) error {
+		if caller.Id == id {
+			return service.ErrAPISelfModification
+		}
+		scopeList, err := service.JoinAPIScopes(scopes)
+		if err != nil {
+			return err
+		}
+		if err := a.checkTarget(caller, id, func(target *model.APIUser) { target.Scopes = scopeList }); err != nil {
+			return err
+		}
+		if !slices.Contains(scopes, model.APIScopeAdmin) {
+			return a.apiUserService.CheckAdminRemains(id)
+		}
+		return nil
+	}) {
+		return
+	}
+	err = a.apiUserService.UpdateScopes(id, scopes)
+	jsonMsg(c, I18nWeb(c, "pages.settings.api.scopesUpdated"), err)
+}
+
+func (a *APIUserAdminController) origins(c *gin.Context) {
+	id := mustID(c.Param("id"))
+	form := &updateOriginsForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.originsUpdateFailed"), err)
+		return
+	}
+	origins, err := service.ParseOrigins(form.Origins)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.originsUpdateFailed"), err)
+		return
+	}
+	if !a.guard(c, func(caller *model.APIUser) error {
+		if caller.Id == id {
+			return service.ErrAPISelfModification
+		}
+		return a.checkTarget(caller, id, func(target *model.APIUser) { target.AllowedOrigins = origins })
+	}) {
+		return
+	}
+	err = a.apiUserService.UpdateOrigins(id, origins)
+	jsonMsg(c, I18nWeb(c, "pages.settings.api.originsUpdated"), err)
+}
+
+func (a *APIUserAdminController) window(c *gin.Context) {
+	id := mustID(c.Param("id"))
+	form := &updateAccessWindowForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.windowUpdateFailed"), err)
+		return
+	}
+	window, err := service.NormalizeAPIAccessWindow(form.Window)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.windowUpdateFailed"), err)
+		return
+	}
+	if !a.guard(c, func(caller *model.APIUser) error {
+		if caller.Id == id {
+			return service.ErrAPISelfModification
+		}
+		return a.checkTarget(caller, id, func(target *model.APIUser) { target.AccessWindow = window })
+	}) {
+		return
+	}
+	err = a.apiUserService.UpdateAccessWindow(id, window)
+	jsonMsg(c, I18nWeb(c, "pages.settings.api.windowUpdated"), err)
+}
+
+func (a *APIUserAdminController) inbounds(c *gin.Context) {
+	id := mustID(c.Param("id"))
+	form := &updateInboundScopeForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.inboundsUpdateFailed"), err)
+		return
+	}
+	allowlist, err := service.NormalizeAPIInboundScope(form.Inbounds)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.inboundsUpdateFailed"), err)
+		return
+	}
+	if !a.guard(c, func(caller *model.APIUser) error {
+		if caller.Id == id {
+			return service.ErrAPISelfModification
+		}
+		return a.checkTarget(caller, id, func(target *model.APIUser) { target.InboundAllowlist = allowlist })
+	}) {
+		return
+	}
+	err = a.apiUserService.UpdateInboundScope(id, allowlist)
+	jsonMsg(c, I18nWeb(c, "pages.settings.api.inboundsUpdated"), err)
+}
+
 func (a *APIUserAdminController) getSettings(c *gin.Context) {
 	apiTokenOnly, _ := a.settingService.GetAPITokenOnly()
 	defaultRate, _ := a.settingService.GetAPIDefaultRateLimit()
+	jwtTTL, _ := a.settingService.GetAPIJWTTTL()
 	jsonObj(c, updateAPISettingForm{
 		APITokenOnly:        apiTokenOnly,
 		APIDefaultRateLimit: defaultRate,
+		APIJWTTTL:           &jwtTTL,
 	}, nil)
 }
 
@@ -142,14 +388,121 @@ func (a *APIUserAdminController) updateSettings(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.settings.api.settingsUpdateFailed"), err)
 		return
 	}
+	if !a.guard(c, func(caller *model.APIUser) error {
+		// Users without their own limit run on the default, so it may not go above the
+		// caller's effective limit. Access tokens carry that limit in RateLimitPerMinute
+		// whether it came from the user or the default, so it is compared in every case.
+		own := a.apiUserService.EffectiveRateLimit(caller)
+		if own > 0 && (form.APIDefaultRateLimit <= 0 || form.APIDefaultRateLimit > own) {
+			return fmt.Errorf("%w: default rate limit above %d/min", service.ErrAPIPrivilegeEscalation, own)
+		}
+		return nil
+	}) {
+		return
+	}
+	if apiTokenOnly, _ := a.settingService.GetAPITokenOnly(); apiTokenOnly != form.APITokenOnly && !a.stepUp(c) {
+		return
+	}
 	if err := a.settingService.SetAPITokenOnly(form.APITokenOnly); err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.settings.api.settingsUpdateFailed"), err)
 		return
 	}
+	if form.APIJWTTTL != nil {
+		if *form.APIJWTTTL < 0 || *form.APIJWTTTL > service.MaxAPIJWTTTL {
+			jsonMsg(c, I18nWeb(c, "pages.settings.api.settingsUpdateFailed"),
+				fmt.Errorf("access token lifetime must be between 0 and %d minutes", service.MaxAPIJWTTTL))
+			return
+		}
+		if err := a.settingService.SetAPIJWTTTL(*form.APIJWTTTL); err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.settings.api.settingsUpdateFailed"), err)
+			return
+		}
+	}
 	err := a.settingService.SetAPIDefaultRateLimit(form.APIDefaultRateLimit)
 	jsonMsg(c, I18nWeb(c, "pages.settings.api.settingsUpdated"), err)
 }
 
+// reauth confirms the password of the logged-in user, plus the TOTP code when two-factor
+// authentication is enabled, and unlocks sensitive operations for reauthWindow.
+func (a *APIUserAdminController) reauth(c *gin.Context) {
+	if middleware.GetAPIUserFromContext(c) != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.reauthFailed"), errors.New("API tokens do not re-authenticate"))
+		return
+	}
+	form := &reauthForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.reauthFailed"), err)
+		return
+	}
+	user := session.GetLoginUser(c)
+	if user == nil || a.userService.CheckUser(user.Username, form.Password, form.TwoFactorCode) == nil {
+		username := ""
+		if user != nil {
+			username = user.Username
+		}
+		logger.Warningf("api-users: re-authentication failed for %q from %s", username, getRemoteIp(c))
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.reauthFailed"), errReauthFailed)
+		return
+	}
+	if err := session.MarkReauthenticated(c); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.reauthFailed"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.api.reauthConfirmed"), gin.H{"validFor": int(reauthWindow.Seconds())}, nil)
+}
+
+// stepUp requires a browser session to have passed reauth within reauthWindow; token
+// callers are not affected. It answers the request and returns false when the
+// confirmation is missing or expired, telling the page whether a TOTP code is needed.
+func (a *APIUserAdminController) stepUp(c *gin.Context) bool {
+	if middleware.GetAPIUserFromContext(c) != nil || session.IsReauthenticated(c, reauthWindow) {
+		return true
+	}
+	twoFactor, _ := a.settingService.GetTwoFactorEnable()
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.api.reauthRequired"), gin.H{
+		"reauthRequired": true,
+		"twoFactor":      twoFactor,
+	}, errReauthRequired)
+	return false
+}
+
+// guard runs check for token-authenticated requests; browser sessions are not restricted.
+// It answers the request and returns false when the check fails.
+func (a *APIUserAdminController) guard(c *gin.Context, check func(caller *model.APIUser) error) bool {
+	caller := middleware.GetAPIUserFromContext(c)
+	if caller == nil {
+		return true
+	}
+	err := check(caller)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, service.ErrAPIPrivilegeEscalation),
+		errors.Is(err, service.ErrAPISelfModification),
+		errors.Is(err, service.ErrLastAdminAPIUser):
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"msg":     I18nWeb(c, "pages.settings.api.privilegeDenied") + " (" + err.Error() + ")",
+		})
+	default:
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+	}
+	return false
+}
+
+// checkTarget reports ErrAPIPrivilegeEscalation unless the API user with id, after change
+// (nil for none), is at most as privileged and at least as restricted as caller.
+func (a *APIUserAdminController) checkTarget(caller *model.APIUser, id int, change func(target *model.APIUser)) error {
+	target, err := a.apiUserService.GetUser(id)
+	if err != nil {
+		return err
+	}
+	if change != nil {
+		change(target)
+	}
+	return a.apiUserService.CheckUserGrant(caller, target)
+}
+
 func mustID(raw string) int {
 	id, _ := strconv.Atoi(strings.TrimSpace(raw))
 	return id
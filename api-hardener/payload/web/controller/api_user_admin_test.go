@@ -0,0 +1,134 @@
+//go:build toolsignore
+// +build toolsignore
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+)
+
+// panelBrowser is a logged-in browser session of the API user admin routes.
+type panelBrowser struct {
+	t      *testing.T
+	engine *gin.Engine
+	cookie string
+}
+
+func newPanelBrowser(t *testing.T) *panelBrowser {
+	engine := gin.New()
+	engine.Use(sessions.Sessions("3x-ui", cookie.NewStore([]byte("0123456789abcdef0123456789abcdef"))))
+	engine.POST("/login", func(c *gin.Context) {
+		user := &model.User{}
+		if err := database.GetDB().First(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		session.SetLoginUser(c, user)
+		if err := sessions.Default(c).Save(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	NewAPIUserAdminController(engine.Group("/panel"))
+	b := &panelBrowser{t: t, engine: engine}
+	b.post("/login", nil)
+	return b
+}
+
+// post sends form to path with the session cookie and returns the decoded answer.
+func (b *panelBrowser) post(path string, form url.Values) map[string]any {
+	b.t.Helper()
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if b.cookie != "" {
+		r.Header.Set("Cookie", b.cookie)
+	}
+	w := httptest.NewRecorder()
+	b.engine.ServeHTTP(w, r)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "3x-ui" {
+			b.cookie = c.Name + "=" + c.Value
+		}
+	}
+	answer := map[string]any{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &answer); err != nil {
+			b.t.Fatalf("%s answered %d %s", path, w.Code, w.Body)
+		}
+	}
+	return answer
+}
+
+func TestAPIUserAdminStepUp(t *testing.T) {
+	users := &service.APIUserService{}
+	settingService := &service.SettingService{}
+	target, _, err := users.CreateUser("stepup-target", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenOnly, _ := settingService.GetAPITokenOnly()
+	t.Cleanup(func() {
+		for _, name := range []string{"stepup-target", "stepup-created"} {
+			if u, err := users.GetUserByName(name); err == nil {
+				users.DeleteUser(u.Id)
+			}
+		}
+		settingService.SetAPITokenOnly(tokenOnly)
+	})
+	id := "/" + strconv.Itoa(target.Id)
+	toggled := "false"
+	if !tokenOnly {
+		toggled = "true"
+	}
+
+	b := newPanelBrowser(t)
+	steps := []struct {
+		name        string
+		path        string
+		form        url.Values
+		wantSuccess bool
+		wantReauth  bool // the answer asks for re-authentication
+	}{
+		{"create before reauth", "/panel/api-users/create", url.Values{"name": {"stepup-created"}}, false, true},
+		{"rotate before reauth", "/panel/api-users/rotate" + id, nil, false, true},
+		{"delete before reauth", "/panel/api-users/delete" + id, nil, false, true},
+		{"token-only before reauth", "/panel/api-users/settings", url.Values{"apiTokenOnly": {toggled}, "apiDefaultRateLimit": {"120"}}, false, true},
+		{"rate without reauth", "/panel/api-users/rate" + id, url.Values{"rate": {"30"}}, true, false},
+		{"wrong password", "/panel/api-users/reauth", url.Values{"password": {"wrong"}}, false, false},
+		{"reauth", "/panel/api-users/reauth", url.Values{"password": {"admin"}}, true, false},
+		{"create", "/panel/api-users/create", url.Values{"name": {"stepup-created"}}, true, false},
+		{"rotate", "/panel/api-users/rotate" + id, nil, true, false},
+		{"token-only", "/panel/api-users/settings", url.Values{"apiTokenOnly": {toggled}, "apiDefaultRateLimit": {"120"}}, true, false},
+		{"new login", "/login", nil, false, false},
+		{"delete after a new login", "/panel/api-users/delete" + id, nil, false, true},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			b.t = t
+			answer := b.post(tt.path, tt.form)
+			if tt.path == "/login" {
+				return
+			}
+			obj, _ := answer["obj"].(map[string]any)
+			if answer["success"] != tt.wantSuccess || (obj["reauthRequired"] == true) != tt.wantReauth {
+				t.Fatalf("answer = %v, want success %v and reauthRequired %v", answer, tt.wantSuccess, tt.wantReauth)
+			}
+		})
+	}
+	if _, err := users.GetUser(target.Id); err != nil {
+		t.Fatalf("the user was deleted without re-authentication: %v", err)
+	}
+}
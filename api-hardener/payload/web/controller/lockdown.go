@@ -0,0 +1,62 @@
+//go:build toolsignore
+// +build toolsignore
+
+package controller
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LockdownController switches the emergency lockdown of /panel/api. It is mounted in the
+// admin group of /panel/api, so during a lockdown only the break-glass token reaches it.
+type LockdownController struct {
+	BaseController
+	lockdownService service.APILockdownService
+}
+
+// NewLockdownController registers the lockdown routes.
+func NewLockdownController(g *gin.RouterGroup) *LockdownController {
+	a := &LockdownController{}
+	a.initRouter(g)
+	return a
+}
+
+type lockdownForm struct {
+	Mode           string `json:"mode" form:"mode"` // on (full), readonly or off
+	RevokeSessions bool   `json:"revokeSessions" form:"revokeSessions"`
+	Reason         string `json:"reason" form:"reason"`
+}
+
+func (a *LockdownController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/lockdown")
+
+	g.GET("", a.status)
+	g.POST("", a.setMode)
+}
+
+func (a *LockdownController) status(c *gin.Context) {
+	status, err := a.lockdownService.Status()
+	jsonObj(c, status, err)
+}
+
+func (a *LockdownController) setMode(c *gin.Context) {
+	form := &lockdownForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, "switch api lockdown", err)
+		return
+	}
+	actor := "unknown"
+	if user := session.GetLoginUser(c); user != nil {
+		actor = user.Username
+	}
+	revoked, err := a.lockdownService.SetMode(form.Mode, form.RevokeSessions, actor, getRemoteIp(c), form.Reason)
+	if err != nil {
+		jsonMsg(c, "switch api lockdown", err)
+		return
+	}
+	status, err := a.lockdownService.Status()
+	jsonMsgObj(c, "api lockdown: "+status.Mode, gin.H{"mode": status.Mode, "breakGlass": status.BreakGlass, "revokedSessions": revoked}, err)
+}
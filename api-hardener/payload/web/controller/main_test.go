@@ -0,0 +1,52 @@
+//go:build toolsignore
+// +build toolsignore
+
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// TestMain runs the tests against a fresh database with a fixed token pepper. The
+// database holds the default admin/admin panel login.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "x-ui-controller-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv(service.APITokenPepperEnv, "0123456789abcdef0123456789abcdef")
+	if err := database.InitDB(filepath.Join(dir, "x-ui.db")); err != nil {
+		panic(err)
+	}
+	gin.SetMode(gin.TestMode)
+	code := m.Run()
+	database.CloseDB()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// setSetting stores value for the panel setting key, or drops it for the default when
+// value is empty, until the test ends.
+func setSetting(t *testing.T, key string, value string) {
+	t.Helper()
+	drop := func() {
+		if err := database.GetDB().Where("key = ?", key).Delete(&model.Setting{}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	drop()
+	t.Cleanup(drop)
+	if value != "" {
+		if err := database.GetDB().Create(&model.Setting{Key: key, Value: value}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+//go:build toolsignore
+// +build toolsignore
+
+package controller
+
+import (
+	"errors"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionAdminController lists and revokes panel login sessions. Like the API user routes it
+// is mounted under the session-protected /panel group and under /panel/api for admin tokens.
+type SessionAdminController struct {
+	BaseController
+	panelSessionService service.PanelSessionService
+	settingService      service.SettingService
+}
+
+// NewSessionAdminController registers routes for managing panel sessions.
+func NewSessionAdminController(g *gin.RouterGroup) *SessionAdminController {
+	a := &SessionAdminController{}
+	a.initRouter(g)
+	return a
+}
+
+// panelSessionView marks the session the request was made with.
+type panelSessionView struct {
+	model.PanelSession
+	Current bool `json:"current"`
+}
+
+type revokeUserSessionsForm struct {
+	Username string `json:"username" form:"username"`
+}
+
+type sessionSettingForm struct {
+	MaxSessions int `json:"maxSessions" form:"maxSessions"`
+}
+
+func (a *SessionAdminController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/sessions")
+
+	g.GET("/list", a.list)
+	g.POST("/revoke/:id", a.revoke)
+	g.POST("/revokeUser", a.revokeUser)
+
+	g.GET("/settings", a.getSettings)
+	g.POST("/settings", a.updateSettings)
+}
+
+// currentID returns the server-side session ID of a browser caller; token callers have none.
+func (a *SessionAdminController) currentID(c *gin.Context) string {
+	if middleware.GetAPIUserFromContext(c) != nil {
+		return ""
+	}
+	return session.GetSessionID(c)
+}
+
+func (a *SessionAdminController) list(c *gin.Context) {
+	sessions, err := a.panelSessionService.ListSessions()
+	if err != nil {
+		jsonObj(c, nil, err)
+		return
+	}
+	current := a.currentID(c)
+	views := make([]panelSessionView, 0, len(sessions))
+	for i := range sessions {
+		views = append(views, panelSessionView{
+			PanelSession: sessions[i],
+			Current:      a.panelSessionService.IsCurrent(&sessions[i], current),
+		})
+	}
+	jsonObj(c, views, nil)
+}
+
+func (a *SessionAdminController) revoke(c *gin.Context) {
+	id := mustID(c.Param("id"))
+	err := a.panelSessionService.RevokeSession(id)
+	jsonMsg(c, I18nWeb(c, "pages.settings.api.sessionRevoked"), err)
+}
+
+// revokeUser logs out every session of a panel user; the caller's own session is kept.
+func (a *SessionAdminController) revokeUser(c *gin.Context) {
+	form := &revokeUserSessionsForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.sessionRevokeFailed"), err)
+		return
+	}
+	if form.Username == "" {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.sessionRevokeFailed"), errors.New("username is required"))
+		return
+	}
+	count, err := a.panelSessionService.RevokeUserSessions(form.Username, a.currentID(c))
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.api.sessionRevoked"), gin.H{"revoked": count}, err)
+}
+
+func (a *SessionAdminController) getSettings(c *gin.Context) {
+	maxSessions, err := a.settingService.GetPanelMaxSessions()
+	jsonObj(c, sessionSettingForm{MaxSessions: maxSessions}, err)
+}
+
+func (a *SessionAdminController) updateSettings(c *gin.Context) {
+	form := &sessionSettingForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.api.settingsUpdateFailed"), err)
+		return
+	}
+	err := a.settingService.SetPanelMaxSessions(form.MaxSessions)
+	jsonMsg(c, I18nWeb(c, "pages.settings.api.settingsUpdated"), err)
+}
@@ -4,6 +4,9 @@
 package controller
 
 import (
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,6 +17,10 @@ type XUIController struct {
 	settingController     *SettingController
 	xraySettingController *XraySettingController
 	apiUserController     *APIUserAdminController
+	sessionController     *SessionAdminController
+
+	settingService      service.SettingService
+	panelSessionService service.PanelSessionService
 }
 
 // NewXUIController creates a new XUIController and initializes its routes.
@@ -26,7 +33,10 @@ func NewXUIController(g *gin.RouterGroup) *XUIController {
 // initRouter sets up the main panel routes and initializes sub-controllers.
 func (a *XUIController) initRouter(g *gin.RouterGroup) {
 	g = g.Group("/panel")
+	g.Use(middleware.RealClientIP(&a.settingService))
 	g.Use(a.checkLogin)
+	g.Use(middleware.NewSessionTrackingMiddleware(&a.panelSessionService))
+	g.Use(middleware.NewCSRFMiddleware(&a.settingService))
 
 	g.GET("/", a.index)
 	g.GET("/inbounds", a.inbounds)
@@ -38,6 +48,7 @@ func (a *XUIController) initRouter(g *gin.RouterGroup) {
 	a.settingController = NewSettingController(g)
 	a.xraySettingController = NewXraySettingController(g)
 	a.apiUserController = NewAPIUserAdminController(g)
+	a.sessionController = NewSessionAdminController(g)
 }
 
 // index renders the main panel index page.
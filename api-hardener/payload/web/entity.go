@@ -8,6 +8,9 @@ import (
 	"crypto/tls"
 	"math"
 	"net"
+	"net/netip"
+	"net/url"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -54,6 +57,24 @@ type AllSetting struct {
 	// Security settings
 	APITokenOnly        bool   `json:"apiTokenOnly" form:"apiTokenOnly"`               // Require API tokens for /panel/api
 	APIDefaultRateLimit int    `json:"apiDefaultRateLimit" form:"apiDefaultRateLimit"` // Default per-minute limit for API tokens
+	APIJWTTTL           int    `json:"apiJWTTTL" form:"apiJWTTTL"`                     // Lifetime of API access tokens in minutes (0 = exchange disabled)
+	PanelMaxSessions    int    `json:"panelMaxSessions" form:"panelMaxSessions"`       // Concurrent sessions per panel user (0 = unlimited)
+	APIListen           string `json:"apiListen" form:"apiListen"`                     // API listener IP address
+	APIPort             int    `json:"apiPort" form:"apiPort"`                         // API listener port (0 = disabled), accepts client certificates
+	APICertFile         string `json:"apiCertFile" form:"apiCertFile"`                 // API listener certificate (empty = webCertFile)
+	APIKeyFile          string `json:"apiKeyFile" form:"apiKeyFile"`                   // API listener private key (empty = webKeyFile)
+	APIBasePath         string `json:"apiBasePath" form:"apiBasePath"`                 // API listener base path (empty = webBasePath)
+	APIWebListener      bool   `json:"apiWebListener" form:"apiWebListener"`           // Accept API tokens on the web listener (off = panel sessions only)
+	APIClientCAFile     string `json:"apiClientCAFile" form:"apiClientCAFile"`         // CA bundle for API client certificates (empty = local CA)
+	APIClientCRLFile    string `json:"apiClientCRLFile" form:"apiClientCRLFile"`       // CRL for API client certificates (empty = local CRL)
+	APISocket           string `json:"apiSocket" form:"apiSocket"`                     // Unix socket serving /panel/api to local callers mapped by UID/GID (empty = disabled)
+	TrustedProxies      string `json:"trustedProxies" form:"trustedProxies"`           // Reverse proxy CIDRs whose forwarding header is believed
+	TrustedProxyHeader  string `json:"trustedProxyHeader" form:"trustedProxyHeader"`   // Header with the client IP: X-Forwarded-For, X-Real-IP or CF-Connecting-IP
+	APICorsOrigins      string `json:"apiCorsOrigins" form:"apiCorsOrigins"`           // Browser origins allowed to call /panel/api (empty = none, * = any)
+	APICorsMethods      string `json:"apiCorsMethods" form:"apiCorsMethods"`           // Methods allowed in cross-origin requests
+	APICorsHeaders      string `json:"apiCorsHeaders" form:"apiCorsHeaders"`           // Request headers allowed in cross-origin requests
+	APICorsCredentials  bool   `json:"apiCorsCredentials" form:"apiCorsCredentials"`   // Allow cross-origin requests with the session cookie
+	APICorsMaxAge       int    `json:"apiCorsMaxAge" form:"apiCorsMaxAge"`             // Seconds browsers may cache a preflight response
 	TimeLocation        string `json:"timeLocation" form:"timeLocation"`               // Time zone location
 	TwoFactorEnable     bool   `json:"twoFactorEnable" form:"twoFactorEnable"`         // Enable two-factor authentication
 	TwoFactorToken      string `json:"twoFactorToken" form:"twoFactorToken"`           // Two-factor authentication token
@@ -168,6 +189,26 @@ func (s *AllSetting) CheckValid() error {
 		s.SubJsonPath += "/"
 	}
 
+	if err := s.checkAPIListener(); err != nil {
+		return err
+	}
+
+	if err := s.checkTrustedProxies(); err != nil {
+		return err
+	}
+
+	if err := s.checkAPICORS(); err != nil {
+		return err
+	}
+
+	if s.APISocket != "" && !filepath.IsAbs(s.APISocket) {
+		return common.NewError("api socket must be an absolute path:", s.APISocket)
+	}
+
+	if s.APIJWTTTL < 0 || s.APIJWTTTL > 24*60 {
+		return common.NewError("api access token lifetime must be between 0 and 1440 minutes:", s.APIJWTTTL)
+	}
+
 	_, err := time.LoadLocation(s.TimeLocation)
 	if err != nil {
 		return common.NewError("time location not exist:", s.TimeLocation)
@@ -175,3 +216,86 @@ func (s *AllSetting) CheckValid() error {
 
 	return nil
 }
+
+// checkAPIListener validates the dedicated API listener and normalizes its base path.
+func (s *AllSetting) checkAPIListener() error {
+	if s.APIListen != "" && net.ParseIP(s.APIListen) == nil {
+		return common.NewError("api listen is not valid ip:", s.APIListen)
+	}
+	if s.APIPort < 0 || s.APIPort > math.MaxUint16 {
+		return common.NewError("api port is not a valid port:", s.APIPort)
+	}
+	if s.APIPort > 0 {
+		if s.APIPort == s.WebPort && s.APIListen == s.WebListen {
+			return common.NewError("API and Web could not use same ip:port, ", s.APIListen, ":", s.APIPort)
+		}
+		if s.APIPort == s.SubPort && s.APIListen == s.SubListen {
+			return common.NewError("API and Sub could not use same ip:port, ", s.APIListen, ":", s.APIPort)
+		}
+		if s.APICertFile == "" && s.APIKeyFile == "" && (s.WebCertFile == "" || s.WebKeyFile == "") {
+			return common.NewError("api listener needs a certificate: set api cert and key files or the web ones")
+		}
+	}
+	if s.APICertFile != "" || s.APIKeyFile != "" {
+		_, err := tls.LoadX509KeyPair(s.APICertFile, s.APIKeyFile)
+		if err != nil {
+			return common.NewErrorf("api cert file <%v> or key file <%v> invalid: %v", s.APICertFile, s.APIKeyFile, err)
+		}
+	}
+	if s.APIBasePath != "" {
+		if !strings.HasPrefix(s.APIBasePath, "/") {
+			s.APIBasePath = "/" + s.APIBasePath
+		}
+		if !strings.HasSuffix(s.APIBasePath, "/") {
+			s.APIBasePath += "/"
+		}
+	}
+	return nil
+}
+
+// checkTrustedProxies validates the reverse proxy networks (CIDRs or single addresses) and
+// the header they pass the client IP in.
+func (s *AllSetting) checkTrustedProxies() error {
+	for _, field := range strings.FieldsFunc(s.TrustedProxies, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' }) {
+		if _, err := netip.ParsePrefix(field); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(field); err != nil {
+			return common.NewError("trusted proxy is not a valid ip or cidr:", field)
+		}
+	}
+	switch strings.ToLower(s.TrustedProxyHeader) {
+	case "x-forwarded-for", "x-real-ip", "cf-connecting-ip":
+		return nil
+	}
+	return common.NewError("trusted proxy header must be X-Forwarded-For, X-Real-IP or CF-Connecting-IP:", s.TrustedProxyHeader)
+}
+
+// checkAPICORS validates the CORS policy of /panel/api.
+func (s *AllSetting) checkAPICORS() error {
+	wildcard := false
+	for _, origin := range strings.FieldsFunc(s.APICorsOrigins, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if origin == "*" {
+			wildcard = true
+			continue
+		}
+		u, err := url.Parse(strings.TrimSuffix(origin, "/"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return common.NewError("cors origin must look like https://host[:port]:", origin)
+		}
+	}
+	if wildcard && s.APICorsCredentials {
+		return common.NewError("cors credentials can not be allowed for any origin (*)")
+	}
+	for _, method := range strings.FieldsFunc(s.APICorsMethods, func(r rune) bool { return r == ',' || r == ' ' }) {
+		switch strings.ToUpper(method) {
+		case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE":
+		default:
+			return common.NewError("cors method is not supported:", method)
+		}
+	}
+	if s.APICorsMaxAge < 0 || s.APICorsMaxAge > 86400 {
+		return common.NewError("cors max age must be between 0 and 86400 seconds:", s.APICorsMaxAge)
+	}
+	return nil
+}
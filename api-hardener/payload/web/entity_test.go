@@ -0,0 +1,93 @@
+//go:build toolsignore
+// +build toolsignore
+
+package entity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate and its key to dir.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "api.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "api.crt"), filepath.Join(dir, "api.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestCheckAPIListener(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+	base := func() AllSetting {
+		return AllSetting{WebPort: 2053, SubPort: 2096}
+	}
+
+	tests := []struct {
+		name         string
+		edit         func(s *AllSetting)
+		wantErr      bool
+		wantBasePath string
+	}{
+		{"disabled", func(s *AllSetting) {}, false, ""},
+		{"invalid listen", func(s *AllSetting) { s.APIListen = "api.example.com" }, true, ""},
+		{"invalid port", func(s *AllSetting) { s.APIPort = 70000 }, true, ""},
+		{"negative port", func(s *AllSetting) { s.APIPort = -1 }, true, ""},
+		{"port of the web listener", func(s *AllSetting) { s.APIPort, s.APICertFile, s.APIKeyFile = 2053, certFile, keyFile }, true, ""},
+		{"web port on another ip", func(s *AllSetting) {
+			s.APIPort, s.APIListen, s.APICertFile, s.APIKeyFile = 2053, "127.0.0.1", certFile, keyFile
+		}, false, ""},
+		{"port of the sub listener", func(s *AllSetting) { s.APIPort, s.APICertFile, s.APIKeyFile = 2096, certFile, keyFile }, true, ""},
+		{"no certificate", func(s *AllSetting) { s.APIPort = 8443 }, true, ""},
+		{"panel certificate", func(s *AllSetting) { s.APIPort, s.WebCertFile, s.WebKeyFile = 8443, certFile, keyFile }, false, ""},
+		{"api certificate", func(s *AllSetting) { s.APIPort, s.APICertFile, s.APIKeyFile = 8443, certFile, keyFile }, false, ""},
+		{"api certificate without key", func(s *AllSetting) { s.APIPort, s.APICertFile = 8443, certFile }, true, ""},
+		{"api key as certificate", func(s *AllSetting) { s.APICertFile, s.APIKeyFile = keyFile, keyFile }, true, ""},
+		{"base path", func(s *AllSetting) { s.APIBasePath = "api-x" }, false, "/api-x/"},
+		{"normalized base path", func(s *AllSetting) { s.APIBasePath = "/api-x/" }, false, "/api-x/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := base()
+			tt.edit(&s)
+			err := s.checkAPIListener()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkAPIListener = %v, want error %v", err, tt.wantErr)
+			}
+			if s.APIBasePath != tt.wantBasePath {
+				t.Fatalf("APIBasePath = %q, want %q", s.APIBasePath, tt.wantBasePath)
+			}
+		})
+	}
+}
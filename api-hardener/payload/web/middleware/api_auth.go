@@ -4,7 +4,12 @@
 package middleware
 
 import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -21,84 +26,170 @@ import (
 const (
 	apiUserContextKey      = "api_user"
 	apiVirtualUserIDOffset = 1_000_000
+	breakGlassUserName     = "break-glass"
+	noSessionsContextKey   = "api_no_sessions"
 )
 
-type apiRateLimiterStore struct {
+// apiRateLimiterMaxIdle bounds a limiter store: once it holds this many callers, the
+// limiters of callers whose bucket has refilled completely are dropped.
+const apiRateLimiterMaxIdle = 10000
+
+// apiRateLimiterStore keeps a token bucket per caller, an API user ID or a client IP.
+type apiRateLimiterStore[K comparable] struct {
 	mu       sync.Mutex
-	limiters map[int]*rate.Limiter
-	limits   map[int]int
+	limiters map[K]*rate.Limiter
+	limits   map[K]int
 }
 
-func newAPIRateLimiterStore() *apiRateLimiterStore {
-	return &apiRateLimiterStore{
-		limiters: make(map[int]*rate.Limiter),
-		limits:   make(map[int]int),
+func newAPIRateLimiterStore[K comparable]() *apiRateLimiterStore[K] {
+	return &apiRateLimiterStore[K]{
+		limiters: make(map[K]*rate.Limiter),
+		limits:   make(map[K]int),
 	}
 }
 
-func (s *apiRateLimiterStore) allow(userID int, perMinute int) bool {
+// allow consumes one request from the caller's bucket. It reports the tokens left
+// afterwards and, when the request is rejected, how long until the next one fits.
+func (s *apiRateLimiterStore[K]) allow(key K, perMinute int) (bool, int, time.Duration) {
 	if perMinute <= 0 {
-		return true
+		return true, 0, 0
 	}
 
-	limiter := s.getLimiter(userID, perMinute)
+	limiter := s.getLimiter(key, perMinute)
 	if limiter == nil {
-		return true
+		return true, 0, 0
+	}
+	if limiter.Allow() {
+		return true, int(limiter.Tokens()), 0
 	}
-	return limiter.Allow()
+	return false, 0, time.Minute / time.Duration(perMinute)
 }
 
-func (s *apiRateLimiterStore) getLimiter(userID int, perMinute int) *rate.Limiter {
+func (s *apiRateLimiterStore[K]) getLimiter(key K, perMinute int) *rate.Limiter {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	current, ok := s.limiters[userID]
-	currentLimit := s.limits[userID]
+	current, ok := s.limiters[key]
+	currentLimit := s.limits[key]
 	if ok && currentLimit == perMinute {
 		return current
 	}
+	if !ok && len(s.limiters) >= apiRateLimiterMaxIdle {
+		s.pruneIdle()
+	}
 
 	// Recreate limiter when the configured limit changes
 	interval := time.Minute / time.Duration(perMinute)
-	s.limiters[userID] = rate.NewLimiter(rate.Every(interval), perMinute)
-	s.limits[userID] = perMinute
-	return s.limiters[userID]
+	s.limiters[key] = rate.NewLimiter(rate.Every(interval), perMinute)
+	s.limits[key] = perMinute
+	return s.limiters[key]
 }
 
-// NewAPIAuthMiddleware enforces API token authentication and per-user rate limits.
-// It optionally allows existing session-based access if apiTokenOnly is disabled.
-func NewAPIAuthMiddleware(apiUserService *service.APIUserService, settingService *service.SettingService) gin.HandlerFunc {
-	limiterStore := newAPIRateLimiterStore()
+// pruneIdle drops the limiters that are full again; their callers start over with a
+// full bucket anyway. s.mu must be held.
+func (s *apiRateLimiterStore[K]) pruneIdle() {
+	for key, limiter := range s.limiters {
+		if limiter.Tokens() >= float64(limiter.Burst()) {
+			delete(s.limiters, key)
+			delete(s.limits, key)
+		}
+	}
+}
+
+// apiUserLimiters is shared by the API auth middleware of every listener (panel port, API
+// TLS listener, unix socket), so a user gets one budget however it reaches the API.
+var apiUserLimiters = newAPIRateLimiterStore[int]()
 
+// NewAPIAuthMiddleware enforces API token, access token (JWT), client certificate or
+// unix socket peer credential authentication, per-user origins and access windows, and
+// per-user rate limits. Access tokens are verified without a database lookup and carry
+// their own rate limit. It optionally allows existing session-based access if
+// apiTokenOnly is disabled. During a lockdown only the break-glass token (and for
+// read-only lockdowns, GET requests) get through.
+func NewAPIAuthMiddleware(apiUserService *service.APIUserService, settingService *service.SettingService, lockdownService *service.APILockdownService, jwtService *service.APIJWTService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenOnly, err := settingService.GetAPITokenOnly()
 		if err != nil {
 			logger.Warning("read apiTokenOnly failed:", err)
 		}
 
-		token := extractAPIToken(c)
-		if token == "" && !tokenOnly && session.IsLogin(c) {
-			c.Next()
-			return
+		token := ExtractAPIToken(c)
+		mode, err := lockdownService.Mode()
+		if err != nil {
+			logger.Warning("read apiLockdown failed:", err)
+		}
+		if mode != service.APILockdownOff {
+			if token != "" && lockdownService.IsBreakGlassToken(token) {
+				logger.Infof("break-glass request %s %s from %s", c.Request.Method, c.Request.URL.Path, c.ClientIP())
+				setBreakGlassUser(c)
+				c.Next()
+				return
+			}
+			if mode != service.APILockdownReadOnly || methodScope(c.Request.Method) != model.APIScopeRead {
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "api lockdown", "mode": mode})
+				return
+			}
 		}
 
-		if token == "" {
-			c.AbortWithStatus(http.StatusNotFound)
+		cert := VerifiedClientCert(c)
+		peer := PeerCred(c)
+		if token == "" && cert == nil && peer == nil && !tokenOnly && !c.GetBool(noSessionsContextKey) && session.IsLogin(c) {
+			c.Next()
 			return
 		}
 
-		apiUser, err := apiUserService.VerifyToken(token)
+		var apiUser *model.APIUser
+		var claims *service.APIJWTClaims
+		switch {
+		case token == "" && cert != nil:
+			apiUser, err = apiUserService.VerifyClientCert(cert)
+		case token == "" && peer != nil:
+			if apiUser, err = apiUserService.VerifyPeerCred(peer); err != nil {
+				logger.Warningf("unix socket caller %s is not mapped to an api user", peer)
+			}
+		case service.IsAPIJWT(token):
+			if claims, err = jwtService.Verify(token); err == nil {
+				apiUser = claims.APIUser()
+			}
+		case token == "" || !service.IsWellFormedAPIToken(token):
+			// Malformed tokens (bad shape or checksum) are rejected before touching the database.
+			err = service.ErrInvalidAPIToken
+		default:
+			apiUser, err = apiUserService.VerifyToken(token)
+		}
 		if err != nil {
 			c.AbortWithStatus(http.StatusNotFound)
 			return
 		}
+		if !apiUser.AllowsOrigin(c.GetHeader("Origin")) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
+			return
+		}
+		if err := apiUserService.CheckAccessWindow(apiUser, time.Now()); err != nil {
+			abortOutsideAccessWindow(c, apiUser, settingService, err)
+			return
+		}
 
-		effectiveLimit := apiUserService.EffectiveRateLimit(apiUser)
-		if !limiterStore.allow(apiUser.Id, effectiveLimit) {
+		effectiveLimit := apiUser.RateLimitPerMinute
+		if claims == nil {
+			effectiveLimit = apiUserService.EffectiveRateLimit(apiUser)
+		}
+		allowed, remaining, retryAfter := apiUserLimiters.allow(apiUser.Id, effectiveLimit)
+		if effectiveLimit > 0 {
+			c.Header("X-RateLimit-Limit", strconv.Itoa(effectiveLimit))
+			c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
 			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
 			return
 		}
 
+		if !apiUser.HasScope(methodScope(c.Request.Method)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient scope"})
+			return
+		}
+
 		c.Set(apiUserContextKey, apiUser)
 		session.SetContextUser(c, &model.User{
 			Id:       apiVirtualUserIDOffset + apiUser.Id,
@@ -109,6 +200,106 @@ func NewAPIAuthMiddleware(apiUserService *service.APIUserService, settingService
 	}
 }
 
+// abortOutsideAccessWindow answers 403 with the access window of apiUser and the time zone it
+// is evaluated in.
+func abortOutsideAccessWindow(c *gin.Context, apiUser *model.APIUser, settingService *service.SettingService, err error) {
+	if !errors.Is(err, service.ErrOutsideAccessWindow) {
+		logger.Warning("check api access window failed:", err)
+	}
+	location, _ := settingService.GetTimeLocation()
+	body := gin.H{"error": service.ErrOutsideAccessWindow.Error(), "accessWindow": apiUser.AccessWindow}
+	if location != nil {
+		body["timeLocation"] = location.String()
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, body)
+}
+
+// VerifiedClientCert returns the client certificate of the request when the TLS
+// handshake verified it against the client CA bundle.
+func VerifiedClientCert(c *gin.Context) *x509.Certificate {
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 || len(c.Request.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return c.Request.TLS.PeerCertificates[0]
+}
+
+type peerCredContextKey struct{}
+
+// ContextWithPeerCred attaches the credentials of a unix socket caller to the context of
+// its connection.
+func ContextWithPeerCred(ctx context.Context, cred *service.PeerCred) context.Context {
+	return context.WithValue(ctx, peerCredContextKey{}, cred)
+}
+
+// PeerCred returns the credentials of the unix socket caller, or nil for other requests.
+func PeerCred(c *gin.Context) *service.PeerCred {
+	cred, _ := c.Request.Context().Value(peerCredContextKey{}).(*service.PeerCred)
+	return cred
+}
+
+// WithoutSessions marks requests of an engine that has no session store, such as the
+// API listener; they can only authenticate with a token or client certificate.
+func WithoutSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(noSessionsContextKey, true)
+		c.Next()
+	}
+}
+
+// PanelSessionsOnly guards the API routes of the web listener. With apiWebListener off they
+// serve only the panel's own pages, which use the session; tokens, access tokens, client
+// credentials and anonymous requests get 404, so API clients have to use the API listener
+// or socket.
+func PanelSessionsOnly(settingService *service.SettingService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		enabled, err := settingService.GetAPIWebListener()
+		if err != nil {
+			logger.Warning("read apiWebListener failed:", err)
+		}
+		if enabled {
+			c.Next()
+			return
+		}
+		_, _, basic := c.Request.BasicAuth()
+		if ExtractAPIToken(c) != "" || basic || !session.IsLogin(c) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.Next()
+	}
+}
+
+// setBreakGlassUser authenticates the request as the admin-scoped break-glass user.
+func setBreakGlassUser(c *gin.Context) {
+	c.Set(apiUserContextKey, &model.APIUser{Name: breakGlassUserName, Scopes: string(model.APIScopeAdmin), Enabled: true})
+	session.SetContextUser(c, &model.User{
+		Id:       apiVirtualUserIDOffset,
+		Username: "api:" + breakGlassUserName,
+	})
+}
+
+// RequireAPIScope rejects token-authenticated requests whose API user lacks scope.
+// Session-authenticated requests (allowed when apiTokenOnly is off) pass through.
+func RequireAPIScope(scope model.APIScope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiUser := GetAPIUserFromContext(c)
+		if apiUser != nil && !apiUser.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient scope"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// methodScope maps safe HTTP methods to the read scope and everything else to write.
+func methodScope(method string) model.APIScope {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return model.APIScopeRead
+	}
+	return model.APIScopeWrite
+}
+
 // GetAPIUserFromContext returns the authenticated API user (if any) set by the middleware.
 func GetAPIUserFromContext(c *gin.Context) *model.APIUser {
 	if c == nil {
@@ -125,7 +316,8 @@ func GetAPIUserFromContext(c *gin.Context) *model.APIUser {
 	return apiUser
 }
 
-func extractAPIToken(c *gin.Context) string {
+// ExtractAPIToken returns the token from the Authorization bearer, X-API-Token or the api_token query.
+func ExtractAPIToken(c *gin.Context) string {
 	auth := strings.TrimSpace(c.GetHeader("Authorization"))
 	if strings.HasPrefix(strings.ToLower(auth), "bearer ") {
 		return strings.TrimSpace(auth[7:])
@@ -0,0 +1,71 @@
+//go:build toolsignore
+// +build toolsignore
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+)
+
+func TestPanelSessionsOnly(t *testing.T) {
+	engine := gin.New()
+	engine.Use(sessions.Sessions("3x-ui", cookie.NewStore([]byte("0123456789abcdef0123456789abcdef"))))
+	engine.POST("/login", func(c *gin.Context) {
+		session.SetLoginUser(c, &model.User{Id: 1, Username: "admin"})
+		sessions.Default(c).Save()
+	})
+	engine.GET("/panel/api/status", PanelSessionsOnly(&service.SettingService{}), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	var login string
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", nil))
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "3x-ui" {
+			login = c.Name + "=" + c.Value
+		}
+	}
+
+	tests := []struct {
+		name        string
+		webListener string // apiWebListener, "" for the default
+		path        string
+		headers     map[string]string
+		want        int
+	}{
+		{"default token", "", "/panel/api/status", map[string]string{"Authorization": "Bearer xui_live_x"}, http.StatusOK},
+		{"default anonymous", "", "/panel/api/status", nil, http.StatusOK},
+		{"session", "false", "/panel/api/status", map[string]string{"Cookie": login}, http.StatusOK},
+		{"bearer token", "false", "/panel/api/status", map[string]string{"Authorization": "Bearer xui_live_x"}, http.StatusNotFound},
+		{"token header", "false", "/panel/api/status", map[string]string{"X-API-Token": "xui_live_x"}, http.StatusNotFound},
+		{"token query", "false", "/panel/api/status?api_token=xui_live_x", nil, http.StatusNotFound},
+		{"session with token", "false", "/panel/api/status", map[string]string{"Cookie": login, "X-API-Token": "xui_live_x"}, http.StatusNotFound},
+		{"client credentials", "false", "/panel/api/status", map[string]string{"Authorization": "Basic Y2k6c2VjcmV0"}, http.StatusNotFound},
+		{"anonymous", "false", "/panel/api/status", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setSetting(t, "apiWebListener", tt.webListener)
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+//go:build toolsignore
+// +build toolsignore
+
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// forwardingHeaders carry the client address through proxies. They are removed from every
+// request once the client IP is resolved, so nothing downstream reads them unchecked.
+var forwardingHeaders = []string{"X-Forwarded-For", "X-Real-IP", "CF-Connecting-IP", "True-Client-IP", "Forwarded"}
+
+// RealClientIP resolves the client address of requests relayed by a trusted reverse proxy
+// (trustedProxies) from trustedProxyHeader, and ignores the forwarding headers of anyone
+// else. The resolved address replaces the remote address of the request and the headers
+// are removed, so gin's ClientIP, the audit trail, the session list and the logs all see
+// the same client.
+func RealClientIP(settingService *service.SettingService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resolveClientIPFromSettings(c.Request, settingService)
+		c.Next()
+	}
+}
+
+// resolveClientIPFromSettings runs resolveClientIP with the current trustedProxies and
+// trustedProxyHeader. Without them, forwarding headers are not believed.
+func resolveClientIPFromSettings(r *http.Request, settingService *service.SettingService) {
+	proxies, header, err := settingService.GetTrustedProxySettings()
+	if err != nil {
+		logger.Warning("read trusted proxy settings failed:", err)
+	}
+	resolveClientIP(r, proxies, header)
+}
+
+// resolveClientIP rewrites the remote address of r to the client behind trusted proxies
+// and strips the forwarding headers. Requests that do not come from an IP address, such
+// as those on the unix socket, are left alone.
+func resolveClientIP(r *http.Request, proxies []netip.Prefix, header string) {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return
+	}
+	peer = peer.Unmap()
+
+	client := peer
+	if isTrustedProxy(peer, proxies) {
+		switch {
+		case strings.EqualFold(header, "X-Forwarded-For"):
+			client = forwardedForClient(r.Header.Values("X-Forwarded-For"), peer, proxies)
+		case service.IsTrustedProxyHeader(header):
+			if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get(header))); err == nil {
+				client = addr.Unmap()
+			}
+		}
+	} else if hasForwardingHeader(r.Header) {
+		logger.Debugf("ignoring forwarding headers from untrusted %s", peer)
+	}
+
+	for _, h := range forwardingHeaders {
+		r.Header.Del(h)
+	}
+	r.RemoteAddr = net.JoinHostPort(client.String(), port)
+}
+
+// forwardedForClient walks the X-Forwarded-For chain from the nearest hop and returns the
+// first address that is not a trusted proxy. Everything left of it was written by the
+// client and can not be believed. A malformed entry ends the walk at the last good hop.
+func forwardedForClient(values []string, peer netip.Addr, proxies []netip.Prefix) netip.Addr {
+	hops := strings.Split(strings.Join(values, ","), ",")
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !isTrustedProxy(client, proxies) {
+			break
+		}
+	}
+	return client
+}
+
+func isTrustedProxy(addr netip.Addr, proxies []netip.Prefix) bool {
+	return slices.ContainsFunc(proxies, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+func hasForwardingHeader(h http.Header) bool {
+	return slices.ContainsFunc(forwardingHeaders, func(name string) bool { return h.Get(name) != "" })
+}
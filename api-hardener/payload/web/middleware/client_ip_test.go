@@ -0,0 +1,60 @@
+//go:build toolsignore
+// +build toolsignore
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string // trustedProxyHeader
+		headers    map[string]string
+		want       string // RemoteAddr afterwards
+	}{
+		{"direct client", "203.0.113.7:4000", "X-Forwarded-For", nil, "203.0.113.7:4000"},
+		{"untrusted peer with a forged header", "203.0.113.7:4000", "X-Forwarded-For",
+			map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"}, "203.0.113.7:4000"},
+		{"trusted proxy", "127.0.0.1:4000", "X-Forwarded-For",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1:4000"},
+		{"chain through trusted proxies", "127.0.0.1:4000", "X-Forwarded-For",
+			map[string]string{"X-Forwarded-For": "192.0.2.9, 198.51.100.1, 10.1.2.3"}, "198.51.100.1:4000"},
+		{"malformed hop ends the walk", "127.0.0.1:4000", "X-Forwarded-For",
+			map[string]string{"X-Forwarded-For": "198.51.100.1, junk, 10.1.2.3"}, "10.1.2.3:4000"},
+		{"X-Real-IP", "127.0.0.1:4000", "X-Real-IP",
+			map[string]string{"X-Real-IP": "198.51.100.3", "X-Forwarded-For": "192.0.2.9"}, "198.51.100.3:4000"},
+		{"CF-Connecting-IP, case-insensitive", "10.0.0.1:4000", "cf-connecting-ip",
+			map[string]string{"CF-Connecting-IP": "2001:db8::1"}, "[2001:db8::1]:4000"},
+		{"invalid header value", "127.0.0.1:4000", "X-Real-IP",
+			map[string]string{"X-Real-IP": "nobody"}, "127.0.0.1:4000"},
+		{"unsupported header", "127.0.0.1:4000", "True-Client-IP",
+			map[string]string{"True-Client-IP": "198.51.100.4"}, "127.0.0.1:4000"},
+		{"IPv4-mapped peer", "[::ffff:127.0.0.1]:4000", "X-Forwarded-For",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1:4000"},
+		{"unix socket", "@", "X-Forwarded-For",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"}, "@"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			resolveClientIP(r, proxies, tt.header)
+			if r.RemoteAddr != tt.want {
+				t.Errorf("RemoteAddr = %q, want %q", r.RemoteAddr, tt.want)
+			}
+			if tt.remoteAddr != "@" && hasForwardingHeader(r.Header) {
+				t.Errorf("forwarding headers left: %v", r.Header)
+			}
+		})
+	}
+}
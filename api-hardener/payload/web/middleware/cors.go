@@ -0,0 +1,77 @@
+//go:build toolsignore
+// +build toolsignore
+
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// corsExposedHeaders lets dashboards read the rate limit state of their token.
+const corsExposedHeaders = "X-RateLimit-Limit, X-RateLimit-Remaining, Retry-After"
+
+// NewCORSMiddleware applies the CORS policy of /panel/api (apiCors* settings). It answers
+// preflight requests itself, so they never reach the token middleware: browsers send them
+// without credentials. Requests from origins outside the policy are served without CORS
+// headers, which makes the browser withhold the response from the page.
+func NewCORSMiddleware(settingService *service.SettingService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		policy, err := settingService.GetAPICORSPolicy()
+		if err != nil {
+			logger.Warning("read api cors settings failed:", err)
+		}
+		if err != nil || !policy.AllowsOrigin(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusNoContent)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		h := c.Writer.Header()
+		if policy.Credentials || !policy.AllowsOrigin("*") {
+			h.Set("Access-Control-Allow-Origin", origin)
+		} else {
+			h.Set("Access-Control-Allow-Origin", "*")
+		}
+		if policy.Credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			c.Next()
+			return
+		}
+
+		if policy.AllowsMethod(c.GetHeader("Access-Control-Request-Method")) {
+			h.Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+			h.Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+			if policy.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+			}
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// CORSPreflight is the handler of OPTIONS routes: gin runs group middleware only for
+// routes it matched, so preflight requests need one to reach NewCORSMiddleware.
+func CORSPreflight(c *gin.Context) {
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,138 @@
+//go:build toolsignore
+// +build toolsignore
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	engine := gin.New()
+	api := engine.Group("/panel/api", NewCORSMiddleware(&service.SettingService{}))
+	api.GET("/status", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	api.OPTIONS("/status", CORSPreflight)
+
+	const dash = "https://dash.example.com"
+	tests := []struct {
+		name        string
+		origins     string // apiCorsOrigins
+		credentials string // apiCorsCredentials, "" for the default
+		method      string
+		headers     map[string]string
+		wantCode    int
+		wantHeaders map[string]string // "" for absent
+	}{
+		{"no origin", dash, "", http.MethodGet, nil, http.StatusOK, map[string]string{
+			"Access-Control-Allow-Origin": "", "Vary": "",
+		}},
+		{"allowed origin", dash, "", http.MethodGet, map[string]string{"Origin": dash}, http.StatusOK, map[string]string{
+			"Access-Control-Allow-Origin": dash, "Access-Control-Allow-Credentials": "", "Access-Control-Expose-Headers": corsExposedHeaders, "Vary": "Origin",
+		}},
+		{"other origin", dash, "", http.MethodGet, map[string]string{"Origin": "https://evil.example"}, http.StatusOK, map[string]string{
+			"Access-Control-Allow-Origin": "", "Vary": "Origin",
+		}},
+		{"no policy", "", "", http.MethodGet, map[string]string{"Origin": dash}, http.StatusOK, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"any origin", "*", "", http.MethodGet, map[string]string{"Origin": dash}, http.StatusOK, map[string]string{
+			"Access-Control-Allow-Origin": "*",
+		}},
+		{"credentials", dash, "true", http.MethodGet, map[string]string{"Origin": dash}, http.StatusOK, map[string]string{
+			"Access-Control-Allow-Origin": dash, "Access-Control-Allow-Credentials": "true",
+		}},
+		{"credentials with any origin", "*", "true", http.MethodGet, map[string]string{"Origin": dash}, http.StatusOK, map[string]string{
+			"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Credentials": "",
+		}},
+		{"preflight", dash, "", http.MethodOptions, map[string]string{"Origin": dash, "Access-Control-Request-Method": "POST"}, http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": dash, "Access-Control-Allow-Methods": "GET, POST",
+			"Access-Control-Allow-Headers": "Authorization, Content-Type, X-API-Token", "Access-Control-Max-Age": "600",
+			"Access-Control-Expose-Headers": "",
+		}},
+		{"preflight of another method", dash, "", http.MethodOptions, map[string]string{"Origin": dash, "Access-Control-Request-Method": "DELETE"}, http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": dash, "Access-Control-Allow-Methods": "",
+		}},
+		{"preflight from another origin", dash, "", http.MethodOptions, map[string]string{"Origin": "https://evil.example", "Access-Control-Request-Method": "GET"}, http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": "",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setSetting(t, "apiCorsOrigins", tt.origins)
+			setSetting(t, "apiCorsCredentials", tt.credentials)
+			r := httptest.NewRequest(tt.method, "/panel/api/status", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIAuthOrigins(t *testing.T) {
+	users := &service.APIUserService{}
+	jwtService := &service.APIJWTService{}
+	user, token, err := users.CreateUser("cors-dashboard", 0, []model.APIScope{model.APIScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { users.DeleteUser(user.Id) })
+	if err := users.UpdateOrigins(user.Id, "https://dash.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if user, err = users.GetUser(user.Id); err != nil {
+		t.Fatal(err)
+	}
+	accessToken, _, err := jwtService.Issue(user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := gin.New()
+	engine.GET("/panel/api/status", NewAPIAuthMiddleware(users, &service.SettingService{}, &service.APILockdownService{}, jwtService), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		origin string
+		want   int
+	}{
+		{"token without origin", token, "", http.StatusOK},
+		{"token from allowed origin", token, "https://dash.example.com", http.StatusOK},
+		{"token from other origin", token, "https://evil.example", http.StatusForbidden},
+		{"access token from allowed origin", accessToken, "https://dash.example.com", http.StatusOK},
+		{"access token from other origin", accessToken, "https://evil.example", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/panel/api/status", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
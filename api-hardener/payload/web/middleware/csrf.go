@@ -0,0 +1,106 @@
+//go:build toolsignore
+// +build toolsignore
+
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+)
+
+const (
+	// CSRFCookieName is the readable cookie that carries the session's CSRF token to page scripts.
+	CSRFCookieName = "xui_csrf"
+	// CSRFHeaderName is the request header that must echo the token on mutating requests.
+	CSRFHeaderName = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
+)
+
+// NewCSRFMiddleware protects session-authenticated requests against cross-site request forgery.
+// Safe requests receive the session's token in a SameSite=Strict cookie; mutating requests must
+// come from the panel's own origin (webDomain when set) and echo the token in X-CSRF-Token.
+// Requests authenticated by an API token are not affected.
+func NewCSRFMiddleware(settingService *service.SettingService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetAPIUserFromContext(c) != nil {
+			c.Next()
+			return
+		}
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if token := session.GetCSRFToken(c); token != "" {
+				setCSRFCookie(c, token)
+			}
+			c.Next()
+			return
+		}
+
+		webDomain, err := settingService.GetWebDomain()
+		if err != nil {
+			logger.Warning("read webDomain failed:", err)
+		}
+		if !sameOrigin(c.Request, webDomain) {
+			abortCSRF(c, "cross-origin request rejected")
+			return
+		}
+
+		token := c.GetHeader(CSRFHeaderName)
+		if token == "" {
+			token = c.PostForm(csrfFormField)
+		}
+		if !session.CheckCSRFToken(c, token) {
+			abortCSRF(c, "invalid csrf token")
+			return
+		}
+		c.Next()
+	}
+}
+
+func setCSRFCookie(c *gin.Context, token string) {
+	path := c.GetString("base_path")
+	if path == "" {
+		path = "/"
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    token,
+		Path:     path,
+		Secure:   c.Request.TLS != nil,
+		HttpOnly: false, // read by the page scripts
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func abortCSRF(c *gin.Context, msg string) {
+	logger.Warning("csrf:", msg, c.Request.Method, c.Request.URL.Path, c.ClientIP())
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"success": false, "msg": msg})
+}
+
+// sameOrigin checks the Origin header, falling back to Referer. The source host must be
+// webDomain when it is configured, otherwise the Host the request was sent to. Requests
+// carrying neither header are left to the token check.
+func sameOrigin(r *http.Request, webDomain string) bool {
+	source := r.Header.Get("Origin")
+	if source == "" || source == "null" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if webDomain != "" {
+		return strings.EqualFold(u.Hostname(), webDomain)
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,127 @@
+//go:build toolsignore
+// +build toolsignore
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		name      string
+		origin    string
+		referer   string
+		webDomain string
+		want      bool
+	}{
+		{"no headers", "", "", "", true},
+		{"same origin", "https://panel.example:2053", "", "", true},
+		{"other port", "https://panel.example:8443", "", "", false},
+		{"other host", "https://evil.example", "", "", false},
+		{"null origin falls back to referer", "null", "https://panel.example:2053/panel/", "", true},
+		{"cross-site referer", "", "https://evil.example/page", "", false},
+		{"malformed origin", "panel.example", "", "", false},
+		{"webDomain", "https://Panel.Example", "", "panel.example", true},
+		{"webDomain, other host", "https://panel.example:2053", "", "admin.example", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "https://panel.example:2053/panel/setting/update", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			if got := sameOrigin(r, tt.webDomain); got != tt.want {
+				t.Fatalf("sameOrigin = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	engine := gin.New()
+	engine.Use(sessions.Sessions("3x-ui", cookie.NewStore([]byte("0123456789abcdef0123456789abcdef"))))
+	engine.Use(func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			c.Set(apiUserContextKey, &model.APIUser{Name: "token"})
+		}
+	})
+	engine.Use(NewCSRFMiddleware(&service.SettingService{}))
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	engine.GET("/panel/", ok)
+	engine.POST("/panel/update", ok)
+
+	// A page load hands out the token of the session.
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://panel.example/panel/", nil))
+	var sessionCookie, token string
+	for _, c := range w.Result().Cookies() {
+		switch c.Name {
+		case "3x-ui":
+			sessionCookie = c.Name + "=" + c.Value
+		case CSRFCookieName:
+			token = c.Value
+			if c.HttpOnly || c.SameSite != http.SameSiteStrictMode {
+				t.Errorf("csrf cookie %+v, want readable and SameSite=Strict", c)
+			}
+		}
+	}
+	if sessionCookie == "" || len(token) != 64 {
+		t.Fatalf("GET set cookies %v", w.Result().Cookies())
+	}
+
+	tests := []struct {
+		name      string
+		origin    string
+		header    string
+		form      string
+		auth      string
+		webDomain string
+		want      int
+	}{
+		{"token in header", "http://panel.example", token, "", "", "", http.StatusOK},
+		{"token in form", "", "", token, "", "", http.StatusOK},
+		{"no token", "http://panel.example", "", "", "", "", http.StatusForbidden},
+		{"wrong token", "http://panel.example", strings.Repeat("0", 64), "", "", "", http.StatusForbidden},
+		{"cross-origin", "http://evil.example", token, "", "", "", http.StatusForbidden},
+		{"webDomain", "https://panel.example", token, "", "", "panel.example", http.StatusOK},
+		{"not webDomain", "http://panel.example", token, "", "", "admin.example", http.StatusForbidden},
+		{"api token", "http://evil.example", "", "", "Bearer xui_live_x", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setSetting(t, "webDomain", tt.webDomain)
+			body := url.Values{}
+			if tt.form != "" {
+				body.Set(csrfFormField, tt.form)
+			}
+			r := httptest.NewRequest(http.MethodPost, "http://panel.example/panel/update", strings.NewReader(body.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Cookie", sessionCookie)
+			for name, value := range map[string]string{"Origin": tt.origin, CSRFHeaderName: tt.header, "Authorization": tt.auth} {
+				if value != "" {
+					r.Header.Set(name, value)
+				}
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status %d (%s), want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+//go:build toolsignore
+// +build toolsignore
+
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// inboundRoutesPrefix is where the inbound and client routes live below the API base path.
+const inboundRoutesPrefix = "/panel/api/inbounds/"
+
+// NewInboundScopeMiddleware confines API users with an inbound allowlist to the inbounds it
+// permits. Routes that name an inbound or client are checked before they run, add and import
+// are checked against the port and tag the new inbound will get, and the list routes are
+// filtered after. Every other route, server and admin ones included, is closed to such users,
+// as are inbound routes this middleware does not know.
+func NewInboundScopeMiddleware(apiUserService *service.APIUserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := apiUserService.InboundScope(GetAPIUserFromContext(c))
+		if scope == nil {
+			c.Next()
+			return
+		}
+
+		fullPath := c.FullPath()
+		i := strings.Index(fullPath, inboundRoutesPrefix)
+		if i < 0 {
+			abortInboundNotAllowed(c, "route not available to api users restricted to inbounds")
+			return
+		}
+
+		var err error
+		switch route := fullPath[i+len(inboundRoutesPrefix):]; route {
+		case "list":
+			filterInboundResponse(c, func(item map[string]json.RawMessage) bool {
+				return scope.AllowsInbound(jsonInt(item["id"]), jsonString(item["tag"]), jsonInt(item["port"]))
+			})
+			return
+		case "getClientTrafficsById/:id":
+			// :id is the client's ID here, which can be shared by clients of several inbounds.
+			filterInboundResponse(c, func(item map[string]json.RawMessage) bool {
+				return apiUserService.CheckInbound(scope, jsonInt(item["inboundId"])) == nil
+			})
+			return
+		case "onlines", "lastOnline":
+			emails, err := apiUserService.AllowedClientEmails(scope)
+			if err != nil {
+				abortInboundScopeError(c, err)
+				return
+			}
+			filterClientResponse(c, emails)
+			return
+		case "get/:id", "del/:id", "resetAllClientTraffics/:id", "delDepletedClients/:id",
+			":id/delClient/:clientId", ":id/resetClientTraffic/:email", ":id/delClientByEmail/:email":
+			err = checkInboundParam(c, apiUserService, scope)
+		case "update/:id":
+			err = checkInboundUpdate(c, apiUserService, scope)
+		case "getClientTraffics/:email", "clientIps/:email", "clearClientIps/:email", "updateClientTraffic/:email":
+			err = apiUserService.CheckClient(scope, c.Param("email"))
+		case "addClient", "updateClient/:clientId":
+			var body inboundBody
+			if body, err = readInboundBody(c, ""); err == nil {
+				err = apiUserService.CheckInbound(scope, body.Id)
+			}
+		case "add":
+			err = checkInboundAdd(c, scope)
+		case "import":
+			var body inboundBody
+			if body, err = readInboundBody(c, "data"); err == nil && !scope.AllowsNew(service.InboundTag(body.Listen, body.Port), body.Port) {
+				err = service.ErrInboundNotAllowed
+			}
+		default:
+			abortInboundNotAllowed(c, "route not available to api users restricted to inbounds")
+			return
+		}
+		if err != nil {
+			abortInboundScopeError(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// inboundBody holds the fields of an inbound request body the scope is checked against.
+type inboundBody struct {
+	Id     int    `json:"id" form:"id"`
+	Listen string `json:"listen" form:"listen"`
+	Port   int    `json:"port" form:"port"`
+}
+
+// readInboundBody reads the inbound fields of a JSON or form body and leaves the body for the
+// handler. With field set, the inbound is the JSON document in that form field, as on import.
+func readInboundBody(c *gin.Context, field string) (inboundBody, error) {
+	var body inboundBody
+	if field != "" {
+		err := json.Unmarshal([]byte(c.PostForm(field)), &body)
+		return body, err
+	}
+	if c.ContentType() != gin.MIMEJSON {
+		// The parsed form is cached on the request, so the handler binds it again.
+		if err := c.Request.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return body, err
+		}
+		body.Id, _ = strconv.Atoi(c.PostForm("id"))
+		body.Listen = c.PostForm("listen")
+		body.Port, _ = strconv.Atoi(c.PostForm("port"))
+		return body, nil
+	}
+	raw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return body, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(raw))
+	err = json.Unmarshal(raw, &body)
+	return body, err
+}
+
+// checkInboundParam checks the inbound named by the :id route parameter.
+func checkInboundParam(c *gin.Context, apiUserService *service.APIUserService, scope *service.APIInboundScope) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return service.ErrInboundNotAllowed
+	}
+	return apiUserService.CheckInbound(scope, id)
+}
+
+// checkInboundAdd checks the port and tag the inbound in the body gets when it is added. A
+// body with an ID would be saved over that inbound, so it is refused.
+func checkInboundAdd(c *gin.Context, scope *service.APIInboundScope) error {
+	body, err := readInboundBody(c, "")
+	if err != nil {
+		return err
+	}
+	if body.Id != 0 || !scope.AllowsNew(service.InboundTag(body.Listen, body.Port), body.Port) {
+		return service.ErrInboundNotAllowed
+	}
+	return nil
+}
+
+// checkInboundUpdate checks the inbound an update writes and the port and tag it moves to.
+// The handler binds the body over the :id inbound, so an ID in the body picks the inbound
+// that is really written; one that differs from :id is refused. An updated inbound keeps
+// its ID, so an inbound permitted by ID may move anywhere.
+func checkInboundUpdate(c *gin.Context, apiUserService *service.APIUserService, scope *service.APIInboundScope) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return service.ErrInboundNotAllowed
+	}
+	body, err := readInboundBody(c, "")
+	if err != nil {
+		return err
+	}
+	if body.Id != 0 && body.Id != id {
+		return service.ErrInboundNotAllowed
+	}
+	if err := apiUserService.CheckInbound(scope, id); err != nil {
+		return err
+	}
+	if !scope.AllowsInbound(id, service.InboundTag(body.Listen, body.Port), body.Port) {
+		return service.ErrInboundNotAllowed
+	}
+	return nil
+}
+
+func abortInboundScopeError(c *gin.Context, err error) {
+	if !errors.Is(err, service.ErrInboundNotAllowed) {
+		logger.Warning("check api inbound allowlist failed:", err)
+	}
+	abortInboundNotAllowed(c, service.ErrInboundNotAllowed.Error())
+}
+
+func abortInboundNotAllowed(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": message})
+}
+
+// bufferedResponseWriter holds back the body of a response so it can be rewritten.
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// msgEnvelope is the JSON message every panel handler answers with (entity.Msg).
+type msgEnvelope struct {
+	Success bool            `json:"success"`
+	Msg     string          `json:"msg"`
+	Obj     json.RawMessage `json:"obj"`
+}
+
+// rewriteResponse runs the handler and passes the obj of its JSON answer through rewrite.
+// Answers that are not a JSON message are dropped, as they can not be filtered.
+func rewriteResponse(c *gin.Context, rewrite func(obj json.RawMessage) (any, error)) {
+	writer := &bufferedResponseWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	c.Next()
+	c.Writer = writer.ResponseWriter
+
+	var msg msgEnvelope
+	err := json.Unmarshal(writer.body.Bytes(), &msg)
+	if err == nil && len(msg.Obj) > 0 && string(msg.Obj) != "null" {
+		var filtered any
+		if filtered, err = rewrite(msg.Obj); err == nil {
+			msg.Obj, err = json.Marshal(filtered)
+		}
+	}
+	if err != nil {
+		logger.Warning("filter api inbound response failed:", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	out, _ := json.Marshal(msg)
+	c.Writer.Write(out)
+}
+
+// filterInboundResponse keeps the items of a JSON array answer that keep accepts.
+func filterInboundResponse(c *gin.Context, keep func(item map[string]json.RawMessage) bool) {
+	rewriteResponse(c, func(obj json.RawMessage) (any, error) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(obj, &items); err != nil {
+			return nil, err
+		}
+		kept := make([]json.RawMessage, 0, len(items))
+		for _, raw := range items {
+			var item map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &item); err != nil {
+				return nil, err
+			}
+			if keep(item) {
+				kept = append(kept, raw)
+			}
+		}
+		return kept, nil
+	})
+}
+
+// filterClientResponse keeps the clients in emails of an answer that lists emails (onlines)
+// or maps them to values (lastOnline).
+func filterClientResponse(c *gin.Context, emails map[string]bool) {
+	rewriteResponse(c, func(obj json.RawMessage) (any, error) {
+		var list []string
+		if err := json.Unmarshal(obj, &list); err == nil {
+			kept := make([]string, 0, len(list))
+			for _, email := range list {
+				if emails[email] {
+					kept = append(kept, email)
+				}
+			}
+			return kept, nil
+		}
+		var byEmail map[string]json.RawMessage
+		if err := json.Unmarshal(obj, &byEmail); err != nil {
+			return nil, err
+		}
+		for email := range byEmail {
+			if !emails[email] {
+				delete(byEmail, email)
+			}
+		}
+		return byEmail, nil
+	})
+}
+
+func jsonInt(raw json.RawMessage) int {
+	var n int
+	_ = json.Unmarshal(raw, &n)
+	return n
+}
+
+func jsonString(raw json.RawMessage) string {
+	var s string
+	_ = json.Unmarshal(raw, &s)
+	return s
+}
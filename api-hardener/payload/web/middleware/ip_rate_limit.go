@@ -0,0 +1,34 @@
+//go:build toolsignore
+// +build toolsignore
+
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UnauthenticatedRateLimitPerMinute is how many requests a client IP can make per minute to
+// the routes that authenticate on their own: the token exchange, OAuth2 and token revocation.
+const UnauthenticatedRateLimitPerMinute = 60
+
+// unauthenticatedLimiters is shared by all those routes on every listener, so an address
+// can not multiply its budget by spreading guesses over routes or ports.
+var unauthenticatedLimiters = newAPIRateLimiterStore[string]()
+
+// NewIPRateLimitMiddleware limits requests per client IP to UnauthenticatedRateLimitPerMinute,
+// for routes that check credentials before the API auth middleware could rate limit the caller.
+func NewIPRateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, _, retryAfter := unauthenticatedLimiters.allow(c.ClientIP(), UnauthenticatedRateLimitPerMinute)
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
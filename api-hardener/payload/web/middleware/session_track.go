@@ -0,0 +1,77 @@
+//go:build toolsignore
+// +build toolsignore
+
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+)
+
+// NewSessionTrackingMiddleware binds logged-in browser sessions to a server-side record.
+// The login creates the record (login time, IP, user agent); later requests refresh its
+// activity, and a session whose record was revoked, or that never got one, is logged out
+// immediately. Requests authenticated by an API token are not affected.
+func NewSessionTrackingMiddleware(sessionService *service.PanelSessionService) gin.HandlerFunc {
+	settingService := &service.SettingService{}
+	session.SetClearHook(func(id string) {
+		if err := sessionService.RemoveSession(id); err != nil {
+			logger.Warning("remove panel session failed:", err)
+		}
+	})
+	session.SetCreateHook(func(c *gin.Context, user *model.User) (string, error) {
+		// The login route is outside /panel, where RealClientIP has not run yet.
+		resolveClientIPFromSettings(c.Request, settingService)
+		return sessionService.Create(user, c.ClientIP(), c.Request.UserAgent())
+	})
+
+	return func(c *gin.Context) {
+		if GetAPIUserFromContext(c) != nil {
+			c.Next()
+			return
+		}
+		user := session.GetLoginUser(c)
+		if user == nil {
+			c.Next()
+			return
+		}
+
+		id := session.GetSessionID(c)
+		valid := false
+		if id != "" {
+			var err error
+			if valid, err = sessionService.Touch(id, user.Id, c.ClientIP()); err != nil {
+				// Keep the panel usable when the database is busy; revocation applies on the next request.
+				logger.Warning("check panel session failed:", err)
+				c.Next()
+				return
+			}
+		}
+		if !valid {
+			logger.Infof("panel session of %q revoked or unrecorded, logging out %s", user.Username, c.ClientIP())
+			session.ClearSession(c)
+			if err := sessions.Default(c).Save(); err != nil {
+				logger.Warning("clear revoked session failed:", err)
+			}
+			if c.GetHeader("X-Requested-With") == "XMLHttpRequest" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "session revoked"})
+				return
+			}
+			basePath := c.GetString("base_path")
+			if basePath == "" {
+				basePath = "/"
+			}
+			c.Redirect(http.StatusTemporaryRedirect, basePath)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,118 @@
+//go:build toolsignore
+// +build toolsignore
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+)
+
+func TestSessionTrackingMiddleware(t *testing.T) {
+	sessionService := &service.PanelSessionService{}
+	t.Cleanup(func() {
+		sessionService.RevokeAllSessions()
+		session.SetCreateHook(nil)
+		session.SetClearHook(nil)
+	})
+	engine := gin.New()
+	engine.Use(sessions.Sessions("3x-ui", cookie.NewStore([]byte("0123456789abcdef0123456789abcdef"))))
+	engine.POST("/login", func(c *gin.Context) {
+		session.SetLoginUser(c, &model.User{Id: 1, Username: "admin"})
+		sessions.Default(c).Save()
+	})
+	engine.POST("/logout", func(c *gin.Context) {
+		session.ClearSession(c)
+		sessions.Default(c).Save()
+	})
+	panel := engine.Group("/panel")
+	panel.Use(func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			c.Set(apiUserContextKey, &model.APIUser{Name: "token"})
+		}
+	})
+	panel.Use(NewSessionTrackingMiddleware(sessionService))
+	panel.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	request := func(method, path, cookie string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = "192.0.2.1:4000"
+		if cookie != "" {
+			r.Header.Set("Cookie", cookie)
+		}
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+	login := func() string {
+		var cookie string
+		for _, c := range request(http.MethodPost, "/login", "", nil).Result().Cookies() {
+			if c.Name == "3x-ui" {
+				cookie = c.Name + "=" + c.Value
+			}
+		}
+		return cookie
+	}
+	revoke := func(t *testing.T) {
+		list, err := sessionService.ListSessions()
+		if err != nil || len(list) == 0 {
+			t.Fatalf("sessions = %+v, %v", list, err)
+		}
+		if err := sessionService.RevokeSession(list[0].Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		revoke   bool
+		logout   bool
+		headers  map[string]string
+		wantCode int
+	}{
+		{"recorded session", false, false, nil, http.StatusOK},
+		{"revoked session", true, false, nil, http.StatusTemporaryRedirect},
+		{"revoked session, ajax", true, false, map[string]string{"X-Requested-With": "XMLHttpRequest"}, http.StatusUnauthorized},
+		{"revoked session with a token", true, false, map[string]string{"Authorization": "Bearer xui_live_x"}, http.StatusOK},
+		{"cookie replayed after logout", false, true, nil, http.StatusTemporaryRedirect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookie := login()
+			list, _ := sessionService.ListSessions()
+			if len(list) == 0 || list[0].IP != "192.0.2.1" {
+				t.Fatalf("login recorded %+v", list)
+			}
+			if tt.revoke {
+				revoke(t)
+			}
+			if tt.logout {
+				request(http.MethodPost, "/logout", cookie, nil)
+				if after, _ := sessionService.ListSessions(); len(after) != len(list)-1 {
+					t.Fatalf("logout left %d of %d sessions", len(after), len(list))
+				}
+			}
+			if w := request(http.MethodGet, "/panel/", cookie, tt.headers); w.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+
+	// A cookie logged in before sessions were recorded has no record and is logged out.
+	session.SetCreateHook(nil)
+	if w := request(http.MethodGet, "/panel/", login(), nil); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("unrecorded session: status %d, want %d", w.Code, http.StatusTemporaryRedirect)
+	}
+}
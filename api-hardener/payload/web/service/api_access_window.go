@@ -0,0 +1,207 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// ErrOutsideAccessWindow is returned when an API user is used outside its access window.
+var ErrOutsideAccessWindow = errors.New("outside access window")
+
+var accessWindowDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// APIAccessWindow is the set of weekly time ranges an API user may be used in, in the
+// panel's timeLocation. It is written as entries separated by ";", each a list of days
+// (mon-fri, sat,sun) optionally followed by hour ranges (09:00-18:00, 22:00-06:00):
+//
+//	mon-fri 09:00-18:00; sat 10:00-14:00
+//
+// A range that ends before it starts runs past midnight into the next day. Days without
+// hours are allowed all day.
+type APIAccessWindow struct {
+	rules []accessWindowRule
+}
+
+type accessWindowRule struct {
+	days     [7]bool // indexed by time.Weekday
+	from, to int     // minutes since midnight; to <= from wraps past midnight
+}
+
+// ParseAPIAccessWindow parses an access window; empty yields nil, which allows any time.
+func ParseAPIAccessWindow(raw string) (*APIAccessWindow, error) {
+	window := &APIAccessWindow{}
+	for _, entry := range strings.Split(strings.ToLower(raw), ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		days, err := parseAccessWindowDays(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		ranges := strings.Join(fields[1:], ",")
+		if ranges == "" {
+			window.rules = append(window.rules, accessWindowRule{days: days, from: 0, to: 24 * 60})
+			continue
+		}
+		for _, hours := range strings.FieldsFunc(ranges, func(r rune) bool { return r == ',' }) {
+			from, to, ok := strings.Cut(hours, "-")
+			if !ok {
+				return nil, fmt.Errorf("invalid hours %q, want HH:MM-HH:MM", hours)
+			}
+			rule := accessWindowRule{days: days}
+			if rule.from, err = parseAccessWindowTime(from); err != nil {
+				return nil, err
+			}
+			if rule.to, err = parseAccessWindowTime(to); err != nil {
+				return nil, err
+			}
+			if rule.from == rule.to || rule.from == 24*60 {
+				return nil, fmt.Errorf("invalid hours %q", hours)
+			}
+			window.rules = append(window.rules, rule)
+		}
+	}
+	if len(window.rules) == 0 {
+		return nil, nil
+	}
+	return window, nil
+}
+
+func parseAccessWindowDays(raw string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(raw, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+		from, to := dayIndex(first), dayIndex(last)
+		if from < 0 || to < 0 {
+			return days, fmt.Errorf("invalid days %q, want e.g. mon-fri or sat,sun", raw)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func dayIndex(day string) int {
+	for i, name := range accessWindowDays {
+		if day == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseAccessWindowTime parses HH:MM (or HH) into minutes since midnight; 24:00 is allowed
+// as the end of a day.
+func parseAccessWindowTime(raw string) (int, error) {
+	hh, mm, _ := strings.Cut(strings.TrimSpace(raw), ":")
+	h, err := strconv.Atoi(hh)
+	m := 0
+	if err == nil && mm != "" {
+		m, err = strconv.Atoi(mm)
+	}
+	if err != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", raw)
+	}
+	return h*60 + m, nil
+}
+
+// Allows reports whether t, in the location of the window, falls into the window.
+func (w *APIAccessWindow) Allows(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	return w.allowsAt(t.Weekday(), t.Hour()*60+t.Minute())
+}
+
+// Within reports whether every minute of the week w allows is allowed by outer as well.
+func (w *APIAccessWindow) Within(outer *APIAccessWindow) bool {
+	if outer == nil {
+		return true
+	}
+	if w == nil {
+		return false
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		for minute := 0; minute < 24*60; minute++ {
+			if w.allowsAt(day, minute) && !outer.allowsAt(day, minute) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (w *APIAccessWindow) allowsAt(day time.Weekday, minute int) bool {
+	for _, rule := range w.rules {
+		if rule.from < rule.to {
+			if rule.days[day] && minute >= rule.from && minute < rule.to {
+				return true
+			}
+			continue
+		}
+		if (rule.days[day] && minute >= rule.from) || (rule.days[(day+6)%7] && minute < rule.to) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeAPIAccessWindow validates an access window and returns it lower-cased with
+// single spaces, as it is stored.
+func NormalizeAPIAccessWindow(raw string) (string, error) {
+	if _, err := ParseAPIAccessWindow(raw); err != nil {
+		return "", err
+	}
+	entries := make([]string, 0)
+	for _, entry := range strings.Split(strings.ToLower(raw), ";") {
+		if fields := strings.Fields(entry); len(fields) > 0 {
+			entries = append(entries, strings.Join(fields, " "))
+		}
+	}
+	return strings.Join(entries, "; "), nil
+}
+
+// UpdateAccessWindow limits the API user to an access window; empty allows any time.
+func (s *APIUserService) UpdateAccessWindow(id int, window string) error {
+	window, err := NormalizeAPIAccessWindow(window)
+	if err != nil {
+		return err
+	}
+	return s.updateUser(id, voidAccessTokens(map[string]any{"access_window": window}))
+}
+
+// CheckAccessWindow returns ErrOutsideAccessWindow when now, in the panel's timeLocation,
+// is outside the access window of apiUser. A window that no longer parses denies access.
+func (s *APIUserService) CheckAccessWindow(apiUser *model.APIUser, now time.Time) error {
+	if apiUser.AccessWindow == "" {
+		return nil
+	}
+	window, err := ParseAPIAccessWindow(apiUser.AccessWindow)
+	if err != nil {
+		return ErrOutsideAccessWindow
+	}
+	location, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return err
+	}
+	if !window.Allows(now.In(location)) {
+		return ErrOutsideAccessWindow
+	}
+	return nil
+}
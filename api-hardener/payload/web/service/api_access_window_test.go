@@ -0,0 +1,138 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns a time on the weekday day of a fixed week, at hh:mm.
+func at(day time.Weekday, hh, mm int) time.Time {
+	// 2026-10-18 is a Sunday.
+	return time.Date(2026, 10, 18+int(day), hh, mm, 0, 0, time.UTC)
+}
+
+func TestParseAPIAccessWindow(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantNil bool
+		wantErr bool
+	}{
+		{raw: "", wantNil: true},
+		{raw: " ; ;", wantNil: true},
+		{raw: "mon-fri 09:00-18:00"},
+		{raw: "MON-FRI 09:00-18:00; Sat 10-14"},
+		{raw: "sat,sun"},
+		{raw: "fri-mon 22:00-06:00"},
+		{raw: "mon 09:00-12:00,13:00-18:00"},
+		{raw: "mon 09:00-12:00 13:00-24:00"},
+		{raw: "monday 09:00-18:00", wantErr: true},
+		{raw: "mon-xyz", wantErr: true},
+		{raw: "mon 09:00", wantErr: true},
+		{raw: "mon 09:00-09:00", wantErr: true},
+		{raw: "mon 24:00-06:00", wantErr: true},
+		{raw: "mon 09:60-18:00", wantErr: true},
+		{raw: "mon 25:00-26:00", wantErr: true},
+		{raw: "mon 24:30-01:00", wantErr: true},
+		{raw: "mon 9am-5pm", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			window, err := ParseAPIAccessWindow(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (window == nil) != tt.wantNil {
+				t.Fatalf("window = %v, wantNil %v", window, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestAPIAccessWindowAllows(t *testing.T) {
+	tests := []struct {
+		window string
+		at     time.Time
+		want   bool
+	}{
+		{"", at(time.Sunday, 3, 0), true},
+		{"mon-fri 09:00-18:00", at(time.Monday, 9, 0), true},
+		{"mon-fri 09:00-18:00", at(time.Friday, 17, 59), true},
+		{"mon-fri 09:00-18:00", at(time.Friday, 18, 0), false},
+		{"mon-fri 09:00-18:00", at(time.Monday, 8, 59), false},
+		{"mon-fri 09:00-18:00", at(time.Saturday, 12, 0), false},
+		{"sat,sun", at(time.Sunday, 0, 0), true},
+		{"sat,sun", at(time.Saturday, 23, 59), true},
+		{"sat,sun", at(time.Monday, 12, 0), false},
+		// A range that ends before it starts runs into the next day.
+		{"fri 22:00-06:00", at(time.Friday, 23, 0), true},
+		{"fri 22:00-06:00", at(time.Saturday, 5, 59), true},
+		{"fri 22:00-06:00", at(time.Saturday, 6, 0), false},
+		{"fri 22:00-06:00", at(time.Friday, 5, 0), false},
+		// Day ranges wrap around the week.
+		{"fri-mon", at(time.Sunday, 12, 0), true},
+		{"fri-mon", at(time.Wednesday, 12, 0), false},
+		{"mon 09:00-12:00,13:00-18:00", at(time.Monday, 12, 30), false},
+		{"mon 09:00-12:00,13:00-18:00", at(time.Monday, 13, 0), true},
+		{"mon 22:00-24:00", at(time.Monday, 23, 59), true},
+	}
+	for _, tt := range tests {
+		window, err := ParseAPIAccessWindow(tt.window)
+		if err != nil {
+			t.Fatalf("ParseAPIAccessWindow(%q): %v", tt.window, err)
+		}
+		if got := window.Allows(tt.at); got != tt.want {
+			t.Errorf("%q allows %s %s = %v, want %v", tt.window, tt.at.Weekday(), tt.at.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestAPIAccessWindowWithin(t *testing.T) {
+	tests := []struct {
+		inner, outer string
+		want         bool
+	}{
+		{"mon-fri 10:00-12:00", "mon-fri 09:00-18:00", true},
+		{"mon-fri 09:00-18:00", "mon-fri 09:00-18:00", true},
+		{"mon-sat 10:00-12:00", "mon-fri 09:00-18:00", false},
+		{"mon 08:00-10:00", "mon 09:00-18:00", false},
+		{"sat 23:00-01:00", "sat,sun", true},
+		{"sun 23:00-01:00", "sat,sun", false},
+		{"mon 10:00-11:00", "", true},
+		{"", "mon-fri", false},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		inner, err := ParseAPIAccessWindow(tt.inner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outer, err := ParseAPIAccessWindow(tt.outer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := inner.Within(outer); got != tt.want {
+			t.Errorf("%q within %q = %v, want %v", tt.inner, tt.outer, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeAPIAccessWindow(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"", ""},
+		{"  MON-FRI   09:00-18:00 ;; Sat 10-14 ", "mon-fri 09:00-18:00; sat 10-14"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeAPIAccessWindow(tt.raw)
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeAPIAccessWindow(%q) = %q, %v; want %q", tt.raw, got, err, tt.want)
+		}
+	}
+	if _, err := NormalizeAPIAccessWindow("mon 9am"); err == nil {
+		t.Error("NormalizeAPIAccessWindow accepted an invalid window")
+	}
+}
@@ -0,0 +1,60 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// Audit events recorded for API users and API-wide changes.
+const (
+	APIAuditTokenLeaked        = "token.leaked"
+	APIAuditTokenSelfRevoked   = "token.self_revoked"
+	APIAuditAccessTokenRevoked = "token.access_revoked"
+	APIAuditLockdown           = "api.lockdown"
+	APIAuditBreakGlassIssued   = "api.break_glass_issued"
+	APIAuditSigningKeyRotate   = "api.signing_key_rotated"
+)
+
+// APIAuditService stores the API audit trail.
+type APIAuditService struct{}
+
+// recordAPIAudit writes event with tx; a failure is logged and returned.
+func recordAPIAudit(tx *gorm.DB, event *model.APIAuditEvent) error {
+	if err := tx.Create(event).Error; err != nil {
+		logger.Warningf("api audit %s for %q failed: %v", event.Event, event.APIUserName, err)
+		return err
+	}
+	if event.APIUserName == "" {
+		logger.Infof("api audit: %s by %s from %s: %s", event.Event, event.Actor, event.IP, event.Detail)
+		return nil
+	}
+	logger.Infof("api audit: %s api user %q by %s from %s: %s",
+		event.Event, event.APIUserName, event.Actor, event.IP, event.Detail)
+	return nil
+}
+
+// Record appends event to the audit trail.
+func (s *APIAuditService) Record(event *model.APIAuditEvent) error {
+	return recordAPIAudit(database.GetDB(), event)
+}
+
+// ListEvents returns the newest audit events first, only those of the API user apiUserId
+// when it is set; limit <= 0 returns all.
+func (s *APIAuditService) ListEvents(apiUserId int, limit int) ([]model.APIAuditEvent, error) {
+	events := make([]model.APIAuditEvent, 0)
+	query := database.GetDB().Order("id DESC")
+	if apiUserId > 0 {
+		query = query.Where("api_user_id = ?", apiUserId)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,61 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestAPIAuditListEvents(t *testing.T) {
+	audit := &APIAuditService{}
+	if err := database.GetDB().Where("1 = 1").Delete(&model.APIAuditEvent{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []model.APIAuditEvent{
+		{Event: APIAuditTokenLeaked, APIUserId: 7, APIUserName: "seven", Actor: "admin"},
+		{Event: APIAuditLockdown, Actor: "admin", Detail: "off -> on"},
+		{Event: APIAuditTokenSelfRevoked, APIUserId: 7, APIUserName: "seven", Actor: "token holder"},
+		{Event: APIAuditTokenLeaked, APIUserId: 8, APIUserName: "eight", Actor: "admin"},
+	} {
+		if err := audit.Record(&event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		apiUserId int
+		limit     int
+		want      []string
+	}{
+		{"all, newest first", 0, 0, []string{"eight", "seven", "", "seven"}},
+		{"limit", 0, 2, []string{"eight", "seven"}},
+		{"one user", 7, 0, []string{"seven", "seven"}},
+		{"one user, limit", 7, 1, []string{"seven"}},
+		{"user without events", 9, 0, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := audit.ListEvents(tt.apiUserId, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(events))
+			for _, e := range events {
+				got = append(got, e.APIUserName)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("events = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("events = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,501 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// API users can authenticate on the API listener with an X.509 client certificate
+// instead of a token. Certificates are verified against the CA bundle in
+// apiClientCAFile or, when it is empty, against the local CA that api-guard cert
+// issue keeps next to the database. Revoked certificates are refused through a CRL:
+// apiClientCRLFile, or the local CRL for the local CA. A verified certificate maps
+// to the API user bound to its SHA-256 fingerprint or, failing that, to its subject.
+const (
+	LocalClientCACertFile = "api-client-ca.crt"
+	LocalClientCAKeyFile  = "api-client-ca.key"
+	LocalClientCRLFile    = "api-client-ca.crl"
+
+	localClientCAValidity = 10 * 365 * 24 * time.Hour
+	clientCRLValidity     = 365 * 24 * time.Hour
+)
+
+// ErrInvalidClientCert is returned for client certificates that are revoked or not bound to an enabled API user.
+var ErrInvalidClientCert = errors.New("invalid client certificate")
+
+// errClientCRLExpired is returned for a CRL past its NextUpdate, which can no longer
+// vouch that a certificate has not been revoked since.
+var errClientCRLExpired = errors.New("client certificate CRL has expired")
+
+// clientCABundle is a parsed CA bundle for client certificates.
+type clientCABundle struct {
+	path    string
+	modTime time.Time
+	certs   []*x509.Certificate
+	pool    *x509.CertPool
+}
+
+// clientCACache keeps the last CA bundle read; it is reloaded when the file changes, so a
+// new or changed CA applies without a restart.
+var clientCACache struct {
+	mu     sync.Mutex
+	bundle *clientCABundle
+}
+
+// clientCRLCache keeps the serials of the last CRL read; it is reloaded when the file or
+// the CA bundle it was checked against changes.
+var clientCRLCache struct {
+	mu         sync.Mutex
+	path       string
+	modTime    time.Time
+	ca         *clientCABundle
+	nextUpdate time.Time
+	serials    map[string]bool
+}
+
+// LocalClientCAPaths returns the certificate, key and CRL files of the local client CA.
+func LocalClientCAPaths() (certFile string, keyFile string, crlFile string) {
+	dir := config.GetDBFolderPath()
+	return filepath.Join(dir, LocalClientCACertFile), filepath.Join(dir, LocalClientCAKeyFile), filepath.Join(dir, LocalClientCRLFile)
+}
+
+// ClientCertFingerprint returns the hex SHA-256 of the DER encoded certificate.
+func ClientCertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeCertFingerprint accepts fingerprints as printed by openssl (AB:CD:...) or plain hex.
+func NormalizeCertFingerprint(fingerprint string) (string, error) {
+	fingerprint = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+	if raw, err := hex.DecodeString(fingerprint); err != nil || len(raw) != sha256.Size {
+		return "", errors.New("fingerprint must be a hex SHA-256 digest")
+	}
+	return fingerprint, nil
+}
+
+// clientCAFiles returns the CA bundle and CRL that client certificates are checked against.
+func (s *APIUserService) clientCAFiles() (caFile string, crlFile string, err error) {
+	caFile, err = s.settingService.GetAPIClientCAFile()
+	if err != nil {
+		return "", "", err
+	}
+	crlFile, err = s.settingService.GetAPIClientCRLFile()
+	if err != nil {
+		return "", "", err
+	}
+	if caFile == "" {
+		localCert, _, localCRL := LocalClientCAPaths()
+		caFile = localCert
+		if crlFile == "" {
+			crlFile = localCRL
+		}
+	}
+	return caFile, crlFile, nil
+}
+
+// ClientCAPool returns the CAs trusted for client certificates. It returns nil when no
+// CA bundle is configured and the local CA has not been created yet.
+func (s *APIUserService) ClientCAPool() (*x509.CertPool, error) {
+	caFile, _, err := s.clientCAFiles()
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := loadClientCABundle(caFile)
+	if err != nil || bundle == nil {
+		return nil, err
+	}
+	return bundle.pool, nil
+}
+
+// loadClientCABundle returns the CA bundle in caFile, nil when the file does not exist.
+func loadClientCABundle(caFile string) (*clientCABundle, error) {
+	info, err := os.Stat(caFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read client CA bundle: %w", err)
+	}
+
+	clientCACache.mu.Lock()
+	defer clientCACache.mu.Unlock()
+	if cached := clientCACache.bundle; cached != nil && cached.path == caFile && cached.modTime.Equal(info.ModTime()) {
+		return cached, nil
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA bundle: %w", err)
+	}
+	bundle := &clientCABundle{path: caFile, modTime: info.ModTime(), pool: x509.NewCertPool()}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse client CA bundle %s: %w", caFile, err)
+		}
+		bundle.certs = append(bundle.certs, cert)
+		bundle.pool.AddCert(cert)
+	}
+	if len(bundle.certs) == 0 {
+		return nil, fmt.Errorf("%s contains no PEM certificates", caFile)
+	}
+	clientCACache.bundle = bundle
+	return bundle, nil
+}
+
+// isClientCertRevoked reports whether cert is listed in crlFile; a missing file revokes
+// nothing. The CRL must be signed by a CA of ca and not have expired.
+func isClientCertRevoked(ca *clientCABundle, crlFile string, cert *x509.Certificate) (bool, error) {
+	if crlFile == "" {
+		return false, nil
+	}
+	info, err := os.Stat(crlFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	clientCRLCache.mu.Lock()
+	defer clientCRLCache.mu.Unlock()
+	if clientCRLCache.path != crlFile || !clientCRLCache.modTime.Equal(info.ModTime()) || clientCRLCache.ca != ca {
+		list, err := readRevocationList(crlFile)
+		if err != nil {
+			return false, err
+		}
+		if err := checkRevocationListIssuer(list, ca); err != nil {
+			return false, fmt.Errorf("CRL %s: %w", crlFile, err)
+		}
+		serials := make(map[string]bool, len(list.RevokedCertificateEntries))
+		for _, entry := range list.RevokedCertificateEntries {
+			serials[entry.SerialNumber.String()] = true
+		}
+		clientCRLCache.path, clientCRLCache.modTime, clientCRLCache.ca = crlFile, info.ModTime(), ca
+		clientCRLCache.nextUpdate, clientCRLCache.serials = list.NextUpdate, serials
+	}
+	if !clientCRLCache.nextUpdate.IsZero() && time.Now().After(clientCRLCache.nextUpdate) {
+		return false, fmt.Errorf("%w: %s at %s", errClientCRLExpired, crlFile, clientCRLCache.nextUpdate.Format(time.RFC3339))
+	}
+	return clientCRLCache.serials[cert.SerialNumber.String()], nil
+}
+
+// checkRevocationListIssuer checks that list is signed by one of the CAs of ca.
+func checkRevocationListIssuer(list *x509.RevocationList, ca *clientCABundle) error {
+	if ca != nil {
+		for _, cert := range ca.certs {
+			if bytes.Equal(cert.RawSubject, list.RawIssuer) && list.CheckSignatureFrom(cert) == nil {
+				return nil
+			}
+		}
+	}
+	return errors.New("not signed by a trusted client CA")
+}
+
+// readRevocationList parses a PEM or DER CRL.
+func readRevocationList(path string) (*x509.RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse CRL %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// VerifyClientCert returns the enabled API user bound to cert, which the TLS handshake
+// has already verified against ClientCAPool.
+func (s *APIUserService) VerifyClientCert(cert *x509.Certificate) (*model.APIUser, error) {
+	caFile, crlFile, err := s.clientCAFiles()
+	if err != nil {
+		return nil, err
+	}
+	ca, err := loadClientCABundle(caFile)
+	if err != nil {
+		logger.Warning("client certificate CA check failed:", err)
+		return nil, ErrInvalidClientCert
+	}
+	revoked, err := isClientCertRevoked(ca, crlFile, cert)
+	if _, _, localCRL := LocalClientCAPaths(); errors.Is(err, errClientCRLExpired) && crlFile == localCRL {
+		// The panel holds the key of the local CA, so it renews the local CRL instead of
+		// refusing every certificate until someone revokes one.
+		if err = updateLocalClientCRL(nil); err == nil {
+			revoked, err = isClientCertRevoked(ca, crlFile, cert)
+		}
+	}
+	if err != nil {
+		// Fail closed: a CRL that can not be read must not let revoked certificates in.
+		logger.Warning("client certificate CRL check failed:", err)
+		return nil, ErrInvalidClientCert
+	}
+	if revoked {
+		return nil, ErrInvalidClientCert
+	}
+
+	db := database.GetDB()
+	apiUser := &model.APIUser{}
+	err = db.Where("cert_fingerprint = ? AND enabled = ?", ClientCertFingerprint(cert), true).First(apiUser).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = db.Where("cert_subject IN ? AND enabled = ?", []string{cert.Subject.String(), cert.Subject.CommonName}, true).
+			Where("cert_subject <> ''").
+			First(apiUser).Error
+	}
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Warning("client certificate lookup failed:", err)
+		}
+		return nil, ErrInvalidClientCert
+	}
+
+	_ = db.Model(&model.APIUser{}).
+		Where("id = ?", apiUser.Id).
+		Updates(map[string]any{
+			"last_used_at":  time.Now(),
+			"request_count": gorm.Expr("request_count + 1"),
+		}).
+		Error
+	return apiUser, nil
+}
+
+// BindClientCert maps certificates with fingerprint or subject to the API user id;
+// empty values remove the binding.
+func (s *APIUserService) BindClientCert(id int, fingerprint string, subject string) error {
+	if fingerprint != "" {
+		normalized, err := NormalizeCertFingerprint(fingerprint)
+		if err != nil {
+			return err
+		}
+		fingerprint = normalized
+	}
+	return s.updateUser(id, voidAccessTokens(map[string]any{
+		"cert_fingerprint": fingerprint,
+		"cert_subject":     strings.TrimSpace(subject),
+	}))
+}
+
+// loadLocalClientCA reads the local client CA, creating it on first use when create is set.
+func loadLocalClientCA(create bool) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certFile, keyFile, _ := LocalClientCAPaths()
+	certPEM, err := os.ReadFile(certFile)
+	if errors.Is(err, os.ErrNotExist) && create {
+		return createLocalClientCA(certFile, keyFile)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read local client CA: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read local client CA key: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, errors.New("local client CA files are not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("local client CA key is not an ECDSA key")
+	}
+	return cert, key, nil
+}
+
+func createLocalClientCA(certFile string, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "3x-ui API client CA"},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(localClientCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePEMFile(keyFile, "PRIVATE KEY", keyDER, 0o600); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEMFile(certFile, "CERTIFICATE", der, 0o644); err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func writePEMFile(path string, blockType string, der []byte, mode os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, mode); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file.
+	return os.Chmod(path, mode)
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+}
+
+// IssueClientCert mints a client certificate for the API user id from the local CA,
+// creating the CA on first use, and binds its fingerprint to the user. It returns
+// the PEM encoded certificate and private key.
+func (s *APIUserService) IssueClientCert(id int, validity time.Duration) (*x509.Certificate, []byte, []byte, error) {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ca, caKey, err := loadLocalClientCA(true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: user.Name, OrganizationalUnit: []string{"3x-ui API"}},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := s.updateUser(id, voidAccessTokens(map[string]any{"cert_fingerprint": ClientCertFingerprint(cert)})); err != nil {
+		return nil, nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return cert, certPEM, keyPEM, nil
+}
+
+// RevokeClientCert adds serial to the CRL of the local CA, which takes effect on the
+// next request, and unbinds the certificate with fingerprint when it is given.
+func (s *APIUserService) RevokeClientCert(serial *big.Int, fingerprint string) error {
+	if err := updateLocalClientCRL(serial); err != nil {
+		return err
+	}
+	return s.unbindClientCert(fingerprint)
+}
+
+// updateLocalClientCRL signs the CRL of the local CA anew, valid for clientCRLValidity,
+// adding serial unless it is nil or listed already.
+func updateLocalClientCRL(serial *big.Int) error {
+	ca, caKey, err := loadLocalClientCA(false)
+	if err != nil {
+		return err
+	}
+	_, _, crlFile := LocalClientCAPaths()
+
+	entries := make([]x509.RevocationListEntry, 0, 1)
+	number := big.NewInt(1)
+	if list, err := readRevocationList(crlFile); err == nil {
+		entries = append(entries, list.RevokedCertificateEntries...)
+		number.Add(list.Number, big.NewInt(1))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	now := time.Now()
+	if serial != nil && !slices.ContainsFunc(entries, func(entry x509.RevocationListEntry) bool { return entry.SerialNumber.Cmp(serial) == 0 }) {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: now})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(clientCRLValidity),
+	}, ca, caKey)
+	if err != nil {
+		return err
+	}
+	return writePEMFile(crlFile, "X509 CRL", der, 0o644)
+}
+
+func (s *APIUserService) unbindClientCert(fingerprint string) error {
+	if fingerprint == "" {
+		return nil
+	}
+	return database.GetDB().Model(&model.APIUser{}).
+		Where("cert_fingerprint = ?", fingerprint).
+		Update("cert_fingerprint", "").
+		Error
+}
@@ -0,0 +1,165 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testClientCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestClientCA(t *testing.T, name string) *testClientCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClientCA{cert: cert, key: key}
+}
+
+// writeCRL writes a CRL of ca revoking serials that is valid until nextUpdate.
+func (ca *testClientCA) writeCRL(t *testing.T, path string, nextUpdate time.Time, serials ...int64) {
+	t.Helper()
+	entries := make([]x509.RevocationListEntry, 0, len(serials))
+	for _, serial := range serials {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(time.Now().UnixNano()),
+		ThisUpdate:                time.Now().Add(-2 * time.Hour),
+		NextUpdate:                nextUpdate,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writePEMFile(path, "X509 CRL", der, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsClientCertRevoked(t *testing.T) {
+	dir := t.TempDir()
+	trusted := newTestClientCA(t, "trusted CA")
+	other := newTestClientCA(t, "other CA")
+	impostor := newTestClientCA(t, "trusted CA") // same subject, other key
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := writePEMFile(caFile, "CERTIFICATE", trusted.cert.Raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := loadClientCABundle(caFile)
+	if err != nil || ca == nil {
+		t.Fatalf("loadClientCABundle = %v, %v", ca, err)
+	}
+	revokedCert := &x509.Certificate{SerialNumber: big.NewInt(7)}
+	validCert := &x509.Certificate{SerialNumber: big.NewInt(8)}
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		prepare     func(path string)
+		cert        *x509.Certificate
+		want        bool
+		wantErr     bool
+		wantExpired bool
+	}{
+		{"no CRL", func(string) {}, revokedCert, false, false, false},
+		{"revoked", func(path string) { trusted.writeCRL(t, path, later, 7) }, revokedCert, true, false, false},
+		{"not revoked", func(path string) { trusted.writeCRL(t, path, later, 7) }, validCert, false, false, false},
+		{"other CA", func(path string) { other.writeCRL(t, path, later) }, validCert, false, true, false},
+		{"same subject, other key", func(path string) { impostor.writeCRL(t, path, later) }, validCert, false, true, false},
+		{"expired", func(path string) { trusted.writeCRL(t, path, time.Now().Add(-time.Hour), 7) }, validCert, false, true, true},
+		{"garbage", func(path string) {
+			if err := os.WriteFile(path, []byte("not a CRL"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, validCert, false, true, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "crl-"+string(rune('a'+i))+".pem")
+			tt.prepare(path)
+			revoked, err := isClientCertRevoked(ca, path, tt.cert)
+			if (err != nil) != tt.wantErr || errors.Is(err, errClientCRLExpired) != tt.wantExpired {
+				t.Fatalf("err = %v, wantErr %v, wantExpired %v", err, tt.wantErr, tt.wantExpired)
+			}
+			if revoked != tt.want {
+				t.Fatalf("revoked = %v, want %v", revoked, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadClientCABundleReloads(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	if bundle, err := loadClientCABundle(caFile); bundle != nil || err != nil {
+		t.Fatalf("missing bundle = %v, %v; want nil, nil", bundle, err)
+	}
+
+	first := newTestClientCA(t, "first CA")
+	if err := writePEMFile(caFile, "CERTIFICATE", first.cert.Raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := loadClientCABundle(caFile)
+	if err != nil || len(bundle.certs) != 1 {
+		t.Fatalf("loadClientCABundle = %v, %v", bundle, err)
+	}
+	if again, _ := loadClientCABundle(caFile); again != bundle {
+		t.Fatal("unchanged bundle was read again")
+	}
+
+	second := newTestClientCA(t, "second CA")
+	if err := writePEMFile(caFile, "CERTIFICATE", second.cert.Raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(caFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := loadClientCABundle(caFile)
+	if err != nil || reloaded == bundle || !reloaded.certs[0].Equal(second.cert) {
+		t.Fatalf("changed bundle was not reloaded: %v, %v", reloaded, err)
+	}
+
+	if err := os.WriteFile(caFile, []byte("no certificates"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(caFile, later.Add(time.Second), later.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadClientCABundle(caFile); err == nil {
+		t.Fatal("a bundle without certificates was accepted")
+	}
+}
@@ -0,0 +1,115 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// APICORSPolicy is the CORS policy of /panel/api for browser dashboards on other origins.
+type APICORSPolicy struct {
+	Origins     []string // normalized origins, or "*" for any
+	Methods     []string
+	Headers     []string
+	Credentials bool // let pages send the panel session cookie
+	MaxAge      int  // seconds browsers may cache a preflight
+}
+
+// AllowsOrigin reports whether the policy lets pages from origin call the API.
+func (p *APICORSPolicy) AllowsOrigin(origin string) bool {
+	return slices.Contains(p.Origins, "*") || slices.ContainsFunc(p.Origins, func(o string) bool {
+		return strings.EqualFold(o, origin)
+	})
+}
+
+// AllowsMethod reports whether the policy lets pages send method.
+func (p *APICORSPolicy) AllowsMethod(method string) bool {
+	return slices.ContainsFunc(p.Methods, func(m string) bool { return strings.EqualFold(m, method) })
+}
+
+// GetAPICORSPolicy returns the CORS policy; it allows no origins when apiCorsOrigins is empty.
+func (s *SettingService) GetAPICORSPolicy() (*APICORSPolicy, error) {
+	origins, err := s.getString("apiCorsOrigins")
+	if err != nil {
+		return nil, err
+	}
+	methods, err := s.getString("apiCorsMethods")
+	if err != nil {
+		return nil, err
+	}
+	headers, err := s.getString("apiCorsHeaders")
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := s.getBool("apiCorsCredentials")
+	if err != nil {
+		return nil, err
+	}
+	maxAge, err := s.getInt("apiCorsMaxAge")
+	if err != nil {
+		return nil, err
+	}
+	policy := &APICORSPolicy{
+		Methods:     splitList(strings.ToUpper(methods)),
+		Headers:     splitList(headers),
+		Credentials: credentials,
+		MaxAge:      maxAge,
+	}
+	for _, origin := range splitList(origins) {
+		if origin != "*" {
+			if origin, err = NormalizeOrigin(origin); err != nil {
+				continue
+			}
+		}
+		policy.Origins = append(policy.Origins, origin)
+	}
+	if policy.Credentials && slices.Contains(policy.Origins, "*") {
+		// Browsers refuse this combination; echoing every origin instead would let any site use the session.
+		policy.Credentials = false
+	}
+	return policy, nil
+}
+
+// NormalizeOrigin checks that raw is a browser origin (scheme://host[:port], no path) and
+// returns it in lower case without a trailing slash.
+func NormalizeOrigin(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(strings.TrimSpace(raw), "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("invalid origin %q, want scheme://host[:port]", raw)
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), nil
+}
+
+// ParseOrigins normalizes a comma separated origin list and joins it again.
+func ParseOrigins(raw string) (string, error) {
+	origins := make([]string, 0)
+	for _, origin := range splitList(raw) {
+		origin, err := NormalizeOrigin(origin)
+		if err != nil {
+			return "", err
+		}
+		if !slices.Contains(origins, origin) {
+			origins = append(origins, origin)
+		}
+	}
+	return strings.Join(origins, ","), nil
+}
+
+// UpdateOrigins restricts the API user to browser pages from origins (comma separated);
+// empty lifts the restriction.
+func (s *APIUserService) UpdateOrigins(id int, origins string) error {
+	list, err := ParseOrigins(origins)
+	if err != nil {
+		return err
+	}
+	return s.updateUser(id, voidAccessTokens(map[string]any{"allowed_origins": list}))
+}
+
+func splitList(raw string) []string {
+	return strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' })
+}
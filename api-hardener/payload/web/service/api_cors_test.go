@@ -0,0 +1,184 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestNormalizeOrigin(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"https://dash.example.com", "https://dash.example.com", false},
+		{" HTTPS://Dash.Example.com/ ", "https://dash.example.com", false},
+		{"http://127.0.0.1:8080", "http://127.0.0.1:8080", false},
+		{"https://dash.example.com/app", "", true},
+		{"https://dash.example.com?x=1", "", true},
+		{"https://user@dash.example.com", "", true},
+		{"ftp://dash.example.com", "", true},
+		{"dash.example.com", "", true},
+		{"*", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeOrigin(tt.raw)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeOrigin(%q) = %q, %v; want %q, error %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseOrigins(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"https://a.example, https://B.example/", "https://a.example,https://b.example", false},
+		{"https://a.example https://a.example", "https://a.example", false},
+		{"https://a.example,,", "https://a.example", false},
+		{"https://a.example,a.example", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseOrigins(tt.raw)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseOrigins(%q) = %q, %v; want %q, error %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGetAPICORSPolicy(t *testing.T) {
+	settingService := &SettingService{}
+	keys := []string{"apiCorsOrigins", "apiCorsMethods", "apiCorsHeaders", "apiCorsCredentials", "apiCorsMaxAge"}
+	t.Cleanup(func() {
+		for _, key := range keys {
+			settingService.saveSetting(key, defaultValueMap[key])
+		}
+	})
+
+	tests := []struct {
+		name     string
+		settings map[string]string // the defaults for the others
+		want     APICORSPolicy
+	}{
+		{"defaults", nil, APICORSPolicy{
+			Methods: []string{"GET", "POST"}, Headers: []string{"Authorization", "Content-Type", "X-API-Token"}, MaxAge: 600,
+		}},
+		{"origins", map[string]string{"apiCorsOrigins": "https://Dash.example.com/, bogus, http://localhost:3000"}, APICORSPolicy{
+			Origins: []string{"https://dash.example.com", "http://localhost:3000"},
+			Methods: []string{"GET", "POST"}, Headers: []string{"Authorization", "Content-Type", "X-API-Token"}, MaxAge: 600,
+		}},
+		{"methods and headers", map[string]string{"apiCorsMethods": "get, delete", "apiCorsHeaders": "X-API-Token", "apiCorsMaxAge": "0"}, APICORSPolicy{
+			Methods: []string{"GET", "DELETE"}, Headers: []string{"X-API-Token"},
+		}},
+		{"credentials", map[string]string{"apiCorsOrigins": "https://dash.example.com", "apiCorsCredentials": "true"}, APICORSPolicy{
+			Origins: []string{"https://dash.example.com"}, Credentials: true,
+			Methods: []string{"GET", "POST"}, Headers: []string{"Authorization", "Content-Type", "X-API-Token"}, MaxAge: 600,
+		}},
+		{"credentials with any origin", map[string]string{"apiCorsOrigins": "*", "apiCorsCredentials": "true"}, APICORSPolicy{
+			Origins: []string{"*"},
+			Methods: []string{"GET", "POST"}, Headers: []string{"Authorization", "Content-Type", "X-API-Token"}, MaxAge: 600,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range keys {
+				value, ok := tt.settings[key]
+				if !ok {
+					value = defaultValueMap[key]
+				}
+				if err := settingService.saveSetting(key, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := settingService.GetAPICORSPolicy()
+			if err != nil || !reflect.DeepEqual(*got, tt.want) {
+				t.Fatalf("GetAPICORSPolicy = %+v, %v; want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPICORSPolicyAllows(t *testing.T) {
+	policy := &APICORSPolicy{Origins: []string{"https://dash.example.com"}, Methods: []string{"GET", "POST"}}
+	anyOrigin := &APICORSPolicy{Origins: []string{"*"}}
+	origins := []struct {
+		policy *APICORSPolicy
+		origin string
+		want   bool
+	}{
+		{policy, "https://dash.example.com", true},
+		{policy, "https://DASH.example.com", true},
+		{policy, "https://evil.example", false},
+		{anyOrigin, "https://evil.example", true},
+	}
+	for _, tt := range origins {
+		if got := tt.policy.AllowsOrigin(tt.origin); got != tt.want {
+			t.Errorf("%+v.AllowsOrigin(%q) = %v, want %v", tt.policy, tt.origin, got, tt.want)
+		}
+	}
+	methods := []struct {
+		method string
+		want   bool
+	}{
+		{"GET", true},
+		{"post", true},
+		{"DELETE", false},
+	}
+	for _, tt := range methods {
+		if got := policy.AllowsMethod(tt.method); got != tt.want {
+			t.Errorf("AllowsMethod(%q) = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateOrigins(t *testing.T) {
+	users := &APIUserService{}
+	user, _ := newTestAPIUser(t, "cors-origins", model.APIScopeRead)
+
+	tests := []struct {
+		origins string
+		want    string
+		wantErr bool
+	}{
+		{"https://Dash.example.com/, https://b.example", "https://dash.example.com,https://b.example", false},
+		{"https://dash.example.com/app", "https://dash.example.com,https://b.example", true},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		err := users.UpdateOrigins(user.Id, tt.origins)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("UpdateOrigins(%q) = %v, want error %v", tt.origins, err, tt.wantErr)
+		}
+		got, err := users.GetUser(user.Id)
+		if err != nil || got.AllowedOrigins != tt.want {
+			t.Fatalf("after UpdateOrigins(%q): AllowedOrigins = %q (%v), want %q", tt.origins, got.AllowedOrigins, err, tt.want)
+		}
+	}
+
+	// Access tokens carry the restriction.
+	if err := users.UpdateOrigins(user.Id, "https://dash.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.GetUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt := &APIJWTService{}
+	token, _, err := jwt.Issue(user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := jwt.Verify(token)
+	if err != nil || claims.APIUser().AllowsOrigin("https://evil.example") || !claims.APIUser().AllowsOrigin("https://dash.example.com") {
+		t.Fatalf("claims = %+v, %v; want the origins of %s", claims, err, user.Name)
+	}
+}
@@ -0,0 +1,251 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// ErrInboundNotAllowed is returned when an API user restricted to some inbounds touches another one.
+var ErrInboundNotAllowed = errors.New("inbound is not allowed for this api user")
+
+// APIInboundScope is the inbound allowlist of an API user, comma separated entries of
+//
+//	id:3                 the inbound with ID 3
+//	tag:inbound-2000*    inbounds whose tag matches the pattern (path.Match syntax)
+//	port:20000-20999     inbounds on a port in the range (or a single port)
+//
+// An inbound is permitted when any entry matches it. New inbounds get no ID before they
+// are created, so only tag and port entries can permit adding one.
+type APIInboundScope struct {
+	ids   []int
+	tags  []string
+	ports [][2]int
+}
+
+// ParseAPIInboundScope parses an inbound allowlist; empty yields nil, which permits every inbound.
+func ParseAPIInboundScope(raw string) (*APIInboundScope, error) {
+	scope := &APIInboundScope{}
+	for _, entry := range splitList(raw) {
+		kind, value, ok := strings.Cut(entry, ":")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid inbound entry %q, want id:N, tag:PATTERN or port:FROM-TO", entry)
+		}
+		switch strings.ToLower(kind) {
+		case "id":
+			id, err := strconv.Atoi(value)
+			if err != nil || id <= 0 {
+				return nil, fmt.Errorf("invalid inbound id %q", value)
+			}
+			scope.ids = append(scope.ids, id)
+		case "tag":
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("invalid inbound tag pattern %q", value)
+			}
+			scope.tags = append(scope.tags, value)
+		case "port":
+			from, to, isRange := strings.Cut(value, "-")
+			if !isRange {
+				to = from
+			}
+			lo, errLo := strconv.Atoi(from)
+			hi, errHi := strconv.Atoi(to)
+			if errLo != nil || errHi != nil || lo < 1 || hi > 65535 || lo > hi {
+				return nil, fmt.Errorf("invalid inbound port range %q", value)
+			}
+			scope.ports = append(scope.ports, [2]int{lo, hi})
+		default:
+			return nil, fmt.Errorf("invalid inbound entry %q, want id:N, tag:PATTERN or port:FROM-TO", entry)
+		}
+	}
+	if len(scope.ids)+len(scope.tags)+len(scope.ports) == 0 {
+		return nil, nil
+	}
+	return scope, nil
+}
+
+// NormalizeAPIInboundScope validates an inbound allowlist and returns it as stored.
+func NormalizeAPIInboundScope(raw string) (string, error) {
+	scope, err := ParseAPIInboundScope(raw)
+	if err != nil || scope == nil {
+		return "", err
+	}
+	return scope.String(), nil
+}
+
+func (s *APIInboundScope) String() string {
+	entries := make([]string, 0, len(s.ids)+len(s.tags)+len(s.ports))
+	for _, id := range s.ids {
+		entries = append(entries, "id:"+strconv.Itoa(id))
+	}
+	for _, tag := range s.tags {
+		entries = append(entries, "tag:"+tag)
+	}
+	for _, ports := range s.ports {
+		if ports[0] == ports[1] {
+			entries = append(entries, fmt.Sprintf("port:%d", ports[0]))
+		} else {
+			entries = append(entries, fmt.Sprintf("port:%d-%d", ports[0], ports[1]))
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// AllowsInbound reports whether the scope permits the inbound with id, tag and port.
+func (s *APIInboundScope) AllowsInbound(id int, tag string, port int) bool {
+	if s == nil {
+		return true
+	}
+	return slices.Contains(s.ids, id) || s.AllowsNew(tag, port)
+}
+
+// AllowsNew reports whether the scope permits creating an inbound with tag on port.
+func (s *APIInboundScope) AllowsNew(tag string, port int) bool {
+	if s == nil {
+		return true
+	}
+	for _, pattern := range s.tags {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	for _, ports := range s.ports {
+		if port >= ports[0] && port <= ports[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Within reports whether every entry of s is also an entry of outer: the same ID, the same
+// tag pattern, or a port range inside one of outer's. A nil scope permits every inbound.
+func (s *APIInboundScope) Within(outer *APIInboundScope) bool {
+	if outer == nil {
+		return true
+	}
+	if s == nil {
+		return false
+	}
+	for _, id := range s.ids {
+		if !slices.Contains(outer.ids, id) {
+			return false
+		}
+	}
+	for _, tag := range s.tags {
+		if !slices.Contains(outer.tags, tag) {
+			return false
+		}
+	}
+	for _, ports := range s.ports {
+		if !slices.ContainsFunc(outer.ports, func(o [2]int) bool { return ports[0] >= o[0] && ports[1] <= o[1] }) {
+			return false
+		}
+	}
+	return true
+}
+
+// InboundScope returns the inbound allowlist of apiUser, nil when it is not restricted. An
+// allowlist that no longer parses permits nothing.
+func (s *APIUserService) InboundScope(apiUser *model.APIUser) *APIInboundScope {
+	if apiUser == nil || apiUser.InboundAllowlist == "" {
+		return nil
+	}
+	scope, err := ParseAPIInboundScope(apiUser.InboundAllowlist)
+	if err != nil || scope == nil {
+		return &APIInboundScope{}
+	}
+	return scope
+}
+
+// UpdateInboundScope restricts the API user to the inbounds of an allowlist; empty lifts
+// the restriction.
+func (s *APIUserService) UpdateInboundScope(id int, allowlist string) error {
+	allowlist, err := NormalizeAPIInboundScope(allowlist)
+	if err != nil {
+		return err
+	}
+	return s.updateUser(id, voidAccessTokens(map[string]any{"inbound_allowlist": allowlist}))
+}
+
+// CheckInbound returns ErrInboundNotAllowed unless scope permits the inbound with inboundID.
+// Inbounds that do not exist are not permitted either, so IDs can not be probed.
+func (s *APIUserService) CheckInbound(scope *APIInboundScope, inboundID int) error {
+	if scope == nil {
+		return nil
+	}
+	inbound := &model.Inbound{}
+	err := database.GetDB().Select("id", "tag", "port").Where("id = ?", inboundID).First(inbound).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInboundNotAllowed
+	}
+	if err != nil {
+		return err
+	}
+	if !scope.AllowsInbound(inbound.Id, inbound.Tag, inbound.Port) {
+		return ErrInboundNotAllowed
+	}
+	return nil
+}
+
+// CheckClient returns ErrInboundNotAllowed unless scope permits the inbound of the client
+// with email.
+func (s *APIUserService) CheckClient(scope *APIInboundScope, email string) error {
+	if scope == nil {
+		return nil
+	}
+	traffic := &xray.ClientTraffic{}
+	err := database.GetDB().Model(&xray.ClientTraffic{}).Select("inbound_id").Where("email = ?", email).First(traffic).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInboundNotAllowed
+	}
+	if err != nil {
+		return err
+	}
+	return s.CheckInbound(scope, traffic.InboundId)
+}
+
+// AllowedClientEmails returns the emails of the clients on inbounds scope permits.
+func (s *APIUserService) AllowedClientEmails(scope *APIInboundScope) (map[string]bool, error) {
+	var inbounds []model.Inbound
+	if err := database.GetDB().Select("id", "tag", "port").Find(&inbounds).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0)
+	for _, inbound := range inbounds {
+		if scope.AllowsInbound(inbound.Id, inbound.Tag, inbound.Port) {
+			ids = append(ids, inbound.Id)
+		}
+	}
+	emails := make(map[string]bool)
+	if len(ids) == 0 {
+		return emails, nil
+	}
+	var list []string
+	err := database.GetDB().Model(&xray.ClientTraffic{}).Where("inbound_id IN ?", ids).Pluck("email", &list).Error
+	for _, email := range list {
+		emails[email] = true
+	}
+	return emails, err
+}
+
+// InboundTag returns the tag the panel gives an inbound listening on listen:port, as it
+// does on add, update and import.
+func InboundTag(listen string, port int) string {
+	switch listen {
+	case "", "0.0.0.0", "::", "::0":
+		return fmt.Sprintf("inbound-%v", port)
+	}
+	return fmt.Sprintf("inbound-%v:%v", listen, port)
+}
@@ -0,0 +1,138 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import "testing"
+
+func TestParseAPIInboundScope(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string // String() of the parsed scope
+		wantNil bool
+		wantErr bool
+	}{
+		{raw: "", wantNil: true},
+		{raw: " , ", wantNil: true},
+		{raw: "id:3", want: "id:3"},
+		{raw: "ID:3, tag:inbound-2000*  port:20000-20999", want: "id:3,tag:inbound-2000*,port:20000-20999"},
+		{raw: "port:443,port:8443-8443", want: "port:443,port:8443"},
+		{raw: "tag:vless-[ab]", want: "tag:vless-[ab]"},
+		{raw: "id:0", wantErr: true},
+		{raw: "id:-1", wantErr: true},
+		{raw: "id:x", wantErr: true},
+		{raw: "id:", wantErr: true},
+		{raw: "tag:[", wantErr: true},
+		{raw: "port:0", wantErr: true},
+		{raw: "port:65536", wantErr: true},
+		{raw: "port:2000-1000", wantErr: true},
+		{raw: "port:a-b", wantErr: true},
+		{raw: "3", wantErr: true},
+		{raw: "name:foo", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			scope, err := ParseAPIInboundScope(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (scope == nil) != tt.wantNil {
+				t.Fatalf("scope = %v, wantNil %v", scope, tt.wantNil)
+			}
+			if scope != nil && scope.String() != tt.want {
+				t.Fatalf("String() = %q, want %q", scope.String(), tt.want)
+			}
+			normalized, err := NormalizeAPIInboundScope(tt.raw)
+			if err != nil || normalized != tt.want {
+				t.Fatalf("NormalizeAPIInboundScope = %q, %v; want %q", normalized, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIInboundScopeAllows(t *testing.T) {
+	scope, err := ParseAPIInboundScope("id:3,tag:inbound-2000*,port:30000-30099")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		id        int
+		tag       string
+		port      int
+		wantExist bool // AllowsInbound
+		wantNew   bool // AllowsNew
+	}{
+		{"by id", 3, "inbound-443", 443, true, false},
+		{"by tag", 7, "inbound-20001", 20001, true, true},
+		{"by port", 8, "inbound-30050", 30050, true, true},
+		{"port range end", 9, "custom", 30099, true, true},
+		{"other", 4, "inbound-443", 443, false, false},
+		{"port after range", 10, "custom", 30100, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scope.AllowsInbound(tt.id, tt.tag, tt.port); got != tt.wantExist {
+				t.Errorf("AllowsInbound = %v, want %v", got, tt.wantExist)
+			}
+			if got := scope.AllowsNew(tt.tag, tt.port); got != tt.wantNew {
+				t.Errorf("AllowsNew = %v, want %v", got, tt.wantNew)
+			}
+		})
+	}
+
+	var unrestricted *APIInboundScope
+	if !unrestricted.AllowsInbound(1, "any", 1) || !unrestricted.AllowsNew("any", 1) {
+		t.Error("a nil scope must permit every inbound")
+	}
+}
+
+func TestAPIInboundScopeWithin(t *testing.T) {
+	tests := []struct {
+		inner, outer string
+		want         bool
+	}{
+		{"id:3", "id:3,id:4", true},
+		{"id:5", "id:3,id:4", false},
+		{"port:20010-20020", "port:20000-20999", true},
+		{"port:19990-20020", "port:20000-20999", false},
+		{"tag:inbound-2000*", "tag:inbound-2000*", true},
+		{"tag:inbound-20001", "tag:inbound-2000*", false},
+		{"id:3", "", true},
+		{"", "id:3", false},
+	}
+	for _, tt := range tests {
+		inner, err := ParseAPIInboundScope(tt.inner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outer, err := ParseAPIInboundScope(tt.outer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := inner.Within(outer); got != tt.want {
+			t.Errorf("%q within %q = %v, want %v", tt.inner, tt.outer, got, tt.want)
+		}
+	}
+}
+
+func TestInboundTag(t *testing.T) {
+	tests := []struct {
+		listen string
+		port   int
+		want   string
+	}{
+		{"", 443, "inbound-443"},
+		{"0.0.0.0", 443, "inbound-443"},
+		{"::", 443, "inbound-443"},
+		{"127.0.0.1", 8443, "inbound-127.0.0.1:8443"},
+	}
+	for _, tt := range tests {
+		if got := InboundTag(tt.listen, tt.port); got != tt.want {
+			t.Errorf("InboundTag(%q, %d) = %q, want %q", tt.listen, tt.port, got, tt.want)
+		}
+	}
+}
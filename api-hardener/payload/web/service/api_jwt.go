@@ -0,0 +1,516 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// API users can exchange their token or client certificate at /panel/api/auth/token for a
+// short-lived access token: a JWT signed with Ed25519 (alg EdDSA) that carries the user ID,
+// scopes and rate limit. The middleware verifies access tokens against cached public keys
+// and a cached state of their user: disabling or deleting the user, or changing its
+// credentials, rights or restrictions (TokensNotBefore), voids the tokens issued before.
+// Changes made by another process (api-guard) apply within apiJWTKeyCacheTTL. Rotating the
+// signing key starts a new kid; retired keys keep verifying the tokens they signed until
+// those have expired.
+const (
+	APIJWTIssuer   = "3x-ui"
+	APIJWTAudience = "3x-ui-api"
+	// MaxAPIJWTTTL caps apiJWTTTL, in minutes.
+	MaxAPIJWTTTL = 24 * 60
+
+	apiJWTAlgorithm   = "EdDSA"
+	apiJWTKeyCacheTTL = time.Minute
+	apiJWTClockSkew   = time.Minute
+)
+
+var (
+	// ErrInvalidAPIJWT is returned for access tokens that are malformed, expired or not signed by a known key.
+	ErrInvalidAPIJWT = errors.New("invalid api access token")
+	// ErrAPIJWTDisabled is returned by Issue when apiJWTTTL is 0.
+	ErrAPIJWTDisabled = errors.New("api access tokens are disabled")
+)
+
+// APIJWTClaims are the claims of an API access token.
+type APIJWTClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"` // API user ID
+	Audience  string `json:"aud"`
+	Name      string `json:"name"`
+	Scope     string `json:"scope"`         // space separated, as in OAuth2
+	RateLimit int    `json:"rl"`            // effective per-minute limit at issue time, 0 = unlimited
+	Origins   string `json:"org,omitempty"` // comma separated browser origins the user is restricted to
+	Window    string `json:"win,omitempty"` // access window of the user
+	Inbounds  string `json:"inb,omitempty"` // inbound allowlist of the user
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+}
+
+// APIUser returns the API user the claims describe. It is built from the claims alone,
+// RateLimitPerMinute included, and is not loaded from the database.
+func (c *APIJWTClaims) APIUser() *model.APIUser {
+	id, _ := strconv.Atoi(c.Subject)
+	return &model.APIUser{
+		Id:                 id,
+		Name:               c.Name,
+		Scopes:             strings.Join(strings.Fields(c.Scope), ","),
+		RateLimitPerMinute: c.RateLimit,
+		AllowedOrigins:     c.Origins,
+		AccessWindow:       c.Window,
+		InboundAllowlist:   c.Inbounds,
+		Enabled:            true,
+	}
+}
+
+// APIJWTService issues and verifies API access tokens and manages their signing keys.
+type APIJWTService struct {
+	settingService SettingService
+	apiUserService APIUserService
+}
+
+type apiJWTHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// apiJWTKeys caches the signing keys and revoked token IDs so that verification does not
+// hit the database.
+var apiJWTKeys struct {
+	mu       sync.Mutex
+	loadedAt time.Time
+	kid      string // current signing key
+	private  ed25519.PrivateKey
+	public   map[string]ed25519.PublicKey
+	revoked  map[string]bool // jti of revoked access tokens that have not expired yet
+}
+
+// apiJWTUsers caches, per API user, whether access tokens issued to it can still be valid.
+var apiJWTUsers struct {
+	mu      sync.Mutex
+	entries map[int]apiJWTUserState
+}
+
+type apiJWTUserState struct {
+	active    bool  // the user exists and is enabled
+	notBefore int64 // TokensNotBefore of the user
+	loadedAt  time.Time
+}
+
+// checkAPIJWTUser rejects access tokens of users that are disabled or deleted, and tokens
+// issued at or before the user's TokensNotBefore.
+func checkAPIJWTUser(id int, issuedAt int64) error {
+	apiJWTUsers.mu.Lock()
+	defer apiJWTUsers.mu.Unlock()
+	state, ok := apiJWTUsers.entries[id]
+	if !ok || time.Since(state.loadedAt) >= apiJWTKeyCacheTTL {
+		user := &model.APIUser{}
+		err := database.GetDB().Select("id", "enabled", "tokens_not_before").Where("id = ?", id).First(user).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		state = apiJWTUserState{active: err == nil && user.Enabled, notBefore: user.TokensNotBefore, loadedAt: time.Now()}
+		if apiJWTUsers.entries == nil {
+			apiJWTUsers.entries = make(map[int]apiJWTUserState)
+		}
+		apiJWTUsers.entries[id] = state
+	}
+	if !state.active || issuedAt <= state.notBefore {
+		return ErrInvalidAPIJWT
+	}
+	return nil
+}
+
+// forgetAPIJWTUser drops the cached state of the API user with id after it changed, so the
+// change applies to its access tokens at once.
+func forgetAPIJWTUser(id int) {
+	apiJWTUsers.mu.Lock()
+	delete(apiJWTUsers.entries, id)
+	apiJWTUsers.mu.Unlock()
+}
+
+// IsAPIJWT reports whether token has the shape of a JWT rather than of an API token.
+func IsAPIJWT(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
+
+func apiJWTKid(public ed25519.PublicKey) string {
+	sum := sha256.Sum256(public)
+	return hex.EncodeToString(sum[:8])
+}
+
+func newAPISigningKey() (*model.APISigningKey, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	key := &model.APISigningKey{Kid: apiJWTKid(public)}
+	var err error
+	key.Seed, err = sealAPISigningSeed(key.Kid, seed)
+	return key, err
+}
+
+// The private seeds of signing keys are stored sealed with AES-256-GCM under a key derived
+// from the token pepper, which lives outside the database, so a copy of the database alone
+// can not be used to forge access tokens. Sealed seeds look like
+// $aes-gcm$<pepper id>$<base64 nonce and ciphertext>; the kid is bound as additional data.
+const apiSigningSeedSealed = "aes-gcm"
+
+func apiSigningSeedCipher() (cipher.AEAD, string, error) {
+	pepper, err := apiTokenPepper()
+	if err != nil {
+		return nil, "", err
+	}
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte("3x-ui api signing key seal"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, "", err
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, apiTokenPepperID(pepper), err
+}
+
+func sealAPISigningSeed(kid string, seed []byte) (string, error) {
+	aead, pepperID, err := apiSigningSeedCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, seed, []byte(kid))
+	return "$" + apiSigningSeedSealed + "$" + pepperID + "$" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openAPISigningSeed returns the private seed of key. legacy is set for seeds stored in the
+// clear by earlier versions, which the caller seals.
+func openAPISigningSeed(key *model.APISigningKey) (seed []byte, legacy bool, err error) {
+	if !strings.HasPrefix(key.Seed, "$") {
+		seed, err = base64.StdEncoding.DecodeString(key.Seed)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, false, fmt.Errorf("api signing key %s is corrupt", key.Kid)
+		}
+		return seed, true, nil
+	}
+	parts := strings.Split(key.Seed, "$")
+	if len(parts) != 4 || parts[1] != apiSigningSeedSealed {
+		return nil, false, fmt.Errorf("api signing key %s is corrupt", key.Kid)
+	}
+	aead, pepperID, err := apiSigningSeedCipher()
+	if err != nil {
+		return nil, false, err
+	}
+	if parts[2] != pepperID {
+		return nil, false, fmt.Errorf("api signing key %s: %w", key.Kid, ErrAPITokenPepperMismatch)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, false, fmt.Errorf("api signing key %s is corrupt", key.Kid)
+	}
+	seed, err = aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(key.Kid))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, false, fmt.Errorf("api signing key %s is corrupt", key.Kid)
+	}
+	return seed, false, nil
+}
+
+// loadAPIJWTKeys refreshes the key cache from the database. Retired keys and revocations
+// whose tokens can no longer be valid are deleted, seeds stored in the clear are sealed,
+// and a signing key is created when there is no active one that can be opened. Keys that
+// can not be opened, e.g. after the pepper changed, are skipped. The caller holds
+// apiJWTKeys.mu.
+func loadAPIJWTKeys() error {
+	db := database.GetDB()
+	now := time.Now()
+	cutoff := now.Add(-time.Duration(MaxAPIJWTTTL)*time.Minute - apiJWTClockSkew)
+	if err := db.Where("retired_at IS NOT NULL AND retired_at < ?", cutoff).Delete(&model.APISigningKey{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("expires_at < ?", now.Add(-apiJWTClockSkew)).Delete(&model.APIRevokedAccessToken{}).Error; err != nil {
+		return err
+	}
+	var jtis []string
+	if err := db.Model(&model.APIRevokedAccessToken{}).Pluck("jti", &jtis).Error; err != nil {
+		return err
+	}
+	revoked := make(map[string]bool, len(jtis))
+	for _, jti := range jtis {
+		revoked[jti] = true
+	}
+
+	var keys []model.APISigningKey
+	if err := db.Order("id DESC").Find(&keys).Error; err != nil {
+		return err
+	}
+
+	public := make(map[string]ed25519.PublicKey, len(keys)+1)
+	var signer ed25519.PrivateKey
+	var signerKid string
+	for i, key := range keys {
+		seed, legacy, err := openAPISigningSeed(&key)
+		if err != nil {
+			logger.Warning("skip api signing key:", err)
+			continue
+		}
+		if legacy {
+			sealed, err := sealAPISigningSeed(key.Kid, seed)
+			if err != nil {
+				return err
+			}
+			if err := db.Model(&model.APISigningKey{}).Where("id = ?", key.Id).Update("seed", sealed).Error; err != nil {
+				return err
+			}
+		}
+		private := ed25519.NewKeyFromSeed(seed)
+		public[key.Kid] = private.Public().(ed25519.PublicKey)
+		if i == 0 && key.RetiredAt == nil {
+			signer, signerKid = private, key.Kid
+		}
+	}
+	if signer == nil {
+		key, err := newAPISigningKey()
+		if err != nil {
+			return err
+		}
+		if err := db.Create(key).Error; err != nil {
+			return err
+		}
+		seed, _, err := openAPISigningSeed(key)
+		if err != nil {
+			return err
+		}
+		signer, signerKid = ed25519.NewKeyFromSeed(seed), key.Kid
+		public[key.Kid] = signer.Public().(ed25519.PublicKey)
+	}
+	apiJWTKeys.kid, apiJWTKeys.private, apiJWTKeys.public = signerKid, signer, public
+	apiJWTKeys.revoked = revoked
+	apiJWTKeys.loadedAt = now
+	return nil
+}
+
+// cachedAPIJWTKeys loads the key cache when it is older than apiJWTKeyCacheTTL, so
+// rotations and revocations made by another process (api-guard) apply within a minute.
+func cachedAPIJWTKeys() error {
+	if time.Since(apiJWTKeys.loadedAt) < apiJWTKeyCacheTTL {
+		return nil
+	}
+	return loadAPIJWTKeys()
+}
+
+// Issue signs an access token for user limited to scopes, which must be granted to the
+// user; no scopes means all of the user's scopes. Outside the user's access window it
+// returns ErrOutsideAccessWindow.
+func (s *APIJWTService) Issue(user *model.APIUser, scopes []model.APIScope) (string, *APIJWTClaims, error) {
+	if err := s.apiUserService.CheckAccessWindow(user, time.Now()); err != nil {
+		return "", nil, err
+	}
+	ttl, err := s.settingService.GetAPIJWTTTL()
+	if err != nil {
+		return "", nil, err
+	}
+	if ttl <= 0 {
+		return "", nil, ErrAPIJWTDisabled
+	}
+	ttl = min(ttl, MaxAPIJWTTTL)
+
+	if len(scopes) == 0 {
+		scopes = user.ScopeList()
+	}
+	names := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !user.HasScope(scope) {
+			return "", nil, fmt.Errorf("%w: scope %s", ErrAPIPrivilegeEscalation, scope)
+		}
+		names = append(names, string(scope))
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	// Tokens issued in the second TokensNotBefore was set would be void at once.
+	issuedAt := max(now.Unix(), user.TokensNotBefore+1)
+	claims := &APIJWTClaims{
+		Issuer:    APIJWTIssuer,
+		Subject:   strconv.Itoa(user.Id),
+		Audience:  APIJWTAudience,
+		Name:      user.Name,
+		Scope:     strings.Join(names, " "),
+		RateLimit: s.apiUserService.EffectiveRateLimit(user),
+		Origins:   user.AllowedOrigins,
+		Window:    user.AccessWindow,
+		Inbounds:  user.InboundAllowlist,
+		IssuedAt:  issuedAt,
+		ExpiresAt: now.Add(time.Duration(ttl) * time.Minute).Unix(),
+		ID:        hex.EncodeToString(jti),
+	}
+
+	apiJWTKeys.mu.Lock()
+	defer apiJWTKeys.mu.Unlock()
+	if err := cachedAPIJWTKeys(); err != nil {
+		return "", nil, err
+	}
+	header, _ := json.Marshal(apiJWTHeader{Alg: apiJWTAlgorithm, Typ: "JWT", Kid: apiJWTKeys.kid})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(apiJWTKeys.private, []byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), claims, nil
+}
+
+// Verify checks the signature, issuer, audience, lifetime and revocation of an access
+// token, and that its user is still enabled and has not changed since it was issued, and
+// returns its claims.
+func (s *APIJWTService) Verify(token string) (*APIJWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidAPIJWT
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidAPIJWT
+	}
+	header := apiJWTHeader{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil || header.Alg != apiJWTAlgorithm {
+		return nil, ErrInvalidAPIJWT
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidAPIJWT
+	}
+
+	apiJWTKeys.mu.Lock()
+	if err := cachedAPIJWTKeys(); err != nil {
+		apiJWTKeys.mu.Unlock()
+		return nil, err
+	}
+	public, ok := apiJWTKeys.public[header.Kid]
+	revoked := apiJWTKeys.revoked
+	apiJWTKeys.mu.Unlock()
+	if !ok || !ed25519.Verify(public, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidAPIJWT
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidAPIJWT
+	}
+	claims := &APIJWTClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrInvalidAPIJWT
+	}
+	now := time.Now()
+	if claims.Issuer != APIJWTIssuer || claims.Audience != APIJWTAudience ||
+		now.After(time.Unix(claims.ExpiresAt, 0)) ||
+		time.Unix(claims.IssuedAt, 0).After(now.Add(apiJWTClockSkew)) {
+		return nil, ErrInvalidAPIJWT
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil || revoked[claims.ID] {
+		return nil, ErrInvalidAPIJWT
+	}
+	if err := checkAPIJWTUser(id, claims.IssuedAt); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ListKeys returns the signing keys, newest (the active one) first.
+func (s *APIJWTService) ListKeys() ([]model.APISigningKey, error) {
+	apiJWTKeys.mu.Lock()
+	err := loadAPIJWTKeys()
+	apiJWTKeys.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]model.APISigningKey, 0)
+	err = database.GetDB().Order("id DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RotateKey retires the active signing key and creates a new one. With dropOld the
+// retired keys are deleted at once, which invalidates every access token issued so far.
+func (s *APIJWTService) RotateKey(dropOld bool, actor, ip string) (*model.APISigningKey, error) {
+	key, err := newAPISigningKey()
+	if err != nil {
+		return nil, err
+	}
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if dropOld {
+			if err := tx.Where("1 = 1").Delete(&model.APISigningKey{}).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Model(&model.APISigningKey{}).Where("retired_at IS NULL").Update("retired_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(key).Error; err != nil {
+			return err
+		}
+		detail := "new signing key " + key.Kid
+		if dropOld {
+			detail += ", previous keys dropped"
+		}
+		return recordAPIAudit(tx, &model.APIAuditEvent{Event: APIAuditSigningKeyRotate, Actor: actor, IP: ip, Detail: detail})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	apiJWTKeys.mu.Lock()
+	defer apiJWTKeys.mu.Unlock()
+	return key, loadAPIJWTKeys()
+}
+
+// JWKS returns the public signing keys as a JSON Web Key Set (RFC 7517, RFC 8037).
+func (s *APIJWTService) JWKS() (map[string]any, error) {
+	apiJWTKeys.mu.Lock()
+	defer apiJWTKeys.mu.Unlock()
+	if err := cachedAPIJWTKeys(); err != nil {
+		return nil, err
+	}
+	kids := slices.Sorted(maps.Keys(apiJWTKeys.public))
+	keys := make([]map[string]string, 0, len(kids))
+	for _, kid := range kids {
+		public := apiJWTKeys.public[kid]
+		keys = append(keys, map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(public),
+			"kid": kid,
+			"alg": apiJWTAlgorithm,
+			"use": "sig",
+		})
+	}
+	return map[string]any{"keys": keys}, nil
+}
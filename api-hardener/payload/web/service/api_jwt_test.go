@@ -0,0 +1,193 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// signTestAPIJWT signs header and claims with key, or with the current signing key when
+// key is nil.
+func signTestAPIJWT(t *testing.T, header apiJWTHeader, claims any, key ed25519.PrivateKey) string {
+	t.Helper()
+	apiJWTKeys.mu.Lock()
+	if err := cachedAPIJWTKeys(); err != nil {
+		apiJWTKeys.mu.Unlock()
+		t.Fatal(err)
+	}
+	if key == nil {
+		key = apiJWTKeys.private
+	}
+	if header.Kid == "" {
+		header.Kid = apiJWTKeys.kid
+	}
+	apiJWTKeys.mu.Unlock()
+
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, []byte(signingInput)))
+}
+
+func TestAPIJWTVerify(t *testing.T) {
+	users := &APIUserService{}
+	jwt := &APIJWTService{}
+	newUser := func(name string) *model.APIUser {
+		user, _, err := users.CreateUser(name, 0, []model.APIScope{model.APIScopeRead})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { users.DeleteUser(user.Id) })
+		return user
+	}
+	user := newUser("jwt-valid")
+	token, issued, err := jwt.Issue(user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	header := apiJWTHeader{Alg: apiJWTAlgorithm, Typ: "JWT"}
+	claims := func(edit func(c *APIJWTClaims)) *APIJWTClaims {
+		c := *issued
+		edit(&c)
+		return &c
+	}
+	parts := strings.Split(token, ".")
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr bool
+	}{
+		{"valid", func() string { return token }, false},
+		{"tampered payload", func() string {
+			payload, _ := json.Marshal(claims(func(c *APIJWTClaims) { c.Scope = "read write admin" }))
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+		}, true},
+		{"tampered signature", func() string {
+			sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			sig[0] ^= 1
+			return parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(sig)
+		}, true},
+		{"alg none", func() string {
+			none, _ := json.Marshal(apiJWTHeader{Alg: "none", Typ: "JWT"})
+			return base64.RawURLEncoding.EncodeToString(none) + "." + parts[1] + "."
+		}, true},
+		{"unknown kid", func() string {
+			return signTestAPIJWT(t, apiJWTHeader{Alg: apiJWTAlgorithm, Typ: "JWT", Kid: "0000000000000000"}, issued, otherKey)
+		}, true},
+		{"foreign key", func() string { return signTestAPIJWT(t, header, issued, otherKey) }, true},
+		{"resigned", func() string { return signTestAPIJWT(t, header, issued, nil) }, false},
+		{"expired", func() string {
+			return signTestAPIJWT(t, header, claims(func(c *APIJWTClaims) { c.ExpiresAt = time.Now().Add(-time.Second).Unix() }), nil)
+		}, true},
+		{"issued in the future", func() string {
+			return signTestAPIJWT(t, header, claims(func(c *APIJWTClaims) { c.IssuedAt = time.Now().Add(time.Hour).Unix() }), nil)
+		}, true},
+		{"wrong audience", func() string {
+			return signTestAPIJWT(t, header, claims(func(c *APIJWTClaims) { c.Audience = "other" }), nil)
+		}, true},
+		{"wrong issuer", func() string {
+			return signTestAPIJWT(t, header, claims(func(c *APIJWTClaims) { c.Issuer = "other" }), nil)
+		}, true},
+		{"bad subject", func() string {
+			return signTestAPIJWT(t, header, claims(func(c *APIJWTClaims) { c.Subject = "admin" }), nil)
+		}, true},
+		{"unknown user", func() string {
+			return signTestAPIJWT(t, header, claims(func(c *APIJWTClaims) { c.Subject = "999999" }), nil)
+		}, true},
+		{"disabled user", func() string {
+			disabled := newUser("jwt-disabled")
+			token, _, err := jwt.Issue(disabled, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := users.SetEnabled(disabled.Id, false); err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}, true},
+		{"deleted user", func() string {
+			deleted := newUser("jwt-deleted")
+			token, _, err := jwt.Issue(deleted, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := users.DeleteUser(deleted.Id); err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}, true},
+		{"voided by a change", func() string {
+			changed := newUser("jwt-changed")
+			token, _, err := jwt.Issue(changed, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := users.UpdateRateLimit(changed.Id, 5); err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}, true},
+		{"malformed", func() string { return "eyJhbGciOiJFZERTQSJ9.e30" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jwt.Verify(tt.token())
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidAPIJWT) {
+					t.Fatalf("Verify err = %v, want ErrInvalidAPIJWT", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if got.Subject != strconv.Itoa(user.Id) || got.Scope != string(model.APIScopeRead) {
+				t.Fatalf("claims = %+v", got)
+			}
+		})
+	}
+}
+
+func TestAPIJWTIssueAfterChange(t *testing.T) {
+	users := &APIUserService{}
+	jwt := &APIJWTService{}
+	user, _, err := users.CreateUser("jwt-reissue", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer users.DeleteUser(user.Id)
+	if err := users.UpdateRateLimit(user.Id, 5); err != nil {
+		t.Fatal(err)
+	}
+	// A token issued right after the change, in the same second, must stay valid.
+	if err := database.GetDB().First(user, user.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := jwt.Issue(user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Verify(token); err != nil {
+		t.Fatalf("Verify of a token issued after the change: %v", err)
+	}
+	if _, _, err := jwt.Issue(user, []model.APIScope{model.APIScopeAdmin}); !errors.Is(err, ErrAPIPrivilegeEscalation) {
+		t.Fatalf("Issue with an ungranted scope = %v, want ErrAPIPrivilegeEscalation", err)
+	}
+}
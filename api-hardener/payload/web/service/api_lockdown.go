@@ -0,0 +1,223 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// Lockdown modes of /panel/api. A full lockdown rejects every request that is not made
+// with the break-glass token, browser sessions included; a read-only lockdown keeps
+// serving GET requests as usual and reserves everything else for the break-glass token.
+const (
+	APILockdownOff      = "off"
+	APILockdownFull     = "full"
+	APILockdownReadOnly = "readonly"
+)
+
+// APILockdownStatus is the current lockdown state.
+type APILockdownStatus struct {
+	Mode       string `json:"mode"`
+	BreakGlass bool   `json:"breakGlass"` // a break-glass token has been issued
+}
+
+// APILockdownService switches the API lockdown and keeps the break-glass token.
+type APILockdownService struct {
+	settingService      SettingService
+	panelSessionService PanelSessionService
+	tgbot               Tgbot
+}
+
+var telegramHTMLEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// ParseAPILockdownMode normalizes a mode name; "on" is short for full.
+func ParseAPILockdownMode(mode string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case APILockdownOff:
+		return APILockdownOff, nil
+	case "on", APILockdownFull:
+		return APILockdownFull, nil
+	case APILockdownReadOnly, "read-only":
+		return APILockdownReadOnly, nil
+	}
+	return "", fmt.Errorf("unknown lockdown mode %q (use on, readonly or off)", mode)
+}
+
+// Mode returns the current lockdown mode. A stored value that can not be parsed
+// is treated as a full lockdown.
+func (s *APILockdownService) Mode() (string, error) {
+	raw, err := s.settingService.GetAPILockdown()
+	if err != nil {
+		return APILockdownOff, err
+	}
+	mode, err := ParseAPILockdownMode(raw)
+	if err != nil {
+		return APILockdownFull, err
+	}
+	return mode, nil
+}
+
+// Status returns the lockdown mode and whether a break-glass token exists.
+func (s *APILockdownService) Status() (*APILockdownStatus, error) {
+	mode, err := s.Mode()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := s.settingService.GetAPIBreakGlassHash()
+	if err != nil {
+		return nil, err
+	}
+	return &APILockdownStatus{Mode: mode, BreakGlass: hash != ""}, nil
+}
+
+// SetMode switches the lockdown and returns how many panel sessions were revoked;
+// revokeSessions logs out every panel session when a lockdown is entered. The change
+// is recorded in the audit trail and announced to the Telegram admins.
+func (s *APILockdownService) SetMode(mode string, revokeSessions bool, actor string, ip string, reason string) (int, error) {
+	mode, err := ParseAPILockdownMode(mode)
+	if err != nil {
+		return 0, err
+	}
+	previous, _ := s.Mode()
+	if err := s.settingService.SetAPILockdown(mode); err != nil {
+		return 0, err
+	}
+	revoked := 0
+	if revokeSessions && mode != APILockdownOff {
+		if revoked, err = s.panelSessionService.RevokeAllSessions(); err != nil {
+			return 0, err
+		}
+	}
+
+	detail := previous + " -> " + mode
+	if revoked > 0 {
+		detail += fmt.Sprintf(", %d panel session(s) revoked", revoked)
+	}
+	if reason = strings.TrimSpace(reason); reason != "" {
+		detail += ": " + reason
+	}
+	// Record logs its own failures; the lockdown is in effect either way.
+	_ = (&APIAuditService{}).Record(&model.APIAuditEvent{Event: APIAuditLockdown, Actor: actor, IP: ip, Detail: detail})
+
+	var msg string
+	switch mode {
+	case APILockdownFull:
+		msg = "🚨 API lockdown: /panel/api now only accepts the break-glass token."
+	case APILockdownReadOnly:
+		msg = "⚠️ API read-only lockdown: /panel/api only serves GET requests; changes need the break-glass token."
+	default:
+		msg = "✅ API lockdown lifted."
+	}
+	s.alert(fmt.Sprintf("%s\nBy %s from %s (%s).", msg,
+		telegramHTMLEscaper.Replace(actor), telegramHTMLEscaper.Replace(ip), telegramHTMLEscaper.Replace(detail)))
+	return revoked, nil
+}
+
+// IssueBreakGlassToken issues a new break-glass token, replacing the previous one, and
+// returns it; only its hash is stored. The token is accepted only during a lockdown.
+func (s *APILockdownService) IssueBreakGlassToken(actor string, ip string) (string, error) {
+	token, _ := newAPIToken()
+	hash, err := hashAPIToken(token)
+	if err != nil {
+		return "", err
+	}
+	if err := s.settingService.SetAPIBreakGlassHash(hash); err != nil {
+		return "", err
+	}
+	_ = (&APIAuditService{}).Record(&model.APIAuditEvent{Event: APIAuditBreakGlassIssued, Actor: actor, IP: ip, Detail: "break-glass token issued"})
+	s.alert(fmt.Sprintf("🔑 A new API break-glass token was issued by %s from %s.",
+		telegramHTMLEscaper.Replace(actor), telegramHTMLEscaper.Replace(ip)))
+	return token, nil
+}
+
+// IsBreakGlassToken reports whether token is the current break-glass token.
+func (s *APILockdownService) IsBreakGlassToken(token string) bool {
+	hash, err := s.settingService.GetAPIBreakGlassHash()
+	if err != nil || hash == "" {
+		return false
+	}
+	ok, _, err := checkAPITokenHash(hash, token)
+	return err == nil && ok
+}
+
+// BotCommand runs "/lockdown [status|on|readonly|off] [sessions]" sent to the Telegram
+// bot by userID and returns the reply. Only the admins in tgBotChatId may use it, others
+// get ErrNotTelegramAdmin. Switching the mode goes through SetMode, which records it and
+// alerts every admin chat, so the reply is empty then.
+func (s *APILockdownService) BotCommand(args []string, userID int64, from string) (string, error) {
+	if !isTelegramAdmin(&s.settingService, userID) {
+		return "", ErrNotTelegramAdmin
+	}
+	if len(args) == 0 || args[0] == "status" {
+		status, err := s.Status()
+		if err != nil {
+			return "❗ " + telegramHTMLEscaper.Replace(err.Error()), nil
+		}
+		breakGlass := "not issued"
+		if status.BreakGlass {
+			breakGlass = "issued"
+		}
+		return fmt.Sprintf("API lockdown: <b>%s</b>\nBreak-glass token: %s\nUsage: /lockdown on|readonly|off [sessions]", status.Mode, breakGlass), nil
+	}
+	revokeSessions := len(args) > 1 && args[1] == "sessions"
+	if _, err := s.SetMode(args[0], revokeSessions, "telegram:"+from, "telegram", ""); err != nil {
+		return "❗ " + telegramHTMLEscaper.Replace(err.Error()), nil
+	}
+	return "", nil
+}
+
+func (s *APILockdownService) alert(msg string) {
+	alertTelegramAdmins(&s.tgbot, &s.settingService, msg)
+}
+
+// alertTelegramAdmins sends msg to the Telegram admins through the running bot or, in
+// processes without one such as api-guard, directly through the Bot API.
+func alertTelegramAdmins(tgbot *Tgbot, settingService *SettingService, msg string) {
+	if tgbot.IsRunning() {
+		tgbot.SendMsgToTgbotAdmins(msg)
+		return
+	}
+	if err := sendTelegramDirect(settingService, msg); err != nil {
+		logger.Warning("telegram alert failed:", err)
+	}
+}
+
+// sendTelegramDirect sends msg to the configured admin chats through the Bot API; it
+// does nothing when the bot is disabled or not configured.
+func sendTelegramDirect(settingService *SettingService, msg string) error {
+	enabled, err := settingService.GetTgbotEnabled()
+	if err != nil || !enabled {
+		return err
+	}
+	token, err := settingService.GetTgBotToken()
+	if err != nil {
+		return err
+	}
+	chatIds, err := settingService.GetTgBotChatId()
+	if err != nil || token == "" || chatIds == "" {
+		return err
+	}
+	api, err := newTelegramBotAPI(settingService)
+	if err != nil {
+		return err
+	}
+	defer api.client.CloseIdleConnections()
+	api.client.Timeout = 10 * time.Second
+
+	for _, chatId := range strings.Split(chatIds, ",") {
+		chatId = strings.TrimSpace(chatId)
+		if chatId == "" {
+			continue
+		}
+		if err := api.sendMessage(token, chatId, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// The Telegram admins switch the API lockdown with "/lockdown [status|on|readonly|off]
+// [sessions]". The bot's command dispatcher lives in upstream tgbot.go, which the payload
+// does not replace, so the command reaches the panel through a relay instead: while the
+// bot is enabled, GetTgBotAPIServer hands the bot a loopback address that forwards every
+// Bot API call to tgBotAPIServer (through tgBotProxy). The relay runs the /lockdown
+// messages of admins through APILockdownService.BotCommand and passes the bot those
+// updates without their message, so the bot does not answer them as unknown commands.
+
+const defaultTelegramAPIServer = "https://api.telegram.org"
+
+// ErrNotTelegramAdmin is returned for bot commands from users that are not in tgBotChatId.
+var ErrNotTelegramAdmin = errors.New("not a telegram admin")
+
+// isTelegramAdmin reports whether the Telegram user userID is one of the admins in
+// tgBotChatId, as the bot itself decides it.
+func isTelegramAdmin(settingService *SettingService, userID int64) bool {
+	chatIds, err := settingService.GetTgBotChatId()
+	if err != nil {
+		return false
+	}
+	for _, chatId := range strings.Split(chatIds, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(chatId), 10, 64); err == nil && id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLockdownCommand returns the arguments of a "/lockdown" or "/lockdown@bot" message.
+func parseLockdownCommand(text string) ([]string, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, false
+	}
+	command, _, _ := strings.Cut(fields[0], "@")
+	if command != "/lockdown" {
+		return nil, false
+	}
+	return fields[1:], true
+}
+
+// telegramBotAPI is the configured Bot API server and a client that reaches it.
+type telegramBotAPI struct {
+	server string
+	proxy  string
+	client *http.Client
+}
+
+func newTelegramBotAPI(settingService *SettingService) (*telegramBotAPI, error) {
+	server, err := settingService.getString("tgBotAPIServer")
+	if err != nil {
+		return nil, err
+	}
+	if server == "" {
+		server = defaultTelegramAPIServer
+	}
+	proxy, err := settingService.getString("tgBotProxy")
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("telegram proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &telegramBotAPI{server: strings.TrimSuffix(server, "/"), proxy: proxy, client: &http.Client{Transport: transport}}, nil
+}
+
+// sendMessage sends the HTML message msg to chatId.
+func (a *telegramBotAPI) sendMessage(token string, chatId string, msg string) error {
+	resp, err := a.client.PostForm(a.server+"/bot"+token+"/sendMessage", url.Values{"chat_id": {chatId}, "text": {msg}, "parse_mode": {"HTML"}})
+	if err != nil {
+		return fmt.Errorf("send to chat %s: %w", chatId, stripTelegramURL(err))
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("send to chat %s: telegram answered %s", chatId, resp.Status)
+	}
+	return nil
+}
+
+// stripTelegramURL drops the URL from a client error, as it contains the bot token.
+func stripTelegramURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// telegramRelay is the relay of this process, started on first use.
+var telegramRelay struct {
+	mu  sync.Mutex
+	url string
+}
+
+// tgBotRelayURL returns the address of the lockdown command relay while the bot is
+// enabled, starting the relay on first use, and "" otherwise or when it can not start.
+func (s *SettingService) tgBotRelayURL() string {
+	if enabled, err := s.GetTgbotEnabled(); err != nil || !enabled {
+		return ""
+	}
+	telegramRelay.mu.Lock()
+	defer telegramRelay.mu.Unlock()
+	if telegramRelay.url != "" {
+		return telegramRelay.url
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		logger.Warning("telegram lockdown relay not started:", err)
+		return ""
+	}
+	server := &http.Server{Handler: &telegramLockdownRelay{}, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warning("telegram lockdown relay stopped:", err)
+		}
+	}()
+	telegramRelay.url = "http://" + ln.Addr().String()
+	return telegramRelay.url
+}
+
+// telegramLockdownRelay forwards Bot API calls of the panel's bot and takes the /lockdown
+// commands out of the updates it receives.
+type telegramLockdownRelay struct {
+	settingService  SettingService
+	lockdownService APILockdownService
+
+	mu  sync.Mutex
+	api *telegramBotAPI
+}
+
+// botAPI returns the Bot API client, replaced when tgBotAPIServer or tgBotProxy change.
+func (r *telegramLockdownRelay) botAPI() (*telegramBotAPI, error) {
+	api, err := newTelegramBotAPI(&r.settingService)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.api != nil && r.api.server == api.server && r.api.proxy == api.proxy {
+		return r.api, nil
+	}
+	if r.api != nil {
+		r.api.client.CloseIdleConnections()
+	}
+	r.api = api
+	return api, nil
+}
+
+func (r *telegramLockdownRelay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	api, err := r.botAPI()
+	if err != nil {
+		writeTelegramRelayError(w, err)
+		return
+	}
+	target := api.server + req.URL.Path
+	if req.URL.RawQuery != "" {
+		target += "?" + req.URL.RawQuery
+	}
+	out, err := http.NewRequestWithContext(req.Context(), req.Method, target, req.Body)
+	if err != nil {
+		writeTelegramRelayError(w, err)
+		return
+	}
+	out.Header = req.Header.Clone()
+	// Let the transport negotiate compression, so updates can be read.
+	out.Header.Del("Accept-Encoding")
+	resp, err := api.client.Do(out)
+	if err != nil {
+		writeTelegramRelayError(w, stripTelegramURL(err))
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		writeTelegramRelayError(w, err)
+		return
+	}
+
+	if token, method, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/bot"), "/"); ok && method == "getUpdates" && resp.StatusCode == http.StatusOK {
+		body = r.takeLockdownCommands(body, func(chatId int64, reply string) {
+			if err := api.sendMessage(token, strconv.FormatInt(chatId, 10), reply); err != nil {
+				logger.Warning("telegram lockdown reply failed:", err)
+			}
+		})
+	}
+	for key, values := range resp.Header {
+		switch key {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Connection":
+			continue
+		}
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(body)
+}
+
+// takeLockdownCommands runs the /lockdown commands of admins in a getUpdates response and
+// returns the response with those updates emptied; reply sends the answer to a chat.
+func (r *telegramLockdownRelay) takeLockdownCommands(body []byte, reply func(chatId int64, msg string)) []byte {
+	var updates struct {
+		OK     bool              `json:"ok"`
+		Result []json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &updates); err != nil || !updates.OK {
+		return body
+	}
+	taken := false
+	for i, raw := range updates.Result {
+		var update struct {
+			UpdateID int64 `json:"update_id"`
+			Message  *struct {
+				Chat struct {
+					ID int64 `json:"id"`
+				} `json:"chat"`
+				From *struct {
+					ID       int64  `json:"id"`
+					Username string `json:"username"`
+				} `json:"from"`
+				Text string `json:"text"`
+			} `json:"message"`
+		}
+		if json.Unmarshal(raw, &update) != nil || update.Message == nil || update.Message.From == nil {
+			continue
+		}
+		args, ok := parseLockdownCommand(update.Message.Text)
+		if !ok {
+			continue
+		}
+		from := update.Message.From.Username
+		if from == "" {
+			from = strconv.FormatInt(update.Message.From.ID, 10)
+		}
+		msg, err := r.lockdownService.BotCommand(args, update.Message.From.ID, from)
+		if errors.Is(err, ErrNotTelegramAdmin) {
+			// The bot answers it like any command it does not know.
+			continue
+		}
+		if msg != "" {
+			reply(update.Message.Chat.ID, msg)
+		}
+		updates.Result[i], _ = json.Marshal(map[string]int64{"update_id": update.UpdateID})
+		taken = true
+	}
+	if !taken {
+		return body
+	}
+	out, err := json.Marshal(updates)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func writeTelegramRelayError(w http.ResponseWriter, err error) {
+	logger.Warning("telegram lockdown relay:", err)
+	var body bytes.Buffer
+	_ = json.NewEncoder(&body).Encode(map[string]any{"ok": false, "error_code": http.StatusBadGateway, "description": "relay: " + err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadGateway)
+	_, _ = w.Write(body.Bytes())
+}
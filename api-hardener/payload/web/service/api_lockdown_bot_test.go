@@ -0,0 +1,221 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseLockdownCommand(t *testing.T) {
+	tests := []struct {
+		text   string
+		want   []string
+		wantOK bool
+	}{
+		{"/lockdown", []string{}, true},
+		{"/lockdown readonly", []string{"readonly"}, true},
+		{"/lockdown@x_ui_bot on sessions", []string{"on", "sessions"}, true},
+		{"  /lockdown   off ", []string{"off"}, true},
+		{"/lockdowns", nil, false},
+		{"/start", nil, false},
+		{"lockdown on", nil, false},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		args, ok := parseLockdownCommand(tt.text)
+		if ok != tt.wantOK || (ok && !reflect.DeepEqual(args, tt.want)) {
+			t.Errorf("parseLockdownCommand(%q) = %q, %v; want %q, %v", tt.text, args, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestIsTelegramAdmin(t *testing.T) {
+	settingService := &SettingService{}
+	if err := settingService.SetTgBotChatId("1001, 42 ,x"); err != nil {
+		t.Fatal(err)
+	}
+	defer settingService.SetTgBotChatId("")
+	tests := []struct {
+		id   int64
+		want bool
+	}{
+		{1001, true},
+		{42, true},
+		{7, false},
+		{0, false},
+	}
+	for _, tt := range tests {
+		if got := isTelegramAdmin(settingService, tt.id); got != tt.want {
+			t.Errorf("isTelegramAdmin(%d) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+// fakeTelegram serves getUpdates with updates and records the messages sent.
+type fakeTelegram struct {
+	updates string
+
+	mu   sync.Mutex
+	sent []string
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/getUpdates"):
+		_, _ = io.WriteString(w, `{"ok":true,"result":[`+f.updates+`]}`)
+	case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+		_ = r.ParseForm()
+		f.mu.Lock()
+		f.sent = append(f.sent, r.PostForm.Get("chat_id")+": "+r.PostForm.Get("text"))
+		f.mu.Unlock()
+		_, _ = io.WriteString(w, `{"ok":true}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func telegramUpdate(id int, from int64, text string) string {
+	update, _ := json.Marshal(map[string]any{
+		"update_id": id,
+		"message": map[string]any{
+			"chat": map[string]any{"id": from},
+			"from": map[string]any{"id": from, "username": "user" + strconv.Itoa(id)},
+			"text": text,
+		},
+	})
+	return string(update)
+}
+
+func TestTelegramLockdownRelay(t *testing.T) {
+	settingService := &SettingService{}
+	lockdownService := &APILockdownService{}
+	t.Cleanup(func() {
+		settingService.SetTgBotAPIServer("")
+		settingService.SetTgBotChatId("")
+		settingService.SetAPILockdown(APILockdownOff)
+	})
+	if err := settingService.SetTgBotChatId("42"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		from     int64
+		text     string
+		taken    bool   // the update reaches the bot without its message
+		wantMode string // lockdown mode afterwards
+		wantSent string // prefix of the reply sent to the chat, if any
+	}{
+		{"admin switches", 42, "/lockdown readonly", true, APILockdownReadOnly, ""},
+		{"admin status", 42, "/lockdown", true, APILockdownReadOnly, "42: API lockdown: <b>readonly</b>"},
+		{"admin bad mode", 42, "/lockdown maybe", true, APILockdownReadOnly, "42: ❗"},
+		{"admin with bot name", 42, "/lockdown@x_ui_bot off", true, APILockdownOff, ""},
+		{"not an admin", 7, "/lockdown on", false, APILockdownOff, ""},
+		{"other command", 42, "/start", false, APILockdownOff, ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update := telegramUpdate(i+1, tt.from, tt.text)
+			telegram := &fakeTelegram{updates: update}
+			server := httptest.NewServer(telegram)
+			defer server.Close()
+			if err := settingService.SetTgBotAPIServer(server.URL); err != nil {
+				t.Fatal(err)
+			}
+
+			relay := httptest.NewServer(&telegramLockdownRelay{})
+			defer relay.Close()
+			resp, err := http.Get(relay.URL + "/bot123:abc/getUpdates?offset=0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var got struct {
+				OK     bool              `json:"ok"`
+				Result []json.RawMessage `json:"result"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil || !got.OK || len(got.Result) != 1 {
+				t.Fatalf("getUpdates through the relay = %+v, %v", got, err)
+			}
+			want := update
+			if tt.taken {
+				want = `{"update_id":` + strconv.Itoa(i+1) + `}`
+			}
+			if string(got.Result[0]) != want {
+				t.Errorf("update = %s, want %s", got.Result[0], want)
+			}
+
+			if mode, _ := lockdownService.Mode(); mode != tt.wantMode {
+				t.Errorf("mode = %q, want %q", mode, tt.wantMode)
+			}
+			telegram.mu.Lock()
+			defer telegram.mu.Unlock()
+			switch {
+			case tt.wantSent == "" && len(telegram.sent) != 0:
+				t.Errorf("sent %q, want nothing", telegram.sent)
+			case tt.wantSent != "" && (len(telegram.sent) != 1 || !strings.HasPrefix(telegram.sent[0], tt.wantSent)):
+				t.Errorf("sent %q, want %q…", telegram.sent, tt.wantSent)
+			}
+		})
+	}
+
+	events, err := (&APIAuditService{}).ListEvents(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Event != APIAuditLockdown || events[0].Actor != "telegram:user4" ||
+		events[0].Detail != "readonly -> off" || events[1].Actor != "telegram:user1" {
+		t.Fatalf("audit = %+v, want the two switches by telegram:user1 and telegram:user4", events)
+	}
+}
+
+func TestTelegramLockdownRelayForwards(t *testing.T) {
+	settingService := &SettingService{}
+	t.Cleanup(func() { settingService.SetTgBotAPIServer("") })
+	var gotPath, gotQuery, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotQuery, gotBody = r.URL.Path, r.URL.RawQuery, string(body)
+		w.Header().Set("X-Telegram", "yes")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = io.WriteString(w, `{"ok":false,"error_code":429}`)
+	}))
+	defer server.Close()
+	if err := settingService.SetTgBotAPIServer(server.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	relay := httptest.NewServer(&telegramLockdownRelay{})
+	defer relay.Close()
+	resp, err := http.Post(relay.URL+"/bot123:abc/sendMessage?x=1", "application/json", strings.NewReader(`{"chat_id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("X-Telegram") != "yes" || string(body) != `{"ok":false,"error_code":429}` {
+		t.Errorf("relayed %d %q %s", resp.StatusCode, resp.Header.Get("X-Telegram"), body)
+	}
+	if gotPath != "/bot123:abc/sendMessage" || gotQuery != "x=1" || gotBody != `{"chat_id":1}` {
+		t.Errorf("forwarded %s?%s %s", gotPath, gotQuery, gotBody)
+	}
+
+	server.Close()
+	resp, err = http.Get(relay.URL + "/bot123:abc/getMe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("unreachable Telegram: status %d, want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+}
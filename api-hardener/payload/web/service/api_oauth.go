@@ -0,0 +1,128 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"crypto/x509"
+	"errors"
+	"maps"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// OAuth2 support for gateways that can not be configured with a static bearer token. An API
+// user is an OAuth2 client: its name is the client ID and its API token the client secret
+// (or, on the API listener, its client certificate). The client credentials grant (RFC 6749
+// section 4.4) issues the access tokens of APIJWTService, which can be introspected
+// (RFC 7662) and revoked (RFC 7009) before they expire.
+
+var (
+	// ErrInvalidOAuthClient is returned when client authentication fails.
+	ErrInvalidOAuthClient = errors.New("invalid oauth client")
+	// ErrOAuthUnsupportedTokenType is returned when revoking an API token: only access tokens
+	// are revoked through OAuth2, API tokens with api-guard or /panel/api/tokens.
+	ErrOAuthUnsupportedTokenType = errors.New("only access tokens can be revoked")
+	// ErrOAuthTokenNotOwned is returned when a client revokes an access token issued to another
+	// client without holding the admin scope.
+	ErrOAuthTokenNotOwned = errors.New("access token was issued to another client")
+)
+
+// AuthenticateOAuthClient returns the enabled API user named clientID when secret is its API
+// token or, with an empty secret, when cert is its bound client certificate.
+func (s *APIUserService) AuthenticateOAuthClient(clientID, secret string, cert *x509.Certificate) (*model.APIUser, error) {
+	var apiUser *model.APIUser
+	var err error
+	switch {
+	case secret != "":
+		if !IsWellFormedAPIToken(secret) {
+			return nil, ErrInvalidOAuthClient
+		}
+		apiUser, err = s.VerifyToken(secret)
+	case cert != nil:
+		apiUser, err = s.VerifyClientCert(cert)
+	default:
+		return nil, ErrInvalidOAuthClient
+	}
+	if err != nil || apiUser.Name != clientID {
+		return nil, ErrInvalidOAuthClient
+	}
+	return apiUser, nil
+}
+
+// Introspect returns the claims of an access token and whether it is active (RFC 7662).
+// Unlike Verify it also looks the API user up, so tokens of disabled or deleted users
+// are reported inactive. Like Revoke, client must be the client the token was issued to
+// or hold the admin scope; other clients are told the token is inactive.
+func (s *APIJWTService) Introspect(token string, client *model.APIUser) (*APIJWTClaims, bool) {
+	if !IsAPIJWT(token) {
+		return nil, false
+	}
+	claims, err := s.Verify(token)
+	if err != nil {
+		return nil, false
+	}
+	id, _ := strconv.Atoi(claims.Subject)
+	if id != client.Id && !client.HasScope(model.APIScopeAdmin) {
+		return nil, false
+	}
+	apiUser, err := s.apiUserService.GetUser(id)
+	if err != nil || !apiUser.Enabled {
+		return nil, false
+	}
+	return claims, true
+}
+
+// Revoke denies the access token before it expires (RFC 7009). client must be the client
+// the token was issued to or hold the admin scope. Tokens that are already invalid are
+// ignored, as the RFC asks.
+func (s *APIJWTService) Revoke(token string, client *model.APIUser, ip string) error {
+	if IsWellFormedAPIToken(token) {
+		return ErrOAuthUnsupportedTokenType
+	}
+	if !IsAPIJWT(token) {
+		return nil
+	}
+	claims, err := s.Verify(token)
+	if err != nil {
+		return nil
+	}
+	owner := claims.APIUser()
+	if owner.Id != client.Id && !client.HasScope(model.APIScopeAdmin) {
+		return ErrOAuthTokenNotOwned
+	}
+
+	err = database.GetDB().Clauses(clause.OnConflict{DoNothing: true}).Create(&model.APIRevokedAccessToken{
+		Jti:       claims.ID,
+		APIUserId: owner.Id,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	apiJWTKeys.mu.Lock()
+	// Verify reads the map without the lock, so it is replaced rather than modified.
+	revoked := maps.Clone(apiJWTKeys.revoked)
+	if revoked == nil {
+		revoked = make(map[string]bool)
+	}
+	revoked[claims.ID] = true
+	apiJWTKeys.revoked = revoked
+	apiJWTKeys.mu.Unlock()
+
+	_ = (&APIAuditService{}).Record(&model.APIAuditEvent{
+		Event:       APIAuditAccessTokenRevoked,
+		APIUserId:   owner.Id,
+		APIUserName: owner.Name,
+		Actor:       "oauth client " + client.Name,
+		IP:          ip,
+		Detail:      "access token " + claims.ID,
+	})
+	return nil
+}
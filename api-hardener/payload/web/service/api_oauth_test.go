@@ -0,0 +1,124 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestAuthenticateOAuthClient(t *testing.T) {
+	users := &APIUserService{}
+	client, secret := newTestAPIUser(t, "oauth-auth", model.APIScopeRead)
+	_, otherSecret := newTestAPIUser(t, "oauth-auth-other", model.APIScopeRead)
+
+	tests := []struct {
+		name     string
+		clientID string
+		secret   string
+		wantErr  bool
+	}{
+		{"name and token", "oauth-auth", secret, false},
+		{"token of another client", "oauth-auth", otherSecret, true},
+		{"unknown name", "oauth-nobody", secret, true},
+		{"malformed secret", "oauth-auth", "secret", true},
+		{"no secret nor certificate", "oauth-auth", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := users.AuthenticateOAuthClient(tt.clientID, tt.secret, nil)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidOAuthClient) {
+					t.Fatalf("err = %v, want ErrInvalidOAuthClient", err)
+				}
+				return
+			}
+			if err != nil || got.Id != client.Id {
+				t.Fatalf("AuthenticateOAuthClient = %+v, %v; want client %d", got, err, client.Id)
+			}
+		})
+	}
+}
+
+func TestAPIJWTIntrospect(t *testing.T) {
+	jwt := &APIJWTService{}
+	owner, ownerSecret := newTestAPIUser(t, "oauth-owner", model.APIScopeRead)
+	other, _ := newTestAPIUser(t, "oauth-other", model.APIScopeRead)
+	admin, _ := newTestAPIUser(t, "oauth-admin", model.APIScopeAdmin)
+	token, _, err := jwt.Issue(owner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		client *model.APIUser
+		want   bool
+	}{
+		{"owner", token, owner, true},
+		{"admin", token, admin, true},
+		{"another client", token, other, false},
+		{"api token", ownerSecret, owner, false},
+		{"garbage", "eyJ.x.y", admin, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, active := jwt.Introspect(tt.token, tt.client)
+			if active != tt.want || (claims != nil) != tt.want {
+				t.Fatalf("Introspect = %+v, %v; want active %v", claims, active, tt.want)
+			}
+			if active && claims.Name != owner.Name {
+				t.Fatalf("claims = %+v, want the claims of %s", claims, owner.Name)
+			}
+		})
+	}
+
+	if err := (&APIUserService{}).SetEnabled(owner.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, active := jwt.Introspect(token, admin); active {
+		t.Fatal("a token of a disabled client is reported active")
+	}
+}
+
+func TestAPIJWTRevoke(t *testing.T) {
+	jwt := &APIJWTService{}
+	owner, ownerSecret := newTestAPIUser(t, "oauth-revoke-owner", model.APIScopeRead)
+	other, _ := newTestAPIUser(t, "oauth-revoke-other", model.APIScopeRead)
+	admin, _ := newTestAPIUser(t, "oauth-revoke-admin", model.APIScopeAdmin)
+	issue := func() string {
+		token, _, err := jwt.Issue(owner, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		client     *model.APIUser
+		wantErr    error
+		wantVerify error // of Verify afterwards
+	}{
+		{"another client", issue(), other, ErrOAuthTokenNotOwned, nil},
+		{"api token", ownerSecret, owner, ErrOAuthUnsupportedTokenType, ErrInvalidAPIJWT},
+		{"garbage", "eyJ.x.y", other, nil, ErrInvalidAPIJWT},
+		{"owner", issue(), owner, nil, ErrInvalidAPIJWT},
+		{"admin", issue(), admin, nil, ErrInvalidAPIJWT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := jwt.Revoke(tt.token, tt.client, "127.0.0.1"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Revoke = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := jwt.Verify(tt.token); !errors.Is(err, tt.wantVerify) {
+				t.Fatalf("Verify after Revoke = %v, want %v", err, tt.wantVerify)
+			}
+		})
+	}
+}
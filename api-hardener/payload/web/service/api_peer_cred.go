@@ -0,0 +1,86 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+var (
+	// ErrUnknownPeer is returned when no enabled API user is mapped to a unix socket caller.
+	ErrUnknownPeer = errors.New("unix socket caller is not mapped to an api user")
+	// ErrPeerMappingTaken is returned when a UID or GID is already mapped to another API user.
+	ErrPeerMappingTaken = errors.New("uid or gid is already mapped to another api user")
+)
+
+// PeerCred identifies the process on the other end of the API unix socket, as reported
+// by the kernel (SO_PEERCRED), so it can not be forged by the caller.
+type PeerCred struct {
+	PID int
+	UID int
+	GID int
+}
+
+func (p *PeerCred) String() string {
+	return fmt.Sprintf("uid=%d gid=%d pid=%d", p.UID, p.GID, p.PID)
+}
+
+// VerifyPeerCred returns the enabled API user mapped to the UID of cred (and its GID, if the
+// mapping has both) or, when no user has that UID, to its GID.
+func (s *APIUserService) VerifyPeerCred(cred *PeerCred) (*model.APIUser, error) {
+	db := database.GetDB()
+	apiUser := &model.APIUser{}
+	err := db.Where("peer_uid = ? AND (peer_gid IS NULL OR peer_gid = ?) AND enabled = ?", cred.UID, cred.GID, true).First(apiUser).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = db.Where("peer_uid IS NULL AND peer_gid = ? AND enabled = ?", cred.GID, true).First(apiUser).Error
+	}
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Warning("unix socket caller lookup failed:", err)
+		}
+		return nil, ErrUnknownPeer
+	}
+
+	_ = db.Model(&model.APIUser{}).
+		Where("id = ?", apiUser.Id).
+		Updates(map[string]any{
+			"last_used_at":  time.Now(),
+			"request_count": gorm.Expr("request_count + 1"),
+		}).
+		Error
+	return apiUser, nil
+}
+
+// BindPeerCred maps unix socket callers with uid, gid or both to the API user id; nil for
+// both removes the mapping. A UID, or a GID used without UID, can only be mapped to one
+// API user.
+func (s *APIUserService) BindPeerCred(id int, uid *int, gid *int) error {
+	if (uid != nil && *uid < 0) || (gid != nil && *gid < 0) {
+		return errors.New("uid and gid can not be negative")
+	}
+	db := database.GetDB()
+	var taken int64
+	var err error
+	switch {
+	case uid != nil:
+		err = db.Model(&model.APIUser{}).Where("id <> ? AND peer_uid = ?", id, *uid).Count(&taken).Error
+	case gid != nil:
+		err = db.Model(&model.APIUser{}).Where("id <> ? AND peer_uid IS NULL AND peer_gid = ?", id, *gid).Count(&taken).Error
+	}
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrPeerMappingTaken
+	}
+	return s.updateUser(id, voidAccessTokens(map[string]any{"peer_uid": uid, "peer_gid": gid}))
+}
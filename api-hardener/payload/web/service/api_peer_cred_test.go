@@ -0,0 +1,99 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestBindPeerCred(t *testing.T) {
+	users := &APIUserService{}
+	backup, _ := newTestAPIUser(t, "peer-bind-backup", model.APIScopeRead)
+	monitor, _ := newTestAPIUser(t, "peer-bind-monitor", model.APIScopeRead)
+	id := func(n int) *int { return &n }
+
+	tests := []struct {
+		name    string
+		user    *model.APIUser
+		uid     *int
+		gid     *int
+		wantErr bool
+		taken   bool // the error is ErrPeerMappingTaken
+	}{
+		{"uid", backup, id(2000), nil, false, false},
+		{"same uid again", backup, id(2000), id(2000), false, false},
+		{"uid of another user", monitor, id(2000), nil, true, true},
+		{"uid with a taken gid", monitor, id(2001), id(2000), false, false},
+		{"gid", backup, nil, id(3000), false, false},
+		{"gid of another user", monitor, nil, id(3000), true, true},
+		{"negative uid", monitor, id(-1), nil, true, false},
+		{"negative gid", monitor, nil, id(-1), true, false},
+		{"unbind", backup, nil, nil, false, false},
+		{"gid free again", monitor, nil, id(3000), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := users.BindPeerCred(tt.user.Id, tt.uid, tt.gid)
+			if (err != nil) != tt.wantErr || errors.Is(err, ErrPeerMappingTaken) != tt.taken {
+				t.Fatalf("BindPeerCred = %v, want error %v (taken %v)", err, tt.wantErr, tt.taken)
+			}
+		})
+	}
+}
+
+func TestVerifyPeerCred(t *testing.T) {
+	users := &APIUserService{}
+	id := func(n int) *int { return &n }
+	byUID, _ := newTestAPIUser(t, "peer-verify-uid", model.APIScopeRead)
+	byBoth, _ := newTestAPIUser(t, "peer-verify-both", model.APIScopeRead)
+	byGID, _ := newTestAPIUser(t, "peer-verify-gid", model.APIScopeRead)
+	disabled, _ := newTestAPIUser(t, "peer-verify-disabled", model.APIScopeRead)
+	for _, bind := range []struct {
+		user     *model.APIUser
+		uid, gid *int
+	}{
+		{byUID, id(4000), nil},
+		{byBoth, id(4001), id(4001)},
+		{byGID, nil, id(5000)},
+		{disabled, id(4002), nil},
+	} {
+		if err := users.BindPeerCred(bind.user.Id, bind.uid, bind.gid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := users.SetEnabled(disabled.Id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cred PeerCred
+		want *model.APIUser // nil: ErrUnknownPeer
+	}{
+		{"uid", PeerCred{PID: 1, UID: 4000, GID: 100}, byUID},
+		{"uid over gid", PeerCred{PID: 1, UID: 4000, GID: 5000}, byUID},
+		{"uid and gid", PeerCred{PID: 1, UID: 4001, GID: 4001}, byBoth},
+		{"uid with another gid", PeerCred{PID: 1, UID: 4001, GID: 100}, nil},
+		{"gid", PeerCred{PID: 1, UID: 1234, GID: 5000}, byGID},
+		{"disabled", PeerCred{PID: 1, UID: 4002, GID: 100}, nil},
+		{"unmapped", PeerCred{PID: 1, UID: 1234, GID: 100}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := users.VerifyPeerCred(&tt.cred)
+			if tt.want == nil {
+				if !errors.Is(err, ErrUnknownPeer) {
+					t.Fatalf("VerifyPeerCred(%s) = %+v, %v; want ErrUnknownPeer", &tt.cred, got, err)
+				}
+				return
+			}
+			if err != nil || got.Id != tt.want.Id {
+				t.Fatalf("VerifyPeerCred(%s) = %+v, %v; want %s", &tt.cred, got, err, tt.want.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"hash/crc32"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+)
+
+// API tokens are issued as xui_<env>_<lookup prefix><secret><checksum>, e.g.
+// xui_live_AbCd1234...Zz09yX. The fixed xui_ prefix lets secret scanners find
+// leaked tokens, and the trailing base62 CRC32 of everything before it lets both
+// scanners and the panel drop mistyped or made-up tokens without a database lookup.
+// Tokens issued before this format (48 plain alphanumerics) stay valid.
+const (
+	APITokenPrefix = "xui_"
+	// APITokenEnvVar sets the environment marker of newly issued tokens (default live).
+	APITokenEnvVar = "XUI_API_TOKEN_ENV"
+
+	defaultAPITokenEnv     = "live"
+	maxAPITokenEnvLength   = 8
+	apiTokenChecksumLength = 6
+	base62Alphabet         = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+var apiTokenEnv = sync.OnceValue(func() string {
+	env := strings.TrimSpace(os.Getenv(APITokenEnvVar))
+	if !isAPITokenEnv(env) {
+		return defaultAPITokenEnv
+	}
+	return env
+})
+
+func isAPITokenEnv(env string) bool {
+	if env == "" || len(env) > maxAPITokenEnvLength {
+		return false
+	}
+	for _, r := range env {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune(base62Alphabet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// apiTokenChecksum encodes the CRC32 of s as a fixed-width base62 string.
+func apiTokenChecksum(s string) string {
+	sum := crc32.ChecksumIEEE([]byte(s))
+	out := make([]byte, apiTokenChecksumLength)
+	for i := apiTokenChecksumLength - 1; i >= 0; i-- {
+		out[i] = base62Alphabet[sum%62]
+		sum /= 62
+	}
+	return string(out)
+}
+
+// newAPIToken issues a token in the structured format and returns its lookup prefix.
+func newAPIToken() (token string, prefix string) {
+	body := random.Seq(apiTokenLength)
+	head := APITokenPrefix + apiTokenEnv() + "_" + body
+	return head + apiTokenChecksum(head), body[:apiTokenPrefixLength]
+}
+
+// apiTokenLookupPrefix checks the shape (and for structured tokens the checksum)
+// of token and returns the prefix used to find its user.
+func apiTokenLookupPrefix(token string) (string, bool) {
+	rest, structured := strings.CutPrefix(token, APITokenPrefix)
+	if !structured {
+		// Legacy token: the bare random string.
+		if len(token) != apiTokenLength || !isAlphanumeric(token) {
+			return "", false
+		}
+		return token[:apiTokenPrefixLength], true
+	}
+
+	env, payload, ok := strings.Cut(rest, "_")
+	if !ok || !isAPITokenEnv(env) || len(payload) != apiTokenLength+apiTokenChecksumLength || !isAlphanumeric(payload) {
+		return "", false
+	}
+	split := len(token) - apiTokenChecksumLength
+	if apiTokenChecksum(token[:split]) != token[split:] {
+		return "", false
+	}
+	return payload[:apiTokenPrefixLength], true
+}
+
+// IsWellFormedAPIToken reports whether token could have been issued by the panel,
+// either in the structured xui_ format with a valid checksum or as a legacy token.
+func IsWellFormedAPIToken(token string) bool {
+	_, ok := apiTokenLookupPrefix(token)
+	return ok
+}
@@ -0,0 +1,104 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+// structuredToken builds a token in the xui_ format around body, with a valid checksum.
+func structuredToken(env, body string) string {
+	head := APITokenPrefix + env + "_" + body
+	return head + apiTokenChecksum(head)
+}
+
+func TestAPITokenLookupPrefix(t *testing.T) {
+	body := strings.Repeat("AbCd1234", apiTokenLength/8)
+	valid := structuredToken("live", body)
+	lastChar := valid[len(valid)-1:]
+	otherChar := "0"
+	if lastChar == otherChar {
+		otherChar = "1"
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantPrefix string
+		wantOK     bool
+	}{
+		{"structured", valid, body[:apiTokenPrefixLength], true},
+		{"structured test env", structuredToken("test", body), body[:apiTokenPrefixLength], true},
+		{"legacy", body, body[:apiTokenPrefixLength], true},
+		{"bad checksum", valid[:len(valid)-1] + otherChar, "", false},
+		{"checksum of another env", strings.Replace(valid, "_live_", "_test_", 1), "", false},
+		{"uppercase env", structuredToken("LIVE", body), "", false},
+		{"env too long", structuredToken("production", body), "", false},
+		{"missing env", APITokenPrefix + body + apiTokenChecksum(APITokenPrefix+body), "", false},
+		{"body too short", structuredToken("live", body[1:]), "", false},
+		{"symbol in body", structuredToken("live", body[1:]+"-"), "", false},
+		{"legacy too short", body[1:], "", false},
+		{"legacy with symbol", body[1:] + "_", "", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, ok := apiTokenLookupPrefix(tt.token)
+			if ok != tt.wantOK || prefix != tt.wantPrefix {
+				t.Fatalf("apiTokenLookupPrefix(%q) = %q, %v; want %q, %v", tt.token, prefix, ok, tt.wantPrefix, tt.wantOK)
+			}
+			if IsWellFormedAPIToken(tt.token) != tt.wantOK {
+				t.Fatalf("IsWellFormedAPIToken(%q) = %v, want %v", tt.token, !tt.wantOK, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAPITokenChecksum(t *testing.T) {
+	tests := []string{"", "xui_live_", strings.Repeat("z", 200)}
+	for _, s := range tests {
+		sum := apiTokenChecksum(s)
+		if len(sum) != apiTokenChecksumLength || !isAlphanumeric(sum) {
+			t.Fatalf("apiTokenChecksum(%q) = %q, want %d base62 characters", s, sum, apiTokenChecksumLength)
+		}
+		if apiTokenChecksum(s) != sum {
+			t.Fatalf("apiTokenChecksum(%q) is not stable", s)
+		}
+	}
+	if apiTokenChecksum("xui_live_a") == apiTokenChecksum("xui_live_b") {
+		t.Fatal("checksum does not change with the token")
+	}
+}
+
+func TestNewAPIToken(t *testing.T) {
+	token, prefix := newAPIToken()
+	if !strings.HasPrefix(token, APITokenPrefix+apiTokenEnv()+"_") {
+		t.Fatalf("token %q does not start with %s%s_", token, APITokenPrefix, apiTokenEnv())
+	}
+	got, ok := apiTokenLookupPrefix(token)
+	if !ok || got != prefix {
+		t.Fatalf("issued token %q does not verify: prefix %q, %v; want %q", token, got, ok, prefix)
+	}
+	if other, _ := newAPIToken(); other == token {
+		t.Fatal("two issued tokens are equal")
+	}
+}
+
+func TestMaskAPIToken(t *testing.T) {
+	body := strings.Repeat("AbCd1234", apiTokenLength/8)
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{structuredToken("live", body), "xui_live_AbCd1234…"},
+		{body, "AbCd1234…"},
+		{"short", "*****"},
+	}
+	for _, tt := range tests {
+		if got := maskAPIToken(tt.token); got != tt.want {
+			t.Errorf("maskAPIToken(%q) = %q, want %q", tt.token, got, tt.want)
+		}
+	}
+}
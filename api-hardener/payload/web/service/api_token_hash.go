@@ -0,0 +1,170 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+)
+
+// API token hashes are versioned by scheme. Tokens are long random strings, so a
+// keyed HMAC is as strong as bcrypt here and far cheaper per request; the key (the
+// pepper) lives outside the database, so a leaked DB alone can not be used to
+// check token guesses. Hashes look like $hmac-sha256$<pepper id>$<hex digest>.
+// Legacy bcrypt hashes are still accepted and replaced on the next successful use.
+const (
+	APITokenHashHMAC   = "hmac-sha256"
+	APITokenHashBcrypt = "bcrypt"
+	// APITokenHashRevoked marks a token revoked as leaked; it never verifies.
+	APITokenHashRevoked = "revoked"
+
+	// APITokenPepperEnv overrides the pepper file, e.g. for secret managers.
+	APITokenPepperEnv = "XUI_API_TOKEN_PEPPER"
+	// APITokenPepperFile is created next to the database on first use.
+	APITokenPepperFile = "api-token.pepper"
+
+	apiTokenPepperMinLength = 32
+)
+
+// ErrAPITokenPepperMismatch is returned for hashes made with a pepper other than the local one.
+var ErrAPITokenPepperMismatch = errors.New("api token hash was made with a different pepper")
+
+var (
+	apiTokenPepperOnce  sync.Once
+	apiTokenPepperValue []byte
+	apiTokenPepperErr   error
+)
+
+// APITokenPepperPath returns where the pepper file is kept.
+func APITokenPepperPath() string {
+	return filepath.Join(config.GetDBFolderPath(), APITokenPepperFile)
+}
+
+// apiTokenPepper loads the pepper from APITokenPepperEnv or the pepper file,
+// generating the file (mode 0600) when neither exists.
+func apiTokenPepper() ([]byte, error) {
+	apiTokenPepperOnce.Do(func() {
+		apiTokenPepperValue, apiTokenPepperErr = loadAPITokenPepper()
+	})
+	return apiTokenPepperValue, apiTokenPepperErr
+}
+
+func loadAPITokenPepper() ([]byte, error) {
+	if value := strings.TrimSpace(os.Getenv(APITokenPepperEnv)); value != "" {
+		if len(value) < apiTokenPepperMinLength {
+			return nil, fmt.Errorf("%s must be at least %d characters", APITokenPepperEnv, apiTokenPepperMinLength)
+		}
+		return []byte(value), nil
+	}
+
+	path := APITokenPepperPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		data = []byte(hex.EncodeToString(buf))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			// Another process created it first.
+			return loadAPITokenPepper()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("create api token pepper: %w", err)
+		}
+		_, err = f.Write(append(data, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("write api token pepper: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("read api token pepper: %w", err)
+	}
+
+	pepper := []byte(strings.TrimSpace(string(data)))
+	if len(pepper) < apiTokenPepperMinLength {
+		return nil, fmt.Errorf("%s is shorter than %d characters", path, apiTokenPepperMinLength)
+	}
+	return pepper, nil
+}
+
+func apiTokenPepperID(pepper []byte) string {
+	sum := sha256.Sum256(pepper)
+	return hex.EncodeToString(sum[:4])
+}
+
+// LocalAPITokenPepperID identifies the pepper of this panel in HMAC token hashes.
+func LocalAPITokenPepperID() (string, error) {
+	pepper, err := apiTokenPepper()
+	if err != nil {
+		return "", err
+	}
+	return apiTokenPepperID(pepper), nil
+}
+
+// hashAPIToken hashes token with the current scheme.
+func hashAPIToken(token string) (string, error) {
+	pepper, err := apiTokenPepper()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(token))
+	return "$" + APITokenHashHMAC + "$" + apiTokenPepperID(pepper) + "$" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// APITokenHashScheme returns the scheme of a stored hash and, for HMAC hashes, the pepper ID.
+func APITokenHashScheme(hash string) (scheme string, pepperID string) {
+	if rest, ok := strings.CutPrefix(hash, "$"+APITokenHashHMAC+"$"); ok {
+		pepperID, _, _ = strings.Cut(rest, "$")
+		return APITokenHashHMAC, pepperID
+	}
+	if hash == revokedAPITokenHash {
+		return APITokenHashRevoked, ""
+	}
+	if strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") {
+		return APITokenHashBcrypt, ""
+	}
+	return "", ""
+}
+
+// checkAPITokenHash reports whether token matches hash and whether the hash uses
+// a legacy scheme that should be replaced.
+func checkAPITokenHash(hash string, token string) (ok bool, legacy bool, err error) {
+	scheme, pepperID := APITokenHashScheme(hash)
+	switch scheme {
+	case APITokenHashHMAC:
+		pepper, err := apiTokenPepper()
+		if err != nil {
+			return false, false, err
+		}
+		if pepperID != apiTokenPepperID(pepper) {
+			return false, false, ErrAPITokenPepperMismatch
+		}
+		expected, err := hashAPIToken(token)
+		if err != nil {
+			return false, false, err
+		}
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1, false, nil
+	case APITokenHashBcrypt:
+		return crypto.CheckPasswordHash(hash, token), true, nil
+	default:
+		return false, false, nil
+	}
+}
@@ -0,0 +1,91 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+)
+
+func TestCheckAPITokenHash(t *testing.T) {
+	token, _ := newAPIToken()
+	hash, err := hashAPIToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := crypto.HashPasswordAsBcrypt(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPepper := "$" + APITokenHashHMAC + "$00000000$" + strings.Repeat("0", 64)
+
+	tests := []struct {
+		name       string
+		hash       string
+		token      string
+		wantOK     bool
+		wantLegacy bool
+		wantErr    error
+	}{
+		{"hmac", hash, token, true, false, nil},
+		{"hmac wrong token", hash, token + "x", false, false, nil},
+		{"bcrypt", bcryptHash, token, true, true, nil},
+		{"bcrypt wrong token", bcryptHash, token + "x", false, true, nil},
+		{"other pepper", otherPepper, token, false, false, ErrAPITokenPepperMismatch},
+		{"revoked", revokedAPITokenHash, token, false, false, nil},
+		{"unknown scheme", "plain", token, false, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, legacy, err := checkAPITokenHash(tt.hash, tt.token)
+			if ok != tt.wantOK || legacy != tt.wantLegacy || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkAPITokenHash = %v, %v, %v; want %v, %v, %v", ok, legacy, err, tt.wantOK, tt.wantLegacy, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenRehashesBcrypt(t *testing.T) {
+	s := &APIUserService{}
+	user, token, err := s.CreateUser("rehash", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.DeleteUser(user.Id)
+	bcryptHash, err := crypto.HashPasswordAsBcrypt(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.GetDB()
+	if err := db.Model(&model.APIUser{}).Where("id = ?", user.Id).Update("token_hash", bcryptHash).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.VerifyToken(token); err != nil {
+		t.Fatalf("VerifyToken with a bcrypt hash: %v", err)
+	}
+	stored := &model.APIUser{}
+	if err := db.First(stored, user.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	scheme, pepperID := APITokenHashScheme(stored.TokenHash)
+	localID, _ := LocalAPITokenPepperID()
+	if scheme != APITokenHashHMAC || pepperID != localID {
+		t.Fatalf("stored hash %q was not moved to %s", stored.TokenHash, APITokenHashHMAC)
+	}
+	if _, err := s.VerifyToken(token); err != nil {
+		t.Fatalf("VerifyToken after the rehash: %v", err)
+	}
+	// Same lookup prefix and a valid checksum, but another secret.
+	head := APITokenPrefix + apiTokenEnv() + "_" + user.TokenPrefix + strings.Repeat("x", apiTokenLength-apiTokenPrefixLength)
+	forged := head + apiTokenChecksum(head)
+	if _, err := s.VerifyToken(forged); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Fatalf("VerifyToken of a forged token = %v, want ErrInvalidAPIToken", err)
+	}
+}
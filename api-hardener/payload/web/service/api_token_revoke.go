@@ -0,0 +1,172 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// MaxLeakedTokensPerRequest bounds one revocation request.
+const MaxLeakedTokensPerRequest = 100
+
+// Statuses of a token passed to RevokeLeakedTokens.
+const (
+	LeakedTokenRevoked        = "revoked"
+	LeakedTokenAlreadyRevoked = "already-revoked"
+	LeakedTokenUnknown        = "unknown"
+	LeakedTokenMalformed      = "malformed"
+)
+
+// revokedAPITokenHash replaces the hash of a leaked token so that the token never
+// verifies again, even if the user is re-enabled; only a rotation issues a new one.
+const revokedAPITokenHash = "$" + APITokenHashRevoked + "$"
+
+// LeakedTokenResult reports what happened to one leaked token. The token itself is
+// masked down to its public part.
+type LeakedTokenResult struct {
+	Token     string `json:"token"`
+	Status    string `json:"status"`
+	APIUserId int    `json:"apiUserId,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// maskAPIToken keeps the non-secret head of a token: xui_<env>_ and the lookup prefix.
+func maskAPIToken(token string) string {
+	keep := apiTokenPrefixLength
+	if rest, ok := strings.CutPrefix(token, APITokenPrefix); ok {
+		if env, _, ok := strings.Cut(rest, "_"); ok {
+			keep += len(APITokenPrefix) + len(env) + 1
+		}
+	}
+	if len(token) <= keep {
+		return strings.Repeat("*", len(token))
+	}
+	return token[:keep] + "…"
+}
+
+// RevokeLeakedTokens disables the owners of tokens found in a leak and invalidates
+// the tokens themselves. Holding a token is the authority to revoke it, so the
+// caller does not need to be authenticated otherwise. Every revocation is recorded
+// in the audit trail with actor, ip and source, and reported to the Telegram admins.
+func (s *APIUserService) RevokeLeakedTokens(tokens []string, actor string, ip string, source string) ([]LeakedTokenResult, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("no tokens given")
+	}
+	if len(tokens) > MaxLeakedTokensPerRequest {
+		return nil, fmt.Errorf("at most %d tokens per request", MaxLeakedTokensPerRequest)
+	}
+	detail := "reported as leaked"
+	if source = strings.TrimSpace(source); source != "" {
+		detail += " by " + source
+	}
+
+	results := make([]LeakedTokenResult, 0, len(tokens))
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, token := range tokens {
+			result, err := s.revokeToken(tx, strings.TrimSpace(token), APIAuditTokenLeaked, actor, ip, detail)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		forgetAPIJWTUser(result.APIUserId)
+		if result.Status == LeakedTokenRevoked {
+			s.alert(fmt.Sprintf("🚨 API token of <b>%s</b> was reported as leaked%s and revoked (from %s).",
+				telegramHTMLEscaper.Replace(result.Name), sourceSuffix(source), telegramHTMLEscaper.Replace(ip)))
+		}
+	}
+	return results, nil
+}
+
+// RevokeOwnToken lets a token holder kill their own token; ErrInvalidAPIToken is
+// returned when token is not a live token. The revocation is reported to the Telegram
+// admins.
+func (s *APIUserService) RevokeOwnToken(token string, ip string) (*model.APIUser, error) {
+	var user *model.APIUser
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		result, err := s.revokeToken(tx, strings.TrimSpace(token), APIAuditTokenSelfRevoked, "token holder", ip, "revoked by its holder")
+		if err != nil {
+			return err
+		}
+		if result.Status != LeakedTokenRevoked {
+			return ErrInvalidAPIToken
+		}
+		user = &model.APIUser{Id: result.APIUserId, Name: result.Name}
+		return nil
+	})
+	if user != nil {
+		forgetAPIJWTUser(user.Id)
+		s.alert(fmt.Sprintf("🔒 API token of <b>%s</b> was revoked by its holder (from %s).",
+			telegramHTMLEscaper.Replace(user.Name), telegramHTMLEscaper.Replace(ip)))
+	}
+	return user, err
+}
+
+func (s *APIUserService) revokeToken(tx *gorm.DB, token string, event string, actor string, ip string, detail string) (LeakedTokenResult, error) {
+	result := LeakedTokenResult{Token: maskAPIToken(token), Status: LeakedTokenMalformed}
+	prefix, ok := apiTokenLookupPrefix(token)
+	if !ok {
+		return result, nil
+	}
+
+	result.Status = LeakedTokenUnknown
+	user := &model.APIUser{}
+	err := tx.Where("token_prefix = ?", prefix).First(user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	if user.TokenHash == revokedAPITokenHash {
+		// The token can no longer be verified, so the owner is not disclosed.
+		result.Status = LeakedTokenAlreadyRevoked
+		return result, nil
+	}
+	if match, _, _ := checkAPITokenHash(user.TokenHash, token); !match {
+		return result, nil
+	}
+
+	err = tx.Model(&model.APIUser{}).Where("id = ?", user.Id).Updates(voidAccessTokens(map[string]any{
+		"enabled":    false,
+		"token_hash": revokedAPITokenHash,
+	})).Error
+	if err != nil {
+		return result, err
+	}
+	result.Status, result.APIUserId, result.Name = LeakedTokenRevoked, user.Id, user.Name
+	err = recordAPIAudit(tx, &model.APIAuditEvent{
+		Event:       event,
+		APIUserId:   user.Id,
+		APIUserName: user.Name,
+		Actor:       actor,
+		IP:          ip,
+		Detail:      detail,
+	})
+	return result, err
+}
+
+func (s *APIUserService) alert(msg string) {
+	alertTelegramAdmins(&s.tgbot, &s.settingService, msg)
+}
+
+func sourceSuffix(source string) string {
+	if source == "" {
+		return ""
+	}
+	return " by " + telegramHTMLEscaper.Replace(source)
+}
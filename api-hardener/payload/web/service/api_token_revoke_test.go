@@ -0,0 +1,120 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// forgeAPIToken returns a well-formed token with the lookup prefix of token but another secret.
+func forgeAPIToken(token string) string {
+	rest := strings.TrimPrefix(token, APITokenPrefix)
+	env, rest, _ := strings.Cut(rest, "_")
+	body := []byte(rest[:apiTokenLength])
+	if body[len(body)-1] == 'A' {
+		body[len(body)-1] = 'B'
+	} else {
+		body[len(body)-1] = 'A'
+	}
+	return structuredToken(env, string(body))
+}
+
+func TestRevokeLeakedTokens(t *testing.T) {
+	users := &APIUserService{}
+	leaked, leakedToken := newTestAPIUser(t, "leak-victim", model.APIScopeRead)
+	_, bystanderToken := newTestAPIUser(t, "leak-bystander", model.APIScopeRead)
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus string
+		wantUser   int
+	}{
+		{"leaked token", leakedToken, LeakedTokenRevoked, leaked.Id},
+		{"reported again", leakedToken, LeakedTokenAlreadyRevoked, 0},
+		{"right prefix, wrong secret", forgeAPIToken(bystanderToken), LeakedTokenUnknown, 0},
+		{"no such token", structuredToken("live", strings.Repeat("Zz", apiTokenLength/2)), LeakedTokenUnknown, 0},
+		{"not a token", "password123", LeakedTokenMalformed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := users.RevokeLeakedTokens([]string{" " + tt.token + " "}, "admin", "192.0.2.1", "scanner")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 || results[0].Status != tt.wantStatus || results[0].APIUserId != tt.wantUser {
+				t.Fatalf("results = %+v, want %s of user %d", results, tt.wantStatus, tt.wantUser)
+			}
+			if results[0].Token != maskAPIToken(tt.token) {
+				t.Fatalf("result shows %q, want the masked token", results[0].Token)
+			}
+		})
+	}
+
+	if _, err := users.VerifyToken(leakedToken); err == nil {
+		t.Fatal("the leaked token still verifies")
+	}
+	if u, _ := users.GetUser(leaked.Id); u == nil || u.Enabled {
+		t.Fatalf("the owner of the leaked token is %+v, want disabled", u)
+	}
+	// Re-enabling the user does not bring the token back.
+	if err := users.SetEnabled(leaked.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.VerifyToken(leakedToken); err == nil {
+		t.Fatal("the leaked token verifies after the user was re-enabled")
+	}
+	if _, err := users.VerifyToken(bystanderToken); err != nil {
+		t.Fatalf("the token of another user was revoked: %v", err)
+	}
+
+	events, err := (&APIAuditService{}).ListEvents(leaked.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Event != APIAuditTokenLeaked || events[0].Actor != "admin" ||
+		events[0].IP != "192.0.2.1" || events[0].Detail != "reported as leaked by scanner" {
+		t.Fatalf("audit = %+v, want one leak event", events)
+	}
+
+	for _, tokens := range [][]string{nil, make([]string, MaxLeakedTokensPerRequest+1)} {
+		if _, err := users.RevokeLeakedTokens(tokens, "admin", "192.0.2.1", ""); err == nil {
+			t.Errorf("RevokeLeakedTokens accepted %d tokens", len(tokens))
+		}
+	}
+}
+
+func TestRevokeOwnToken(t *testing.T) {
+	users := &APIUserService{}
+	holder, token := newTestAPIUser(t, "self-revoker", model.APIScopeRead)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"forged", forgeAPIToken(token), ErrInvalidAPIToken},
+		{"own token", token, nil},
+		{"again", token, ErrInvalidAPIToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := users.RevokeOwnToken(tt.token, "192.0.2.1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RevokeOwnToken = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && user.Id != holder.Id {
+				t.Fatalf("revoked the token of %+v", user)
+			}
+		})
+	}
+	events, _ := (&APIAuditService{}).ListEvents(holder.Id, 0)
+	if len(events) != 1 || events[0].Event != APIAuditTokenSelfRevoked {
+		t.Fatalf("audit = %+v, want one self-revoke event", events)
+	}
+}
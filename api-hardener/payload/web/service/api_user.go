@@ -5,34 +5,81 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
-	"github.com/mhsanaei/3x-ui/v2/util/crypto"
-	"github.com/mhsanaei/3x-ui/v2/util/random"
 
 	"gorm.io/gorm"
 )
 
 const (
-	apiTokenLength       = 48
+	apiTokenLength       = 48 // random part; structured tokens add the xui_<env>_ head and a checksum
 	apiTokenPrefixLength = 8
 )
 
 // ErrInvalidAPIToken is returned when a token cannot be matched to an enabled API user.
 var ErrInvalidAPIToken = errors.New("invalid api token")
 
+// Errors returned by the guards applied to token-authenticated API user management.
+var (
+	ErrAPIPrivilegeEscalation = errors.New("api token can not grant more than it holds")
+	ErrAPISelfModification    = errors.New("api token can not change its own scopes or rate limit")
+	ErrLastAdminAPIUser       = errors.New("can not remove the last enabled admin api user")
+)
+
+// defaultAPIScopes are granted when a user is created without explicit scopes.
+var defaultAPIScopes = []model.APIScope{model.APIScopeRead, model.APIScopeWrite}
+
+// ParseAPIScopes splits a comma-separated scope list and validates every entry.
+func ParseAPIScopes(raw string) ([]model.APIScope, error) {
+	scopes := make([]model.APIScope, 0)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			scopes = append(scopes, model.APIScope(part))
+		}
+	}
+	if _, err := JoinAPIScopes(scopes); err != nil {
+		return nil, err
+	}
+	return scopes, nil
+}
+
+// JoinAPIScopes validates scopes and serializes them in the stable order used for storage.
+// Empty scopes yield the default read,write.
+func JoinAPIScopes(scopes []model.APIScope) (string, error) {
+	if len(scopes) == 0 {
+		scopes = defaultAPIScopes
+	}
+	known := []model.APIScope{model.APIScopeRead, model.APIScopeWrite, model.APIScopeAdmin}
+	parts := make([]string, 0, len(scopes))
+	for _, scope := range known {
+		if slices.Contains(scopes, scope) {
+			parts = append(parts, string(scope))
+		}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(known, scope) {
+			return "", fmt.Errorf("unknown api scope %q", scope)
+		}
+	}
+	return strings.Join(parts, ","), nil
+}
+
 // APIUserService manages API-only users, their tokens, and rate limits.
 type APIUserService struct {
 	settingService SettingService
+	tgbot          Tgbot
 }
 
 // CreateUser provisions a new API user with a freshly generated token.
-// The plaintext token is returned only once to the caller.
-func (s *APIUserService) CreateUser(name string, rateLimitPerMinute int) (*model.APIUser, string, error) {
+// The plaintext token is returned only once to the caller. Empty scopes default to read,write.
+func (s *APIUserService) CreateUser(name string, rateLimitPerMinute int, scopes []model.APIScope) (*model.APIUser, string, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return nil, "", errors.New("name can not be empty")
@@ -40,6 +87,10 @@ func (s *APIUserService) CreateUser(name string, rateLimitPerMinute int) (*model
 	if rateLimitPerMinute < 0 {
 		rateLimitPerMinute = 0
 	}
+	scopeList, err := JoinAPIScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
 
 	token, prefix, hash, err := s.generateToken()
 	if err != nil {
@@ -47,19 +98,34 @@ func (s *APIUserService) CreateUser(name string, rateLimitPerMinute int) (*model
 	}
 
 	db := database.GetDB()
+	now := time.Now()
 	apiUser := &model.APIUser{
 		Name:               name,
 		TokenPrefix:        prefix,
 		TokenHash:          hash,
+		TokenIssuedAt:      &now,
 		RateLimitPerMinute: rateLimitPerMinute,
+		Scopes:             scopeList,
 		Enabled:            true,
 	}
+	if _, err := purgeDeletedAPIUsers(db, name, prefix); err != nil {
+		return nil, "", err
+	}
 	if err := db.Create(apiUser).Error; err != nil {
 		return nil, "", err
 	}
 	return apiUser, token, nil
 }
 
+// purgeDeletedAPIUsers hard-deletes soft-deleted API users that still hold name
+// or prefix, which would otherwise block reuse of the unique indexes.
+func purgeDeletedAPIUsers(tx *gorm.DB, name string, prefix string) (int64, error) {
+	result := tx.Unscoped().
+		Where("deleted_at IS NOT NULL AND (name = ? OR token_prefix = ?)", name, prefix).
+		Delete(&model.APIUser{})
+	return result.RowsAffected, result.Error
+}
+
 // ListUsers returns all non-deleted API users ordered by creation time.
 func (s *APIUserService) ListUsers() ([]model.APIUser, error) {
 	db := database.GetDB()
@@ -84,19 +150,67 @@ func (s *APIUserService) GetUser(id int) (*model.APIUser, error) {
 	return apiUser, nil
 }
 
-// SetEnabled toggles an API user's enabled state.
-func (s *APIUserService) SetEnabled(id int, enabled bool) error {
+// GetUserByName fetches a single API user by its unique name.
+func (s *APIUserService) GetUserByName(name string) (*model.APIUser, error) {
 	db := database.GetDB()
-	return db.Model(&model.APIUser{}).
-		Where("id = ?", id).
-		Update("enabled", enabled).
+	apiUser := &model.APIUser{}
+	err := db.Model(&model.APIUser{}).
+		Where("name = ?", strings.TrimSpace(name)).
+		First(apiUser).
 		Error
+	if err != nil {
+		return nil, err
+	}
+	return apiUser, nil
+}
+
+// updateUser applies column updates to one API user and reports
+// gorm.ErrRecordNotFound when the ID does not exist.
+func (s *APIUserService) updateUser(id int, values map[string]any) error {
+	db := database.GetDB()
+	result := db.Model(&model.APIUser{}).
+		Where("id = ?", id).
+		Updates(values)
+	if result.Error != nil {
+		return result.Error
+	}
+	forgetAPIJWTUser(id)
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// voidAccessTokens adds to values the update that voids the access tokens issued to the
+// user so far; it goes with every change of the user's credentials, rights or restrictions.
+// Issue stamps tokens at least one second after TokensNotBefore, so the mark always moves
+// past the previous one as well.
+func voidAccessTokens(values map[string]any) map[string]any {
+	values["tokens_not_before"] = gorm.Expr("MAX(tokens_not_before + 1, ?)", time.Now().Unix())
+	return values
+}
+
+// SetEnabled toggles an API user's enabled state. Disabling voids its access tokens.
+func (s *APIUserService) SetEnabled(id int, enabled bool) error {
+	values := map[string]any{"enabled": enabled}
+	if !enabled {
+		voidAccessTokens(values)
+	}
+	return s.updateUser(id, values)
 }
 
 // DeleteUser permanently removes an API user and its token.
 func (s *APIUserService) DeleteUser(id int) error {
 	db := database.GetDB()
-	return db.Delete(&model.APIUser{}, id).Error
+	result := db.Delete(&model.APIUser{}, id)
+	forgetAPIJWTUser(id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
 }
 
 // UpdateRateLimit sets a per-minute rate limit for the given API user.
@@ -104,11 +218,16 @@ func (s *APIUserService) UpdateRateLimit(id int, rateLimitPerMinute int) error {
 	if rateLimitPerMinute < 0 {
 		rateLimitPerMinute = 0
 	}
-	db := database.GetDB()
-	return db.Model(&model.APIUser{}).
-		Where("id = ?", id).
-		Update("rate_limit_per_minute", rateLimitPerMinute).
-		Error
+	return s.updateUser(id, voidAccessTokens(map[string]any{"rate_limit_per_minute": rateLimitPerMinute}))
+}
+
+// UpdateScopes replaces the scopes granted to the given API user.
+func (s *APIUserService) UpdateScopes(id int, scopes []model.APIScope) error {
+	scopeList, err := JoinAPIScopes(scopes)
+	if err != nil {
+		return err
+	}
+	return s.updateUser(id, voidAccessTokens(map[string]any{"scopes": scopeList}))
 }
 
 // RotateToken replaces the current token with a new secret and returns the plaintext token.
@@ -118,13 +237,11 @@ func (s *APIUserService) RotateToken(id int) (string, error) {
 		return "", err
 	}
 
-	db := database.GetDB()
-	err = db.Model(&model.APIUser{}).
-		Where("id = ?", id).
-		Updates(map[string]any{
-			"token_prefix": prefix,
-			"token_hash":   hash,
-		}).Error
+	err = s.updateUser(id, voidAccessTokens(map[string]any{
+		"token_prefix":    prefix,
+		"token_hash":      hash,
+		"token_issued_at": time.Now(),
+	}))
 	if err != nil {
 		return "", err
 	}
@@ -134,10 +251,10 @@ func (s *APIUserService) RotateToken(id int) (string, error) {
 // VerifyToken validates a presented token and returns the matching enabled API user.
 func (s *APIUserService) VerifyToken(token string) (*model.APIUser, error) {
 	token = strings.TrimSpace(token)
-	if len(token) < apiTokenPrefixLength {
+	prefix, ok := apiTokenLookupPrefix(token)
+	if !ok {
 		return nil, ErrInvalidAPIToken
 	}
-	prefix := token[:apiTokenPrefixLength]
 
 	db := database.GetDB()
 	apiUser := &model.APIUser{}
@@ -152,14 +269,31 @@ func (s *APIUserService) VerifyToken(token string) (*model.APIUser, error) {
 		return nil, ErrInvalidAPIToken
 	}
 
-	if !crypto.CheckPasswordHash(apiUser.TokenHash, token) {
+	ok, legacy, err := checkAPITokenHash(apiUser.TokenHash, token)
+	if err != nil {
+		logger.Warningf("api token check for %q failed: %v", apiUser.Name, err)
+	}
+	if !ok {
 		return nil, ErrInvalidAPIToken
 	}
 
 	now := time.Now()
+	updates := map[string]any{
+		"last_used_at":  now,
+		"request_count": gorm.Expr("request_count + 1"),
+	}
+	if legacy {
+		// Move the legacy bcrypt hash to the current scheme while the plaintext is at hand.
+		if hash, err := hashAPIToken(token); err != nil {
+			logger.Warningf("rehash api token of %q failed: %v", apiUser.Name, err)
+		} else {
+			updates["token_hash"] = hash
+			apiUser.TokenHash = hash
+		}
+	}
 	_ = db.Model(&model.APIUser{}).
 		Where("id = ?", apiUser.Id).
-		Update("last_used_at", now).
+		Updates(updates).
 		Error
 
 	return apiUser, nil
@@ -188,9 +322,155 @@ func (s *APIUserService) Count() (int64, error) {
 	return count, err
 }
 
+// CheckScopeGrant reports ErrAPIPrivilegeEscalation when caller would grant a scope it lacks.
+// Empty scopes stand for the default read,write.
+func (s *APIUserService) CheckScopeGrant(caller *model.APIUser, scopes []model.APIScope) error {
+	if len(scopes) == 0 {
+		scopes = defaultAPIScopes
+	}
+	for _, scope := range scopes {
+		if !caller.HasScope(scope) {
+			return fmt.Errorf("%w: scope %s", ErrAPIPrivilegeEscalation, scope)
+		}
+	}
+	return nil
+}
+
+// CheckRateGrant reports ErrAPIPrivilegeEscalation when a rate limit of
+// rateLimitPerMinute (0 = panel default) would allow more than caller's own limit.
+func (s *APIUserService) CheckRateGrant(caller *model.APIUser, rateLimitPerMinute int) error {
+	own := s.EffectiveRateLimit(caller)
+	if own == 0 {
+		return nil
+	}
+	granted := rateLimitPerMinute
+	if granted <= 0 {
+		defaultLimit, err := s.settingService.GetAPIDefaultRateLimit()
+		if err != nil {
+			return err
+		}
+		granted = defaultLimit
+	}
+	if granted <= 0 || granted > own {
+		return fmt.Errorf("%w: rate limit above %d/min", ErrAPIPrivilegeEscalation, own)
+	}
+	return nil
+}
+
+// CheckUserGrant reports ErrAPIPrivilegeEscalation unless target is at most as privileged
+// as caller (scopes, rate limit) and at least as restricted (origins, access window,
+// inbounds), so a token can not create or take over a user that escapes its own limits.
+func (s *APIUserService) CheckUserGrant(caller *model.APIUser, target *model.APIUser) error {
+	if err := s.CheckScopeGrant(caller, target.ScopeList()); err != nil {
+		return err
+	}
+	if err := s.CheckRateGrant(caller, target.RateLimitPerMinute); err != nil {
+		return err
+	}
+	if caller.AllowedOrigins != "" {
+		allowed := strings.Split(caller.AllowedOrigins, ",")
+		if target.AllowedOrigins == "" {
+			return fmt.Errorf("%w: origins beyond %s", ErrAPIPrivilegeEscalation, caller.AllowedOrigins)
+		}
+		for _, origin := range strings.Split(target.AllowedOrigins, ",") {
+			if !slices.Contains(allowed, origin) {
+				return fmt.Errorf("%w: origin %s", ErrAPIPrivilegeEscalation, origin)
+			}
+		}
+	}
+	if caller.AccessWindow != "" {
+		outer, err := ParseAPIAccessWindow(caller.AccessWindow)
+		if err != nil {
+			return err
+		}
+		window, err := ParseAPIAccessWindow(target.AccessWindow)
+		if err != nil || !window.Within(outer) {
+			return fmt.Errorf("%w: access window beyond %s", ErrAPIPrivilegeEscalation, caller.AccessWindow)
+		}
+	}
+	if caller.InboundAllowlist != "" {
+		outer := s.InboundScope(caller)
+		scope, err := ParseAPIInboundScope(target.InboundAllowlist)
+		if err != nil || !scope.Within(outer) {
+			return fmt.Errorf("%w: inbounds beyond %s", ErrAPIPrivilegeEscalation, caller.InboundAllowlist)
+		}
+	}
+	return nil
+}
+
+// CopyRestrictions gives the API user with id the origin, access window and inbound
+// restrictions of from.
+func (s *APIUserService) CopyRestrictions(id int, from *model.APIUser) error {
+	return s.updateUser(id, map[string]any{
+		"allowed_origins":   from.AllowedOrigins,
+		"access_window":     from.AccessWindow,
+		"inbound_allowlist": from.InboundAllowlist,
+	})
+}
+
+// CheckAdminRemains reports ErrLastAdminAPIUser when disabling, deleting or
+// demoting the API user id would leave no enabled user with the admin scope.
+func (s *APIUserService) CheckAdminRemains(id int) error {
+	users, err := s.ListUsers()
+	if err != nil {
+		return err
+	}
+	targetIsAdmin := false
+	others := 0
+	for i := range users {
+		u := &users[i]
+		if !u.Enabled || !slices.Contains(u.ScopeList(), model.APIScopeAdmin) {
+			continue
+		}
+		if u.Id == id {
+			targetIsAdmin = true
+		} else {
+			others++
+		}
+	}
+	if targetIsAdmin && others == 0 {
+		return ErrLastAdminAPIUser
+	}
+	return nil
+}
+
+// APITokenHashStatus describes the stored token hash of one API user.
+type APITokenHashStatus struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	Scheme   string `json:"scheme"`
+	PepperID string `json:"pepperId,omitempty"`
+	Legacy   bool   `json:"legacy"`  // migrated on the next successful use of the token
+	Foreign  bool   `json:"foreign"` // made with another pepper, the token can not be verified here
+}
+
+// TokenHashReport lists the hash scheme of every API user token.
+func (s *APIUserService) TokenHashReport() ([]APITokenHashStatus, error) {
+	users, err := s.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	localID, err := LocalAPITokenPepperID()
+	if err != nil {
+		return nil, err
+	}
+	report := make([]APITokenHashStatus, 0, len(users))
+	for _, u := range users {
+		scheme, pepperID := APITokenHashScheme(u.TokenHash)
+		report = append(report, APITokenHashStatus{
+			Id:       u.Id,
+			Name:     u.Name,
+			Scheme:   scheme,
+			PepperID: pepperID,
+			Legacy:   scheme == APITokenHashBcrypt,
+			Foreign:  scheme == "" || (scheme == APITokenHashHMAC && pepperID != localID),
+		})
+	}
+	return report, nil
+}
+
 func (s *APIUserService) generateToken() (token string, prefix string, hash string, err error) {
-	token = random.Seq(apiTokenLength)
-	prefix = token[:apiTokenPrefixLength]
-	hash, err = crypto.HashPasswordAsBcrypt(token)
+	token, prefix = newAPIToken()
+	hash, err = hashAPIToken(token)
 	return
 }
@@ -0,0 +1,351 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+
+	"gorm.io/gorm"
+)
+
+// APIExportVersion is the format version written by ExportAPIUsers.
+const APIExportVersion = 1
+
+// APIImportConflict selects what ImportAPIUsers does when a user with the same name exists.
+type APIImportConflict string
+
+const (
+	APIImportConflictFail    APIImportConflict = "fail"    // abort the whole import
+	APIImportConflictSkip    APIImportConflict = "skip"    // keep the existing user
+	APIImportConflictReplace APIImportConflict = "replace" // overwrite the existing user with the imported one
+)
+
+// ErrAPIImportConflict is returned when an imported name is taken and the conflict mode is fail.
+var ErrAPIImportConflict = errors.New("api user already exists")
+
+// errAPIImportDryRun rolls back the import transaction of a dry run.
+var errAPIImportDryRun = errors.New("dry run")
+
+// APIUserExport is the portable form of an API user, including its token hash.
+type APIUserExport struct {
+	Name               string     `json:"name"`
+	TokenPrefix        string     `json:"tokenPrefix"`
+	TokenHash          string     `json:"tokenHash,omitempty"`
+	TokenIssuedAt      *time.Time `json:"tokenIssuedAt,omitempty"`
+	CertFingerprint    string     `json:"certFingerprint,omitempty"`
+	CertSubject        string     `json:"certSubject,omitempty"`
+	AllowedOrigins     string     `json:"allowedOrigins,omitempty"`
+	AccessWindow       string     `json:"accessWindow,omitempty"`
+	InboundAllowlist   string     `json:"inboundAllowlist,omitempty"`
+	RateLimitPerMinute int        `json:"rateLimitPerMinute"`
+	Scopes             string     `json:"scopes"`
+	Enabled            bool       `json:"enabled"`
+	CreatedAt          time.Time  `json:"createdAt"`
+}
+
+// APISettingsExport holds the global API settings carried by an export.
+type APISettingsExport struct {
+	APITokenOnly        bool `json:"apiTokenOnly"`
+	APIDefaultRateLimit int  `json:"apiDefaultRateLimit"`
+}
+
+// APIUsersExport is the document produced by ExportAPIUsers and consumed by ImportAPIUsers.
+type APIUsersExport struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exportedAt"`
+	Settings   APISettingsExport `json:"settings"`
+	Users      []APIUserExport   `json:"users"`
+}
+
+// APIImportOptions controls ImportAPIUsers.
+type APIImportOptions struct {
+	Conflict      APIImportConflict
+	ReissueTokens bool // generate new tokens instead of copying hashes
+	SkipSettings  bool
+	DryRun        bool // run the import in a transaction that is rolled back
+}
+
+// APIImportResult describes what happened to one imported user.
+type APIImportResult struct {
+	Name   string `json:"name"`
+	Id     int    `json:"id,omitempty"`
+	Action string `json:"action"` // created, replaced or skipped
+	Purged int64  `json:"purged,omitempty"`
+	Token  string `json:"-"` // plaintext token when ReissueTokens is set
+}
+
+// ExportAPIUsers returns all API users with their token hashes and the global API settings.
+func (s *APIUserService) ExportAPIUsers() (*APIUsersExport, error) {
+	users, err := s.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	tokenOnly, err := s.settingService.GetAPITokenOnly()
+	if err != nil {
+		return nil, err
+	}
+	defaultRate, err := s.settingService.GetAPIDefaultRateLimit()
+	if err != nil {
+		return nil, err
+	}
+
+	export := &APIUsersExport{
+		Version:    APIExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Settings:   APISettingsExport{APITokenOnly: tokenOnly, APIDefaultRateLimit: defaultRate},
+		Users:      make([]APIUserExport, 0, len(users)),
+	}
+	for _, u := range users {
+		export.Users = append(export.Users, APIUserExport{
+			Name:               u.Name,
+			TokenPrefix:        u.TokenPrefix,
+			TokenHash:          u.TokenHash,
+			TokenIssuedAt:      u.TokenIssuedAt,
+			CertFingerprint:    u.CertFingerprint,
+			CertSubject:        u.CertSubject,
+			AllowedOrigins:     u.AllowedOrigins,
+			AccessWindow:       u.AccessWindow,
+			InboundAllowlist:   u.InboundAllowlist,
+			RateLimitPerMinute: u.RateLimitPerMinute,
+			Scopes:             u.Scopes,
+			Enabled:            u.Enabled,
+			CreatedAt:          u.CreatedAt,
+		})
+	}
+	return export, nil
+}
+
+// ValidateAPIUsersExport checks an export document before anything is written.
+func ValidateAPIUsersExport(export *APIUsersExport, reissueTokens bool) error {
+	if export.Version != APIExportVersion {
+		return fmt.Errorf("unsupported export version %d", export.Version)
+	}
+	if export.Settings.APIDefaultRateLimit < 0 {
+		return errors.New("settings: apiDefaultRateLimit must be >= 0")
+	}
+	seen := map[string]bool{}
+	for i, u := range export.Users {
+		name := strings.TrimSpace(u.Name)
+		if name == "" {
+			return fmt.Errorf("users[%d]: name is required", i)
+		}
+		if seen[name] {
+			return fmt.Errorf("users[%d]: duplicate name %q", i, name)
+		}
+		seen[name] = true
+		if u.RateLimitPerMinute < 0 {
+			return fmt.Errorf("users[%d] %s: rateLimitPerMinute must be >= 0", i, name)
+		}
+		if u.CertFingerprint != "" {
+			if _, err := NormalizeCertFingerprint(u.CertFingerprint); err != nil {
+				return fmt.Errorf("users[%d] %s: certFingerprint: %w", i, name, err)
+			}
+		}
+		if _, err := ParseAPIScopes(u.Scopes); err != nil {
+			return fmt.Errorf("users[%d] %s: %w", i, name, err)
+		}
+		if _, err := ParseOrigins(u.AllowedOrigins); err != nil {
+			return fmt.Errorf("users[%d] %s: allowedOrigins: %w", i, name, err)
+		}
+		if _, err := ParseAPIAccessWindow(u.AccessWindow); err != nil {
+			return fmt.Errorf("users[%d] %s: accessWindow: %w", i, name, err)
+		}
+		if _, err := ParseAPIInboundScope(u.InboundAllowlist); err != nil {
+			return fmt.Errorf("users[%d] %s: inboundAllowlist: %w", i, name, err)
+		}
+		if reissueTokens {
+			continue
+		}
+		if len(u.TokenPrefix) != apiTokenPrefixLength || u.TokenHash == "" {
+			return fmt.Errorf("users[%d] %s: token prefix and hash are required unless tokens are re-issued", i, name)
+		}
+		scheme, pepperID := APITokenHashScheme(u.TokenHash)
+		if scheme == "" {
+			return fmt.Errorf("users[%d] %s: unknown token hash scheme", i, name)
+		}
+		if scheme == APITokenHashHMAC {
+			localID, err := LocalAPITokenPepperID()
+			if err != nil {
+				return err
+			}
+			if pepperID != localID {
+				return fmt.Errorf("users[%d] %s: %w; copy %s from the source panel or re-issue tokens",
+					i, name, ErrAPITokenPepperMismatch, APITokenPepperFile)
+			}
+		}
+	}
+	return nil
+}
+
+// ImportAPIUsers writes the users of an export in one transaction. Soft-deleted
+// rows that hold an imported name or token prefix are purged first.
+func (s *APIUserService) ImportAPIUsers(export *APIUsersExport, opts APIImportOptions) ([]APIImportResult, error) {
+	if opts.Conflict == "" {
+		opts.Conflict = APIImportConflictFail
+	}
+	switch opts.Conflict {
+	case APIImportConflictFail, APIImportConflictSkip, APIImportConflictReplace:
+	default:
+		return nil, fmt.Errorf("unknown conflict mode %q", opts.Conflict)
+	}
+	if err := ValidateAPIUsersExport(export, opts.ReissueTokens); err != nil {
+		return nil, err
+	}
+
+	results := make([]APIImportResult, 0, len(export.Users))
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, u := range export.Users {
+			result, err := s.importUser(tx, u, opts)
+			if err != nil {
+				return fmt.Errorf("%s: %w", u.Name, err)
+			}
+			results = append(results, result)
+		}
+		if opts.DryRun {
+			return errAPIImportDryRun
+		}
+		return nil
+	})
+	if opts.DryRun && errors.Is(err, errAPIImportDryRun) {
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		forgetAPIJWTUser(result.Id)
+	}
+
+	// SettingService does not take part in the transaction, so settings are written after commit.
+	if !opts.SkipSettings {
+		if err := s.settingService.SetAPITokenOnly(export.Settings.APITokenOnly); err != nil {
+			return nil, err
+		}
+		if err := s.settingService.SetAPIDefaultRateLimit(export.Settings.APIDefaultRateLimit); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (s *APIUserService) importUser(tx *gorm.DB, u APIUserExport, opts APIImportOptions) (APIImportResult, error) {
+	result := APIImportResult{Name: strings.TrimSpace(u.Name)}
+	scopes, _ := ParseAPIScopes(u.Scopes)
+	scopeList, err := JoinAPIScopes(scopes)
+	if err != nil {
+		return result, err
+	}
+	origins, err := ParseOrigins(u.AllowedOrigins)
+	if err != nil {
+		return result, err
+	}
+	window, err := NormalizeAPIAccessWindow(u.AccessWindow)
+	if err != nil {
+		return result, err
+	}
+	inbounds, err := NormalizeAPIInboundScope(u.InboundAllowlist)
+	if err != nil {
+		return result, err
+	}
+
+	prefix, hash, issuedAt := u.TokenPrefix, u.TokenHash, u.TokenIssuedAt
+	if opts.ReissueTokens {
+		token, newPrefix, newHash, err := s.generateToken()
+		if err != nil {
+			return result, err
+		}
+		now := time.Now()
+		prefix, hash, issuedAt = newPrefix, newHash, &now
+		result.Token = token
+	}
+
+	if result.Purged, err = purgeDeletedAPIUsers(tx, result.Name, prefix); err != nil {
+		return result, err
+	}
+
+	existing := &model.APIUser{}
+	err = tx.Where("name = ?", result.Name).First(existing).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		existing = nil
+	case err != nil:
+		return result, err
+	}
+
+	if existing != nil {
+		switch opts.Conflict {
+		case APIImportConflictSkip:
+			result.Id = existing.Id
+			result.Action = "skipped"
+			result.Token = ""
+			return result, nil
+		case APIImportConflictFail:
+			return result, ErrAPIImportConflict
+		}
+	}
+
+	var holder model.APIUser
+	err = tx.Where("token_prefix = ?", prefix).First(&holder).Error
+	if err == nil && (existing == nil || holder.Id != existing.Id) {
+		return result, fmt.Errorf("token prefix %s is already used by api user %q", prefix, holder.Name)
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return result, err
+	}
+
+	if existing != nil {
+		err = tx.Model(&model.APIUser{}).
+			Where("id = ?", existing.Id).
+			Updates(voidAccessTokens(map[string]any{
+				"token_prefix":          prefix,
+				"token_hash":            hash,
+				"token_issued_at":       issuedAt,
+				"cert_fingerprint":      u.CertFingerprint,
+				"cert_subject":          u.CertSubject,
+				"allowed_origins":       origins,
+				"access_window":         window,
+				"inbound_allowlist":     inbounds,
+				"rate_limit_per_minute": u.RateLimitPerMinute,
+				"scopes":                scopeList,
+				"enabled":               u.Enabled,
+			})).
+			Error
+		result.Id = existing.Id
+		result.Action = "replaced"
+		return result, err
+	}
+
+	apiUser := &model.APIUser{
+		Name:               result.Name,
+		TokenPrefix:        prefix,
+		TokenHash:          hash,
+		TokenIssuedAt:      issuedAt,
+		CertFingerprint:    u.CertFingerprint,
+		CertSubject:        u.CertSubject,
+		AllowedOrigins:     origins,
+		AccessWindow:       window,
+		InboundAllowlist:   inbounds,
+		RateLimitPerMinute: u.RateLimitPerMinute,
+		Scopes:             scopeList,
+		Enabled:            true,
+		CreatedAt:          u.CreatedAt,
+	}
+	if err := tx.Create(apiUser).Error; err != nil {
+		return result, err
+	}
+	// Enabled defaults to true in the schema, so a false value needs an explicit update.
+	if !u.Enabled {
+		if err := tx.Model(apiUser).Update("enabled", false).Error; err != nil {
+			return result, err
+		}
+	}
+	result.Id = apiUser.Id
+	result.Action = "created"
+	return result, nil
+}
@@ -0,0 +1,131 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestValidateAPIUsersExport(t *testing.T) {
+	user := func(name string) APIUserExport {
+		return APIUserExport{Name: name, TokenPrefix: strings.Repeat("a", apiTokenPrefixLength), TokenHash: "sha256:00", Scopes: "read"}
+	}
+	tests := []struct {
+		name    string
+		edit    func(e *APIUsersExport)
+		reissue bool
+		wantErr string
+	}{
+		{"reissued", func(e *APIUsersExport) {}, true, ""},
+		{"other version", func(e *APIUsersExport) { e.Version = 2 }, true, "unsupported export version 2"},
+		{"negative default rate", func(e *APIUsersExport) { e.Settings.APIDefaultRateLimit = -1 }, true, "apiDefaultRateLimit"},
+		{"no name", func(e *APIUsersExport) { e.Users[0].Name = " " }, true, "users[0]: name is required"},
+		{"duplicate name", func(e *APIUsersExport) { e.Users[1].Name = "a" }, true, `users[1]: duplicate name "a"`},
+		{"negative rate", func(e *APIUsersExport) { e.Users[0].RateLimitPerMinute = -1 }, true, "rateLimitPerMinute must be >= 0"},
+		{"bad fingerprint", func(e *APIUsersExport) { e.Users[0].CertFingerprint = "zz" }, true, "certFingerprint"},
+		{"unknown scope", func(e *APIUsersExport) { e.Users[0].Scopes = "root" }, true, "users[0] a"},
+		{"bad origin", func(e *APIUsersExport) { e.Users[0].AllowedOrigins = "not an origin" }, true, "allowedOrigins"},
+		{"bad access window", func(e *APIUsersExport) { e.Users[0].AccessWindow = "sometimes" }, true, "accessWindow"},
+		{"bad inbound allowlist", func(e *APIUsersExport) { e.Users[0].InboundAllowlist = "x" }, true, "inboundAllowlist"},
+		{"no token hash", func(e *APIUsersExport) { e.Users[0].TokenHash = "" }, false, "token prefix and hash are required"},
+		{"unknown hash scheme", func(e *APIUsersExport) { e.Users[0].TokenHash = "md5:00" }, false, "unknown token hash scheme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			export := &APIUsersExport{Version: APIExportVersion, Users: []APIUserExport{user("a"), user("b")}}
+			tt.edit(export)
+			err := ValidateAPIUsersExport(export, tt.reissue)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestImportAPIUsers(t *testing.T) {
+	users := &APIUserService{}
+	settingService := &SettingService{}
+	source, sourceToken, err := users.CreateUser("export-source", 15, []model.APIScope{model.APIScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	export, err := users.ExportAPIUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported APIUserExport
+	for _, u := range export.Users {
+		if u.Name == source.Name {
+			exported = u
+		}
+	}
+	if exported.TokenHash == "" || exported.RateLimitPerMinute != 15 {
+		t.Fatalf("export of %s = %+v", source.Name, exported)
+	}
+	// Move the user to a fresh panel: the soft-deleted row is purged on import.
+	if err := users.DeleteUser(source.Id); err != nil {
+		t.Fatal(err)
+	}
+	defaultRate, err := settingService.GetAPIDefaultRateLimit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := settingService.SetAPIDefaultRateLimit(30); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if u, err := users.GetUserByName(source.Name); err == nil {
+			users.DeleteUser(u.Id)
+		}
+		settingService.SetAPIDefaultRateLimit(defaultRate)
+	})
+
+	single := &APIUsersExport{Version: APIExportVersion, Settings: APISettingsExport{APIDefaultRateLimit: 90}, Users: []APIUserExport{exported}}
+	tests := []struct {
+		name       string
+		opts       APIImportOptions
+		wantAction string
+		wantErr    error
+		wantOld    bool // the exported token still authenticates afterwards
+		wantRate   int  // apiDefaultRateLimit afterwards
+	}{
+		{"dry run", APIImportOptions{DryRun: true}, "created", nil, false, 30},
+		{"create", APIImportOptions{SkipSettings: true}, "created", nil, true, 30},
+		{"conflict", APIImportOptions{}, "", ErrAPIImportConflict, true, 30},
+		{"skip", APIImportOptions{Conflict: APIImportConflictSkip, SkipSettings: true}, "skipped", nil, true, 30},
+		{"replace with settings", APIImportOptions{Conflict: APIImportConflictReplace}, "replaced", nil, true, 90},
+		{"reissue", APIImportOptions{Conflict: APIImportConflictReplace, ReissueTokens: true, SkipSettings: true}, "replaced", nil, false, 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := users.ImportAPIUsers(single, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ImportAPIUsers = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (len(results) != 1 || results[0].Action != tt.wantAction) {
+				t.Fatalf("results = %+v, want %s", results, tt.wantAction)
+			}
+			if err == nil && (results[0].Token != "") != tt.opts.ReissueTokens {
+				t.Fatalf("token = %q with ReissueTokens %v", results[0].Token, tt.opts.ReissueTokens)
+			}
+			_, err = users.VerifyToken(sourceToken)
+			if (err == nil) != tt.wantOld {
+				t.Fatalf("VerifyToken of the exported token = %v, want valid %v", err, tt.wantOld)
+			}
+			if rate, _ := settingService.GetAPIDefaultRateLimit(); rate != tt.wantRate {
+				t.Fatalf("apiDefaultRateLimit = %d, want %d", rate, tt.wantRate)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestCheckScopeGrant(t *testing.T) {
+	users := &APIUserService{}
+	read, write, admin := model.APIScopeRead, model.APIScopeWrite, model.APIScopeAdmin
+	tests := []struct {
+		name    string
+		caller  string
+		scopes  []model.APIScope
+		wantErr bool
+	}{
+		{"same scope", "read", []model.APIScope{read}, false},
+		{"default scopes from read,write", "read,write", nil, false},
+		{"default scopes from read", "read", nil, true},
+		{"wider scope", "read", []model.APIScope{read, write}, true},
+		{"admin grants anything", "admin", []model.APIScope{read, write, admin}, false},
+		{"admin from write", "read,write", []model.APIScope{admin}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := users.CheckScopeGrant(&model.APIUser{Scopes: tt.caller}, tt.scopes)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrAPIPrivilegeEscalation)) {
+				t.Fatalf("CheckScopeGrant = %v, want escalation %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckRateGrant(t *testing.T) {
+	users := &APIUserService{}
+	settingService := &SettingService{}
+	defaultRate, err := settingService.GetAPIDefaultRateLimit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { settingService.SetAPIDefaultRateLimit(defaultRate) })
+
+	tests := []struct {
+		name        string
+		defaultRate int
+		callerRate  int
+		rate        int
+		wantErr     bool
+	}{
+		{"unlimited caller", 0, 0, 0, false},
+		{"unlimited caller, any rate", 0, 0, 100000, false},
+		{"lower rate", 60, 30, 10, false},
+		{"same rate", 60, 30, 30, false},
+		{"higher rate", 60, 30, 31, true},
+		{"default within the caller's limit", 60, 100, 0, false},
+		{"default above the caller's limit", 60, 30, 0, true},
+		{"unlimited default from a limited caller", 0, 30, 0, true},
+		{"caller limited by the default", 60, 0, 60, false},
+		{"above the default limiting the caller", 60, 0, 61, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := settingService.SetAPIDefaultRateLimit(tt.defaultRate); err != nil {
+				t.Fatal(err)
+			}
+			err := users.CheckRateGrant(&model.APIUser{RateLimitPerMinute: tt.callerRate}, tt.rate)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrAPIPrivilegeEscalation)) {
+				t.Fatalf("CheckRateGrant = %v, want escalation %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckUserGrant(t *testing.T) {
+	users := &APIUserService{}
+	caller := &model.APIUser{
+		Scopes:             "read,write",
+		RateLimitPerMinute: 60,
+		AllowedOrigins:     "https://a.example,https://b.example",
+		AccessWindow:       "mon-fri 09:00-18:00",
+		InboundAllowlist:   "id:3,id:4",
+	}
+	// within returns a user as restricted as caller, changed by edit.
+	within := func(edit func(u *model.APIUser)) *model.APIUser {
+		u := *caller
+		edit(&u)
+		return &u
+	}
+	tests := []struct {
+		name    string
+		target  *model.APIUser
+		wantErr bool
+	}{
+		{"same limits", within(func(u *model.APIUser) {}), false},
+		{"narrower", within(func(u *model.APIUser) {
+			u.Scopes, u.RateLimitPerMinute = "read", 10
+			u.AllowedOrigins, u.AccessWindow, u.InboundAllowlist = "https://a.example", "mon 10:00-12:00", "id:3"
+		}), false},
+		{"admin scope", within(func(u *model.APIUser) { u.Scopes = "read,admin" }), true},
+		{"higher rate", within(func(u *model.APIUser) { u.RateLimitPerMinute = 120 }), true},
+		{"any origin", within(func(u *model.APIUser) { u.AllowedOrigins = "" }), true},
+		{"other origin", within(func(u *model.APIUser) { u.AllowedOrigins = "https://a.example,https://c.example" }), true},
+		{"any time", within(func(u *model.APIUser) { u.AccessWindow = "" }), true},
+		{"weekends", within(func(u *model.APIUser) { u.AccessWindow = "sat,sun" }), true},
+		{"all inbounds", within(func(u *model.APIUser) { u.InboundAllowlist = "" }), true},
+		{"other inbound", within(func(u *model.APIUser) { u.InboundAllowlist = "id:5" }), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := users.CheckUserGrant(caller, tt.target)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrAPIPrivilegeEscalation)) {
+				t.Fatalf("CheckUserGrant = %v, want escalation %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := users.CheckUserGrant(&model.APIUser{Scopes: "admin"}, within(func(u *model.APIUser) {})); err != nil {
+		t.Fatalf("an unrestricted admin can not grant a restricted user: %v", err)
+	}
+}
+
+func TestCheckAdminRemains(t *testing.T) {
+	users := &APIUserService{}
+	first, _ := newTestAPIUser(t, "grant-admin-first", model.APIScopeAdmin)
+	second, _ := newTestAPIUser(t, "grant-admin-second", model.APIScopeAdmin)
+	reader, _ := newTestAPIUser(t, "grant-reader", model.APIScopeRead)
+
+	tests := []struct {
+		name          string
+		secondEnabled bool
+		id            int
+		wantErr       error
+	}{
+		{"two admins", true, first.Id, nil},
+		{"last admin", false, first.Id, ErrLastAdminAPIUser},
+		{"disabled admin", false, second.Id, nil},
+		{"not an admin", false, reader.Id, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := users.SetEnabled(second.Id, tt.secondEnabled); err != nil {
+				t.Fatal(err)
+			}
+			if err := users.CheckAdminRemains(tt.id); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckAdminRemains = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
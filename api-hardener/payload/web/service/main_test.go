@@ -0,0 +1,41 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// TestMain runs the tests against a fresh database with a fixed token pepper.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "x-ui-service-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv(APITokenPepperEnv, "0123456789abcdef0123456789abcdef")
+	if err := database.InitDB(filepath.Join(dir, "x-ui.db")); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	database.CloseDB()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestAPIUser creates the API user name with scopes and returns it with its API token.
+func newTestAPIUser(t *testing.T, name string, scopes ...model.APIScope) (*model.APIUser, string) {
+	t.Helper()
+	users := &APIUserService{}
+	user, token, err := users.CreateUser(name, 0, scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { users.DeleteUser(user.Id) })
+	return user, token
+}
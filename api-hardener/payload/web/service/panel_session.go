@@ -0,0 +1,170 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	panelSessionIDLength = 32
+	// panelSessionTouchInterval limits how often activity of a session is written back.
+	panelSessionTouchInterval = time.Minute
+	// panelSessionIdleFallback bounds idle records when sessionMaxAge is 0 (browser-session cookies).
+	panelSessionIdleFallback = 30 * 24 * time.Hour
+	maxUserAgentLength       = 255
+)
+
+// PanelSessionService keeps the server-side records of panel login sessions, so they
+// can be listed and revoked while the session itself stays in the signed cookie.
+type PanelSessionService struct {
+	settingService SettingService
+}
+
+func hashPanelSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create records a new session for user and returns the ID to store in its cookie.
+// Idle records are pruned, and when panelMaxSessions is set the least recently
+// active sessions of the user are revoked to stay within the limit.
+func (s *PanelSessionService) Create(user *model.User, ip string, userAgent string) (string, error) {
+	buf := make([]byte, panelSessionIDLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	maxSessions, err := s.settingService.GetPanelMaxSessions()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	record := &model.PanelSession{
+		SessionHash:  hashPanelSessionID(id),
+		UserId:       user.Id,
+		Username:     user.Username,
+		IP:           ip,
+		UserAgent:    userAgent,
+		CreatedAt:    now,
+		LastActiveAt: now,
+	}
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := s.pruneIdle(tx); err != nil {
+			return err
+		}
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		if maxSessions <= 0 {
+			return nil
+		}
+		var excess []int
+		err := tx.Model(&model.PanelSession{}).
+			Where("user_id = ?", user.Id).
+			Order("last_active_at DESC, id DESC").
+			Offset(maxSessions).
+			Pluck("id", &excess).Error
+		if err != nil || len(excess) == 0 {
+			return err
+		}
+		return tx.Where("id IN ?", excess).Delete(&model.PanelSession{}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (s *PanelSessionService) pruneIdle(tx *gorm.DB) error {
+	idle := panelSessionIdleFallback
+	if maxAge, err := s.settingService.GetSessionMaxAge(); err == nil && maxAge > 0 {
+		idle = time.Duration(maxAge) * time.Minute
+	}
+	return tx.Where("last_active_at < ?", time.Now().Add(-idle)).Delete(&model.PanelSession{}).Error
+}
+
+// Touch reports whether the session with id is still valid for userId and records its
+// activity. A missing record means the session was revoked or expired.
+func (s *PanelSessionService) Touch(id string, userId int, ip string) (bool, error) {
+	db := database.GetDB()
+	record := &model.PanelSession{}
+	err := db.Where("session_hash = ?", hashPanelSessionID(id)).First(record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if record.UserId != userId {
+		return false, nil
+	}
+	if record.IP == ip && time.Since(record.LastActiveAt) < panelSessionTouchInterval {
+		return true, nil
+	}
+	err = db.Model(record).Updates(map[string]any{
+		"last_active_at": time.Now(),
+		"ip":             ip,
+	}).Error
+	return true, err
+}
+
+// IsCurrent reports whether record belongs to the session ID held by the caller.
+func (s *PanelSessionService) IsCurrent(record *model.PanelSession, id string) bool {
+	return id != "" && record.SessionHash == hashPanelSessionID(id)
+}
+
+// ListSessions returns all recorded sessions, most recently active first.
+func (s *PanelSessionService) ListSessions() ([]model.PanelSession, error) {
+	sessions := make([]model.PanelSession, 0)
+	err := database.GetDB().Order("last_active_at DESC, id DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession deletes one session record; the session is rejected on its next request.
+func (s *PanelSessionService) RevokeSession(id int) error {
+	result := database.GetDB().Where("id = ?", id).Delete(&model.PanelSession{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions deletes all sessions of the panel user username, except the one
+// with keepID (the caller's own) when it is set, and returns how many were revoked.
+func (s *PanelSessionService) RevokeUserSessions(username string, keepID string) (int, error) {
+	query := database.GetDB().Where("username = ?", username)
+	if keepID != "" {
+		query = query.Where("session_hash <> ?", hashPanelSessionID(keepID))
+	}
+	result := query.Delete(&model.PanelSession{})
+	return int(result.RowsAffected), result.Error
+}
+
+// RevokeAllSessions deletes every session record and returns how many were revoked.
+func (s *PanelSessionService) RevokeAllSessions() (int, error) {
+	result := database.GetDB().Where("1 = 1").Delete(&model.PanelSession{})
+	return int(result.RowsAffected), result.Error
+}
+
+// RemoveSession forgets the session with the cookie ID id, e.g. on logout.
+func (s *PanelSessionService) RemoveSession(id string) error {
+	return database.GetDB().Where("session_hash = ?", hashPanelSessionID(id)).Delete(&model.PanelSession{}).Error
+}
@@ -0,0 +1,168 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// newTestPanelSessions revokes all panel sessions before and after the test.
+func newTestPanelSessions(t *testing.T) *PanelSessionService {
+	t.Helper()
+	sessions := &PanelSessionService{}
+	if _, err := sessions.RevokeAllSessions(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sessions.RevokeAllSessions() })
+	return sessions
+}
+
+func TestPanelSessionTouch(t *testing.T) {
+	sessions := newTestPanelSessions(t)
+	alice := &model.User{Id: 1, Username: "alice"}
+	id, err := sessions.Create(alice, "192.0.2.1", strings.Repeat("a", 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := sessions.Create(alice, "192.0.2.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.RemoveSession(revoked); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		userId int
+		ip     string
+		want   bool
+		wantIP string // IP of the record afterwards
+	}{
+		{"own session", id, 1, "192.0.2.1", true, "192.0.2.1"},
+		{"roamed to another IP", id, 1, "198.51.100.7", true, "198.51.100.7"},
+		{"other user", id, 2, "198.51.100.7", false, "198.51.100.7"},
+		{"revoked", revoked, 1, "192.0.2.1", false, "198.51.100.7"},
+		{"unknown", "ffff", 1, "192.0.2.1", false, "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sessions.Touch(tt.id, tt.userId, tt.ip)
+			if err != nil || got != tt.want {
+				t.Fatalf("Touch = %v, %v; want %v", got, err, tt.want)
+			}
+			list, err := sessions.ListSessions()
+			if err != nil || len(list) != 1 || list[0].IP != tt.wantIP || len(list[0].UserAgent) != maxUserAgentLength {
+				t.Fatalf("sessions = %+v, %v", list, err)
+			}
+		})
+	}
+}
+
+func TestPanelSessionLimit(t *testing.T) {
+	sessions := newTestPanelSessions(t)
+	settingService := &SettingService{}
+	t.Cleanup(func() { settingService.SetPanelMaxSessions(0) })
+	alice, bob := &model.User{Id: 1, Username: "alice"}, &model.User{Id: 2, Username: "bob"}
+
+	tests := []struct {
+		name        string
+		maxSessions int
+		logins      int
+		wantAlice   int
+	}{
+		{"unlimited", 0, 4, 4},
+		{"limit", 2, 1, 2},
+		{"limit of one", 1, 1, 1},
+	}
+	var last string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := settingService.SetPanelMaxSessions(tt.maxSessions); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.logins; i++ {
+				id, err := sessions.Create(alice, "192.0.2.1", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				last = id
+			}
+			if _, err := sessions.Create(bob, "192.0.2.2", ""); err != nil {
+				t.Fatal(err)
+			}
+			list, err := sessions.ListSessions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			count := 0
+			for _, s := range list {
+				if s.Username == "alice" {
+					count++
+				}
+			}
+			if count != tt.wantAlice {
+				t.Fatalf("alice has %d sessions, want %d", count, tt.wantAlice)
+			}
+			if valid, _ := sessions.Touch(last, alice.Id, "192.0.2.1"); !valid {
+				t.Fatal("the newest session was revoked by the limit")
+			}
+		})
+	}
+}
+
+func TestPanelSessionRevoke(t *testing.T) {
+	sessions := newTestPanelSessions(t)
+	alice, bob := &model.User{Id: 1, Username: "alice"}, &model.User{Id: 2, Username: "bob"}
+	current, _ := sessions.Create(alice, "192.0.2.1", "")
+	other, _ := sessions.Create(alice, "192.0.2.1", "")
+	bobs, _ := sessions.Create(bob, "192.0.2.2", "")
+	idle, _ := sessions.Create(bob, "192.0.2.2", "")
+	// A session idle for longer than any cookie lives is pruned on the next login.
+	database.GetDB().Model(&model.PanelSession{}).Where("session_hash = ?", hashPanelSessionID(idle)).
+		Update("last_active_at", time.Now().Add(-panelSessionIdleFallback-time.Hour))
+
+	n, err := sessions.RevokeUserSessions("alice", current)
+	if err != nil || n != 1 {
+		t.Fatalf("RevokeUserSessions = %d, %v; want 1", n, err)
+	}
+	if _, err := sessions.Create(bob, "192.0.2.2", ""); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := sessions.ListSessions()
+
+	tests := []struct {
+		name   string
+		id     string
+		userId int
+		want   bool
+	}{
+		{"current session of the revoking user", current, 1, true},
+		{"other session of the revoking user", other, 1, false},
+		{"session of another user", bobs, 2, true},
+		{"idle session", idle, 2, false},
+	}
+	for _, tt := range tests {
+		if valid, _ := sessions.Touch(tt.id, tt.userId, "192.0.2.9"); valid != tt.want {
+			t.Errorf("%s: valid = %v, want %v", tt.name, valid, tt.want)
+		}
+	}
+	for _, s := range list {
+		if sessions.IsCurrent(&s, current) != (s.Username == "alice") {
+			t.Errorf("IsCurrent(%+v) is wrong", s)
+		}
+		if err := sessions.RevokeSession(s.Id); err != nil {
+			t.Errorf("RevokeSession(%d) = %v", s.Id, err)
+		}
+	}
+	if err := sessions.RevokeSession(list[0].Id); err == nil {
+		t.Error("revoking a revoked session succeeded")
+	}
+}
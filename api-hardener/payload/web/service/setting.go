@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/netip"
 	"reflect"
 	"strconv"
 	"strings"
@@ -36,8 +37,28 @@ var defaultValueMap = map[string]string{
 	"secret":                      random.Seq(32),
 	"webBasePath":                 "/",
 	"sessionMaxAge":               "360",
+	"panelMaxSessions":            "0",
 	"apiTokenOnly":                "false",
 	"apiDefaultRateLimit":         "120",
+	"apiLockdown":                 "off",
+	"apiBreakGlassHash":           "",
+	"apiListen":                   "",
+	"apiPort":                     "0",
+	"apiCertFile":                 "",
+	"apiKeyFile":                  "",
+	"apiBasePath":                 "",
+	"apiWebListener":              "true",
+	"apiClientCAFile":             "",
+	"apiClientCRLFile":            "",
+	"apiSocket":                   "",
+	"apiJWTTTL":                   "15",
+	"trustedProxies":              "127.0.0.0/8,::1/128",
+	"trustedProxyHeader":          "X-Forwarded-For",
+	"apiCorsOrigins":              "",
+	"apiCorsMethods":              "GET,POST",
+	"apiCorsHeaders":              "Authorization,Content-Type,X-API-Token",
+	"apiCorsCredentials":          "false",
+	"apiCorsMaxAge":               "600",
 	"pageSize":                    "25",
 	"expireDiff":                  "0",
 	"trafficDiff":                 "0",
@@ -192,6 +213,7 @@ func (s *SettingService) GetAllSetting() (*entity.AllSetting, error) {
 func (s *SettingService) ResetSettings() error {
 	db := database.GetDB()
 	err := db.Where("1 = 1").Delete(model.Setting{}).Error
+	forgetTrustedProxySettings()
 	if err != nil {
 		return err
 	}
@@ -210,6 +232,9 @@ func (s *SettingService) getSetting(key string) (*model.Setting, error) {
 }
 
 func (s *SettingService) saveSetting(key string, value string) error {
+	if key == "trustedProxies" || key == "trustedProxyHeader" {
+		defer forgetTrustedProxySettings()
+	}
 	setting, err := s.getSetting(key)
 	db := database.GetDB()
 	if database.IsNotFound(err) {
@@ -291,7 +316,13 @@ func (s *SettingService) SetTgBotToken(token string) error {
 	return s.setString("tgBotToken", token)
 }
 
+// GetTgBotProxy returns the proxy of the panel's bot. The lockdown command relay
+// (api_lockdown_bot.go) dials through tgBotProxy itself, so the bot gets none while it
+// talks to the relay.
 func (s *SettingService) GetTgBotProxy() (string, error) {
+	if s.tgBotRelayURL() != "" {
+		return "", nil
+	}
 	return s.getString("tgBotProxy")
 }
 
@@ -299,7 +330,12 @@ func (s *SettingService) SetTgBotProxy(token string) error {
 	return s.setString("tgBotProxy", token)
 }
 
+// GetTgBotAPIServer returns the Bot API server of the panel's bot: while the bot is
+// enabled, the lockdown command relay, which forwards to tgBotAPIServer.
 func (s *SettingService) GetTgBotAPIServer() (string, error) {
+	if relay := s.tgBotRelayURL(); relay != "" {
+		return relay, nil
+	}
 	return s.getString("tgBotAPIServer")
 }
 
@@ -399,6 +435,17 @@ func (s *SettingService) GetSessionMaxAge() (int, error) {
 	return s.getInt("sessionMaxAge")
 }
 
+func (s *SettingService) GetPanelMaxSessions() (int, error) {
+	return s.getInt("panelMaxSessions")
+}
+
+func (s *SettingService) SetPanelMaxSessions(limit int) error {
+	if limit < 0 {
+		limit = 0
+	}
+	return s.setInt("panelMaxSessions", limit)
+}
+
 func (s *SettingService) GetAPITokenOnly() (bool, error) {
 	return s.getBool("apiTokenOnly")
 }
@@ -418,6 +465,115 @@ func (s *SettingService) SetAPIDefaultRateLimit(limit int) error {
 	return s.setInt("apiDefaultRateLimit", limit)
 }
 
+func (s *SettingService) GetAPILockdown() (string, error) {
+	return s.getString("apiLockdown")
+}
+
+func (s *SettingService) SetAPILockdown(mode string) error {
+	return s.setString("apiLockdown", mode)
+}
+
+func (s *SettingService) GetAPIListen() (string, error) {
+	return s.getString("apiListen")
+}
+
+func (s *SettingService) GetAPIPort() (int, error) {
+	return s.getInt("apiPort")
+}
+
+func (s *SettingService) GetAPICertFile() (string, error) {
+	return s.getString("apiCertFile")
+}
+
+func (s *SettingService) GetAPIKeyFile() (string, error) {
+	return s.getString("apiKeyFile")
+}
+
+// GetAPIBasePath returns the base path of the API listener, which defaults to webBasePath.
+func (s *SettingService) GetAPIBasePath() (string, error) {
+	basePath, err := s.getString("apiBasePath")
+	if err != nil || basePath == "" {
+		return s.GetBasePath()
+	}
+	if !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+	if !strings.HasSuffix(basePath, "/") {
+		basePath += "/"
+	}
+	return basePath, nil
+}
+
+func (s *SettingService) GetAPIWebListener() (bool, error) {
+	return s.getBool("apiWebListener")
+}
+
+func (s *SettingService) GetAPIClientCAFile() (string, error) {
+	return s.getString("apiClientCAFile")
+}
+
+func (s *SettingService) GetAPIClientCRLFile() (string, error) {
+	return s.getString("apiClientCRLFile")
+}
+
+func (s *SettingService) GetAPISocket() (string, error) {
+	return s.getString("apiSocket")
+}
+
+func (s *SettingService) GetAPIJWTTTL() (int, error) {
+	return s.getInt("apiJWTTTL")
+}
+
+func (s *SettingService) SetAPIJWTTTL(minutes int) error {
+	if minutes < 0 {
+		minutes = 0
+	}
+	return s.setInt("apiJWTTTL", minutes)
+}
+
+// GetTrustedProxies returns the networks of the reverse proxies whose forwarding headers are
+// believed (trustedProxies).
+func (s *SettingService) GetTrustedProxies() ([]netip.Prefix, error) {
+	raw, err := s.getString("trustedProxies")
+	if err != nil {
+		return nil, err
+	}
+	return ParseTrustedProxies(raw)
+}
+
+func (s *SettingService) GetTrustedProxyHeader() (string, error) {
+	return s.getString("trustedProxyHeader")
+}
+
+// GetTrustedProxySettings returns trustedProxies and trustedProxyHeader from a cache, as
+// they are needed for every request (see trustedProxyCache).
+func (s *SettingService) GetTrustedProxySettings() ([]netip.Prefix, string, error) {
+	trustedProxyCache.mu.Lock()
+	defer trustedProxyCache.mu.Unlock()
+	if !trustedProxyCache.loadedAt.IsZero() && time.Since(trustedProxyCache.loadedAt) < trustedProxyCacheTTL {
+		return trustedProxyCache.proxies, trustedProxyCache.header, nil
+	}
+	proxies, err := s.GetTrustedProxies()
+	if err != nil {
+		return nil, "", err
+	}
+	header, err := s.GetTrustedProxyHeader()
+	if err != nil {
+		return nil, "", err
+	}
+	trustedProxyCache.proxies, trustedProxyCache.header = proxies, header
+	trustedProxyCache.loadedAt = time.Now()
+	return proxies, header, nil
+}
+
+func (s *SettingService) GetAPIBreakGlassHash() (string, error) {
+	return s.getString("apiBreakGlassHash")
+}
+
+func (s *SettingService) SetAPIBreakGlassHash(hash string) error {
+	return s.setString("apiBreakGlassHash", hash)
+}
+
 func (s *SettingService) GetRemarkModel() (string, error) {
 	return s.getString("remarkModel")
 }
@@ -0,0 +1,37 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import "testing"
+
+func TestGetAPIBasePath(t *testing.T) {
+	settingService := &SettingService{}
+	t.Cleanup(func() {
+		settingService.saveSetting("apiBasePath", defaultValueMap["apiBasePath"])
+		settingService.saveSetting("webBasePath", defaultValueMap["webBasePath"])
+	})
+	tests := []struct {
+		apiBasePath string
+		webBasePath string
+		want        string
+	}{
+		{"", "/", "/"},
+		{"", "/panel-x/", "/panel-x/"},
+		{"", "panel-x", "/panel-x/"},
+		{"/api-x/", "/panel-x/", "/api-x/"},
+		{"api-x", "/panel-x/", "/api-x/"},
+		{"/api-x", "/", "/api-x/"},
+	}
+	for _, tt := range tests {
+		if err := settingService.saveSetting("apiBasePath", tt.apiBasePath); err != nil {
+			t.Fatal(err)
+		}
+		if err := settingService.saveSetting("webBasePath", tt.webBasePath); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := settingService.GetAPIBasePath(); err != nil || got != tt.want {
+			t.Errorf("GetAPIBasePath with apiBasePath %q, webBasePath %q = %q, %v; want %q", tt.apiBasePath, tt.webBasePath, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trustedProxyCacheTTL bounds how long a change of trustedProxies or trustedProxyHeader made
+// by another process (api-guard) takes to apply; changes saved by the panel apply at once.
+const trustedProxyCacheTTL = time.Minute
+
+// trustedProxyCache keeps the parsed trustedProxies and trustedProxyHeader, so resolving the
+// client IP does not read the settings for every request.
+var trustedProxyCache struct {
+	mu       sync.Mutex
+	loadedAt time.Time
+	proxies  []netip.Prefix
+	header   string
+}
+
+// forgetTrustedProxySettings drops the cache after either setting changed.
+func forgetTrustedProxySettings() {
+	trustedProxyCache.mu.Lock()
+	trustedProxyCache.loadedAt = time.Time{}
+	trustedProxyCache.mu.Unlock()
+}
+
+// TrustedProxyHeaders are the headers trustedProxyHeader can name: the standard
+// X-Forwarded-For chain, the single address of nginx's X-Real-IP and Cloudflare's
+// CF-Connecting-IP.
+var TrustedProxyHeaders = []string{"X-Forwarded-For", "X-Real-IP", "CF-Connecting-IP"}
+
+// IsTrustedProxyHeader reports whether header is one of TrustedProxyHeaders, ignoring case.
+func IsTrustedProxyHeader(header string) bool {
+	return slices.ContainsFunc(TrustedProxyHeaders, func(h string) bool {
+		return strings.EqualFold(h, header)
+	})
+}
+
+// ParseTrustedProxies parses a comma or space separated list of CIDRs and single addresses.
+func ParseTrustedProxies(raw string) ([]netip.Prefix, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' })
+	proxies := make([]netip.Prefix, 0, len(fields))
+	for _, field := range fields {
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", field)
+			}
+			addr = addr.Unmap()
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", field)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
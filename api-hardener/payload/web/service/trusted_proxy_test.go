@@ -0,0 +1,77 @@
+//go:build toolsignore
+// +build toolsignore
+
+package service
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{raw: "", want: []string{}},
+		{raw: "127.0.0.0/8,::1/128", want: []string{"127.0.0.0/8", "::1/128"}},
+		{raw: "10.1.2.3 192.168.1.77/24\n::ffff:10.0.0.1", want: []string{"10.1.2.3/32", "192.168.1.0/24", "10.0.0.1/32"}},
+		{raw: "10.0.0.0/33", wantErr: true},
+		{raw: "proxy.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		proxies, err := ParseTrustedProxies(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("ParseTrustedProxies(%q) err = %v, wantErr %v", tt.raw, err, tt.wantErr)
+		}
+		got := make([]string, 0, len(proxies))
+		for _, p := range proxies {
+			got = append(got, p.String())
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("ParseTrustedProxies(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestGetTrustedProxySettings(t *testing.T) {
+	settingService := &SettingService{}
+	t.Cleanup(func() {
+		settingService.saveSetting("trustedProxies", defaultValueMap["trustedProxies"])
+		settingService.saveSetting("trustedProxyHeader", defaultValueMap["trustedProxyHeader"])
+	})
+	check := func(wantProxies []netip.Prefix, wantHeader string) {
+		t.Helper()
+		proxies, header, err := settingService.GetTrustedProxySettings()
+		if err != nil || !slices.Equal(proxies, wantProxies) || header != wantHeader {
+			t.Fatalf("GetTrustedProxySettings = %v, %q, %v; want %v, %q", proxies, header, err, wantProxies, wantHeader)
+		}
+	}
+
+	if err := settingService.saveSetting("trustedProxies", "10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	check([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "X-Forwarded-For")
+
+	// Saving either setting applies at once.
+	if err := settingService.saveSetting("trustedProxyHeader", "X-Real-IP"); err != nil {
+		t.Fatal(err)
+	}
+	check([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "X-Real-IP")
+
+	// Changes by another process are seen once the cache expires.
+	if err := database.GetDB().Model(&model.Setting{}).Where("key = ?", "trustedProxies").Update("value", "").Error; err != nil {
+		t.Fatal(err)
+	}
+	check([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "X-Real-IP")
+	trustedProxyCache.mu.Lock()
+	trustedProxyCache.loadedAt = time.Now().Add(-trustedProxyCacheTTL)
+	trustedProxyCache.mu.Unlock()
+	check([]netip.Prefix{}, "X-Real-IP")
+}
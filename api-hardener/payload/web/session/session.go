@@ -6,10 +6,15 @@
 package session
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/gob"
+	"encoding/hex"
 	"net/http"
+	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -18,13 +23,41 @@ import (
 const (
 	loginUserKey   = "LOGIN_USER"
 	contextUserKey = "CTX_LOGIN_USER"
+	csrfTokenKey   = "CSRF_TOKEN"
+	reauthAtKey    = "REAUTH_AT"
+	sessionIDKey   = "SESSION_ID"
 	defaultPath    = "/"
 )
 
+// clearHook is told the server-side session ID whenever a session is replaced or cleared.
+var clearHook func(id string)
+
+// createHook records the server-side session of a new login and returns its ID.
+var createHook func(c *gin.Context, user *model.User) (string, error)
+
 func init() {
 	gob.Register(model.User{})
 }
 
+// SetClearHook registers fn to be called with the server-side session ID when a
+// session is cleared or replaced by a new login, so its record can be removed.
+func SetClearHook(fn func(id string)) {
+	clearHook = fn
+}
+
+// SetCreateHook registers fn to record the server-side session of every new login. The
+// returned ID is bound to the session cookie; a logged-in cookie without one is treated
+// as logged out, so a cookie can not obtain a fresh record after its own was revoked.
+func SetCreateHook(fn func(c *gin.Context, user *model.User) (string, error)) {
+	createHook = fn
+}
+
+func runClearHook(s sessions.Session) {
+	if id, ok := s.Get(sessionIDKey).(string); ok && id != "" && clearHook != nil {
+		clearHook(id)
+	}
+}
+
 // SetLoginUser stores the authenticated user in the session.
 // The user object is serialized and stored for subsequent requests.
 func SetLoginUser(c *gin.Context, user *model.User) {
@@ -32,7 +65,22 @@ func SetLoginUser(c *gin.Context, user *model.User) {
 		return
 	}
 	s := sessions.Default(c)
+	// A new login always starts a new server-side session, without the step-up
+	// confirmation or CSRF token of the previous one.
+	runClearHook(s)
+	s.Delete(sessionIDKey)
+	s.Delete(reauthAtKey)
+	s.Delete(csrfTokenKey)
 	s.Set(loginUserKey, *user)
+	if createHook == nil {
+		return
+	}
+	id, err := createHook(c, user)
+	if err != nil {
+		logger.Warning("record panel session failed:", err)
+		return
+	}
+	s.Set(sessionIDKey, id)
 }
 
 // SetContextUser attaches a user to the request context without persisting it in a session cookie.
@@ -104,6 +152,7 @@ func IsLogin(c *gin.Context) bool {
 // This effectively logs out the user and clears any stored session information.
 func ClearSession(c *gin.Context) {
 	s := sessions.Default(c)
+	runClearHook(s)
 	s.Clear()
 	s.Options(sessions.Options{
 		Path:     defaultPath,
@@ -113,3 +162,50 @@ func ClearSession(c *gin.Context) {
 	})
 	c.Set(contextUserKey, nil)
 }
+
+// GetCSRFToken returns the CSRF token bound to the session, creating and saving it on first use.
+// It returns an empty string when the token can not be created.
+func GetCSRFToken(c *gin.Context) string {
+	s := sessions.Default(c)
+	if token, ok := s.Get(csrfTokenKey).(string); ok && token != "" {
+		return token
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	token := hex.EncodeToString(buf)
+	s.Set(csrfTokenKey, token)
+	if err := s.Save(); err != nil {
+		return ""
+	}
+	return token
+}
+
+// CheckCSRFToken reports whether token matches the CSRF token of the session.
+func CheckCSRFToken(c *gin.Context, token string) bool {
+	expected, ok := sessions.Default(c).Get(csrfTokenKey).(string)
+	if !ok || expected == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+// MarkReauthenticated records that the logged-in user has just confirmed their credentials again.
+func MarkReauthenticated(c *gin.Context) error {
+	s := sessions.Default(c)
+	s.Set(reauthAtKey, time.Now().Unix())
+	return s.Save()
+}
+
+// IsReauthenticated reports whether the user confirmed their credentials within window.
+func IsReauthenticated(c *gin.Context, window time.Duration) bool {
+	at, ok := sessions.Default(c).Get(reauthAtKey).(int64)
+	return ok && time.Since(time.Unix(at, 0)) < window
+}
+
+// GetSessionID returns the ID of the server-side session record, or "" when the session has none.
+func GetSessionID(c *gin.Context) string {
+	id, _ := sessions.Default(c).Get(sessionIDKey).(string)
+	return id
+}
@@ -0,0 +1,106 @@
+//go:build toolsignore
+// +build toolsignore
+
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// serve runs handler in a request carrying the session of cookie and returns the
+// session cookie of the response, or cookie when the session was not saved.
+func serve(t *testing.T, cookieHeader string, handler func(c *gin.Context)) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(sessions.Sessions("3x-ui", cookie.NewStore([]byte("0123456789abcdef0123456789abcdef"))))
+	engine.GET("/", func(c *gin.Context) {
+		handler(c)
+		if err := sessions.Default(c).Save(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookieHeader != "" {
+		r.Header.Set("Cookie", cookieHeader)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, r)
+	// Every Save sets the cookie again; the last one holds the final session.
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "3x-ui" {
+			cookieHeader = c.Name + "=" + c.Value
+		}
+	}
+	return cookieHeader
+}
+
+func TestIsReauthenticated(t *testing.T) {
+	tests := []struct {
+		name string
+		age  time.Duration // since the confirmation; negative for none
+		want bool
+	}{
+		{"never confirmed", -1, false},
+		{"just confirmed", 0, true},
+		{"within the window", 4 * time.Minute, true},
+		{"expired", 6 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookie := serve(t, "", func(c *gin.Context) {
+				if tt.age >= 0 {
+					sessions.Default(c).Set(reauthAtKey, time.Now().Add(-tt.age).Unix())
+				}
+			})
+			serve(t, cookie, func(c *gin.Context) {
+				if got := IsReauthenticated(c, 5*time.Minute); got != tt.want {
+					t.Errorf("IsReauthenticated = %v, want %v", got, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestSetLoginUserResetsSession(t *testing.T) {
+	var token string
+	cookie := serve(t, "", func(c *gin.Context) {
+		SetLoginUser(c, &model.User{Id: 1, Username: "admin"})
+		token = GetCSRFToken(c)
+		if err := MarkReauthenticated(c); err != nil {
+			t.Fatal(err)
+		}
+	})
+	cookie = serve(t, cookie, func(c *gin.Context) {
+		if !IsReauthenticated(c, time.Minute) || !CheckCSRFToken(c, token) {
+			t.Fatal("the session lost its confirmation or CSRF token")
+		}
+		SetLoginUser(c, &model.User{Id: 1, Username: "admin"})
+	})
+	serve(t, cookie, func(c *gin.Context) {
+		tests := []struct {
+			name string
+			got  bool
+		}{
+			{"confirmation", IsReauthenticated(c, time.Minute)},
+			{"CSRF token", CheckCSRFToken(c, token)},
+		}
+		for _, tt := range tests {
+			if tt.got {
+				t.Errorf("the %s of the previous login is still valid", tt.name)
+			}
+		}
+		if user := GetLoginUser(c); user == nil || user.Username != "admin" {
+			t.Errorf("GetLoginUser = %+v", user)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+#!/usr/bin/env bash
+# Builds a signed api-hardener release into DIST_DIR (default ./dist):
+#   payload.b64, payload.b64.sig  - what install.sh downloads and verifies
+#   api-guard.linux-amd64(.sig)   - the CLI, also shipped inside the payload
+# Usage: release.sh <release.key> <clean 3x-ui checkout>
+# The key comes from "api-guard keygen" and must match a key in
+# payload/cmd/api-guard/release.pub and RELEASE_PUBKEYS in install.sh.
+set -euo pipefail
+
+KEY_FILE="${1:?usage: release.sh <release.key> <clean 3x-ui checkout>}"
+UPSTREAM_DIR="${2:?usage: release.sh <release.key> <clean 3x-ui checkout>}"
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+DIST_DIR="${DIST_DIR:-${SCRIPT_DIR}/dist}"
+GO_BIN="${GO_BIN:-go}"
+
+WORK_DIR="$(mktemp -d)"
+trap 'rm -rf "${WORK_DIR}"' EXIT
+
+# Build api-guard against the upstream release the manifest is generated for.
+echo ">> Building api-guard"
+mkdir -p "${WORK_DIR}/stage" "${WORK_DIR}/payload"
+cp -R "${UPSTREAM_DIR}/." "${WORK_DIR}/stage/"
+cp -R "${SCRIPT_DIR}/payload/." "${WORK_DIR}/stage/"
+cp -R "${SCRIPT_DIR}/payload/." "${WORK_DIR}/payload/"
+(cd "${WORK_DIR}/stage" && "${GO_BIN}" mod tidy \
+  && GOOS=linux GOARCH=amd64 CGO_ENABLED=0 "${GO_BIN}" build -tags toolsignore -o "${WORK_DIR}/payload/api-guard.linux-amd64" ./cmd/api-guard)
+GUARD="${WORK_DIR}/payload/api-guard.linux-amd64"
+if [[ "$(uname -s)/$(uname -m)" != "Linux/x86_64" ]]; then
+  (cd "${WORK_DIR}/stage" && "${GO_BIN}" build -tags toolsignore -o "${WORK_DIR}/api-guard" ./cmd/api-guard)
+  GUARD="${WORK_DIR}/api-guard"
+fi
+
+echo ">> Writing manifest.json"
+"${GUARD}" manifest -payload "${WORK_DIR}/payload" -upstream "${UPSTREAM_DIR}"
+
+echo ">> Signing"
+mkdir -p "${DIST_DIR}"
+cp -f "${WORK_DIR}/payload/api-guard.linux-amd64" "${DIST_DIR}/"
+"${GUARD}" sign -key "${KEY_FILE}" -f "${DIST_DIR}/api-guard.linux-amd64"
+tar -czf "${WORK_DIR}/payload.tgz" -C "${WORK_DIR}/payload" .
+base64 -w0 "${WORK_DIR}/payload.tgz" > "${DIST_DIR}/payload.b64"
+"${GUARD}" sign -key "${KEY_FILE}" -f "${DIST_DIR}/payload.b64"
+
+# The embedded release keys must accept what was just signed, or install.sh would refuse it.
+"${GUARD}" verify -f "${DIST_DIR}/payload.b64"
+"${GUARD}" verify -f "${DIST_DIR}/api-guard.linux-amd64"
+echo ">> Release ready in ${DIST_DIR}"